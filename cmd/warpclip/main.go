@@ -4,7 +4,11 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -13,224 +17,1925 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
+
+	"github.com/mquinnv/warpclip/v2/internal/ansi"
+	"github.com/mquinnv/warpclip/v2/internal/client"
+	"github.com/mquinnv/warpclip/v2/internal/config"
+	"github.com/mquinnv/warpclip/v2/internal/eol"
+	"github.com/mquinnv/warpclip/v2/internal/protocol"
+	"github.com/mquinnv/warpclip/v2/internal/term"
+	"github.com/mquinnv/warpclip/v2/internal/version"
+)
+
+const (
+	// PasteCommand is the name that makes the binary behave as "warpclip paste"
+	PasteCommand = "warp-paste"
+	DefaultPort = 9999
+	DefaultLocalPort = 8888
+	DefaultMaxDataSize = 1048576
+	Timeout = 5 * time.Second
+	StdinTimeout = 5 * time.Second
+)
+
+// Human-readable output. --quiet discards progress messages and --json
+// discards both, reporting a single result object on stdout instead.
+var (
+	progressOut io.Writer = os.Stderr
+	errorOut    io.Writer = os.Stderr
+	// debugOut receives diagnostics, such as the port WARPCLIP_TRY_PORTS
+	// chose, which only --debug prints
+	debugOut io.Writer = io.Discard
+	// remoteOut receives what commands run over SSH print, which
+	// install-remote --json discards along with its progress messages
+	remoteOut io.Writer = os.Stdout
+	// colors prints errors, hints and successes in color when stderr is a
+	// terminal
+	colors term.Colors
 )
 
-const (
-	Version = "2.1.11" // Increment from previous versions
-	DefaultPort = 9999
-	Timeout = 5 * time.Second
-)
+// Exit statuses, stable so scripts can branch on why a copy failed
+const (
+	exitFailure     = 1
+	exitNoInput     = 2
+	exitNoTunnel    = 3
+	exitRejected    = 4
+	exitTooLarge    = 5
+	exitOutdated    = 6
+	exitInterrupted = 130
+)
+
+// errStdinTimeout is returned when no input arrives before the stdin timeout
+var errStdinTimeout = fmt.Errorf("%w: nothing received on stdin", client.ErrNoInput)
+
+// errInputDeadline is returned when input is still arriving at
+// --input-deadline and --on-input-deadline=fail was given
+var errInputDeadline = errors.New("input still arriving at --input-deadline")
+
+// options holds the settings that control how data is sent to the daemon
+type options struct {
+	// port is the local end of the SSH tunnel (or the daemon itself)
+	port int
+	// timeout bounds connecting, writing and waiting for the acknowledgement
+	timeout time.Duration
+	// noTunnel connects straight to a daemon on this machine
+	noTunnel bool
+	// verify checks the server's checksum of the payload against our own
+	verify bool
+	// expectSize announces the payload size so the server can detect short writes
+	expectSize bool
+	// sizeKnown announces the payload size to daemons known to understand
+	// it, as input from a file has a size before it is read
+	sizeKnown bool
+	// inputSize is that size, while sizeKnown is set
+	inputSize int64
+	// quiet suppresses progress messages, leaving only errors
+	quiet bool
+	// json replaces all human-readable output with a result object on stdout
+	json bool
+	// stdinTimeout bounds the wait for the first byte of input (zero waits forever)
+	stdinTimeout time.Duration
+	// waitForTunnel is how long to keep checking for a tunnel that isn't up yet
+	waitForTunnel time.Duration
+	// normalizeEOL rewrites line endings before sending
+	normalizeEOL eol.Mode
+	// stripANSI removes terminal escape sequences, such as colors, before sending
+	stripANSI bool
+	// tee writes the input to stdout as well, like tee(1)
+	tee bool
+	// decodeBase64 treats the input as base64 text and copies the bytes it encodes
+	decodeBase64 bool
+	// clearAfter asks the daemon to clear the clipboard this long after the copy
+	clearAfter time.Duration
+	// historyTTL asks the daemon to purge the copy from its history this long
+	// after making it
+	historyTTL time.Duration
+	// ports lists the daemons a copy fans out to, replacing port when set
+	ports []int
+	// tryPorts holds the WARPCLIP_TRY_PORTS candidates until pickTriedPort
+	// probes them, just before dialing
+	tryPorts []int
+	// triedPort is set when port is the first in WARPCLIP_TRY_PORTS that
+	// answered, which the JSON result reports
+	triedPort bool
+	// profilePort is set when port or ports came from the client profile,
+	// which --no-tunnel's default port doesn't replace
+	profilePort bool
+	// chunkSize sends the payload in acknowledged chunks of this many bytes
+	// (zero sends it in one piece)
+	chunkSize int64
+	// ifChanged skips the copy when the clipboard already holds the payload
+	ifChanged bool
+	// listen waits on this port for the daemon to pull the copy through a
+	// forward tunnel instead of sending it (zero sends it)
+	listen int
+	// inputDeadline bounds how long stdin is read for (zero reads to EOF)
+	inputDeadline time.Duration
+	// failOnInputDeadline fails instead of copying what arrived by the
+	// input deadline
+	failOnInputDeadline bool
+	// contentType has the daemon copy the input as this MIME type instead
+	// of plain text
+	contentType string
+	// lineBuffer has --follow send only complete lines, dropping an
+	// unterminated last one
+	lineBuffer bool
+}
+
+func main() {
+	// Define command line flags
+	var opts options
+	var showHelp bool
+	var showVersion bool
+	var follow bool
+	var check bool
+	var onInputDeadline string
+	var noColor bool
+	var debug bool
+	var profileName string
+
+	flag.IntVar(&opts.port, "port", DefaultPort, "Specify custom port")
+	flag.IntVar(&opts.port, "p", DefaultPort, "Specify custom port (shorthand)")
+	flag.BoolVar(&showHelp, "help", false, "Show help message")
+	flag.BoolVar(&showHelp, "h", false, "Show help message (shorthand)")
+	flag.BoolVar(&showVersion, "version", false, "Show version information")
+	flag.BoolVar(&showVersion, "v", false, "Show version information (shorthand)")
+	flag.DurationVar(&opts.timeout, "timeout", Timeout, "Connection and write timeout (e.g. 30s, 2m)")
+	flag.BoolVar(&follow, "follow", false, "Send each line of input as a separate clipboard update")
+	flag.BoolVar(&opts.lineBuffer, "line-buffer", false, "With --follow, send only complete lines, each as soon as its newline arrives")
+	flag.Var((*portsFlag)(&opts.ports), "ports", "Copy to every daemon in this comma-separated list of ports at once")
+	flag.BoolVar(&check, "check", false, "Check the tunnel, daemon and clipboard without copying anything")
+	flag.BoolVar(&opts.verify, "verify", false, "Confirm the daemon received the data intact via SHA-256")
+	flag.BoolVar(&opts.expectSize, "expect-size", false, "Announce the payload size so the daemon can detect a truncated transfer")
+	flag.BoolVar(&opts.noTunnel, "no-tunnel", envBool("WARPCLIP_NO_TUNNEL"), "Connect directly to a local daemon instead of an SSH tunnel")
+	flag.BoolVar(&opts.quiet, "quiet", envBool("WARPCLIP_SILENT"), "Only print errors")
+	flag.BoolVar(&opts.quiet, "q", envBool("WARPCLIP_SILENT"), "Only print errors (shorthand)")
+	flag.BoolVar(&opts.json, "json", false, "Print a JSON result object to stdout instead of messages")
+	flag.Var((*eolFlag)(&opts.normalizeEOL), "normalize-eol", "Convert line endings before copying (bare flag or =lf: CRLF to LF, =crlf: LF to CRLF)")
+	flag.BoolVar(&opts.tee, "tee", false, "Also write the input to stdout, like tee")
+	flag.BoolVar(&opts.tee, "copy-and-print", false, "Also write the input to stdout (same as --tee)")
+	flag.BoolVar(&opts.stripANSI, "strip-ansi", false, "Remove ANSI escape sequences (colors, cursor movement) before copying")
+	flag.DurationVar(&opts.stdinTimeout, "stdin-timeout", StdinTimeout, "How long to wait for input before giving up (0 waits forever)")
+	flag.DurationVar(&opts.waitForTunnel, "wait-for-tunnel", 0, "Keep checking for the tunnel this long before giving up (e.g. 10s)")
+	flag.BoolVar(&opts.decodeBase64, "decode-base64", false, "Decode base64 input and copy the bytes it encodes")
+	flag.DurationVar(&opts.clearAfter, "clear-after", 0, "Have the daemon clear the clipboard this long after copying (e.g. 30s)")
+	flag.DurationVar(&opts.historyTTL, "history-ttl", 0, "Have the daemon drop the copy from its history this long after copying (e.g. 1h)")
+	flag.BoolVar(&opts.ifChanged, "if-changed", false, "Only copy if the clipboard doesn't already hold the input, checked by its SHA-256")
+	flag.StringVar(&opts.contentType, "type", "", "Copy the input as this MIME type, e.g. application/pdf or text/uri-list (macOS daemons only)")
+	flag.Var((*sizeFlag)(&opts.chunkSize), "chunk-size", "Send the payload in acknowledged chunks of this size (e.g. 64KB), resending any that fail")
+	flag.DurationVar(&opts.inputDeadline, "input-deadline", 0, "Stop reading input after this long, e.g. for tail -f (0 reads to EOF)")
+	flag.StringVar(&onInputDeadline, "on-input-deadline", "send", "At --input-deadline, send what arrived (send) or fail (fail)")
+	flag.IntVar(&opts.listen, "listen", 0, "Wait on this port for warpclipd to pull the copy (WARPCLIP_PULL_FROM) instead of sending it")
+	flag.BoolVar(&noColor, "no-color", false, "Print messages without color (also set by NO_COLOR)")
+	flag.BoolVar(&debug, "debug", false, "Print diagnostics, such as the port chosen from WARPCLIP_TRY_PORTS")
+	flag.StringVar(&profileName, "profile", "", "Use this profile from the client config file instead of the one matching this host")
+	
+	// Installed under the name warp-paste, the binary only pastes
+	if filepath.Base(os.Args[0]) == PasteCommand {
+		os.Args = append([]string{os.Args[0], "paste"}, os.Args[1:]...)
+	}
+
+	// Parse flags. Bad flags exit with the generic failure status rather
+	// than the flag package's 2, which means no input.
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		os.Exit(exitFailure)
+	}
+	colors = term.ForStderr(noColor)
+	if debug {
+		debugOut = os.Stderr
+	}
+
+	// The client profile fills in what the command line and environment
+	// leave unset
+	profile, profileErr := selectProfile(profileName)
+	if profileErr != nil {
+		colors.Errorf(os.Stderr, "Error: %v\n", profileErr)
+		os.Exit(1)
+	}
+	applyProfile(&opts, profile)
+
+	// Without a tunnel the daemon's own port is the default target
+	if opts.noTunnel && !flagSet("port", "p") {
+		opts.port = localDaemonPort()
+	}
+	
+	// Show version and exit if requested
+	if showVersion {
+		version.Write(os.Stdout, "warpclip", "WarpClip Remote Client", opts.json)
+		os.Exit(0)
+	}
+	
+	// Show help and exit if requested
+	if showHelp {
+		printHelp()
+		os.Exit(0)
+	}
+
+	if opts.timeout <= 0 {
+		colors.Errorf(os.Stderr, "Error: --timeout must be a positive duration\n")
+		os.Exit(1)
+	}
+
+	if opts.stdinTimeout < 0 {
+		colors.Errorf(os.Stderr, "Error: --stdin-timeout must not be negative\n")
+		os.Exit(1)
+	}
+
+	if opts.waitForTunnel < 0 {
+		colors.Errorf(os.Stderr, "Error: --wait-for-tunnel must not be negative\n")
+		os.Exit(1)
+	}
+
+	// Slow producers like "make | warpclip" legitimately take a while to write
+	// anything, so by default only a terminal gets the stdin timeout
+	if !flagSet("stdin-timeout") && !stdinIsTerminal() {
+		opts.stdinTimeout = 0
+	}
+
+	// Both would write to stdout
+	if opts.tee && opts.json {
+		colors.Errorf(os.Stderr, "Error: --tee cannot be combined with --json\n")
+		os.Exit(1)
+	}
+
+	if opts.verify && follow {
+		colors.Errorf(os.Stderr, "Error: --verify cannot be combined with --follow\n")
+		os.Exit(1)
+	}
+
+	if opts.expectSize && follow {
+		colors.Errorf(os.Stderr, "Error: --expect-size cannot be combined with --follow\n")
+		os.Exit(1)
+	}
+
+	if opts.decodeBase64 && follow {
+		colors.Errorf(os.Stderr, "Error: --decode-base64 cannot be combined with --follow\n")
+		os.Exit(1)
+	}
+
+	// A list from the environment gives way to a single --port
+	if len(opts.ports) == 0 && !flagSet("port", "p") {
+		if value := os.Getenv("WARPCLIP_PORTS"); value != "" {
+			if err := (*portsFlag)(&opts.ports).Set(value); err != nil {
+				colors.Errorf(os.Stderr, "Error: WARPCLIP_PORTS: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Hosts forwarding different ports can share one list, tried in turn
+	// once something is about to connect. The ports chosen below are used
+	// when none of them answers.
+	if len(opts.ports) == 0 && !flagSet("port", "p") && !opts.noTunnel {
+		if value := os.Getenv("WARPCLIP_TRY_PORTS"); value != "" {
+			if err := (*portsFlag)(&opts.tryPorts).Set(value); err != nil {
+				colors.Errorf(os.Stderr, "Error: WARPCLIP_TRY_PORTS: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Then the client profile's, unless WARPCLIP_LOCAL_PORT named the
+	// daemon's port
+	if len(opts.ports) == 0 && !flagSet("port", "p") &&
+		!(opts.noTunnel && os.Getenv("WARPCLIP_LOCAL_PORT") != "") {
+		switch {
+		case len(profile.Ports) > 0:
+			opts.ports = append([]int(nil), profile.Ports...)
+			opts.profilePort = true
+		case profile.Port != 0:
+			opts.port = profile.Port
+			opts.profilePort = true
+		}
+	}
+
+	// Without a port given, use the one ~/.ssh/config forwards to this host
+	if len(opts.ports) == 0 && !flagSet("port", "p") && !opts.noTunnel && !opts.profilePort {
+		if ports := sshForwardedPorts(); len(ports) > 0 {
+			opts.port = ports[0]
+		}
+	}
+
+	if len(opts.ports) > 0 && flagSet("port", "p") && flagSet("ports") {
+		colors.Errorf(os.Stderr, "Error: --ports cannot be combined with --port\n")
+		os.Exit(1)
+	}
+
+	if len(opts.ports) > 1 && follow {
+		colors.Errorf(os.Stderr, "Error: --ports cannot be combined with --follow\n")
+		os.Exit(1)
+	}
+
+	// A list of one is just another way to give the port
+	if len(opts.ports) == 1 {
+		opts.port = opts.ports[0]
+		opts.ports = nil
+	}
+
+	if opts.clearAfter < 0 {
+		colors.Errorf(os.Stderr, "Error: --clear-after must not be negative\n")
+		os.Exit(1)
+	}
+
+	if opts.clearAfter > 0 && follow {
+		colors.Errorf(os.Stderr, "Error: --clear-after cannot be combined with --follow\n")
+		os.Exit(1)
+	}
+
+	if opts.historyTTL < 0 {
+		colors.Errorf(os.Stderr, "Error: --history-ttl must not be negative\n")
+		os.Exit(1)
+	}
+
+	if opts.historyTTL > 0 && follow {
+		colors.Errorf(os.Stderr, "Error: --history-ttl cannot be combined with --follow\n")
+		os.Exit(1)
+	}
+
+	if opts.ifChanged && follow {
+		colors.Errorf(os.Stderr, "Error: --if-changed cannot be combined with --follow\n")
+		os.Exit(1)
+	}
+
+	if opts.contentType != "" && !strings.Contains(opts.contentType, "/") {
+		colors.Errorf(os.Stderr, "Error: --type must be a MIME type such as application/pdf, not %q\n", opts.contentType)
+		os.Exit(1)
+	}
+
+	if opts.contentType != "" && follow {
+		colors.Errorf(os.Stderr, "Error: --type cannot be combined with --follow\n")
+		os.Exit(1)
+	}
+
+	if opts.contentType != "" && opts.ifChanged {
+		colors.Errorf(os.Stderr, "Error: --type cannot be combined with --if-changed\n")
+		os.Exit(1)
+	}
+
+	if opts.inputDeadline < 0 {
+		colors.Errorf(os.Stderr, "Error: --input-deadline must not be negative\n")
+		os.Exit(1)
+	}
+
+	switch onInputDeadline {
+	case "send":
+	case "fail":
+		opts.failOnInputDeadline = true
+	default:
+		colors.Errorf(os.Stderr, "Error: --on-input-deadline must be send or fail, not %q\n", onInputDeadline)
+		os.Exit(1)
+	}
+
+	// Following input is meant to go on for as long as it arrives
+	if opts.inputDeadline > 0 && follow {
+		colors.Errorf(os.Stderr, "Error: --input-deadline cannot be combined with --follow\n")
+		os.Exit(1)
+	}
+
+	if opts.listen < 0 || opts.listen > 65535 {
+		colors.Errorf(os.Stderr, "Error: --listen must be a port from 1 to 65535\n")
+		os.Exit(1)
+	}
+
+	// A pulling daemon takes one copy per connection it makes
+	if opts.listen > 0 && follow {
+		colors.Errorf(os.Stderr, "Error: --listen cannot be combined with --follow\n")
+		os.Exit(1)
+	}
+
+	if opts.listen > 0 && len(opts.ports) > 0 {
+		colors.Errorf(os.Stderr, "Error: --listen cannot be combined with --ports\n")
+		os.Exit(1)
+	}
+
+	// Checking the clipboard first would take a connection of its own
+	if opts.listen > 0 && opts.ifChanged {
+		colors.Errorf(os.Stderr, "Error: --listen cannot be combined with --if-changed\n")
+		os.Exit(1)
+	}
+
+	if opts.listen > 0 && (opts.noTunnel || check) {
+		colors.Errorf(os.Stderr, "Error: --listen cannot be combined with --no-tunnel or --check\n")
+		os.Exit(1)
+	}
+
+	if opts.chunkSize > 0 && follow {
+		colors.Errorf(os.Stderr, "Error: --chunk-size cannot be combined with --follow\n")
+		os.Exit(1)
+	}
+	if opts.lineBuffer && !follow {
+		colors.Errorf(os.Stderr, "Error: --line-buffer only applies to --follow\n")
+		os.Exit(1)
+	}
+
+	// Every chunk is checked on its own, which covers what --expect-size would
+	if opts.chunkSize > 0 && opts.expectSize {
+		colors.Errorf(os.Stderr, "Error: --chunk-size cannot be combined with --expect-size\n")
+		os.Exit(1)
+	}
+
+	// Rewriting line endings would corrupt the binary data base64 usually carries
+	if opts.decodeBase64 && opts.normalizeEOL != eol.None {
+		colors.Errorf(os.Stderr, "Error: --decode-base64 cannot be combined with --normalize-eol\n")
+		os.Exit(1)
+	}
+	
+	// Check for commands
+	if len(flag.Args()) > 0 {
+		cmd := flag.Args()[0]
+		switch cmd {
+		case "help":
+			printHelp()
+			os.Exit(0)
+		case "install-remote":
+			fs := flag.NewFlagSet("warpclip install-remote", flag.ContinueOnError)
+			remoteTmp := fs.String("remote-tmp", "/tmp", "Writable directory on the remote host for the download")
+			jsonSummary := fs.Bool("json", opts.json, "Print a JSON summary to stdout instead of progress messages")
+			if err := fs.Parse(flag.Args()[1:]); err != nil {
+				if err == flag.ErrHelp {
+					os.Exit(0)
+				}
+				os.Exit(1)
+			}
+			if fs.NArg() < 1 {
+				colors.Errorf(os.Stderr, "Error: Missing remote host argument\n")
+				fmt.Fprintf(os.Stderr, "Usage: warpclip install-remote [--remote-tmp DIR] [--json] user@host\n")
+				os.Exit(1)
+			}
+			if *remoteTmp == "" {
+				colors.Errorf(os.Stderr, "Error: --remote-tmp must not be empty\n")
+				os.Exit(1)
+			}
+			host := fs.Arg(0)
+			if *jsonSummary {
+				progressOut = io.Discard
+				remoteOut = io.Discard
+			}
+			res, err := installRemote(host, *remoteTmp)
+			if *jsonSummary {
+				if err != nil {
+					res.Error = err.Error()
+				}
+				json.NewEncoder(os.Stdout).Encode(res)
+				if err != nil {
+					os.Exit(1)
+				}
+				os.Exit(0)
+			}
+			if err != nil {
+				colors.Errorf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			colors.Successf(os.Stderr, "WarpClip successfully installed on the remote host!\n")
+			os.Exit(0)
+		case "verify-remote":
+			if len(flag.Args()) < 2 {
+				colors.Errorf(os.Stderr, "Error: Missing remote host argument\n")
+				fmt.Fprintf(os.Stderr, "Usage: warpclip verify-remote user@host...\n")
+				os.Exit(1)
+			}
+			allOK, err := verifyRemote(flag.Args()[1:])
+			if err != nil {
+				colors.Errorf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			if !allOK {
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case "print-ssh-config":
+			if err := printSSHConfig(opts, flag.Args()[1:]); err != nil {
+				colors.Errorf(os.Stderr, "Error: %v\n", err)
+				os.Exit(exitFailure)
+			}
+			os.Exit(0)
+		case "paste":
+			err := runPaste(opts, flag.Args()[1:])
+			if err == flag.ErrHelp {
+				os.Exit(0)
+			}
+			if err != nil {
+				colors.Errorf(os.Stderr, "Error: %v\n", err)
+			}
+			os.Exit(exitCode(err))
+		case "doctor":
+			code, err := runDoctor(opts, flag.Args()[1:])
+			if err == flag.ErrHelp {
+				os.Exit(0)
+			}
+			if err != nil {
+				colors.Errorf(os.Stderr, "Error: %v\n", err)
+				os.Exit(exitFailure)
+			}
+			os.Exit(code)
+		case "clear":
+			err := runClear(opts, flag.Args()[1:])
+			if err == flag.ErrHelp {
+				os.Exit(0)
+			}
+			if err != nil {
+				colors.Errorf(os.Stderr, "Error: %v\n", err)
+			}
+			os.Exit(exitCode(err))
+		}
+	}
+	
+	if opts.quiet || opts.json {
+		progressOut = io.Discard
+	}
+	if opts.json {
+		errorOut = io.Discard
+	}
+	// A pulled copy doesn't connect to the port at all
+	if opts.listen == 0 {
+		pickTriedPort(&opts)
+	}
+	if check {
+		if opts.json {
+			colors.Errorf(os.Stderr, "Error: --json is not supported by --check\n")
+			os.Exit(exitFailure)
+		}
+		os.Exit(runCheck(opts))
+	}
+	start := time.Now()
+
+	// Input redirected from a file has a size up front, so the daemon can be
+	// told it, while a pipe is only sent in full once it ends. Chunks are
+	// checked on their own.
+	if !follow && opts.chunkSize == 0 {
+		opts.inputSize, opts.sizeKnown = client.InputSize(os.Stdin)
+	}
+
+	// Peeking through a buffered reader leaves the input intact for sending
+	input := bufio.NewReader(os.Stdin)
+	if !follow {
+		if err := waitForInput(input, opts.stdinTimeout); err != nil {
+			err = fmt.Errorf("%w within %v", err, opts.stdinTimeout)
+			colors.Errorf(errorOut, "Error: %v.\n", err)
+			printInputHelp()
+			finish(opts, result{}, err, start)
+		}
+	}
+
+	if follow {
+		fmt.Fprintln(progressOut, "Following input, each line updates the clipboard...")
+	} else {
+		fmt.Fprintln(progressOut, "Sending input to clipboard...")
+	}
+	
+	// Set up signal handling for graceful shutdown
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
+	ctx, stop := client.WithInterrupt(context.Background(), signalCh, func(sig os.Signal) {
+		fmt.Fprintf(errorOut, "\nReceived signal: %v. Canceling operation...\n", sig)
+	})
+	
+	// --tee passes the input on exactly as it arrived, colors and all, while
+	// it is read for copying, so the producer only has to run once
+	var r io.Reader = input
+	if opts.tee {
+		r = io.TeeReader(r, os.Stdout)
+	}
+	// Escape sequences go first, so a color code can't split a CRLF
+	if opts.stripANSI {
+		r = ansi.NewReader(r)
+	}
+	r = eol.NewReader(r, opts.normalizeEOL)
+
+	// Send data from stdin to the clipboard
+	var res result
+	var err error
+	if follow {
+		res, err = followToClipboard(ctx, opts, r)
+	} else {
+		res, err = sendToClipboard(ctx, opts, r)
+	}
+	
+	// Cancel the context in case sendToClipboard returned naturally, waiting
+	// for a signal that arrived to be handled
+	interruptReceived := stop()
+	
+	// Handle the result
+	if interruptReceived {
+		fmt.Fprintln(errorOut, "Operation canceled by user.")
+		finish(opts, res, fmt.Errorf("%w by user", client.ErrCanceled), start)
+	} else if err != nil {
+		colors.Errorf(errorOut, "Error: %v\n", err)
+		fmt.Fprintln(errorOut, "Failed to copy content to clipboard.")
+		finish(opts, res, err, start)
+	}
+	
+	if len(res.Targets) > 0 {
+		colors.Successf(progressOut, "Content copied to %d clipboards successfully! (%s)\n", len(res.Targets), formatSize(res.Bytes))
+	} else if res.Unchanged {
+		fmt.Fprintf(progressOut, "Clipboard already holds this content, nothing copied (%s)\n", formatSize(res.Bytes))
+	} else {
+		colors.Successf(progressOut, "Content copied to clipboard successfully! (%s)\n", formatSize(res.Bytes))
+	}
+	finish(opts, res, nil, start)
+}
+
+// result summarizes a copy for --json output
+type result struct {
+	OK         bool   `json:"ok"`
+	Bytes      int64  `json:"bytes"`
+	Records    int    `json:"records,omitempty"`
+	Backend    string `json:"backend,omitempty"`
+	SHA256     string `json:"sha256,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+	Category   string `json:"category,omitempty"`
+	ClearAt    string `json:"clear_at,omitempty"`
+	// Unchanged is set when --if-changed found the content already copied
+	Unchanged bool `json:"unchanged,omitempty"`
+	// Targets holds each daemon's result when a copy fans out with --ports
+	Targets []targetResult `json:"targets,omitempty"`
+	// TriedPort is the port WARPCLIP_TRY_PORTS chose, when it chose one
+	TriedPort int `json:"tried_port,omitempty"`
+}
+
+// targetResult is the result of a fanned-out copy for one daemon
+type targetResult struct {
+	Port int `json:"port"`
+	result
+}
+
+// finish prints the JSON result when requested and exits with a status
+// matching err
+func finish(opts options, res result, err error, start time.Time) {
+	if opts.json {
+		res.OK = err == nil
+		res.DurationMS = time.Since(start).Milliseconds()
+		if opts.triedPort {
+			res.TriedPort = opts.port
+		}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		json.NewEncoder(os.Stdout).Encode(res)
+	}
+	os.Exit(exitCode(err))
+}
+
+// exitCode returns the exit status for err, 0 when it is nil
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, client.ErrCanceled):
+		return exitInterrupted
+	case errors.Is(err, client.ErrNoInput):
+		return exitNoInput
+	case errors.Is(err, client.ErrNoTunnel):
+		return exitNoTunnel
+	case errors.Is(err, client.ErrTooLarge):
+		return exitTooLarge
+	case errors.Is(err, client.ErrServerRejected):
+		return exitRejected
+	default:
+		return exitFailure
+	}
+}
+
+// formatSize describes a byte count, adding a KB or MB figure for larger
+// sizes, e.g. "5120 bytes, 5.0 KB"
+func formatSize(n int64) string {
+	switch {
+	case n == 1:
+		return "1 byte"
+	case n < 1024:
+		return fmt.Sprintf("%d bytes", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%d bytes, %.1f KB", n, float64(n)/1024)
+	default:
+		return fmt.Sprintf("%d bytes, %.1f MB", n, float64(n)/(1024*1024))
+	}
+}
+
+// stdinIsTerminal reports whether stdin is an interactive terminal
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// waitForInput blocks until r has data or reaches EOF, returning
+// errStdinTimeout if neither happens within timeout. A zero timeout waits
+// forever. The peeked data stays buffered in r.
+func waitForInput(r *bufio.Reader, timeout time.Duration) error {
+	if timeout <= 0 {
+		return nil
+	}
+
+	// Peek can't be interrupted, so leave it blocked in the background if we
+	// give up; the process is about to exit anyway
+	ready := make(chan struct{})
+	go func() {
+		r.Peek(1)
+		close(ready)
+	}()
+
+	select {
+	case <-ready:
+		return nil
+	case <-time.After(timeout):
+		return errStdinTimeout
+	}
+}
+
+// readInput reads input to EOF, or with a deadline, for at most that long.
+// Input still arriving at the deadline is returned as read so far, with
+// errInputDeadline. A read can't be interrupted, so one in progress then is
+// left blocked in the background; the process is about to exit anyway.
+func readInput(ctx context.Context, input io.Reader, deadline time.Duration) ([]byte, error) {
+	if deadline <= 0 {
+		var buf bytes.Buffer
+		_, err := io.Copy(&buf, input)
+		return buf.Bytes(), err
+	}
+	ctx, cancel := context.WithTimeout(ctx, deadline)
+	defer cancel()
+
+	// The reader hands over each chunk before reporting how reading ended
+	chunks := make(chan []byte)
+	done := make(chan error, 1)
+	go func() {
+		for {
+			chunk := make([]byte, 32*1024)
+			n, err := input.Read(chunk)
+			if n > 0 {
+				select {
+				case chunks <- chunk[:n]:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+
+	var buf bytes.Buffer
+	for {
+		select {
+		case chunk := <-chunks:
+			buf.Write(chunk)
+		case err := <-done:
+			if err == io.EOF {
+				err = nil
+			}
+			return buf.Bytes(), err
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return buf.Bytes(), errInputDeadline
+			}
+			return buf.Bytes(), ctx.Err()
+		}
+	}
+}
+
+// printInputHelp explains how to provide input to warpclip
+func printInputHelp() {
+	fmt.Fprintln(errorOut, "Please provide content via stdin.")
+	fmt.Fprintln(errorOut, "Examples:")
+	fmt.Fprintln(errorOut, "  cat file.txt | warpclip")
+	fmt.Fprintln(errorOut, "  echo 'text' | warpclip")
+	fmt.Fprintln(errorOut, "  warpclip < file.txt")
+	colors.Hintf(errorOut, "To empty the clipboard on purpose, run 'warpclip clear'.\n")
+	colors.Hintf(errorOut, "Run 'warpclip --help' for all options.\n")
+}
+
+// sendToClipboard sends data from stdin to the clipboard service
+func sendToClipboard(ctx context.Context, opts options, input io.Reader) (result, error) {
+    var res result
+    // A file too large to copy is refused without reading it. Base64 input
+    // is larger than what it decodes to, and stripping escape sequences or
+    // converting line endings changes the size, so those are checked once
+    // transformed.
+    transformed := opts.decodeBase64 || opts.stripANSI || opts.normalizeEOL != eol.None
+    if opts.sizeKnown && !transformed && opts.inputDeadline <= 0 {
+        if err := client.CheckSize(opts.inputSize, maxDataSize()); err != nil {
+            res.Bytes = opts.inputSize
+            return res, err
+        }
+    }
+
+    // Read all input into a buffer first (simpler and more reliable)
+    data, err := readInput(ctx, input, opts.inputDeadline)
+    switch {
+    case errors.Is(err, errInputDeadline) && opts.failOnInputDeadline:
+        return res, fmt.Errorf("%w after %v (%d bytes read)", err, opts.inputDeadline, len(data))
+    case errors.Is(err, errInputDeadline):
+        fmt.Fprintf(progressOut, "Stopped reading input after %v\n", opts.inputDeadline)
+    case ctx.Err() != nil:
+        return res, client.ErrCanceled
+    case err != nil:
+        return res, fmt.Errorf("error reading stdin: %w", err)
+    }
+    
+    res.Bytes = int64(len(data))
+    
+    // Print debug information
+    fmt.Fprintf(progressOut, "Read %d bytes from stdin\n", len(data))
+    
+    // Verify we have data
+    if len(data) == 0 {
+        colors.Errorf(errorOut, "Error: No input provided. ")
+        printInputHelp()
+        return res, fmt.Errorf("%w: stdin was empty", client.ErrNoInput)
+    }
+
+	if opts.decodeBase64 {
+		decoded, err := decodeBase64(data, maxDataSize())
+		if err != nil {
+			return res, err
+		}
+		fmt.Fprintf(progressOut, "Decoded %d bytes of base64 into %d bytes\n", len(data), len(decoded))
+		data = decoded
+		res.Bytes = int64(len(data))
+	}
+
+	// Fail before connecting rather than have the daemon refuse it
+	if err := client.CheckSize(int64(len(data)), maxDataSize()); err != nil {
+		return res, err
+	}
+
+	if len(opts.ports) > 0 {
+		return fanOut(ctx, opts, data, res)
+	}
+	return sendData(ctx, opts, data, res)
+}
+
+// fanOut sends data to every daemon in opts.ports at once and prints a line
+// for each. It fails if any of them does, with every failure in the error.
+func fanOut(ctx context.Context, opts options, data []byte, res result) (result, error) {
+	fmt.Fprintf(progressOut, "Sending %d bytes to %d daemons...\n", len(data), len(opts.ports))
+
+	// Messages from copies running side by side would interleave, so only
+	// the summary below is printed
+	progress, problems := progressOut, errorOut
+	progressOut, errorOut = io.Discard, io.Discard
+	targets := make([]targetResult, len(opts.ports))
+	failures := make([]error, len(opts.ports))
+	var wg sync.WaitGroup
+	for i, port := range opts.ports {
+		wg.Add(1)
+		go func(i, port int) {
+			defer wg.Done()
+			start := time.Now()
+			target := opts
+			target.port = port
+			target.ports = nil
+			r, err := sendData(ctx, target, data, res)
+			r.OK = err == nil
+			r.DurationMS = time.Since(start).Milliseconds()
+			if err != nil {
+				r.Error = err.Error()
+				failures[i] = fmt.Errorf("port %d: %w", port, err)
+			}
+			targets[i] = targetResult{Port: port, result: r}
+		}(i, port)
+	}
+	wg.Wait()
+	progressOut, errorOut = progress, problems
+
+	failed := 0
+	for _, target := range targets {
+		switch {
+		case target.OK && target.Unchanged:
+			fmt.Fprintf(progressOut, "  Port %d: unchanged, %s already copied\n", target.Port, formatSize(target.Bytes))
+		case target.OK:
+			fmt.Fprintf(progressOut, "  Port %d: copied %s\n", target.Port, formatSize(target.Bytes))
+		default:
+			failed++
+			fmt.Fprintf(errorOut, "  Port %d: failed: %s\n", target.Port, target.Error)
+		}
+	}
+	res.Targets = targets
+	if failed > 0 {
+		return res, fmt.Errorf("%d of %d copies failed: %w", failed, len(targets), errors.Join(failures...))
+	}
+	return res, nil
+}
+
+// sendData copies data through the daemon on opts.port
+func sendData(ctx context.Context, opts options, data []byte, res result) (result, error) {
+	// Set up the connection with timeout, or wait for the daemon to come
+	// and pull the copy
+	var conn *client.Conn
+	var err error
+	if opts.listen > 0 {
+		fmt.Fprintf(progressOut, "Waiting for warpclipd to pull the copy on port %d...\n", opts.listen)
+		conn, err = client.Accept(ctx, opts.listen, opts.timeout)
+	} else {
+		// Check if SSH tunnel is available
+		if !tunnelUp(ctx, opts) {
+			return res, tunnelError(opts)
+		}
+
+		if opts.ifChanged {
+			unchanged, err := clipboardHolds(opts, data)
+			if err != nil {
+				return res, err
+			}
+			if unchanged {
+				res.Unchanged = true
+				return res, nil
+			}
+		}
+
+		conn, err = dial(opts)
+	}
+	if err != nil {
+		return res, err
+	}
+	defer conn.Close()
+
+	// Older servers would copy the chunks, headers and all
+	if opts.chunkSize > 0 && conn.Version < protocol.ChunkedVersion {
+		return res, fmt.Errorf("warpclipd does not support --chunk-size; upgrade it on your local machine")
+	}
+	if opts.historyTTL > 0 && conn.Version < protocol.HistoryTTLVersion {
+		return res, fmt.Errorf("warpclipd does not support --history-ttl; upgrade it on your local machine")
+	}
+	if opts.contentType != "" && conn.Version < protocol.ContentTypeVersion {
+		return res, fmt.Errorf("warpclipd does not support --type; upgrade it on your local machine")
+	}
+	
+	// Set deadlines for writing
+	deadline := time.Now().Add(opts.timeout)
+	if err := conn.SetWriteDeadline(deadline); err != nil {
+		return res, fmt.Errorf("failed to set write deadline: %w", err)
+	}
+	
+	// Ask the server to echo a checksum of what it received
+	if opts.verify {
+		if _, err := conn.Write([]byte(protocol.VerifyDirective)); err != nil {
+			return res, fmt.Errorf("failed to request verification: %w", err)
+		}
+	}
+
+	// Let the server check that the whole payload arrived. Daemons predating
+	// the handshake may also predate the header, so only --expect-size
+	// announces the size to them.
+	if opts.expectSize || (opts.sizeKnown && conn.Version > protocol.LegacyVersion) {
+		if err := protocol.WriteContentLength(conn, int64(len(data))); err != nil {
+			return res, fmt.Errorf("failed to announce payload size: %w", err)
+		}
+	}
+
+	// Have the server clear the clipboard again once the data has been used
+	if opts.clearAfter > 0 {
+		if err := protocol.WriteClearAfter(conn, opts.clearAfter); err != nil {
+			return res, fmt.Errorf("failed to request clearing: %w", err)
+		}
+	}
+
+	// Keep the copy out of the daemon's history once it has served its purpose
+	if opts.historyTTL > 0 {
+		if err := protocol.WriteHistoryTTL(conn, opts.historyTTL); err != nil {
+			return res, fmt.Errorf("failed to request a history TTL: %w", err)
+		}
+	}
+
+	// Have the server put the copy on the clipboard as something other than text
+	if opts.contentType != "" {
+		if err := protocol.WriteContentType(conn, opts.contentType); err != nil {
+			return res, fmt.Errorf("failed to send the content type: %w", err)
+		}
+	}
+
+	if opts.chunkSize > 0 {
+		fmt.Fprintf(progressOut, "Sending %d bytes to clipboard in chunks of %d bytes...\n", len(data), opts.chunkSize)
+	} else {
+		fmt.Fprintf(progressOut, "Sending %d bytes to clipboard...\n", len(data))
+	}
+	ack, err := client.Send(ctx, conn, data, int(opts.chunkSize), opts.timeout)
+	switch {
+	case errors.Is(err, client.ErrCanceled), errors.Is(err, client.ErrNotSent):
+		return res, err
+	case err == io.EOF:
+		// Servers predating acknowledgements close without replying
+		if opts.verify {
+			return res, fmt.Errorf("server did not return a checksum; it may not support --verify")
+		}
+	case err != nil:
+		if opts.verify {
+			return res, fmt.Errorf("could not verify the copy: %w", err)
+		}
+		colors.Warningf(errorOut, "Warning: server did not confirm the copy: %v\n", err)
+	case !ack.OK:
+		res.Category = ack.Category
+		printRemediation(ack.Category)
+		return res, fmt.Errorf("server failed to copy data: %w", client.Rejected(ack))
+	}
+
+	// Report what the server says it copied, falling back to what we sent
+	// for servers that don't acknowledge
+	if ack.OK {
+		res.Bytes = ack.Bytes
+		res.Backend = ack.Backend
+	}
+	if opts.clearAfter > 0 {
+		if ack.ClearAt.IsZero() {
+			colors.Warningf(errorOut, "Warning: server did not schedule a clear; it may not support --clear-after\n")
+		} else {
+			res.ClearAt = ack.ClearAt.Format(time.RFC3339)
+			fmt.Fprintf(progressOut, "Clipboard will clear at %s\n", ack.ClearAt.Local().Format("15:04:05"))
+		}
+	}
+	if opts.verify {
+		res.SHA256 = ack.SHA256
+		return res, verifyChecksum(data, ack.SHA256)
+	}
+
+	return res, nil
+}
+
+// clipboardHolds reports whether the clipboard behind the daemon on
+// opts.port already holds data, comparing SHA-256s so the contents needn't
+// come back. It takes a connection of its own ahead of the copy. A daemon
+// that can't tell, because it is too old or the clipboard can't be read,
+// gets the copy anyway.
+func clipboardHolds(opts options, data []byte) (bool, error) {
+	conn, err := dial(opts)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	// Older servers would send the contents back, or even copy the request
+	if conn.Version < protocol.ClipboardHashVersion {
+		fmt.Fprintf(progressOut, "warpclipd can't report what the clipboard holds, copying anyway\n")
+		return false, nil
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(opts.timeout)); err != nil {
+		return false, fmt.Errorf("failed to set deadline: %w", err)
+	}
+	if _, err := conn.Write([]byte(protocol.VerifyDirective + protocol.PasteDirective)); err != nil {
+		return false, fmt.Errorf("failed to ask for the clipboard's hash: %w", err)
+	}
+	if tcpConn, ok := conn.Conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+
+	ack, err := protocol.ReadAck(conn.Reader)
+	if err != nil {
+		return false, fmt.Errorf("failed to read the clipboard's hash: %w", err)
+	}
+	if !ack.OK {
+		fmt.Fprintf(progressOut, "Could not check the clipboard (%s), copying anyway\n", ack.Error)
+		return false, nil
+	}
+	sum := sha256.Sum256(data)
+	return ack.SHA256 == hex.EncodeToString(sum[:]), nil
+}
+
+// runPaste implements "warpclip paste": it writes the local clipboard to
+// stdout in the requested type, or lists the types it holds
+func runPaste(opts options, args []string) error {
+	name := "warpclip paste"
+	if filepath.Base(os.Args[0]) == PasteCommand {
+		name = PasteCommand
+	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	mimeTypes := fs.String("type", "", "MIME types to accept, most preferred first (default text/plain)")
+	listTypes := fs.Bool("list-types", false, "List the types the clipboard holds instead of pasting")
+	if err := parseSubcommand(fs, &opts, args); err != nil {
+		return err
+	}
+
+	var accept []string
+	for _, t := range strings.Split(*mimeTypes, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			accept = append(accept, t)
+		}
+	}
+	if *listTypes {
+		// Any type will do; only the list in the reply is wanted
+		accept = []string{"*/*"}
+	}
+
+	ack, data, err := pasteFromClipboard(opts, accept)
+	if err != nil {
+		return err
+	}
+
+	if *listTypes {
+		for _, t := range ack.Types {
+			fmt.Println(t)
+		}
+		return nil
+	}
+	if _, err := os.Stdout.Write(data); err != nil {
+		return fmt.Errorf("failed to write clipboard contents: %w", err)
+	}
+	return nil
+}
+
+// parseSubcommand parses the arguments of a subcommand, whose own flags are
+// already in fs. The connection options may follow the subcommand as well as
+// precede it, as they must for warp-paste, and are checked the same way.
+func parseSubcommand(fs *flag.FlagSet, opts *options, args []string) error {
+	fs.IntVar(&opts.port, "port", opts.port, "Specify custom port")
+	fs.IntVar(&opts.port, "p", opts.port, "Specify custom port (shorthand)")
+	fs.DurationVar(&opts.timeout, "timeout", opts.timeout, "Connection and write timeout")
+	fs.BoolVar(&opts.noTunnel, "no-tunnel", opts.noTunnel, "Connect directly to a local daemon instead of an SSH tunnel")
+	noColor := fs.Bool("no-color", false, "Print messages without color (also set by NO_COLOR)")
+	debug := fs.Bool("debug", false, "Print diagnostics, such as the port chosen from WARPCLIP_TRY_PORTS")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *noColor {
+		colors = term.Colors{}
+	}
+	if *debug {
+		debugOut = os.Stderr
+	}
+	portSet := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "port" || f.Name == "p" {
+			portSet = true
+		}
+	})
+	if opts.noTunnel && !portSet && !flagSet("port", "p") && !opts.profilePort {
+		opts.port = localDaemonPort()
+	}
+	if !portSet && !opts.noTunnel {
+		pickTriedPort(opts)
+	}
+	if opts.timeout <= 0 {
+		return fmt.Errorf("--timeout must be a positive duration")
+	}
+	if opts.json {
+		return fmt.Errorf("--json is not supported by %s", strings.TrimPrefix(fs.Name(), "warpclip "))
+	}
+	if opts.quiet {
+		progressOut = io.Discard
+	}
+	return nil
+}
+
+// pickTriedPort connects to each WARPCLIP_TRY_PORTS candidate in turn and
+// uses the first that answers instead of the port otherwise chosen. Each
+// probe is a connection the daemon logs, so only commands about to connect
+// call this.
+func pickTriedPort(opts *options) {
+	if len(opts.tryPorts) == 0 {
+		return
+	}
+	candidates := opts.tryPorts
+	opts.tryPorts = nil
+	port, ok := client.FirstListening(candidates)
+	if !ok {
+		fmt.Fprintf(debugOut, "No port in WARPCLIP_TRY_PORTS answers, using port %d\n", opts.port)
+		return
+	}
+	fmt.Fprintf(debugOut, "Using port %d, the first in WARPCLIP_TRY_PORTS that answers\n", port)
+	opts.port = port
+	opts.ports = nil
+	opts.triedPort = true
+}
+
+// runCheck probes each link between this client and the local clipboard in
+// turn, printing a line per stage, and returns the exit status for the first
+// one that fails: no tunnel, a daemon too old to report its health, or an
+// unhealthy clipboard backend. Nothing is copied.
+func runCheck(opts options) int {
+	if !client.CheckTunnel(opts.port) {
+		tunnelError(opts)
+		return exitNoTunnel
+	}
+	if opts.noTunnel {
+		fmt.Fprintf(progressOut, "Tunnel:    not used, warpclipd is listening on port %d\n", opts.port)
+	} else {
+		fmt.Fprintf(progressOut, "Tunnel:    OK, port %d is forwarded\n", opts.port)
+	}
+
+	conn, err := client.Dial(opts.port, opts.timeout)
+	if err != nil {
+		fmt.Fprintf(errorOut, "Daemon:    PROBLEM, %v\n", err)
+		return exitNoTunnel
+	}
+	defer conn.Close()
+	if conn.Version == protocol.LegacyVersion {
+		fmt.Fprintln(errorOut, "Daemon:    PROBLEM, warpclipd predates version negotiation and can't report")
+		fmt.Fprintln(errorOut, "           its health; upgrade it on your local machine")
+		return exitOutdated
+	}
+	fmt.Fprintf(progressOut, "Daemon:    OK, speaks protocol version %d\n", conn.Version)
+
+	status, err := requestStatus(conn, opts.timeout)
+	switch {
+	case errors.Is(err, protocol.ErrStatusUnsupported):
+		fmt.Fprintln(errorOut, "Clipboard: PROBLEM, warpclipd can't report its health; upgrade it on your")
+		fmt.Fprintln(errorOut, "           local machine")
+		return exitOutdated
+	case err != nil:
+		fmt.Fprintf(errorOut, "Clipboard: PROBLEM, %v\n", err)
+		return exitFailure
+	case status.BackendCategory == protocol.CategoryNoSession:
+		// No later copy can succeed until warpclipd is restarted in the desktop
+		fmt.Fprintln(errorOut, "Clipboard: PROBLEM, warpclipd has no GUI login session to copy in")
+		printRemediation(status.BackendCategory)
+		return exitRejected
+	case status.BackendUnhealthy:
+		fmt.Fprintf(errorOut, "Clipboard: PROBLEM, copies are refused after %d consecutive failures\n", status.BackendFailures)
+		if status.BackendError != "" {
+			fmt.Fprintf(errorOut, "           %s\n", status.BackendError)
+		}
+		return exitRejected
+	case status.BackendFailures > 0:
+		fmt.Fprintf(progressOut, "Clipboard: OK, though the last %d copies failed: %s\n", status.BackendFailures, status.BackendError)
+	default:
+		fmt.Fprintln(progressOut, "Clipboard: OK")
+	}
+	fmt.Fprintln(progressOut, "Ready to copy")
+	return 0
+}
+
+// requestStatus asks the daemon on conn for its status
+func requestStatus(conn *client.Conn, timeout time.Duration) (protocol.Status, error) {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return protocol.Status{}, fmt.Errorf("failed to set deadline: %w", err)
+	}
+	if _, err := conn.Write([]byte(protocol.StatusDirective)); err != nil {
+		return protocol.Status{}, fmt.Errorf("failed to send status request: %w", err)
+	}
+	return protocol.ReadStatus(conn.Reader)
+}
+
+// runDoctor implements "warpclip doctor": beyond what --check confirms, it
+// works out where the port leads. A tunnel set up with LocalForward, or a
+// warpclipd left running on this machine, passes --check while copies land
+// in this machine's clipboard instead of the one you connected from.
+func runDoctor(opts options, args []string) (int, error) {
+	fs := flag.NewFlagSet("warpclip doctor", flag.ContinueOnError)
+	if err := parseSubcommand(fs, &opts, args); err != nil {
+		return 0, err
+	}
+
+	here := getHostname()
+	session, inSSH := currentSSHSession()
+	route := "direct"
+	switch {
+	case opts.noTunnel:
+		fmt.Fprintf(progressOut, "Session:   local on %s, no tunnel\n", here)
+	case inSSH:
+		fmt.Fprintf(progressOut, "Session:   SSH from %s to %s\n", session.clientIP, here)
+		route = "SSH tunnel"
+	default:
+		fmt.Fprintf(progressOut, "Session:   not inside SSH, on %s\n", here)
+	}
+
+	if !client.CheckTunnel(opts.port) {
+		tunnelError(opts)
+		return exitNoTunnel, nil
+	}
+	fmt.Fprintf(progressOut, "Port:      OK, %d accepts connections\n", opts.port)
+
+	// A RemoteForward accepts connections even with nothing behind it
+	conn, err := client.Dial(opts.port, opts.timeout)
+	if err != nil {
+		fmt.Fprintf(errorOut, "Daemon:    PROBLEM, port %d answers but not as warpclipd: %v\n", opts.port, err)
+		colors.Hintf(errorOut, "Start warpclipd on the machine you connect from, and make sure nothing else\n")
+		colors.Hintf(errorOut, "listens on port %d here.\n", opts.port)
+		return exitNoTunnel, nil
+	}
+	defer conn.Close()
+	if conn.Version == protocol.LegacyVersion {
+		fmt.Fprintln(errorOut, "Daemon:    PROBLEM, nothing answered the handshake: either warpclipd")
+		fmt.Fprintln(errorOut, "           predates it, or something else listens on the port")
+		return exitOutdated, nil
+	}
+	fmt.Fprintf(progressOut, "Daemon:    OK, warpclipd speaks protocol version %d\n", conn.Version)
+
+	status, err := requestStatus(conn, opts.timeout)
+	if errors.Is(err, protocol.ErrStatusUnsupported) || err == nil && status.Hostname == "" {
+		fmt.Fprintln(errorOut, "Location:  PROBLEM, warpclipd can't report which machine it runs on;")
+		fmt.Fprintln(errorOut, "           upgrade it on your local machine")
+		return exitOutdated, nil
+	}
+	if err != nil {
+		fmt.Fprintf(errorOut, "Location:  PROBLEM, %v\n", err)
+		return exitFailure, nil
+	}
+	fmt.Fprintf(progressOut, "Topology:  warpclip on %s -> port %d (%s) -> warpclipd on %s\n", here, opts.port, route, status.Hostname)
+
+	// Through a tunnel, a daemon here is the one copies were meant to leave
+	if inSSH && !opts.noTunnel && strings.EqualFold(status.Hostname, here) {
+		fmt.Fprintf(errorOut, "Location:  PROBLEM, port %d leads back to a warpclipd on this machine,\n", opts.port)
+		fmt.Fprintln(errorOut, "           not the one you connected from, so copies land in this")
+		fmt.Fprintln(errorOut, "           machine's clipboard")
+		colors.Hintf(errorOut, "This happens when the tunnel uses LocalForward (ssh -L) instead of\n")
+		colors.Hintf(errorOut, "RemoteForward (ssh -R), or a warpclipd started here holds the port.\n")
+		colors.Hintf(errorOut, "Stop it with 'warpclipd stop' and reconnect with:\n")
+		fmt.Fprintf(errorOut, "  %s\n", sessionTunnel(session, opts.port).command())
+		return exitNoTunnel, nil
+	}
+	fmt.Fprintf(progressOut, "Location:  OK, copies reach the clipboard on %s\n", status.Hostname)
+	return 0, nil
+}
+
+// runClear implements "warpclip clear": it empties the local clipboard. An
+// empty stdin is refused as a likely mistake, so this is the way to do it on
+// purpose.
+func runClear(opts options, args []string) error {
+	fs := flag.NewFlagSet("warpclip clear", flag.ContinueOnError)
+	if err := parseSubcommand(fs, &opts, args); err != nil {
+		return err
+	}
+
+	if !client.CheckTunnel(opts.port) {
+		return tunnelError(opts)
+	}
+	conn, err := dial(opts)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Older servers would copy the directive itself rather than clear
+	if conn.Version < protocol.ClearVersion {
+		return fmt.Errorf("warpclipd does not support clear; upgrade it on your local machine")
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(opts.timeout)); err != nil {
+		return fmt.Errorf("failed to set deadline: %w", err)
+	}
+	if _, err := conn.Write([]byte(protocol.ClearDirective)); err != nil {
+		return fmt.Errorf("failed to send clear request: %w", err)
+	}
+	if tcpConn, ok := conn.Conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+
+	ack, err := protocol.ReadAck(conn.Reader)
+	switch {
+	case err != nil:
+		return fmt.Errorf("server did not confirm the clear: %w", err)
+	case !ack.OK:
+		printRemediation(ack.Category)
+		return fmt.Errorf("server could not clear the clipboard: %w", client.Rejected(ack))
+	}
+	fmt.Fprintln(progressOut, "Clipboard cleared")
+	return nil
+}
+
+// pasteFromClipboard asks the daemon for the clipboard contents in the first
+// of the accepted MIME types the clipboard holds
+func pasteFromClipboard(opts options, accept []string) (protocol.Ack, []byte, error) {
+	if !client.CheckTunnel(opts.port) {
+		return protocol.Ack{}, nil, tunnelError(opts)
+	}
+
+	conn, err := dial(opts)
+	if err != nil {
+		return protocol.Ack{}, nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(opts.timeout)); err != nil {
+		return protocol.Ack{}, nil, fmt.Errorf("failed to set deadline: %w", err)
+	}
+	request := protocol.PasteDirective
+	if len(accept) > 0 {
+		request += protocol.AcceptHeader + strings.Join(accept, ", ") + "\n"
+	}
+	if _, err := conn.Write([]byte(request)); err != nil {
+		return protocol.Ack{}, nil, fmt.Errorf("failed to send paste request: %w", err)
+	}
+	if tcpConn, ok := conn.Conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+
+	ack, data, err := protocol.ReadPaste(conn.Reader)
+	switch {
+	case err == io.EOF, errors.Is(err, protocol.ErrPasteUnsupported):
+		// Old servers copied the request instead, so say what happened
+		return ack, nil, fmt.Errorf("warpclipd does not support paste; upgrade it on your local machine (the request may have replaced the clipboard)")
+	case err != nil:
+		return ack, nil, fmt.Errorf("failed to read clipboard: %w", err)
+	case !ack.OK:
+		printRemediation(ack.Category)
+		return ack, nil, fmt.Errorf("server could not paste: %w", client.Rejected(ack))
+	}
+	return ack, data, nil
+}
+
+// printRemediation suggests a fix for a failure the server categorized. The
+// tunnel works in these cases; the problem is on the local machine.
+func printRemediation(category string) {
+	switch category {
+	case protocol.CategoryNoSession:
+		fmt.Fprintln(errorOut, "warpclipd is running but can't reach your desktop's clipboard. It must run")
+		fmt.Fprintln(errorOut, "inside your login session: on macOS as a LaunchAgent ('warpclipd install-service'),")
+		fmt.Fprintln(errorOut, "not a LaunchDaemon or an SSH login; on Linux with DISPLAY or WAYLAND_DISPLAY set.")
+	case protocol.CategoryBackendMissing:
+		fmt.Fprintln(errorOut, "warpclipd is running but its clipboard program is not installed on your local")
+		fmt.Fprintln(errorOut, "machine. Install it or choose another backend with WARPCLIP_BACKEND.")
+	case protocol.CategoryTimeout:
+		fmt.Fprintln(errorOut, "The clipboard program on your local machine did not finish in time. The system")
+		fmt.Fprintln(errorOut, "may be busy or waiting on a permission prompt; try again.")
+	case protocol.CategoryClientTooOld:
+		fmt.Fprintln(errorOut, "warpclipd on your local machine only accepts newer clients. Install the same")
+		fmt.Fprintln(errorOut, "warpclip version here by running 'warpclip install-remote user@host' locally.")
+	}
+}
+
+// verifyChecksum compares the server's digest of the payload with our own
+func verifyChecksum(data []byte, serverSum string) error {
+	sum := sha256.Sum256(data)
+	localSum := hex.EncodeToString(sum[:])
+
+	if serverSum == "" {
+		return fmt.Errorf("server did not return a checksum; it may not support --verify")
+	}
+	if serverSum != localSum {
+		return fmt.Errorf("checksum mismatch: sent %s, server received %s", localSum, serverSum)
+	}
+
+	fmt.Fprintf(progressOut, "Verified SHA-256: %s\n", localSum)
+	return nil
+}
+
+// followToClipboard sends each line read from stdin as a separate clipboard
+// update over a single connection until stdin is exhausted. With
+// --line-buffer a last line without a newline is dropped, as it may be one a
+// log writer was cut off in the middle of.
+func followToClipboard(ctx context.Context, opts options, input io.Reader) (result, error) {
+	var res result
+	// Check if SSH tunnel is available
+	if !tunnelUp(ctx, opts) {
+		return res, tunnelError(opts)
+	}
 
-func main() {
-	// Define command line flags
-	var port int
-	var showHelp bool
-	var showVersion bool
+	conn, err := dial(opts)
+	if err != nil {
+		return res, err
+	}
+	defer conn.Close()
 
-	flag.IntVar(&port, "port", DefaultPort, "Specify custom port")
-	flag.IntVar(&port, "p", DefaultPort, "Specify custom port (shorthand)")
-	flag.BoolVar(&showHelp, "help", false, "Show help message")
-	flag.BoolVar(&showHelp, "h", false, "Show help message (shorthand)")
-	flag.BoolVar(&showVersion, "version", false, "Show version information")
-	flag.BoolVar(&showVersion, "v", false, "Show version information (shorthand)")
-	
-	// Parse flags
-	flag.Parse()
-	
-	// Show version and exit if requested
-	if showVersion {
-		fmt.Printf("WarpClip Remote Client v%s\n", Version)
-		os.Exit(0)
+	if err := conn.SetWriteDeadline(time.Now().Add(opts.timeout)); err != nil {
+		return res, fmt.Errorf("failed to set write deadline: %w", err)
 	}
-	
-	// Show help and exit if requested
-	if showHelp {
-		printHelp()
-		os.Exit(0)
+	if _, err := conn.Write([]byte(protocol.FollowPreamble)); err != nil {
+		return res, fmt.Errorf("failed to start follow mode: %w", err)
 	}
-	
-	// Check for commands
-	if len(flag.Args()) > 0 {
-		cmd := flag.Args()[0]
-		switch cmd {
-		case "help":
-			printHelp()
-			os.Exit(0)
-		case "install-remote":
-			if len(flag.Args()) < 2 {
-				fmt.Fprintf(os.Stderr, "Error: Missing remote host argument\n")
-				fmt.Fprintf(os.Stderr, "Usage: warpclip install-remote user@host\n")
-				os.Exit(1)
+
+	// Read lines in the background so cancellation isn't blocked on stdin
+	lines := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		reader := bufio.NewReader(input)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if opts.lineBuffer && err == io.EOF && len(line) > 0 {
+				fmt.Fprintf(progressOut, "Dropped an unterminated last line of %d bytes\n", len(line))
+				line = nil
 			}
-			host := flag.Args()[1]
-			if err := installRemote(host); err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
+			if len(line) > 0 {
+				select {
+				case lines <- bytes.TrimRight(line, "\r\n"):
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					readErr <- fmt.Errorf("error reading stdin: %w", err)
+				}
+				return
 			}
-			fmt.Fprintf(os.Stderr, "WarpClip successfully installed on the remote host!\n")
-			os.Exit(0)
 		}
-	}
-	
-// We're going to skip the isEmpty check to avoid consuming stdin data
-// This check was causing problems because it consumed data from stdin
-// that was then not available to sendToClipboard
+	}()
 
-	fmt.Fprintln(os.Stderr, "Sending input to clipboard...")
-	
-	// Set up context with signal handling
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	
-	// Set up signal handling for graceful shutdown
-	signalCh := make(chan os.Signal, 1)
-	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
-	
-	// Create a WaitGroup to ensure we clean up properly
-	var wg sync.WaitGroup
-	
-	// Start a goroutine to handle signals
-	wg.Add(1)
-	var interruptReceived bool
-	go func() {
-		defer wg.Done()
+	for {
 		select {
-		case sig := <-signalCh:
-			fmt.Fprintf(os.Stderr, "\nReceived signal: %v. Canceling operation...\n", sig)
-			interruptReceived = true
-			cancel()
 		case <-ctx.Done():
-			// Context was canceled elsewhere, just exit
+			return res, client.ErrCanceled
+		case line, ok := <-lines:
+			if !ok {
+				select {
+				case err := <-readErr:
+					return res, err
+				default:
+				}
+				fmt.Fprintf(progressOut, "Sent %d records to clipboard\n", res.Records)
+				return res, nil
+			}
+			if len(line) == 0 {
+				continue
+			}
+
+			if err := conn.SetWriteDeadline(time.Now().Add(opts.timeout)); err != nil {
+				return res, fmt.Errorf("failed to set write deadline: %w", err)
+			}
+			if err := protocol.WriteFrame(conn, line); err != nil {
+				return res, fmt.Errorf("failed to send record: %w", err)
+			}
+			res.Records++
+			res.Bytes += int64(len(line))
 		}
-	}()
-	
-	// Send data from stdin to the clipboard
-	err := sendToClipboard(ctx, port)
-	
-	// Cancel the context in case sendToClipboard returned naturally
-	cancel()
-	
-	// Wait for signal handler to complete
-	wg.Wait()
-	
-	// Handle the result
-	if interruptReceived {
-		fmt.Fprintln(os.Stderr, "Operation canceled by user.")
-		os.Exit(1)
-	} else if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		fmt.Fprintln(os.Stderr, "Failed to copy content to clipboard.")
-		os.Exit(1)
 	}
-	
-	fmt.Fprintln(os.Stderr, "Content copied to clipboard successfully!")
 }
 
-// checkTunnel verifies if the SSH tunnel is properly set up
-func checkTunnel(port int) bool {
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("localhost:%d", port), 1*time.Second)
+// dial connects to the daemon, noting when it only speaks the legacy protocol
+func dial(opts options) (*client.Conn, error) {
+	conn, err := client.Dial(opts.port, opts.timeout)
 	if err != nil {
-		return false
+		return nil, err
 	}
-	conn.Close()
-	return true
+	if conn.Version == protocol.LegacyVersion {
+		fmt.Fprintf(progressOut, "warpclipd predates version negotiation, using the legacy protocol\n")
+	}
+	return conn, nil
 }
 
-// isEmpty checks if there is any data available on the reader
-func isEmpty(r io.Reader) bool {
-	// Create a bufio.Reader to peek at the first byte
-	stdin := bufio.NewReader(r)
-	
-	// Try to peek at the first byte
-	_, err := stdin.Peek(1)
-	
-	// If we got an EOF, the input is empty
-	if err == io.EOF {
+// tunnelUp reports whether the tunnel on opts.port is up, waiting up to
+// --wait-for-tunnel for one that isn't yet, as when warpclip runs from a
+// login script before SSH has set up the forward
+func tunnelUp(ctx context.Context, opts options) bool {
+	if client.CheckTunnel(opts.port) {
 		return true
 	}
-	
-	// If we got some other error, we can't determine if it's empty
-	// For safety, assume it's not empty
-	if err != nil {
+	if opts.waitForTunnel <= 0 {
 		return false
 	}
-	
-	// If we got no error, there's at least one byte, so not empty
-	return false
+	fmt.Fprintf(progressOut, "Waiting up to %v for the tunnel on port %d...\n", opts.waitForTunnel, opts.port)
+	return client.WaitForTunnel(ctx, opts.port, opts.waitForTunnel)
 }
 
-// sendToClipboard sends data from stdin to the clipboard service
-func sendToClipboard(ctx context.Context, port int) error {
-    // Read all input into a buffer first (simpler and more reliable)
-    var buf bytes.Buffer
-    _, err := io.Copy(&buf, os.Stdin)
-    if err != nil {
-        return fmt.Errorf("error reading stdin: %w", err)
-    }
-    
-    data := buf.Bytes()
-    
-    // Print debug information
-    fmt.Fprintf(os.Stderr, "Read %d bytes from stdin\n", len(data))
-    
-    // Verify we have data
-    if len(data) == 0 {
-        fmt.Fprintln(os.Stderr, "Error: No input provided. Please provide content via stdin.")
-        fmt.Fprintln(os.Stderr, "Examples:")
-        fmt.Fprintln(os.Stderr, "  cat file.txt | warpclip")
-        fmt.Fprintln(os.Stderr, "  echo 'text' | warpclip")
-        fmt.Fprintln(os.Stderr, "  warpclip < file.txt")
-        return fmt.Errorf("no data received from stdin")
-    }
-    
-    // Check if SSH tunnel is available
-    if !checkTunnel(port) {
-        fmt.Fprintf(os.Stderr, "Error: SSH tunnel not detected on port %d.\n", port)
-        fmt.Fprintln(os.Stderr, "Make sure you connected with SSH using RemoteForward option:")
-        fmt.Fprintf(os.Stderr, "  ssh -R %d:localhost:8888 user@%s\n", port, getHostname())
-        fmt.Fprintln(os.Stderr, "")
-        fmt.Fprintln(os.Stderr, "Or add to your ~/.ssh/config:")
-        fmt.Fprintf(os.Stderr, "  Host %s\n", getHostname())
-        fmt.Fprintf(os.Stderr, "      RemoteForward %d localhost:8888\n", port)
-        return fmt.Errorf("SSH tunnel not available")
-    }
-	
-	// Set up the connection with timeout
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("localhost:%d", port), Timeout)
-	if err != nil {
-		return fmt.Errorf("failed to connect to localhost:%d: %w", port, err)
+// tunnelError prints SSH tunnel setup advice and returns the matching error.
+// Without a tunnel the only thing that can be missing is the daemon itself.
+func tunnelError(opts options) error {
+	port := opts.port
+	if opts.noTunnel {
+		colors.Errorf(errorOut, "Error: warpclipd is not running on port %d.\n", port)
+		colors.Hintf(errorOut, "Start the daemon on this machine with:\n")
+		fmt.Fprintln(errorOut, "  warpclipd start")
+		return fmt.Errorf("%w: daemon not running", client.ErrNoTunnel)
 	}
-	defer conn.Close()
-	
-	// Set deadlines for writing
-	deadline := time.Now().Add(Timeout)
-	if err := conn.SetWriteDeadline(deadline); err != nil {
-		return fmt.Errorf("failed to set write deadline: %w", err)
+
+	colors.Errorf(errorOut, "Error: SSH tunnel not detected on port %d.\n", port)
+
+	session, inSSH := currentSSHSession()
+	if !inSSH {
+		// Without an SSH session there is nothing a RemoteForward could attach to
+		colors.Hintf(errorOut, "This shell is not running inside an SSH session, so no reverse tunnel can exist.\n")
+		colors.Hintf(errorOut, "Run warpclip on a host you reached with ssh, or use --no-tunnel to talk to\n")
+		colors.Hintf(errorOut, "a warpclipd running on this machine.\n")
+		return fmt.Errorf("%w: SSH tunnel not available", client.ErrNoTunnel)
 	}
-	
-	// Write data directly for simplicity
-    fmt.Fprintf(os.Stderr, "Sending %d bytes to clipboard...\n", len(data))
-    if _, err := conn.Write(data); err != nil {
-        return fmt.Errorf("failed to write data: %w", err)
-    }
-	
-	// Try to close write side if this is a TCPConn
-	if tcpConn, ok := conn.(*net.TCPConn); ok {
-		tcpConn.CloseWrite()
+
+	tunnel := sessionTunnel(session, port)
+	colors.Hintf(errorOut, "Make sure you connected with SSH using RemoteForward option:\n")
+	fmt.Fprintf(errorOut, "  %s\n", tunnel.command())
+	fmt.Fprintln(errorOut, "")
+	colors.Hintf(errorOut, "Or add to your ~/.ssh/config on the machine you connect from\n")
+	colors.Hintf(errorOut, "(warpclip print-ssh-config prints this block):\n")
+	for _, line := range strings.SplitAfter(tunnel.config(), "\n") {
+		if line != "" {
+			fmt.Fprintf(errorOut, "  %s", line)
+		}
 	}
-	
-	// Wait for either completion or context cancellation
-	select {
-	case <-ctx.Done():
-		return fmt.Errorf("operation canceled")
+	return fmt.Errorf("%w: SSH tunnel not available", client.ErrNoTunnel)
+}
+
+// sshSession describes the SSH connection this client is running under
+type sshSession struct {
+	clientIP   string
+	serverIP   string
+	serverPort string
+}
+
+// currentSSHSession parses SSH_CONNECTION ("client_ip client_port server_ip
+// server_port"), falling back to the older SSH_CLIENT ("client_ip client_port
+// server_port"). It reports false when not running under sshd.
+func currentSSHSession() (sshSession, bool) {
+	if fields := strings.Fields(os.Getenv("SSH_CONNECTION")); len(fields) == 4 {
+		return sshSession{clientIP: fields[0], serverIP: fields[2], serverPort: fields[3]}, true
+	}
+	if fields := strings.Fields(os.Getenv("SSH_CLIENT")); len(fields) == 3 {
+		return sshSession{clientIP: fields[0], serverPort: fields[2]}, true
+	}
+	return sshSession{}, false
+}
+
+// sshTunnel is the SSH setup that forwards warpclip's port on a remote host
+// to warpclipd on the machine the user connects from
+type sshTunnel struct {
+	// host is the name the user connects to, used as the Host pattern
+	host string
+	// user and hostName are only set when they differ from what host implies
+	user     string
+	hostName string
+	// sshPort is the port sshd listens on, empty for the default
+	sshPort string
+	// remotePort is the port warpclip connects to on the remote host
+	remotePort int
+	// daemonPort is the port warpclipd listens on
+	daemonPort int
+}
+
+// newTunnel returns the tunnel for connecting to host, which may be given
+// as user@host, with the daemon on its configured port
+func newTunnel(host string, remotePort int) sshTunnel {
+	tunnel := sshTunnel{host: host, remotePort: remotePort, daemonPort: localDaemonPort()}
+	if user, name, ok := strings.Cut(host, "@"); ok {
+		tunnel.user, tunnel.host = user, name
+	}
+	return tunnel
+}
+
+// sessionTunnel returns the tunnel the current SSH session should have had
+func sessionTunnel(session sshSession, remotePort int) sshTunnel {
+	// The address the user connected to is more useful than our own hostname,
+	// which often isn't resolvable from the user's machine
+	tunnel := newTunnel(getHostname(), remotePort)
+	if session.serverIP != "" && session.serverIP != tunnel.host {
+		tunnel.hostName = session.serverIP
+	}
+	if session.serverPort != "22" {
+		tunnel.sshPort = session.serverPort
+	}
+	return tunnel
+}
+
+// command returns the ssh command line that sets up the tunnel
+func (t sshTunnel) command() string {
+	cmd := fmt.Sprintf("ssh -R %d:localhost:%d", t.remotePort, t.daemonPort)
+	if t.sshPort != "" {
+		cmd += " -p " + t.sshPort
+	}
+	target := t.host
+	if t.hostName != "" {
+		target = t.hostName
+	}
+	user := t.user
+	if user == "" {
+		user = "user"
+	}
+	return cmd + " " + user + "@" + target
+}
+
+// config returns the ~/.ssh/config block that sets up the tunnel
+func (t sshTunnel) config() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Host %s\n", t.host)
+	if t.hostName != "" {
+		fmt.Fprintf(&b, "    HostName %s\n", t.hostName)
+	}
+	if t.user != "" {
+		fmt.Fprintf(&b, "    User %s\n", t.user)
+	}
+	if t.sshPort != "" {
+		fmt.Fprintf(&b, "    Port %s\n", t.sshPort)
+	}
+	fmt.Fprintf(&b, "    RemoteForward %d localhost:%d\n", t.remotePort, t.daemonPort)
+	return b.String()
+}
+
+// printSSHConfig prints the ~/.ssh/config block for reaching host, or for the
+// current SSH session when no host is given
+func printSSHConfig(opts options, args []string) error {
+	// --no-tunnel swaps the default port for the daemon's, which is not the
+	// port to forward
+	remotePort := opts.port
+	if opts.noTunnel && !flagSet("port", "p") {
+		remotePort = DefaultPort
+	}
+
+	var tunnel sshTunnel
+	switch len(args) {
+	case 0:
+		session, inSSH := currentSSHSession()
+		if !inSSH {
+			return fmt.Errorf("not running inside an SSH session; name the host you connect to, e.g. warpclip print-ssh-config myserver")
+		}
+		tunnel = sessionTunnel(session, remotePort)
+	case 1:
+		tunnel = newTunnel(args[0], remotePort)
 	default:
-		// Operation completed successfully
+		return fmt.Errorf("print-ssh-config takes at most one host, got %d", len(args))
+	}
+	fmt.Print(tunnel.config())
+	return nil
+}
+
+// sshForwardedPorts returns the ports an ssh config on this machine forwards
+// here with RemoteForward, for users who share it between machines
+func sshForwardedPorts() []int {
+	home, err := os.UserHomeDir()
+	if err != nil {
 		return nil
 	}
+	// An unreadable config is no reason to fail a copy; the default port
+	// still applies
+	ports, _ := client.ForwardedPorts(filepath.Join(home, ".ssh", "config"), hostNames())
+	return ports
+}
+
+// hostNames returns the names this host goes by, which ssh config Host
+// lines and client profiles are matched against: its hostname, short and in
+// full, and the address the current SSH session connected to
+func hostNames() []string {
+	hosts := []string{getHostname()}
+	if short, _, ok := strings.Cut(hosts[0], "."); ok {
+		hosts = append(hosts, short)
+	}
+	if session, ok := currentSSHSession(); ok && session.serverIP != "" {
+		hosts = append(hosts, session.serverIP)
+	}
+	return hosts
+}
+
+// localDaemonPort returns the port the local daemon listens on
+func localDaemonPort() int {
+	if port, err := strconv.Atoi(os.Getenv("WARPCLIP_LOCAL_PORT")); err == nil {
+		return port
+	}
+	return DefaultLocalPort
+}
+
+// maxDataSize returns the largest payload the daemon accepts, assuming it
+// shares this machine's WARPCLIP_MAX_DATA_SIZE, or else that the client
+// profile's max_data_size matches it
+func maxDataSize() int64 {
+	if size, err := config.ParseSize(os.Getenv("WARPCLIP_MAX_DATA_SIZE")); err == nil && size > 0 {
+		return size
+	}
+	if profileMaxDataSize > 0 {
+		return profileMaxDataSize
+	}
+	return DefaultMaxDataSize
+}
+
+// profileMaxDataSize is the client profile's max_data_size (zero if unset)
+var profileMaxDataSize int64
+
+// selectProfile loads the client config file and returns the profile called
+// name or, without a name, the first matching this host. No file, or no
+// match, is the zero profile, which changes nothing.
+func selectProfile(name string) (client.Profile, error) {
+	path, err := client.ProfilesPath()
+	if err != nil {
+		if name != "" {
+			return client.Profile{}, err
+		}
+		return client.Profile{}, nil
+	}
+	profiles, err := client.LoadProfiles(path)
+	if err != nil {
+		return client.Profile{}, fmt.Errorf("failed to read client config: %w", err)
+	}
+	profile, _, err := client.SelectProfile(profiles, name, hostNames())
+	if err != nil {
+		return client.Profile{}, fmt.Errorf("%w in %s", err, path)
+	}
+	return profile, nil
+}
+
+// applyProfile sets the options the profile gives and no flag or
+// environment variable does. Ports are left to the port selection in main,
+// as they sit between the environment's and ~/.ssh/config's.
+func applyProfile(opts *options, profile client.Profile) {
+	if profile.NoTunnel && !flagSet("no-tunnel") && os.Getenv("WARPCLIP_NO_TUNNEL") == "" {
+		opts.noTunnel = true
+	}
+	if profile.Timeout > 0 && !flagSet("timeout") {
+		opts.timeout = profile.Timeout
+	}
+	if profile.WaitForTunnel > 0 && !flagSet("wait-for-tunnel") {
+		opts.waitForTunnel = profile.WaitForTunnel
+	}
+	profileMaxDataSize = profile.MaxDataSize
+}
+
+// decodeBase64 decodes base64 input, ignoring the line breaks and other
+// whitespace encoders wrap it with, and accepting it with or without padding.
+// Decoded data larger than limit is refused.
+func decodeBase64(data []byte, limit int64) ([]byte, error) {
+	text := strings.Join(strings.Fields(string(data)), "")
+	encoding := base64.StdEncoding
+	if len(text)%4 != 0 {
+		encoding = base64.RawStdEncoding
+	}
+
+	decoded, err := encoding.DecodeString(text)
+	if err != nil {
+		return nil, fmt.Errorf("input is not valid base64: %w", err)
+	}
+	if len(decoded) == 0 {
+		return nil, fmt.Errorf("%w: base64 input decoded to nothing", client.ErrNoInput)
+	}
+	if int64(len(decoded)) > limit {
+		return nil, fmt.Errorf("%w: decoded input is %d bytes, exceeding the maximum of %d bytes", client.ErrTooLarge, len(decoded), limit)
+	}
+	return decoded, nil
+}
+
+// flagSet reports whether any of the named flags was given on the command line
+func flagSet(names ...string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		for _, name := range names {
+			if f.Name == name {
+				set = true
+			}
+		}
+	})
+	return set
+}
+
+// portsFlag parses a comma-separated list of ports for --ports
+type portsFlag []int
+
+func (f *portsFlag) String() string {
+	ports := make([]string, len(*f))
+	for i, port := range *f {
+		ports[i] = strconv.Itoa(port)
+	}
+	return strings.Join(ports, ",")
+}
+
+func (f *portsFlag) Set(value string) error {
+	var ports []int
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		port, err := strconv.Atoi(field)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %q", field)
+		}
+		ports = append(ports, port)
+	}
+	if len(ports) == 0 {
+		return fmt.Errorf("no ports given")
+	}
+	*f = ports
+	return nil
+}
+
+// sizeFlag lets --chunk-size be given in bytes or with a unit, like 64KB
+type sizeFlag int64
+
+func (f *sizeFlag) String() string {
+	return strconv.FormatInt(int64(*f), 10)
+}
+
+func (f *sizeFlag) Set(value string) error {
+	size, err := config.ParseSize(value)
+	if err != nil {
+		return err
+	}
+	*f = sizeFlag(size)
+	return nil
+}
+
+// eolFlag lets --normalize-eol be given bare (meaning lf) or with a mode
+type eolFlag eol.Mode
+
+func (f *eolFlag) String() string {
+	return eol.Mode(*f).String()
+}
+
+func (f *eolFlag) Set(value string) error {
+	// A bare flag arrives as "true"
+	if value == "true" {
+		value = "lf"
+	} else if value == "false" {
+		value = "none"
+	}
+	mode, err := eol.ParseMode(value)
+	if err != nil {
+		return err
+	}
+	*f = eolFlag(mode)
+	return nil
+}
+
+func (f *eolFlag) IsBoolFlag() bool {
+	return true
+}
+
+// envBool reports whether the environment variable is set to a true value
+func envBool(name string) bool {
+	value, err := strconv.ParseBool(os.Getenv(name))
+	return err == nil && value
 }
 
 // getHostname returns the hostname of the current system
@@ -244,41 +1949,184 @@ func getHostname() string {
 
 // printHelp prints the help message
 func printHelp() {
-	fmt.Printf("WarpClip Remote Client v%s\n", Version)
+	fmt.Printf("WarpClip Remote Client v%s\n", version.Version)
 	fmt.Println("Usage: cat file.txt | warpclip [options]")
 	fmt.Println("   or: warpclip [options] < file.txt")
-	fmt.Println("   or: warpclip install-remote user@host")
+	fmt.Println("   or: warpclip paste [--type MIME[,MIME...]] [--list-types]")
+	fmt.Println("   or: warp-paste [--type MIME[,MIME...]] [--list-types] [options]")
+	fmt.Println("   or: warpclip install-remote [--remote-tmp DIR] [--json] user@host")
+	fmt.Println("   or: warpclip verify-remote user@host...")
+	fmt.Println("   or: warpclip doctor [options]")
+	fmt.Println("   or: warpclip print-ssh-config [user@]host")
 	fmt.Println("")
 	fmt.Println("Commands:")
+	fmt.Println("  paste                Write the local clipboard to stdout")
+	fmt.Println("    --type MIME        Paste as the first listed type the clipboard holds,")
+	fmt.Println("                       e.g. --type image/png (default: text/plain); fails")
+	fmt.Println("                       if none of them is present. Other image formats")
+	fmt.Println("                       are converted when PNG is asked for")
+	fmt.Println("    --list-types       List the types the clipboard holds")
+	fmt.Println("                       Run as warp-paste (a link to warpclip), the binary")
+	fmt.Println("                       always pastes, e.g. warp-paste > file.txt")
+	fmt.Println("  clear                Empty the local clipboard; plain empty input is")
+	fmt.Println("                       refused as a mistake instead")
 	fmt.Println("  install-remote HOST  Install warpclip on a remote host")
+	fmt.Println("    --remote-tmp DIR   Download into DIR on the host instead of /tmp, e.g.")
+	fmt.Println("                       ~/.cache where /tmp is noexec or full")
+	fmt.Println("    --json             Print one JSON summary to stdout (host, os, version,")
+	fmt.Println("                       installed, method) instead of progress messages")
+	fmt.Println("  doctor               Check where the port leads: that warpclipd answers")
+	fmt.Println("                       there and runs on the machine you connected from,")
+	fmt.Println("                       not this one, as a LocalForward would make it")
+	fmt.Println("  verify-remote HOST...")
+	fmt.Println("                       Report whether each host has the latest warpclip")
+	fmt.Println("                       release (OK), an older one (outdated) or none")
+	fmt.Println("                       (missing), without installing anything")
+	fmt.Println("  print-ssh-config [HOST]")
+	fmt.Println("                       Print the ~/.ssh/config block that forwards --port on")
+	fmt.Println("                       HOST to warpclipd ($WARPCLIP_LOCAL_PORT, default 8888);")
+	fmt.Println("                       inside an SSH session HOST defaults to this machine")
 	fmt.Println("  help                 Show this help message")
 	fmt.Println("")
 	fmt.Println("Options:")
-	fmt.Println("  --port, -p PORT      Specify custom port (default: 9999)")
+	fmt.Println("  --port, -p PORT      Specify custom port (default: the RemoteForward port")
+	fmt.Println("                       ~/.ssh/config gives this host, else 9999)")
+	fmt.Println("  --ports LIST         Copy to several daemons at once, e.g. 9999,9998, each")
+	fmt.Println("                       reached through its own forwarded port; fails if any")
+	fmt.Println("                       copy does")
+	fmt.Println("  --timeout DURATION   Connection and write timeout (default: 5s)")
+	fmt.Println("  --verify             Confirm the data arrived intact (SHA-256 echo)")
+	fmt.Println("  --if-changed         Skip the copy when the clipboard already holds the")
+	fmt.Println("                       input, compared by SHA-256 (one extra round trip)")
+	fmt.Println("  --type MIME          Copy the input as MIME instead of text, e.g.")
+	fmt.Println("                       application/pdf, or text/uri-list for file URLs that")
+	fmt.Println("                       paste into Finder as the files (macOS daemons only)")
+	fmt.Println("  --expect-size        Announce the payload size so a truncated transfer is")
+	fmt.Println("                       reported as an error instead of copied; automatic")
+	fmt.Println("                       when stdin is redirected from a file")
+	fmt.Println("  --normalize-eol[=MODE]")
+	fmt.Println("                       Convert line endings before copying: lf (the default")
+	fmt.Println("                       when given bare) turns CRLF into LF, crlf does the reverse")
+	fmt.Println("  --strip-ansi         Remove ANSI escape sequences such as colors, so")
+	fmt.Println("                       colorized terminal output copies as plain text")
+	fmt.Println("  --decode-base64      Treat the input as base64 and copy the bytes it")
+	fmt.Println("                       encodes, e.g. an image encoded upstream")
+	fmt.Println("  --clear-after DURATION")
+	fmt.Println("                       Have warpclipd clear the clipboard after DURATION,")
+	fmt.Println("                       e.g. 30s for a password; a newer copy cancels the clear")
+	fmt.Println("                       and the copy leaves warpclipd's history at the same time")
+	fmt.Println("  --history-ttl DURATION")
+	fmt.Println("                       Have warpclipd drop the copy from its history after")
+	fmt.Println("                       DURATION, e.g. 1h, whether or not the clipboard is cleared")
+	fmt.Println("  --chunk-size SIZE    Send the payload in chunks of SIZE, e.g. 64KB, each")
+	fmt.Println("                       checked and acknowledged, resending any that arrive")
+	fmt.Println("                       corrupt or go unacknowledged for --timeout")
+	fmt.Println("  --stdin-timeout DURATION")
+	fmt.Println("                       Give up if no input arrives in time (default: 5s when")
+	fmt.Println("                       stdin is a terminal, otherwise wait; 0 waits forever)")
+	fmt.Println("  --input-deadline DURATION")
+	fmt.Println("                       Stop reading input after DURATION and copy what")
+	fmt.Println("                       arrived, e.g. for tail -f (default: 0, read until EOF)")
+	fmt.Println("  --on-input-deadline send|fail")
+	fmt.Println("                       Fail instead of copying input cut off by the deadline")
+	fmt.Println("  --wait-for-tunnel DURATION")
+	fmt.Println("                       Keep checking for a tunnel that isn't up yet, e.g. in a")
+	fmt.Println("                       login script, before giving up (default: 0, fail at once)")
+	fmt.Println("  --no-tunnel          Connect directly to warpclipd on this machine")
+	fmt.Println("                       (default port 8888, or $WARPCLIP_LOCAL_PORT)")
+	fmt.Println("  --check              Check the tunnel, the daemon and its clipboard, then")
+	fmt.Println("                       exit without copying (status 3, 6 or 4 names the")
+	fmt.Println("                       stage that failed)")
+	fmt.Println("  --follow             Send each input line as a separate clipboard update")
+	fmt.Println("                       over one connection (e.g. fswatch file | warpclip --follow)")
+	fmt.Println("  --line-buffer        With --follow, send only complete lines, each as soon")
+	fmt.Println("                       as its newline arrives, dropping an unterminated last")
+	fmt.Println("                       line (e.g. tail -f app.log | warpclip --follow")
+	fmt.Println("                       --line-buffer); set WARPCLIP_DEBOUNCE on the daemon so")
+	fmt.Println("                       busy logs don't thrash the clipboard")
+	fmt.Println("  --listen PORT        Wait on PORT for warpclipd to pull the copy through a")
+	fmt.Println("                       forward tunnel (ssh -L) instead of sending it; for")
+	fmt.Println("                       daemons started with WARPCLIP_PULL_FROM")
+	fmt.Println("  --tee, --copy-and-print")
+	fmt.Println("                       Also write the input to stdout as it is read, so")
+	fmt.Println("                       output can be watched and copied in one run")
+	fmt.Println("  --profile NAME       Use this profile from the client config file rather")
+	fmt.Println("                       than the first whose hosts match this machine")
+	fmt.Println("  --quiet, -q          Only print errors")
+	fmt.Println("  --debug              Print diagnostics to stderr, such as the port chosen")
+	fmt.Println("                       from WARPCLIP_TRY_PORTS")
+	fmt.Println("  --no-color           Print messages without color, as when NO_COLOR is")
+	fmt.Println("                       set or stderr isn't a terminal")
+	fmt.Println("  --json               Print one JSON result object to stdout instead of")
+	fmt.Println("                       messages, e.g. {\"ok\":true,\"bytes\":12,...}; with")
+	fmt.Println("                       --version, the version, commit and build date")
 	fmt.Println("  --help, -h           Show this help message")
 	fmt.Println("")
+	fmt.Println("Environment:")
+	fmt.Println("  WARPCLIP_NO_TUNNEL=1 Same as --no-tunnel")
+	fmt.Println("  WARPCLIP_SILENT=1    Same as --quiet; --quiet=false turns it back off")
+	fmt.Println("  WARPCLIP_PORTS       Default for --ports; --port overrides it")
+	fmt.Println("  WARPCLIP_TRY_PORTS   Ports to try in order, e.g. 9999,9998, using the first")
+	fmt.Println("                       that answers; --port and --ports override it")
+	fmt.Println("  WARPCLIP_MAX_DATA_SIZE")
+	fmt.Println("                       The daemon's size limit, checked against decoded")
+	fmt.Println("                       --decode-base64 input (default: 1048576)")
+	fmt.Println("  WARPCLIP_CLIENT_CONFIG")
+	fmt.Println("                       Client config file of profiles (default:")
+	fmt.Println("                       ~/.config/warpclip/client.toml); flags and the")
+	fmt.Println("                       variables above override its settings")
+	fmt.Println("")
+	fmt.Println("Exit status:")
+	fmt.Println("  0                    Success")
+	fmt.Println("  1                    Any other failure, including bad options")
+	fmt.Println("  2                    No input: stdin was empty or nothing arrived in time")
+	fmt.Println("  3                    No tunnel: warpclipd is not reachable")
+	fmt.Println("  4                    warpclipd rejected the request, e.g. no clipboard")
+	fmt.Println("  5                    Input too large for warpclipd")
+	fmt.Println("  6                    --check: warpclipd is too old to report its health")
+	fmt.Println("  130                  Interrupted")
+	fmt.Println("")
 	fmt.Println("WarpClip copies content from the remote server to your local macOS clipboard")
 	fmt.Println("via a secure SSH tunnel. Make sure you connected with port forwarding enabled.")
 }
 
-// installRemote installs warpclip on a remote host
-func installRemote(host string) error {
+// installResult summarizes an installation for install-remote --json
+type installResult struct {
+    Host      string `json:"host"`
+    OS        string `json:"os,omitempty"`
+    Version   string `json:"version,omitempty"`
+    Installed bool   `json:"installed"`
+    // Method is "binary" for a release download or "brew" for Homebrew
+    Method string `json:"method,omitempty"`
+    Error  string `json:"error,omitempty"`
+}
+
+// installRemote installs warpclip on a remote host, downloading it into a
+// directory under remoteTmp, and reports what it installed
+func installRemote(host, remoteTmp string) (installResult, error) {
+    res := installResult{Host: host}
+
     // First, detect the remote OS
     osType, err := detectRemoteOS(host)
     if err != nil {
-        return fmt.Errorf("failed to detect remote OS: %w", err)
+        return res, fmt.Errorf("failed to detect remote OS: %w", err)
     }
+    res.OS = strings.ToLower(osType)
 
-    fmt.Fprintf(os.Stderr, "Detected remote OS: %s\n", osType)
+    fmt.Fprintf(progressOut, "Detected remote OS: %s\n", osType)
 
     switch osType {
     case "Linux":
-        return installLinuxRemote(host)
+        res.Method = "binary"
+        res.Version, err = installLinuxRemote(host, remoteTmp)
     case "Darwin":
-        return installDarwinRemote(host)
+        res.Method = "brew"
+        res.Version, err = installDarwinRemote(host)
     default:
-        return fmt.Errorf("unsupported remote OS: %s", osType)
+        return res, fmt.Errorf("unsupported remote OS: %s", osType)
     }
+    res.Installed = err == nil
+    return res, err
 }
 
 // detectRemoteOS determines the OS type of the remote host
@@ -291,6 +2139,80 @@ func detectRemoteOS(host string) (string, error) {
     return strings.TrimSpace(string(output)), nil
 }
 
+// sshUnreachable is the status ssh exits with when it can't reach the host,
+// as opposed to the remote command failing
+const sshUnreachable = 255
+
+// verifyRemote reports for each host whether warpclip is installed and up to
+// date with the latest release, reporting whether all of them are
+func verifyRemote(hosts []string) (bool, error) {
+	release, err := getLatestRelease()
+	if err != nil {
+		return false, err
+	}
+	latest := strings.TrimPrefix(release.TagName, "v")
+
+	allOK := true
+	for _, host := range hosts {
+		status, err := remoteInstallStatus(host, latest)
+		if err != nil {
+			status = fmt.Sprintf("error (%v)", err)
+		}
+		if !strings.HasPrefix(status, "OK") {
+			allOK = false
+		}
+		fmt.Printf("%s: %s\n", host, status)
+	}
+	return allOK, nil
+}
+
+// remoteInstallStatus returns "OK", "outdated" or "missing" for the
+// warpclip installed on host, with the version found where there is one
+func remoteInstallStatus(host, latest string) (string, error) {
+	err := executeRemoteCommand(host, "command -v warpclip >/dev/null")
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() != sshUnreachable {
+		return "missing", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to reach host: %w", err)
+	}
+
+	installed, err := remoteVersion(host)
+	if err != nil {
+		return "", err
+	}
+	if version.Compare(installed, latest) < 0 {
+		return fmt.Sprintf("outdated (v%s, latest v%s)", installed, latest), nil
+	}
+	return fmt.Sprintf("OK (v%s)", installed), nil
+}
+
+// remoteVersion returns the version of the warpclip installed on host
+func remoteVersion(host string) (string, error) {
+	// Releases before --version only print the version atop --help
+	output, err := exec.Command("ssh", host, "warpclip --version 2>/dev/null || warpclip --help 2>&1 | head -n 1").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run warpclip --version: %w", err)
+	}
+	installed := versionIn(string(output))
+	if installed == "" {
+		return "", fmt.Errorf("no version in %q", strings.TrimSpace(string(output)))
+	}
+	return installed, nil
+}
+
+// versionIn returns the version from warpclip's version line, such as
+// "2.1.11" from "WarpClip Remote Client v2.1.11 (commit ...)"
+func versionIn(line string) string {
+	for _, field := range strings.Fields(line) {
+		if len(field) > 1 && field[0] == 'v' && field[1] >= '0' && field[1] <= '9' {
+			return field[1:]
+		}
+	}
+	return ""
+}
+
 // Release represents a GitHub release
 type Release struct {
 	TagName string `json:"tag_name"`
@@ -301,26 +2223,32 @@ type Release struct {
 }
 
 // installLinuxRemote installs warpclip on a Linux remote host
-func installLinuxRemote(host string) error {
-    fmt.Fprintf(os.Stderr, "Installing warpclip on Linux host %s...\n", host)
+func installLinuxRemote(host, remoteTmp string) (string, error) {
+    fmt.Fprintf(progressOut, "Installing warpclip on Linux host %s...\n", host)
 
     // Check if already installed
     if checkRemoteFile(host, "/usr/local/bin/warpclip") {
-        fmt.Fprintf(os.Stderr, "WarpClip is already installed. Updating...\n")
+        fmt.Fprintf(progressOut, "WarpClip is already installed. Updating...\n")
+    }
+
+    // Make sure the download has somewhere to go before fetching anything
+    tmpBase := remotePath(remoteTmp)
+    if err := executeRemoteCommand(host, fmt.Sprintf("test -d %s -a -w %s", tmpBase, tmpBase)); err != nil {
+        return "", fmt.Errorf("remote directory %s is missing or not writable; choose another with --remote-tmp", remoteTmp)
     }
 
     // Create temporary directory on remote host
-    tmpDir := fmt.Sprintf("/tmp/warpclip-%d", time.Now().UnixNano())
+    tmpDir := remotePath(fmt.Sprintf("%s/warpclip-%d", strings.TrimRight(remoteTmp, "/"), time.Now().UnixNano()))
     if err := executeRemoteCommand(host, fmt.Sprintf("mkdir -p %s", tmpDir)); err != nil {
-        return fmt.Errorf("failed to create temporary directory: %w", err)
+        return "", fmt.Errorf("failed to create temporary directory: %w", err)
     }
     defer executeRemoteCommand(host, fmt.Sprintf("rm -rf %s", tmpDir)) // Clean up
 
     // Fetch latest release info from GitHub
-    fmt.Fprintf(os.Stderr, "Fetching latest release from GitHub...\n")
+    fmt.Fprintf(progressOut, "Fetching latest release from GitHub...\n")
     releaseInfo, err := getLatestRelease()
     if err != nil {
-        return fmt.Errorf("failed to fetch release info: %w", err)
+        return "", fmt.Errorf("failed to fetch release info: %w", err)
     }
 
     // Find Linux binary in assets
@@ -333,28 +2261,28 @@ func installLinuxRemote(host string) error {
     }
     
     if downloadURL == "" {
-        return fmt.Errorf("could not find Linux binary in release assets")
+        return "", fmt.Errorf("could not find Linux binary in release assets")
     }
 
     // Download the binary to the remote host
-    fmt.Fprintf(os.Stderr, "Downloading binary from GitHub release: %s\n", downloadURL)
+    fmt.Fprintf(progressOut, "Downloading binary from GitHub release: %s\n", downloadURL)
     downloadCmd := fmt.Sprintf("curl -L '%s' -o %s/warpclip", downloadURL, tmpDir)
     if err := executeRemoteCommand(host, downloadCmd); err != nil {
-        return fmt.Errorf("failed to download binary: %w", err)
+        return "", fmt.Errorf("failed to download binary: %w", err)
     }
 
     // Verify download was successful
     if err := executeRemoteCommand(host, fmt.Sprintf("test -f %s/warpclip", tmpDir)); err != nil {
-        return fmt.Errorf("binary download appears to have failed: %w", err)
+        return "", fmt.Errorf("binary download appears to have failed: %w", err)
     }
     
     // Calculate and verify checksum (if available)
     checksumResult, err := verifyBinaryChecksum(host, tmpDir, releaseInfo.TagName)
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Warning: Checksum verification failed: %v\n", err)
-        fmt.Fprintf(os.Stderr, "Continuing with installation anyway...\n")
+        colors.Warningf(progressOut, "Warning: Checksum verification failed: %v\n", err)
+        fmt.Fprintf(progressOut, "Continuing with installation anyway...\n")
     } else if checksumResult {
-        fmt.Fprintf(os.Stderr, "Checksum verification successful\n")
+        colors.Successf(progressOut, "Checksum verification successful\n")
     }
 
     // Install commands (adjusted for fish shell compatibility)
@@ -362,28 +2290,29 @@ func installLinuxRemote(host string) error {
         "sudo mkdir -p /usr/local/bin",
         fmt.Sprintf("sudo mv %s/warpclip /usr/local/bin/warpclip", tmpDir),
         "sudo chmod +x /usr/local/bin/warpclip",
+        "sudo ln -sf /usr/local/bin/warpclip /usr/local/bin/" + PasteCommand,
     }
 
     // Execute commands
     for _, cmd := range commands {
-        fmt.Fprintf(os.Stderr, "Running: %s\n", cmd)
+        fmt.Fprintf(progressOut, "Running: %s\n", cmd)
         if err := executeRemoteCommand(host, cmd); err != nil {
-            return fmt.Errorf("installation failed during command '%s': %w", cmd, err)
+            return "", fmt.Errorf("installation failed during command '%s': %w", cmd, err)
         }
     }
 
     // Verify installation
     if err := executeRemoteCommand(host, "which warpclip"); err != nil {
-        return fmt.Errorf("installation verification failed: %w", err)
+        return "", fmt.Errorf("installation verification failed: %w", err)
     }
 
     // Verify version
-    if err := executeRemoteCommand(host, "warpclip --help | grep -q 'v" + Version + "'"); err != nil {
-        return fmt.Errorf("version verification failed: binary might be corrupted")
+    if err := executeRemoteCommand(host, "warpclip --help | grep -q 'v" + version.Version + "'"); err != nil {
+        return "", fmt.Errorf("version verification failed: binary might be corrupted")
     }
 
-    fmt.Fprintf(os.Stderr, "Successfully installed warpclip v%s on %s\n", Version, host)
-    return nil
+    colors.Successf(progressOut, "Successfully installed warpclip v%s on %s\n", version.Version, host)
+    return version.Version, nil
 }
 
 // getLatestRelease fetches the latest release information from GitHub
@@ -469,17 +2398,17 @@ func verifyBinaryChecksum(host, tmpDir, version string) (bool, error) {
 }
 
 // installDarwinRemote installs warpclip on a macOS remote host
-func installDarwinRemote(host string) error {
-    fmt.Fprintf(os.Stderr, "Installing warpclip on macOS host %s...\n", host)
+func installDarwinRemote(host string) (string, error) {
+    fmt.Fprintf(progressOut, "Installing warpclip on macOS host %s...\n", host)
 
     // Check if Homebrew is installed
     hasHomebrew, err := checkRemoteHomebrew(host)
     if err != nil {
-        return err
+        return "", err
     }
 
     if !hasHomebrew {
-        return fmt.Errorf("Homebrew not found on remote macOS host. Please install Homebrew first")
+        return "", fmt.Errorf("Homebrew not found on remote macOS host. Please install Homebrew first")
     }
 
     // Install via Homebrew
@@ -490,14 +2419,20 @@ func installDarwinRemote(host string) error {
     }
 
     for _, cmd := range commands {
-        fmt.Fprintf(os.Stderr, "Running: %s\n", cmd)
+        fmt.Fprintf(progressOut, "Running: %s\n", cmd)
         if err := executeRemoteCommand(host, cmd); err != nil {
-            return fmt.Errorf("installation failed: %w", err)
+            return "", fmt.Errorf("installation failed: %w", err)
         }
     }
 
-    fmt.Fprintf(os.Stderr, "Successfully installed warpclip on %s\n", host)
-    return nil
+    colors.Successf(progressOut, "Successfully installed warpclip on %s\n", host)
+
+    // Homebrew picks the version, so ask the binary which it is
+    installed, err := remoteVersion(host)
+    if err != nil {
+        colors.Warningf(progressOut, "Warning: Couldn't read the installed version: %v\n", err)
+    }
+    return installed, nil
 }
 
 // checkRemoteHomebrew checks if Homebrew is installed on the remote host
@@ -509,11 +2444,28 @@ func checkRemoteHomebrew(host string) (bool, error) {
 // executeRemoteCommand executes a command on the remote host
 func executeRemoteCommand(host, command string) error {
     cmd := exec.Command("ssh", host, command)
-    cmd.Stdout = os.Stdout
-    cmd.Stderr = os.Stderr
+    cmd.Stdout = remoteOut
+    cmd.Stderr = progressOut
     return cmd.Run()
 }
 
+// remotePath quotes path for the remote shell, leaving a leading ~ for the
+// remote home directory
+func remotePath(path string) string {
+    if path == "~" {
+        return `"$HOME"`
+    }
+    if rest, ok := strings.CutPrefix(path, "~/"); ok {
+        return `"$HOME"/` + shellQuote(rest)
+    }
+    return shellQuote(path)
+}
+
+// shellQuote quotes s as a single-quoted shell word
+func shellQuote(s string) string {
+    return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
 // checkRemoteFile checks if a file exists on the remote host
 func checkRemoteFile(host, path string) bool {
     err := executeRemoteCommand(host, fmt.Sprintf("test -f %s", path))
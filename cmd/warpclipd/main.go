@@ -1,25 +1,52 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/mquinnv/warpclip/v2/internal/config"
+	"github.com/mquinnv/warpclip/v2/internal/history"
 	"github.com/mquinnv/warpclip/v2/internal/log"
+	"github.com/mquinnv/warpclip/v2/internal/protocol"
 	"github.com/mquinnv/warpclip/v2/internal/server"
+	"github.com/mquinnv/warpclip/v2/internal/service"
+	"github.com/mquinnv/warpclip/v2/internal/term"
+	"github.com/mquinnv/warpclip/v2/internal/version"
 )
 
-const Version = "2.1.11"
+// colors prints errors in color when stderr is a terminal
+var colors term.Colors
 
 func main() {
 	// Define the command line flags
 	versionFlag := flag.Bool("version", false, "Show version information")
+	jsonFlag := flag.Bool("json", false, "Show version information or capabilities as JSON")
 	helpFlag := flag.Bool("help", false, "Show help message")
+	debugFlag := flag.Bool("debug", false, "Log at every level, overriding WARPCLIP_SILENT")
+	var overrides config.Overrides
+	flag.IntVar(&overrides.Port, "port", 0, "Port to listen on, overriding WARPCLIP_LOCAL_PORT")
+	flag.Var((*sizeFlag)(&overrides.MaxDataSize), "max-size", "Maximum payload size, such as 1048576 or 10MB, overriding WARPCLIP_MAX_DATA_SIZE")
+	flag.StringVar(&overrides.LogFile, "log-file", "", "Log file, overriding WARPCLIP_LOG_FILE")
+	sinceFlag := flag.String("since", "", "Only show history from this long ago (e.g. 1h, 7d) or this date on")
+	limitFlag := flag.Int("limit", 0, "Only show this many of the newest history entries")
+	formatFlag := flag.String("format", "table", "History output format: table, json or csv")
+	logsFlag := flag.Bool("logs", false, "Also remove the log files when resetting")
+	yesFlag := flag.Bool("yes", false, "Reset without asking for confirmation")
+	noColorFlag := flag.Bool("no-color", false, "Print errors without color (also set by NO_COLOR)")
 	
 	// Parse command line arguments
 	flag.Parse()
@@ -28,11 +55,19 @@ func main() {
 	command := "start" // Default command
 	if flag.NArg() > 0 {
 		command = flag.Arg(0)
+		// Flags may also follow the command, as in "warpclipd start --port 8890"
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
+	colors = term.ForStderr(*noColorFlag)
+	if flag.NArg() > 0 {
+		colors.Errorf(os.Stderr, "Unexpected argument: %s\n", flag.Arg(0))
+		showHelp()
+		os.Exit(1)
 	}
 	
 	// Handle version flag
 	if *versionFlag {
-		fmt.Printf("warpclipd v%s\n", Version)
+		version.Write(os.Stdout, "warpclipd", "warpclipd", *jsonFlag)
 		return
 	}
 	
@@ -41,11 +76,43 @@ func main() {
 		showHelp()
 		return
 	}
+
+	if command != "history" && (*sinceFlag != "" || *limitFlag != 0) {
+		colors.Errorf(os.Stderr, "--since and --limit only apply to the history command\n")
+		os.Exit(1)
+	}
+	if *limitFlag < 0 {
+		colors.Errorf(os.Stderr, "--limit must not be negative\n")
+		os.Exit(1)
+	}
+	switch *formatFlag {
+	case "table", "json", "csv":
+	default:
+		colors.Errorf(os.Stderr, "--format must be table, json or csv, not %q\n", *formatFlag)
+		os.Exit(1)
+	}
+	if command != "history" && *formatFlag != "table" {
+		colors.Errorf(os.Stderr, "--format only applies to the history command\n")
+		os.Exit(1)
+	}
+	if command != "reset" && (*logsFlag || *yesFlag) {
+		colors.Errorf(os.Stderr, "--logs and --yes only apply to the reset command\n")
+		os.Exit(1)
+	}
 	
 	// Initialize configuration
 	cfg, err := config.Load()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+		colors.Errorf(os.Stderr, "Error loading configuration: %v\n", err)
+		os.Exit(1)
+	}
+	
+	// Flags are the more specific request, so they win over the environment
+	if *debugFlag {
+		cfg.Silent = false
+	}
+	if err := cfg.Apply(overrides); err != nil {
+		colors.Errorf(os.Stderr, "Error loading configuration: %v\n", err)
 		os.Exit(1)
 	}
 	
@@ -56,14 +123,23 @@ func main() {
 	case "stop":
 		stopServer(cfg)
 	case "restart":
-		stopServer(cfg)
-		startServer(cfg)
+		restartServer(cfg)
 	case "status":
 		showStatus(cfg)
+	case "top":
+		showTop(cfg)
+	case "capabilities":
+		showCapabilities(cfg, *jsonFlag)
+	case "history":
+		showHistory(cfg, *sinceFlag, *limitFlag, *formatFlag)
+	case "install-service":
+		installService(cfg)
+	case "reset":
+		resetState(cfg, *logsFlag, *yesFlag)
 	case "version":
-		fmt.Printf("warpclipd v%s\n", Version)
+		version.Write(os.Stdout, "warpclipd", "warpclipd", *jsonFlag)
 	default:
-		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", command)
+		colors.Errorf(os.Stderr, "Unknown command: %s\n", command)
 		showHelp()
 		os.Exit(1)
 	}
@@ -71,30 +147,47 @@ func main() {
 
 func startServer(cfg *config.Config) {
 	// Initialize logger
-	logger, err := log.New(cfg.LogFile)
+	logger, err := log.NewWithOptions(cfg.LogFile, log.Options{NoDebugLog: cfg.NoDebugLog})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error initializing logger: %v\n", err)
+		colors.Errorf(os.Stderr, "Error initializing logger: %v\n", err)
 		os.Exit(1)
 	}
 	defer logger.Close()
 
+	redactor, err := log.NewRedactor(cfg.Redact)
+	if err != nil {
+		colors.Errorf(os.Stderr, "Error initializing logger: %v\n", err)
+		os.Exit(1)
+	}
+	logger.SetRedactor(redactor)
+	if cfg.Silent {
+		logger.SetLevel(log.WARNING)
+	}
+	logger.SetMirrorErrorsToStderr(!cfg.NoStderrMirror)
+
 	logger.Info("Starting warpclipd")
 
 	// Create and start the server
-	srv := server.New(cfg, logger)
+	srv, err := server.New(cfg, logger)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Server error: %v", err))
+		os.Exit(1)
+	}
 
 	// Set up signal handling for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	signalCh := make(chan os.Signal, 1)
+	signalCh := make(chan os.Signal, 2)
 	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signalCh)
 
-	go func() {
-		sig := <-signalCh
-		logger.Info(fmt.Sprintf("Received signal: %v", sig))
-		cancel()
-	}()
+	// A forced exit skips the deferred cleanup, so do what matters of it here
+	go srv.WatchSignals(signalCh, cancel, func(code int) {
+		removePidFiles(cfg)
+		logger.Close()
+		os.Exit(code)
+	})
 
 	// Start the server
 	if err := srv.Start(ctx); err != nil {
@@ -105,17 +198,28 @@ func startServer(cfg *config.Config) {
 	logger.Info("Server shutdown complete")
 }
 
+// stopWait is how long 'warpclipd stop' waits for the daemon to exit
+const stopWait = 2500 * time.Millisecond
+
 func stopServer(cfg *config.Config) {
+	if !stopDaemon(cfg, stopWait) {
+		fmt.Println("Server may still be running, consider using 'kill -9' if needed")
+	}
+}
+
+// stopDaemon sends SIGTERM to the daemon recorded in the PID file and waits
+// up to wait for it to exit, reporting whether it is no longer running
+func stopDaemon(cfg *config.Config, wait time.Duration) bool {
 	// Check if PID file exists
 	if _, err := os.Stat(cfg.PidFile); os.IsNotExist(err) {
 		fmt.Println("Server is not running (no PID file found)")
-		return
+		return true
 	}
 	
 	// Read PID from file
 	pidBytes, err := os.ReadFile(cfg.PidFile)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading PID file: %v\n", err)
+		colors.Errorf(os.Stderr, "Error reading PID file: %v\n", err)
 		os.Exit(1)
 	}
 	
@@ -123,42 +227,84 @@ func stopServer(cfg *config.Config) {
 	pid := 0
 	_, err = fmt.Sscanf(string(pidBytes), "%d", &pid)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Invalid PID in PID file: %v\n", err)
+		colors.Errorf(os.Stderr, "Invalid PID in PID file: %v\n", err)
 		os.Exit(1)
 	}
 	
 	// Send SIGTERM to process
 	process, err := os.FindProcess(pid)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error finding process with PID %d: %v\n", pid, err)
+		colors.Errorf(os.Stderr, "Error finding process with PID %d: %v\n", pid, err)
 		os.Exit(1)
 	}
 	
-	fmt.Printf("Stopping warpclipd (PID: %d)...\n", pid)
+	if info, ok := pidInfo(cfg); ok {
+		fmt.Printf("Stopping warpclipd (PID: %d, port %d)...\n", pid, info.Port)
+	} else {
+		fmt.Printf("Stopping warpclipd (PID: %d)...\n", pid)
+	}
 	
 	// Send signal
 	err = process.Signal(syscall.SIGTERM)
+	if errors.Is(err, os.ErrProcessDone) || errors.Is(err, syscall.ESRCH) {
+		// A daemon that was killed leaves its PID file behind
+		fmt.Println("Server is not running (removed stale PID file)")
+		removePidFiles(cfg)
+		return true
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error sending signal to process: %v\n", err)
+		colors.Errorf(os.Stderr, "Error sending signal to process: %v\n", err)
 		os.Exit(1)
 	}
 	
-	// Wait briefly for process to terminate
+	// Wait for process to terminate
 	fmt.Println("Waiting for process to terminate...")
-	for i := 0; i < 5; i++ {
+	for deadline := time.Now().Add(wait); ; {
 		// Check if process still exists
 		if err := process.Signal(syscall.Signal(0)); err != nil {
 			fmt.Println("Server stopped successfully")
 			// Remove PID file if it still exists
-			os.Remove(cfg.PidFile)
-			return
+			removePidFiles(cfg)
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
 		}
 		
 		// Wait a bit
-		time.Sleep(500 * time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
 	}
-	
-	fmt.Println("Server may still be running, consider using 'kill -9' if needed")
+}
+
+// restartServer stops the running daemon, waits for it to exit, then
+// replaces this process with a fresh 'warpclipd start' given the same flags
+// and environment. The old daemon finishes its open connections first, so
+// the wait allows for its whole shutdown timeout.
+func restartServer(cfg *config.Config) {
+	wait := cfg.ShutdownTimeout
+	if wait <= 0 {
+		wait = config.DefaultShutdownTimeout
+	}
+	if !stopDaemon(cfg, wait+stopWait) {
+		colors.Errorf(os.Stderr, "Error: warpclipd did not exit within %v; not starting another\n", wait+stopWait)
+		os.Exit(1)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		colors.Errorf(os.Stderr, "Error finding the warpclipd executable: %v\n", err)
+		os.Exit(1)
+	}
+	// Flags may come before or after the command, so pass on the ones given
+	args := []string{os.Args[0], "start"}
+	flag.Visit(func(f *flag.Flag) {
+		args = append(args, fmt.Sprintf("--%s=%s", f.Name, f.Value))
+	})
+
+	fmt.Println("Starting warpclipd...")
+	err = syscall.Exec(executable, args, os.Environ())
+	colors.Errorf(os.Stderr, "Error starting warpclipd: %v\n", err)
+	os.Exit(1)
 }
 
 func showStatus(cfg *config.Config) {
@@ -171,7 +317,7 @@ func showStatus(cfg *config.Config) {
 	// Read PID from file
 	pidBytes, err := os.ReadFile(cfg.PidFile)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading PID file: %v\n", err)
+		colors.Errorf(os.Stderr, "Error reading PID file: %v\n", err)
 		os.Exit(1)
 	}
 	
@@ -179,7 +325,7 @@ func showStatus(cfg *config.Config) {
 	pid := 0
 	_, err = fmt.Sscanf(string(pidBytes), "%d", &pid)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Invalid PID in PID file: %v\n", err)
+		colors.Errorf(os.Stderr, "Invalid PID in PID file: %v\n", err)
 		os.Exit(1)
 	}
 	
@@ -198,7 +344,37 @@ func showStatus(cfg *config.Config) {
 	}
 	
 	fmt.Printf("Server status: Running (PID: %d)\n", pid)
-	fmt.Printf("Listening on: %s:%d\n", cfg.BindAddress, cfg.Port)
+	if info, ok := pidInfo(cfg); ok {
+		fmt.Printf("Listening on: %s:%d\n", info.BindAddress, info.Port)
+		if info.Port != cfg.Port {
+			fmt.Printf("  (started with a different port than this environment's %d)\n", cfg.Port)
+		}
+		fmt.Printf("Started: %s (up %v)\n", info.Started.Local().Format("2006-01-02 15:04:05"), time.Since(info.Started).Round(time.Second))
+	} else {
+		fmt.Printf("Listening on: %s:%d\n", cfg.BindAddress, cfg.Port)
+	}
+
+	// Show connection counters if the daemon reports them
+	status, err := queryStatus(cfg)
+	switch {
+	case err == nil:
+		fmt.Printf("Connections: %d accepted, %d active, %d queued, %d dropped\n",
+			status.Accepted, status.Active, status.Queued, status.Dropped)
+		fmt.Printf("Clipboard: %s\n", backendHealth(status))
+		if status.BackendError != "" {
+			fmt.Printf("  Last error: %s\n", status.BackendError)
+		}
+		if !status.ClearAt.IsZero() {
+			fmt.Printf("  Clipboard will clear at %s\n", status.ClearAt.Local().Format("15:04:05"))
+		}
+		if status.CopyLatency != nil {
+			fmt.Printf("  Write latency: %s\n", formatLatency(status.CopyLatency))
+		}
+	case errors.Is(err, protocol.ErrStatusUnsupported):
+		fmt.Println("Connections: unavailable (restart the daemon to upgrade it)")
+	default:
+		fmt.Printf("Connections: unavailable (%v)\n", err)
+	}
 	
 	// Show last clipboard activity if available
 	if _, err := os.Stat(cfg.LastFile); err == nil {
@@ -212,29 +388,539 @@ func showStatus(cfg *config.Config) {
 	fmt.Println("\nLog file: " + cfg.LogFile)
 }
 
+// backendHealth summarizes the clipboard backend's state from status
+func backendHealth(status protocol.Status) string {
+	switch {
+	case status.BackendCategory == protocol.CategoryNoSession:
+		return "no GUI login session; run warpclipd under your desktop session, not over SSH"
+	case status.BackendUnhealthy:
+		return fmt.Sprintf("unhealthy, refusing copies after %d consecutive failures", status.BackendFailures)
+	case status.BackendFailures > 0:
+		return fmt.Sprintf("%d consecutive failures", status.BackendFailures)
+	default:
+		return "healthy"
+	}
+}
+
+// topInterval is how often warpclipd top redraws
+const topInterval = time.Second
+
+// showTop displays the running daemon's status, redrawing it every second
+// until interrupted. Without a terminal to redraw on it prints one snapshot.
+func showTop(cfg *config.Config) {
+	if !stdoutIsTerminal() {
+		status, err := queryStatus(cfg)
+		if err != nil {
+			colors.Errorf(os.Stderr, "Error querying warpclipd: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(renderTop(cfg, status, nil, time.Now()))
+		return
+	}
+
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
+	ticker := time.NewTicker(topInterval)
+	defer ticker.Stop()
+
+	// Hide the cursor while redrawing and bring it back on the way out
+	fmt.Print("\033[?25l")
+	defer fmt.Print("\033[?25h")
+	for {
+		status, err := queryStatus(cfg)
+		// Move to the top left and clear the screen before each frame
+		fmt.Print("\033[H\033[2J" + renderTop(cfg, status, err, time.Now()))
+		fmt.Println("\nPress Ctrl-C to quit")
+		select {
+		case <-signalCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// renderTop formats one frame of warpclipd top. err is the failure to
+// query the daemon, if any.
+func renderTop(cfg *config.Config, status protocol.Status, err error, now time.Time) string {
+	var b strings.Builder
+	address, port := daemonAddress(cfg)
+	fmt.Fprintf(&b, "warpclipd on %s:%d at %s\n", address, port, now.Format("15:04:05"))
+	fmt.Fprintln(&b)
+
+	switch {
+	case errors.Is(err, protocol.ErrStatusUnsupported):
+		fmt.Fprintln(&b, "The daemon doesn't report its status; restart it to upgrade")
+		return b.String()
+	case err != nil:
+		fmt.Fprintf(&b, "Not responding: %v\n", err)
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "Connections  %d active, %d queued, %d accepted, %d dropped\n",
+		status.Active, status.Queued, status.Accepted, status.Dropped)
+	fmt.Fprintf(&b, "Copies       %d, %s in total\n", status.Copies, formatBytes(status.Bytes))
+	if status.LastCopy.IsZero() {
+		fmt.Fprintln(&b, "Last copy    never")
+	} else {
+		fmt.Fprintf(&b, "Last copy    %s (%v ago)\n", status.LastCopy.Local().Format("15:04:05"), now.Sub(status.LastCopy).Round(time.Second))
+	}
+	fmt.Fprintf(&b, "Clipboard    %s\n", backendHealth(status))
+	if status.BackendError != "" {
+		fmt.Fprintf(&b, "             %s\n", status.BackendError)
+	}
+	if !status.ClearAt.IsZero() {
+		fmt.Fprintf(&b, "             will clear at %s\n", status.ClearAt.Local().Format("15:04:05"))
+	}
+	if status.CopyLatency != nil {
+		fmt.Fprintf(&b, "Latency      %s\n", formatLatency(status.CopyLatency))
+	}
+	return b.String()
+}
+
+// formatLatency summarizes recent clipboard write durations on one line
+func formatLatency(l *protocol.Latency) string {
+	return fmt.Sprintf("avg %.1fms, p50 %.1fms, p95 %.1fms, max %.1fms over the last %d writes",
+		l.AvgMS, l.P50MS, l.P95MS, l.MaxMS, l.Samples)
+}
+
+// formatBytes returns n as a human-readable size
+func formatBytes(n uint64) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%d bytes", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	default:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+	}
+}
+
+// sizeFlag lets --max-size be given in bytes or with a unit, like
+// WARPCLIP_MAX_DATA_SIZE
+type sizeFlag int64
+
+func (f *sizeFlag) String() string {
+	return strconv.FormatInt(int64(*f), 10)
+}
+
+func (f *sizeFlag) Set(value string) error {
+	size, err := config.ParseSize(value)
+	if err != nil {
+		return err
+	}
+	*f = sizeFlag(size)
+	return nil
+}
+
+// stdoutIsTerminal reports whether stdout is an interactive terminal
+func stdoutIsTerminal() bool {
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// showCapabilities prints what the running daemon supports, or with
+// jsonOut the same as one JSON object
+func showCapabilities(cfg *config.Config, jsonOut bool) {
+	caps, err := queryCapabilities(cfg)
+	if err != nil {
+		colors.Errorf(os.Stderr, "Error querying warpclipd: %v\n", err)
+		os.Exit(1)
+	}
+	if jsonOut {
+		json.NewEncoder(os.Stdout).Encode(caps)
+		return
+	}
+
+	fmt.Printf("Protocol:     version %d (this warpclipd speaks %d)\n", caps.Version, protocol.Version)
+	features := "none"
+	if len(caps.Features) > 0 {
+		features = strings.Join(caps.Features, ", ")
+	}
+	fmt.Printf("Features:     %s\n", features)
+	if caps.Version < protocol.CapsVersion {
+		fmt.Println("Details:      unavailable (restart the daemon to upgrade it)")
+		return
+	}
+	fmt.Printf("Backend:      %s\n", caps.Backend)
+	if caps.WriteOnly {
+		fmt.Println("Paste:        no, the backend can only write to the clipboard")
+	} else {
+		fmt.Println("Paste:        yes")
+	}
+	fmt.Printf("Copy types:   %s\n", strings.Join(caps.CopyTypes, ", "))
+	fmt.Printf("Max size:     %s\n", formatBytes(uint64(caps.MaxDataSize)))
+	if caps.MinClientVersion > 0 {
+		fmt.Printf("Clients:      protocol version %d or newer\n", caps.MinClientVersion)
+	} else {
+		fmt.Println("Clients:      any protocol version")
+	}
+}
+
+// queryCapabilities asks the running daemon what it supports. Daemons
+// predating CapsVersion only report their protocol version, so just the
+// features it implies are filled in.
+func queryCapabilities(cfg *config.Config) (protocol.Capabilities, error) {
+	host, port := daemonAddress(cfg)
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	conn, err := net.DialTimeout("tcp", address, time.Second)
+	if err != nil {
+		return protocol.Capabilities{}, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	version, err := protocol.Handshake(conn, reader, 2*time.Second)
+	if errors.Is(err, protocol.ErrHandshakeUnsupported) {
+		protocol.Abandon(conn)
+		version = protocol.LegacyVersion
+	} else if err != nil {
+		return protocol.Capabilities{}, err
+	}
+	if version < protocol.CapsVersion {
+		return protocol.Capabilities{Version: version, Features: protocol.Features(version)}, nil
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		return protocol.Capabilities{}, fmt.Errorf("failed to set deadline: %w", err)
+	}
+	if _, err := conn.Write([]byte(protocol.CapsDirective)); err != nil {
+		return protocol.Capabilities{}, fmt.Errorf("failed to send capabilities request: %w", err)
+	}
+	return protocol.ReadCapabilities(reader)
+}
+
+// queryStatus asks the running daemon for its connection counters
+func queryStatus(cfg *config.Config) (protocol.Status, error) {
+	host, port := daemonAddress(cfg)
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	conn, err := net.DialTimeout("tcp", address, time.Second)
+	if err != nil {
+		return protocol.Status{}, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		return protocol.Status{}, fmt.Errorf("failed to set deadline: %w", err)
+	}
+	if _, err := conn.Write([]byte(protocol.StatusDirective)); err != nil {
+		return protocol.Status{}, fmt.Errorf("failed to send status request: %w", err)
+	}
+	return protocol.ReadStatus(bufio.NewReader(conn))
+}
+
+// showHistory prints the recorded copies, optionally only those since a
+// time and at most limit of the newest
+func showHistory(cfg *config.Config, since string, limit int, format string) {
+	var from time.Time
+	if since != "" {
+		var err error
+		if from, err = history.ParseSince(since, time.Now()); err != nil {
+			colors.Errorf(os.Stderr, "Error: --since: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	entries, err := history.Read(cfg.HistoryFile)
+	if err != nil {
+		colors.Errorf(os.Stderr, "Error reading history: %v\n", err)
+		os.Exit(1)
+	}
+	// Entries the daemon hasn't purged yet, say because it isn't running,
+	// are just as gone
+	now := time.Now()
+	entries = history.Filter(history.Unexpired(entries, now), from, limit)
+
+	// Tools reading the output want an empty list rather than a message
+	switch format {
+	case "json":
+		err = history.WriteJSON(os.Stdout, entries)
+	case "csv":
+		err = history.WriteCSV(os.Stdout, entries)
+	}
+	if err != nil {
+		colors.Errorf(os.Stderr, "Error writing history: %v\n", err)
+		os.Exit(1)
+	}
+	if format != "table" {
+		return
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No copies recorded")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tSIZE\tTYPE\tSOURCE\tTTL")
+	for _, entry := range entries {
+		ttl := "-"
+		if !entry.Expires.IsZero() {
+			ttl = entry.Expires.Sub(now).Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", entry.Time.Local().Format("2006-01-02 15:04:05"),
+			formatBytes(uint64(entry.Bytes)), entry.Type, entry.Source, ttl)
+	}
+	w.Flush()
+}
+
+// resetState removes the PID, last-activity and history files, and with logs
+// the log files and their rotated copies, so the next start begins from
+// nothing. It refuses while a daemon is running, as that daemon would go on
+// writing them, and asks first unless yes is set.
+func resetState(cfg *config.Config, logs, yes bool) {
+	if pid, ok := daemonPid(cfg); ok {
+		colors.Errorf(os.Stderr, "Error: warpclipd is running (PID: %d); stop it first with 'warpclipd stop'\n", pid)
+		os.Exit(1)
+	}
+	if _, err := queryStatus(cfg); err == nil {
+		colors.Errorf(os.Stderr, "Error: warpclipd is running on port %d; stop it first with 'warpclipd stop'\n", cfg.Port)
+		os.Exit(1)
+	}
+
+	// A history rewrite cut short leaves its temporary file beside the
+	// history, and the PID file has its sidecar beside it
+	paths := []string{cfg.PidFile, cfg.PidFile + ".*", cfg.LastFile, cfg.HistoryFile, cfg.HistoryFile + ".*", cfg.ClipboardFile, cfg.ClipboardFile + ".*"}
+	if logs {
+		for _, path := range []string{cfg.LogFile, cfg.DebugFile, cfg.OutLogFile, cfg.ErrorLogFile} {
+			paths = append(paths, path, path+".*")
+		}
+	}
+	var files []string
+	seen := make(map[string]bool)
+	for _, pattern := range paths {
+		matches, _ := filepath.Glob(pattern)
+		for _, match := range matches {
+			if info, err := os.Lstat(match); err == nil && info.Mode().IsRegular() && !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+	if len(files) == 0 {
+		fmt.Println("Nothing to reset")
+		return
+	}
+
+	fmt.Println("This removes:")
+	for _, file := range files {
+		fmt.Printf("  %s\n", file)
+	}
+	if !yes {
+		fmt.Print("Continue? [y/N] ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			fmt.Println("Nothing removed")
+			return
+		}
+	}
+
+	failed := false
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			colors.Errorf(os.Stderr, "Error removing %s: %v\n", file, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+	fmt.Printf("Removed %d files\n", len(files))
+}
+
+// daemonPid returns the PID recorded in the PID file if that process is alive
+func daemonPid(cfg *config.Config) (int, bool) {
+	data, err := os.ReadFile(cfg.PidFile)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return 0, false
+	}
+	return pid, process.Signal(syscall.Signal(0)) == nil
+}
+
+// pidInfo returns what the running daemon recorded about itself beside the
+// PID file. A sidecar left by an earlier daemon doesn't count.
+func pidInfo(cfg *config.Config) (server.PidInfo, bool) {
+	pid, ok := daemonPid(cfg)
+	if !ok {
+		return server.PidInfo{}, false
+	}
+	info, err := server.ReadPidInfo(cfg.PidFile)
+	if err != nil || info.PID != pid {
+		return server.PidInfo{}, false
+	}
+	return info, true
+}
+
+// daemonAddress returns the address and port the running daemon listens
+// on, falling back to the configured ones for a daemon that didn't record
+// them, such as one predating the sidecar
+func daemonAddress(cfg *config.Config) (string, int) {
+	if info, ok := pidInfo(cfg); ok {
+		return info.BindAddress, info.Port
+	}
+	return cfg.BindAddress, cfg.Port
+}
+
+// removePidFiles removes the PID file and its sidecar
+func removePidFiles(cfg *config.Config) {
+	os.Remove(cfg.PidFile)
+	os.Remove(server.PidInfoFile(cfg.PidFile))
+}
+
+func installService(cfg *config.Config) {
+	// Resolve the path of the running binary so the service starts this exact daemon
+	executable, err := os.Executable()
+	if err != nil {
+		colors.Errorf(os.Stderr, "Error locating warpclipd binary: %v\n", err)
+		os.Exit(1)
+	}
+	if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+		executable = resolved
+	}
+
+	spec := service.Spec{
+		Executable:   executable,
+		Env:          cfg.Environ(),
+		OutLogFile:   cfg.OutLogFile,
+		ErrorLogFile: cfg.ErrorLogFile,
+	}
+
+	path, err := service.Install(runtime.GOOS, spec)
+	if err != nil {
+		colors.Errorf(os.Stderr, "Error installing service: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Service definition written to %s\n", path)
+	fmt.Println("warpclipd is installed as a user service and has been started")
+}
+
 func showHelp() {
 	fmt.Println("WarpClip Daemon - Local clipboard service")
 	fmt.Println("")
 	fmt.Println("USAGE:")
-	fmt.Println("  warpclipd [COMMAND]")
+	fmt.Println("  warpclipd [OPTIONS] [COMMAND] [OPTIONS]")
 	fmt.Println("")
 	fmt.Println("COMMANDS:")
 	fmt.Println("  start    Start the clipboard daemon (default if no command specified)")
 	fmt.Println("  stop     Stop a running daemon")
-	fmt.Println("  restart  Restart the daemon")
+	fmt.Println("  restart  Stop the running daemon, wait for it to finish open copies and")
+	fmt.Println("           exit, then start a new one in its place")
 	fmt.Println("  status   Check daemon status")
+	fmt.Println("  top      Show the daemon's connections, copies and clipboard health,")
+	fmt.Println("           refreshed every second (a single snapshot when not on a terminal)")
+	fmt.Println("  capabilities  Show the protocol version and features the running daemon")
+	fmt.Println("                supports, its backend, copy types and size limit (--json")
+	fmt.Println("                for a JSON object)")
+	fmt.Println("  history  List recent copies: time, size, type and how they arrived")
+	fmt.Println("           (the content itself is never recorded)")
+	fmt.Println("  install-service  Install and start warpclipd as a user service")
+	fmt.Println("                   (launchd on macOS, systemd on Linux)")
+	fmt.Println("  reset    Remove the PID, last-activity and history files for a clean")
+	fmt.Println("           start, after asking; refused while the daemon is running")
 	fmt.Println("  help     Show this help message")
-	fmt.Println("  version  Show version information")
+	fmt.Println("  version  Show version information (--json adds the commit and build")
+	fmt.Println("           date as JSON)")
+	fmt.Println("")
+	fmt.Println("OPTIONS:")
+	fmt.Println("  --debug           Log at every level even when WARPCLIP_SILENT is set")
+	fmt.Println("  --port PORT       Port to listen on (overrides WARPCLIP_LOCAL_PORT)")
+	fmt.Println("  --max-size SIZE   Largest payload accepted, in bytes or with a unit such as")
+	fmt.Println("                    512KB or 10MB (overrides WARPCLIP_MAX_DATA_SIZE)")
+	fmt.Println("  --log-file PATH   Log file (overrides WARPCLIP_LOG_FILE)")
+	fmt.Println("  --since WHEN      history: only copies from this long ago, e.g. 1h or 7d,")
+	fmt.Println("                    or from this date on, e.g. 2024-01-01")
+	fmt.Println("  --limit N         history: only the N newest copies")
+	fmt.Println("  --format FORMAT   history: table (the default), json or csv, with")
+	fmt.Println("                    RFC 3339 times in json and csv")
+	fmt.Println("  --logs            reset: also remove the log files and rotated copies")
+	fmt.Println("  --yes             reset: don't ask for confirmation")
+	fmt.Println("  --no-color        Print errors without color, as when NO_COLOR is set or")
+	fmt.Println("                    stderr isn't a terminal")
 	fmt.Println("")
 	fmt.Println("ENVIRONMENT VARIABLES:")
 	fmt.Println("  WARPCLIP_LOCAL_PORT  Override default port (8888)")
 	fmt.Println("  WARPCLIP_LOG_FILE    Override log file location")
 	fmt.Println("  WARPCLIP_DEBUG_FILE  Override debug log file location")
+	fmt.Println("  WARPCLIP_HISTORY_FILE  Override copy history location (~/.warpclip.history)")
+	fmt.Println("  XDG_STATE_HOME       Keep logs and history in $XDG_STATE_HOME/warpclip")
+	fmt.Println("  XDG_RUNTIME_DIR      Keep the PID file in $XDG_RUNTIME_DIR/warpclip")
+	fmt.Println("  WARPCLIP_BACKEND     Clipboard backend (pbcopy, xclip, xsel, wl-copy,")
+	fmt.Println("                       clip.exe, custom-command; default depends on OS)")
+	fmt.Println("  WARPCLIP_BACKEND_CHAIN  Backends to try in order, e.g. xclip,custom-command;")
+	fmt.Println("                          each copy uses the first that succeeds")
+	fmt.Println("  WARPCLIP_CLIPBOARD_CMD  Shell command for the custom-command backend")
+	fmt.Println("  WARPCLIP_CLIPBOARD_ARGS Extra arguments for the copy command, e.g.")
+	fmt.Println("                          \"-pboard ruler\" (no shell metacharacters)")
+	fmt.Println("  WARPCLIP_TRANSFORM_CMD  Shell command every copy is piped through; its")
+	fmt.Println("                          output is copied and a failure aborts the copy")
+	fmt.Println("  WARPCLIP_READ_BUFFER    Bytes read from a connection at a time")
+	fmt.Println("                          (default: 32768)")
+	fmt.Println("  WARPCLIP_MEMORY_BUDGET  Turn away connections once the payloads held at once")
+	fmt.Println("                          would exceed this, e.g. 64MB (default: no limit)")
+	fmt.Println("  WARPCLIP_MIN_CLIENT_VERSION")
+	fmt.Println("                          Refuse clients speaking an older protocol version")
+	fmt.Println("                          (default: 0, accept any)")
+	fmt.Println("  WARPCLIP_READ_TIMEOUT   Close a connection that sends nothing for this long")
+	fmt.Println("                          (default: 5s)")
+	fmt.Println("  WARPCLIP_CONN_TIMEOUT   Close a copy connection open this long in total")
+	fmt.Println("                          (default: 10m); --follow and session connections")
+	fmt.Println("                          may idle between records, each held to this")
+	fmt.Println("  WARPCLIP_SHUTDOWN_TIMEOUT  On SIGTERM or Ctrl-C, wait this long for open")
+	fmt.Println("                          connections to finish (default: 30s); a second")
+	fmt.Println("                          Ctrl-C exits at once")
+	fmt.Println("  WARPCLIP_IDLE_TIMEOUT   Exit after this long without a copy, e.g. 30m")
+	fmt.Println("                          (default: run forever)")
+	fmt.Println("  WARPCLIP_NORMALIZE_EOL  Rewrite line endings before copying: lf or crlf")
+	fmt.Println("  WARPCLIP_STRIP_ANSI=1   Remove ANSI escape sequences such as colors")
+	fmt.Println("                          before copying")
+	fmt.Println("  WARPCLIP_TRIM_POLICY    Strip whitespace before copying: trailing-newline,")
+	fmt.Println("                          trailing-ws, both-ends or none (default)")
+	fmt.Println("  WARPCLIP_REDACT         Extra regular expression masked in logs (secrets")
+	fmt.Println("                          such as AWS keys and password=... always are)")
+	fmt.Println("  WARPCLIP_SILENT=1       Only log warnings and errors (--debug overrides)")
+	fmt.Println("  WARPCLIP_NO_DEBUG_LOG=1 Drop debug messages and don't create a debug log")
+	fmt.Println("  WARPCLIP_NO_STDERR_MIRROR=1")
+	fmt.Println("                          Write errors only to the log, not also to stderr")
+	fmt.Println("  WARPCLIP_QUIET_CONN_LOGS=1")
+	fmt.Println("                          Log connections opening and closing at DEBUG,")
+	fmt.Println("                          keeping only copies in the main log")
+	fmt.Println("  WARPCLIP_RESTORE_ON_START=1")
+	fmt.Println("                          Save the clipboard at shutdown and restore it at")
+	fmt.Println("                          the next start")
+	fmt.Println("  WARPCLIP_BREAKER_THRESHOLD  Consecutive failed copies after which copies")
+	fmt.Println("                          are refused until the backend recovers (default: 5,")
+	fmt.Println("                          0 never refuses)")
+	fmt.Println("  WARPCLIP_BREAKER_COOLDOWN   How long to refuse copies before trying the")
+	fmt.Println("                          backend again (default: 30s)")
+	fmt.Println("  WARPCLIP_DEBOUNCE       Coalesce copies arriving within this long and only")
+	fmt.Println("                          copy the latest, e.g. 200ms (default: off)")
+	fmt.Println("  WARPCLIP_NOTIFY_SOUND   Play a sound after each copy and another on failure:")
+	fmt.Println("                          on, SUCCESS[,FAILURE] sound names or files, or")
+	fmt.Println("                          notification for a banner (default: off)")
+	fmt.Println("  WARPCLIP_PULL_FROM      Also poll this address, e.g. localhost:9998 forwarded")
+	fmt.Println("                          with ssh -L, for copies waiting on warpclip --listen")
+	fmt.Println("  WARPCLIP_PULL_INTERVAL  How often to poll WARPCLIP_PULL_FROM (default: 1s)")
+	fmt.Println("  WARPCLIP_SELFTEST=1     At startup, connect to the daemon's own port and")
+	fmt.Println("                          check a clipboard write reads back, logging")
+	fmt.Println("                          whether the self-test passed")
 	fmt.Println("")
 	fmt.Println("EXAMPLES:")
 	fmt.Println("  warpclipd start      # Start the daemon")
 	fmt.Println("  warpclipd status     # Check status")
 	fmt.Println("  warpclipd restart    # Restart the daemon")
+	fmt.Println("  warpclipd start --port 8890  # Start on another port")
+	fmt.Println("  warpclipd history --since 1h # What was copied in the last hour")
 	fmt.Println("")
 	fmt.Println("NOTES:")
 	fmt.Println("  This daemon listens on localhost:8888 and copies received data to the clipboard.")
@@ -244,5 +930,10 @@ func showHelp() {
 	fmt.Println("    brew services start warpclip")
 	fmt.Println("    brew services stop warpclip")
 	fmt.Println("    brew services restart warpclip")
+	fmt.Println("  ")
+	fmt.Println("  Without Homebrew, 'warpclipd install-service' registers the daemon with")
+	fmt.Println("  launchd (~/Library/LaunchAgents) or systemd (~/.config/systemd/user),")
+	fmt.Println("  exporting the current WARPCLIP_* configuration, including any options")
+	fmt.Println("  given with it, to the service.")
 }
 
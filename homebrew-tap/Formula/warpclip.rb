@@ -18,18 +18,20 @@ class Warpclip < Formula
   def install
     # Build from Go source with version information
     system "go", "build", 
-           "-ldflags", "-X main.Version=#{version}",
+           "-ldflags", "-X github.com/mquinnv/warpclip/v2/internal/version.Version=#{version}",
            "-o", "warpclip", 
            "./cmd/warpclip"
     
     system "go", "build", 
-           "-ldflags", "-X main.Version=#{version}",
+           "-ldflags", "-X github.com/mquinnv/warpclip/v2/internal/version.Version=#{version}",
            "-o", "warpclipd", 
            "./cmd/warpclipd"
     
     # Install the main command-line tool and server daemon
     bin.install "warpclip"
     bin.install "warpclipd"
+    # warp-paste is warpclip restricted to pasting
+    bin.install_symlink "warpclip" => "warp-paste"
     
     # Set the proper permissions
     chmod 0755, bin/"warpclip"
@@ -111,7 +111,8 @@ print_success "Installed and set permissions on warpclip-server.sh"
 # 3. Install client script
 print_header "Installing WarpClip client script"
 backup_file "$BIN_DIR/warp-copy"
-cp "$SRC_DIR/warp-copy" "$BIN_DIR/"
+# warp-copy reports the same release version as the Go binaries
+sed "s/^VERSION=\"[^\"]*\"/VERSION=\"$(cat "$SCRIPT_DIR/VERSION")\"/" "$SRC_DIR/warp-copy" > "$BIN_DIR/warp-copy"
 chmod +x "$BIN_DIR/warp-copy"
 print_success "Installed and set permissions on warp-copy"
 
@@ -0,0 +1,123 @@
+// Package ansi removes the terminal escape sequences that colored command
+// output carries, leaving the visible text
+package ansi
+
+import "io"
+
+const (
+	esc = 0x1b
+	bel = 0x07
+)
+
+// state is where a filter is within an escape sequence
+type state int
+
+const (
+	// ground is plain text
+	ground state = iota
+	// escape follows an ESC
+	escape
+	// intermediate is within an ESC sequence's intermediate bytes, as in
+	// ESC ( B
+	intermediate
+	// csi is within a control sequence, ESC [ ... final, which covers SGR
+	// colors and cursor movement
+	csi
+	// osc is within an operating system command, ESC ] ... BEL or ESC \,
+	// such as a hyperlink or window title
+	osc
+	// oscEscape follows an ESC within an operating system command
+	oscEscape
+)
+
+// filter drops escape sequences from the bytes passed through it, carrying
+// its state from one chunk to the next
+type filter struct {
+	state state
+}
+
+// transform appends the bytes of chunk outside escape sequences to out
+func (f *filter) transform(out, chunk []byte) []byte {
+	for _, b := range chunk {
+		switch f.state {
+		case ground:
+			if b == esc {
+				f.state = escape
+				continue
+			}
+			out = append(out, b)
+		case escape:
+			switch {
+			case b == '[':
+				f.state = csi
+			case b == ']':
+				f.state = osc
+			case b >= 0x20 && b <= 0x2f:
+				f.state = intermediate
+			default:
+				// A two-byte sequence such as ESC 7
+				f.state = ground
+			}
+		case intermediate:
+			if b < 0x20 || b > 0x2f {
+				f.state = ground
+			}
+		case csi:
+			// Parameter and intermediate bytes run up to a final byte
+			if b >= 0x40 && b <= 0x7e {
+				f.state = ground
+			}
+		case osc:
+			switch b {
+			case bel:
+				f.state = ground
+			case esc:
+				f.state = oscEscape
+			}
+		case oscEscape:
+			if b == '\\' {
+				f.state = ground
+			} else if b != esc {
+				f.state = osc
+			}
+		}
+	}
+	return out
+}
+
+// Strip returns data without its escape sequences
+func Strip(data []byte) []byte {
+	var f filter
+	return f.transform(make([]byte, 0, len(data)), data)
+}
+
+// reader strips escape sequences as data streams through it
+type reader struct {
+	r      io.Reader
+	filter filter
+	in     []byte
+	out    []byte // stripped bytes not yet returned
+	err    error
+}
+
+// NewReader returns a reader that strips the escape sequences from r. A
+// sequence split across reads of r is still removed whole.
+func NewReader(r io.Reader) io.Reader {
+	return &reader{r: r, in: make([]byte, 32*1024)}
+}
+
+func (t *reader) Read(p []byte) (int, error) {
+	for len(t.out) == 0 {
+		if t.err != nil {
+			return 0, t.err
+		}
+		n, err := t.r.Read(t.in)
+		// Stripping never adds bytes, so the input buffer can hold the output
+		t.out = t.filter.transform(t.in[:0], t.in[:n])
+		t.err = err
+	}
+
+	n := copy(p, t.out)
+	t.out = t.out[n:]
+	return n, nil
+}
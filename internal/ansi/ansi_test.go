@@ -0,0 +1,70 @@
+package ansi
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestStrip(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{name: "plain text", input: "no escapes here\n", expected: "no escapes here\n"},
+		{
+			name:     "ls --color",
+			input:    "\x1b[0m\x1b[01;34mdocs\x1b[0m  \x1b[01;32mbuild.sh\x1b[0m  README.md\n",
+			expected: "docs  build.sh  README.md\n",
+		},
+		{
+			name:     "grep --color",
+			input:    "\x1b[35m\x1b[Kmain.go\x1b[m\x1b[K\x1b[36m\x1b[K:\x1b[m\x1b[K\x1b[32m\x1b[K12\x1b[m\x1b[K\x1b[36m\x1b[K:\x1b[m\x1b[Kfunc \x1b[01;31m\x1b[Kmain\x1b[m\x1b[K() {\n",
+			expected: "main.go:12:func main() {\n",
+		},
+		{
+			name:     "256 and true color",
+			input:    "\x1b[38;5;208morange\x1b[0m \x1b[38;2;255;0;0mred\x1b[0m",
+			expected: "orange red",
+		},
+		{
+			name:     "hyperlinks",
+			input:    "\x1b]8;;file:///tmp/notes.txt\x1b\\notes.txt\x1b]8;;\x07\n",
+			expected: "notes.txt\n",
+		},
+		{
+			name:     "cursor movement and charset",
+			input:    "\x1b7\x1b[2J\x1b[1;1H\x1b(Btop\x1b8",
+			expected: "top",
+		},
+		{
+			name:     "other control characters kept",
+			input:    "a\tb\r\nc\x07",
+			expected: "a\tb\r\nc\x07",
+		},
+		{
+			name:     "UTF-8 kept",
+			input:    "\x1b[1mcafé ✓\x1b[22m",
+			expected: "café ✓",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := string(Strip([]byte(tc.input))); got != tc.expected {
+				t.Errorf("Strip(%q) = %q, want %q", tc.input, got, tc.expected)
+			}
+
+			// Reading a byte at a time splits every sequence across reads
+			got, err := io.ReadAll(NewReader(iotest.OneByteReader(strings.NewReader(tc.input))))
+			if err != nil {
+				t.Fatalf("Reading failed: %v", err)
+			}
+			if string(got) != tc.expected {
+				t.Errorf("NewReader(%q) read %q, want %q", tc.input, got, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mquinnv/warpclip/v2/internal/protocol"
+)
+
+// HandshakeTimeout bounds the wait for a daemon to answer the version
+// handshake; older daemons never do
+const HandshakeTimeout = 1 * time.Second
+
+// TunnelPollInterval is how often WaitForTunnel checks for the tunnel
+const TunnelPollInterval = 250 * time.Millisecond
+
+var (
+	// ErrNoTunnel is returned when nothing is listening on the port the
+	// client sends to: there is no SSH tunnel or, without one, no daemon
+	ErrNoTunnel = errors.New("no connection to warpclipd")
+	// ErrNoInput is returned when there is nothing to copy
+	ErrNoInput = errors.New("no input provided")
+	// ErrTooLarge is returned when the payload exceeds the daemon's size limit
+	ErrTooLarge = errors.New("input too large")
+	// ErrCanceled is returned when the operation is interrupted
+	ErrCanceled = errors.New("operation canceled")
+	// ErrServerRejected is returned when the daemon reports a failure in its
+	// acknowledgement
+	ErrServerRejected = errors.New("server rejected the request")
+	// ErrNotSent is returned by Send when the payload couldn't be written,
+	// so unlike a missing acknowledgement it means nothing was copied
+	ErrNotSent = errors.New("failed to write data")
+)
+
+// RejectedError is a failure the daemon reported in its acknowledgement. It
+// matches ErrServerRejected, and ErrTooLarge when the payload was refused
+// for its size.
+type RejectedError struct {
+	Ack protocol.Ack
+}
+
+// Rejected returns the error for a failed acknowledgement
+func Rejected(ack protocol.Ack) error {
+	return &RejectedError{Ack: ack}
+}
+
+// Error returns the daemon's description of the failure
+func (e *RejectedError) Error() string {
+	return e.Ack.Error
+}
+
+// Is reports whether the failure is of the kind target describes
+func (e *RejectedError) Is(target error) bool {
+	switch target {
+	case ErrServerRejected:
+		return true
+	case ErrTooLarge:
+		return e.Ack.Category == protocol.CategoryTooLarge
+	default:
+		return false
+	}
+}
+
+// Conn is a connection to the daemon after version negotiation
+type Conn struct {
+	net.Conn
+	// Reader is what replies must be read through, as it may already hold
+	// buffered data
+	Reader *bufio.Reader
+	// Version is the protocol version both sides speak
+	Version int
+}
+
+// CheckTunnel reports whether anything is listening on port
+func CheckTunnel(port int) bool {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("localhost:%d", port), 1*time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// FirstListening returns the first of ports that CheckTunnel finds
+// listening, trying them in order, for users whose hosts forward different
+// ports
+func FirstListening(ports []int) (int, bool) {
+	for _, port := range ports {
+		if CheckTunnel(port) {
+			return port, true
+		}
+	}
+	return 0, false
+}
+
+// WaitForTunnel reports whether anything is listening on port, checking
+// again until it is, wait has elapsed or ctx is done. A tunnel requested at
+// login can take a moment to come up after the SSH session does.
+func WaitForTunnel(ctx context.Context, port int, wait time.Duration) bool {
+	deadline := time.Now().Add(wait)
+	for {
+		if CheckTunnel(port) {
+			return true
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false
+		}
+		delay := TunnelPollInterval
+		if remaining < delay {
+			delay = remaining
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+	}
+}
+
+// InputSize returns how many bytes are left to read from f when that is
+// known up front, as for a regular file redirected to stdin. Pipes,
+// terminals and sockets can't seek, so their length is only known once they
+// end.
+func InputSize(f *os.File) (int64, bool) {
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() < offset {
+		return 0, false
+	}
+	return info.Size() - offset, true
+}
+
+// CheckSize fails with ErrTooLarge when a payload of size bytes exceeds
+// limit, the largest the daemon is expected to accept, so an oversized copy
+// fails before anything is sent. The error gives both sizes and how to raise
+// the limit.
+func CheckSize(size, limit int64) error {
+	if size <= limit {
+		return nil
+	}
+	return fmt.Errorf("%w: %d bytes is over the maximum of %d bytes by %d bytes; raise WARPCLIP_MAX_DATA_SIZE on both ends to copy more", ErrTooLarge, size, limit, size-limit)
+}
+
+// Dial connects to the daemon on port and negotiates the protocol version. A
+// daemon predating the handshake never answers it; that connection is
+// abandoned so the daemon discards what it received instead of copying it,
+// and a fresh connection speaks the legacy protocol.
+func Dial(port int, timeout time.Duration) (*Conn, error) {
+	address := fmt.Sprintf("localhost:%d", port)
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to connect to %s: %w", ErrNoTunnel, address, err)
+	}
+
+	handshakeTimeout := HandshakeTimeout
+	if timeout < handshakeTimeout {
+		handshakeTimeout = timeout
+	}
+	reader := bufio.NewReader(conn)
+	version, err := protocol.Handshake(conn, reader, handshakeTimeout)
+	if err == nil {
+		return &Conn{Conn: conn, Reader: reader, Version: version}, nil
+	}
+
+	if !errors.Is(err, protocol.ErrHandshakeUnsupported) {
+		conn.Close()
+		return nil, fmt.Errorf("failed to negotiate with %s: %w", address, err)
+	}
+	if err := protocol.Abandon(conn); err != nil {
+		return nil, fmt.Errorf("failed to abandon handshake with %s: %w", address, err)
+	}
+
+	conn, err = net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to connect to %s: %w", ErrNoTunnel, address, err)
+	}
+	return &Conn{Conn: conn, Reader: bufio.NewReader(conn), Version: protocol.LegacyVersion}, nil
+}
+
+// Accept waits on port for a daemon pulling copies with WARPCLIP_PULL_FROM,
+// for setups where the daemon reaches this machine through a forward tunnel
+// instead of this machine reaching it, and negotiates the protocol version
+// with the first to connect. The connection is then used like one from
+// Dial. A connection that doesn't answer the handshake within timeout isn't
+// a daemon, so it is dropped and the wait goes on until ctx is done.
+func Accept(ctx context.Context, port int, timeout time.Duration) (*Conn, error) {
+	address := fmt.Sprintf("127.0.0.1:%d", port)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+	defer listener.Close()
+
+	// Unblock Accept if the operation is canceled
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			listener.Close()
+		case <-stop:
+		}
+	}()
+
+	handshakeTimeout := HandshakeTimeout
+	if timeout < handshakeTimeout {
+		handshakeTimeout = timeout
+	}
+	for {
+		conn, err := listener.Accept()
+		if ctx.Err() != nil {
+			return nil, ErrCanceled
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to accept on %s: %w", address, err)
+		}
+
+		reader := bufio.NewReader(conn)
+		version, err := protocol.Handshake(conn, reader, handshakeTimeout)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		return &Conn{Conn: conn, Reader: reader, Version: version}, nil
+	}
+}
+
+// Send writes data as the payload of a copy on conn, after whatever request
+// directives the caller sent, and waits up to timeout for the daemon's
+// acknowledgement. A positive chunkSize sends the payload in acknowledged
+// chunks of that many bytes, each getting the whole timeout. Canceling ctx
+// closes conn to unblock the write or the wait, and Send then returns
+// ErrCanceled rather than the failure closing it causes. A payload that
+// can't be written fails with ErrNotSent; daemons predating
+// acknowledgements close without replying, which is returned as io.EOF.
+func Send(ctx context.Context, conn *Conn, data []byte, chunkSize int, timeout time.Duration) (protocol.Ack, error) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	ack, err := send(conn, data, chunkSize, timeout)
+	if ctx.Err() != nil {
+		return ack, ErrCanceled
+	}
+	return ack, err
+}
+
+// send does the work of Send
+func send(conn *Conn, data []byte, chunkSize int, timeout time.Duration) (protocol.Ack, error) {
+	if chunkSize > 0 {
+		return protocol.SendChunked(conn, conn.Reader, data, chunkSize, timeout)
+	}
+
+	if err := conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return protocol.Ack{}, fmt.Errorf("%w: %w", ErrNotSent, err)
+	}
+	if _, err := conn.Write(data); err != nil {
+		return protocol.Ack{}, fmt.Errorf("%w: %w", ErrNotSent, err)
+	}
+
+	// The daemon copies the payload once it reaches EOF
+	if tcpConn, ok := conn.Conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return protocol.Ack{}, fmt.Errorf("failed to set read deadline: %w", err)
+	}
+	return protocol.ReadAck(conn.Reader)
+}
+
+// WithInterrupt returns a copy of parent that is canceled when a signal
+// arrives on signals, such as one registered with signal.Notify for SIGINT
+// and SIGTERM, after passing it to onSignal. stop cancels the context,
+// waits for the signal to be handled if one arrived and reports whether
+// one did, so an interrupted copy can be told apart from one that failed.
+func WithInterrupt(parent context.Context, signals <-chan os.Signal, onSignal func(os.Signal)) (ctx context.Context, stop func() bool) {
+	ctx, cancel := context.WithCancel(parent)
+	var wg sync.WaitGroup
+	var interrupted bool
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case sig := <-signals:
+			onSignal(sig)
+			interrupted = true
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() bool {
+		cancel()
+		wg.Wait()
+		return interrupted
+	}
+}
@@ -0,0 +1,564 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mquinnv/warpclip/v2/internal/protocol"
+)
+
+func TestRejectedError(t *testing.T) {
+	testCases := []struct {
+		name     string
+		ack      protocol.Ack
+		rejected bool
+		tooLarge bool
+	}{
+		{name: "backend failure", ack: protocol.Ack{Error: "xclip failed", Category: protocol.CategoryNoSession}, rejected: true},
+		{name: "too large", ack: protocol.Ack{Error: "payload too large", Category: protocol.CategoryTooLarge}, rejected: true, tooLarge: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := fmt.Errorf("server failed to copy data: %w", Rejected(tc.ack))
+			if errors.Is(err, ErrServerRejected) != tc.rejected {
+				t.Errorf("errors.Is(%v, ErrServerRejected) = %v", err, !tc.rejected)
+			}
+			if errors.Is(err, ErrTooLarge) != tc.tooLarge {
+				t.Errorf("errors.Is(%v, ErrTooLarge) = %v", err, !tc.tooLarge)
+			}
+			if errors.Is(err, ErrNoTunnel) {
+				t.Errorf("errors.Is(%v, ErrNoTunnel) = true", err)
+			}
+			if err.Error() != "server failed to copy data: "+tc.ack.Error {
+				t.Errorf("Unexpected message %q", err)
+			}
+
+			var rejected *RejectedError
+			if !errors.As(err, &rejected) || rejected.Ack.Category != tc.ack.Category {
+				t.Errorf("errors.As did not recover the acknowledgement from %v", err)
+			}
+		})
+	}
+}
+
+// listen starts a fake daemon handling each connection with handle and
+// returns its port
+func listen(t *testing.T, handle func(net.Conn)) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+func TestFirstListening(t *testing.T) {
+	// A port that was just freed has nothing listening
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	closed := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	open := listen(t, func(net.Conn) {})
+	if port, ok := FirstListening([]int{closed, open}); !ok || port != open {
+		t.Errorf("FirstListening = %d, %v; want %d, true", port, ok, open)
+	}
+	if port, ok := FirstListening([]int{closed}); ok {
+		t.Errorf("FirstListening with nothing listening = %d, true; want false", port)
+	}
+}
+
+func TestInputSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(path, []byte("hello, world"), 0600); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open input file: %v", err)
+	}
+	defer file.Close()
+
+	if size, ok := InputSize(file); !ok || size != 12 {
+		t.Errorf("InputSize(file) = %d, %v; want 12, true", size, ok)
+	}
+	// Only what is left to read counts
+	if _, err := file.Seek(7, io.SeekStart); err != nil {
+		t.Fatalf("Failed to seek: %v", err)
+	}
+	if size, ok := InputSize(file); !ok || size != 5 {
+		t.Errorf("InputSize(file) after reading 7 bytes = %d, %v; want 5, true", size, ok)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	defer r.Close()
+	defer w.Close()
+	w.Write([]byte("hello"))
+	if size, ok := InputSize(r); ok {
+		t.Errorf("InputSize(pipe) = %d, true; want the size to be unknown", size)
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("x"), 1500), 0600); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open input file: %v", err)
+	}
+	defer file.Close()
+
+	size, ok := InputSize(file)
+	if !ok {
+		t.Fatal("Expected the size of a file to be known")
+	}
+	err = CheckSize(size, 1024)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("CheckSize(1500, 1024) = %v, want ErrTooLarge", err)
+	}
+	for _, want := range []string{"1500 bytes", "1024 bytes", "by 476", "WARPCLIP_MAX_DATA_SIZE"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Error %q doesn't mention %q", err, want)
+		}
+	}
+
+	if err := CheckSize(1024, 1024); err != nil {
+		t.Errorf("CheckSize at the limit = %v, want nil", err)
+	}
+}
+
+func TestDial(t *testing.T) {
+	current := listen(t, func(conn net.Conn) {
+		bufio.NewReader(conn).ReadString('\n')
+		protocol.WriteHandshake(conn, protocol.Version)
+	})
+	conn, err := Dial(current, time.Second)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	conn.Close()
+	if conn.Version != protocol.Version {
+		t.Errorf("Negotiated version %d, want %d", conn.Version, protocol.Version)
+	}
+
+	// A legacy daemon reads until the client closes its side
+	legacy := listen(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+	conn, err = Dial(legacy, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Dial of legacy daemon failed: %v", err)
+	}
+	conn.Close()
+	if conn.Version != protocol.LegacyVersion {
+		t.Errorf("Negotiated version %d with legacy daemon, want %d", conn.Version, protocol.LegacyVersion)
+	}
+
+	// Nothing listens on a port once its listener is closed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+	closed, _ := strconv.Atoi(port)
+	if CheckTunnel(closed) {
+		t.Error("CheckTunnel reported a tunnel on a closed port")
+	}
+	start := time.Now()
+	if WaitForTunnel(context.Background(), closed, 300*time.Millisecond) {
+		t.Error("WaitForTunnel reported a tunnel on a closed port")
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("WaitForTunnel gave up after %v, before its wait elapsed", elapsed)
+	}
+
+	// A listener that appears while waiting is found
+	late := make(chan net.Listener, 1)
+	go func() {
+		time.Sleep(2 * TunnelPollInterval)
+		l, err := net.Listen("tcp", "127.0.0.1:"+port)
+		if err != nil {
+			t.Errorf("Failed to listen again: %v", err)
+		}
+		late <- l
+	}()
+	if !WaitForTunnel(context.Background(), closed, 5*time.Second) {
+		t.Error("WaitForTunnel missed a tunnel that came up while waiting")
+	}
+	if l := <-late; l != nil {
+		l.Close()
+	}
+	if _, err := Dial(closed, time.Second); !errors.Is(err, ErrNoTunnel) {
+		t.Errorf("Expected ErrNoTunnel, got %v", err)
+	}
+}
+
+func TestAccept(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	_, portStr, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+	port, _ := strconv.Atoi(portStr)
+
+	// A pulling daemon answers the handshake; anything else connecting
+	// first, like a forward tunnel's probe, is skipped
+	go func() {
+		var conn net.Conn
+		var err error
+		for i := 0; i < 50; i++ {
+			if conn, err = net.Dial("tcp", "127.0.0.1:"+portStr); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Errorf("Failed to connect: %v", err)
+			return
+		}
+		conn.Close()
+
+		conn, err = net.Dial("tcp", "127.0.0.1:"+portStr)
+		if err != nil {
+			t.Errorf("Failed to connect again: %v", err)
+			return
+		}
+		defer conn.Close()
+		bufio.NewReader(conn).ReadString('\n')
+		protocol.WriteHandshake(conn, protocol.Version)
+		io.Copy(io.Discard, conn)
+	}()
+	conn, err := Accept(context.Background(), port, time.Second)
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	conn.Close()
+	if conn.Version != protocol.Version {
+		t.Errorf("Negotiated version %d, want %d", conn.Version, protocol.Version)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := Accept(ctx, port, time.Second); !errors.Is(err, ErrCanceled) {
+		t.Errorf("Expected ErrCanceled once the wait was canceled, got %v", err)
+	}
+}
+
+func TestSend(t *testing.T) {
+	port := listen(t, func(conn net.Conn) {
+		bufio.NewReader(conn).ReadString('\n')
+		protocol.WriteHandshake(conn, protocol.Version)
+		data, _ := io.ReadAll(conn)
+		protocol.WriteAck(conn, protocol.Ack{OK: true, Bytes: int64(len(data)), Backend: "memory"})
+	})
+	conn, err := Dial(port, time.Second)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	ack, err := Send(context.Background(), conn, []byte("hello"), 0, time.Second)
+	if err != nil || !ack.OK || ack.Bytes != 5 {
+		t.Errorf("Send = %+v, %v; want 5 bytes acknowledged", ack, err)
+	}
+}
+
+func TestSendCanceled(t *testing.T) {
+	testCases := []struct {
+		name string
+		// daemon handles the connection, signaling on sent once the copy
+		// is far enough along to cancel
+		daemon func(conn net.Conn, sent chan<- struct{})
+	}{
+		{
+			// The payload can't be written while the daemon isn't reading
+			name: "while writing",
+			daemon: func(conn net.Conn, sent chan<- struct{}) {
+				close(sent)
+			},
+		},
+		{
+			name: "while waiting for the acknowledgement",
+			daemon: func(conn net.Conn, sent chan<- struct{}) {
+				conn.Read(make([]byte, 5))
+				close(sent)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			local, daemon := net.Pipe()
+			defer daemon.Close()
+			sent := make(chan struct{})
+			go tc.daemon(daemon, sent)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				<-sent
+				time.Sleep(50 * time.Millisecond)
+				cancel()
+			}()
+
+			// The timeout is long enough that only canceling can end the send
+			conn := &Conn{Conn: local, Reader: bufio.NewReader(local), Version: protocol.Version}
+			start := time.Now()
+			_, err := Send(ctx, conn, []byte("hello"), 0, time.Minute)
+			if !errors.Is(err, ErrCanceled) {
+				t.Errorf("Expected ErrCanceled, got %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Send returned %v after being canceled", elapsed)
+			}
+		})
+	}
+}
+
+func TestWithInterrupt(t *testing.T) {
+	signals := make(chan os.Signal, 1)
+	var handled os.Signal
+	ctx, stop := WithInterrupt(context.Background(), signals, func(sig os.Signal) {
+		handled = sig
+	})
+	signals <- syscall.SIGINT
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Context was not canceled by the signal")
+	}
+	if !stop() {
+		t.Error("stop reported no interrupt after a signal")
+	}
+	if handled != syscall.SIGINT {
+		t.Errorf("onSignal got %v, want %v", handled, syscall.SIGINT)
+	}
+
+	// Finishing without a signal isn't an interrupt
+	ctx, stop = WithInterrupt(context.Background(), make(chan os.Signal), func(os.Signal) {
+		t.Error("onSignal called without a signal")
+	})
+	if stop() {
+		t.Error("stop reported an interrupt without a signal")
+	}
+	if ctx.Err() == nil {
+		t.Error("Context still live after stop")
+	}
+}
+
+func TestForwardedPorts(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		hosts  []string
+		want   []int
+	}{
+		{
+			name: "matching host",
+			config: `Host devbox
+    RemoteForward 9999 localhost:8888
+`,
+			hosts: []string{"devbox"},
+			want:  []int{9999},
+		},
+		{
+			name: "other host",
+			config: `Host devbox
+    RemoteForward 9999 localhost:8888
+`,
+			hosts: []string{"buildbox"},
+		},
+		{
+			name: "wildcards, negation and equals signs",
+			config: `Host *.example.com !db?.example.com
+    RemoteForward=127.0.0.1:9998 localhost:8888
+
+Host db*
+    RemoteForward 9997 localhost:8888
+`,
+			hosts: []string{"web1.example.com"},
+			want:  []int{9998},
+		},
+		{
+			name: "negated host",
+			config: `Host *.example.com !db?.example.com
+    RemoteForward 9998 localhost:8888
+`,
+			hosts: []string{"db1.example.com"},
+		},
+		{
+			name: "alias with a matching HostName",
+			config: `Host work
+    HostName dev.example.com
+    RemoteForward [::1]:9996 localhost:8888
+`,
+			hosts: []string{"dev.example.com", "dev"},
+			want:  []int{9996},
+		},
+		{
+			name: "global and repeated forwards",
+			config: `# every host
+RemoteForward 9999 localhost:8888
+
+Host laptop2-*
+    remoteforward 9998 localhost:8890
+Host *
+    RemoteForward 9999 localhost:8888
+`,
+			hosts: []string{"laptop2-dev"},
+			want:  []int{9999, 9998},
+		},
+		{
+			name: "socks proxies, dynamic ports and Match blocks",
+			config: `Host devbox
+    RemoteForward 1080
+    RemoteForward 0 localhost:8888
+Match host devbox
+    RemoteForward 9995 localhost:8888
+`,
+			hosts: []string{"devbox"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config")
+			if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+				t.Fatalf("Failed to write ssh config: %v", err)
+			}
+			got, err := ForwardedPorts(path, tt.hosts)
+			if err != nil {
+				t.Fatalf("ForwardedPorts failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ForwardedPorts(%v) = %v, want %v", tt.hosts, got, tt.want)
+			}
+		})
+	}
+
+	ports, err := ForwardedPorts(filepath.Join(t.TempDir(), "missing"), []string{"devbox"})
+	if err != nil || ports != nil {
+		t.Errorf("Expected no forwards without an ssh config, got %v, %v", ports, err)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	config := `# profiles for the build farm
+[profile.build]
+hosts = ["build-*", 'ci.example.com'] # both farms
+port = 9998
+timeout = "1m"
+wait_for_tunnel = "10s"
+
+[profile."fan out"]
+ports = [9999, 9998,]
+max_data_size = "10MB"
+
+[profile.local]
+no_tunnel = true
+max_data_size = 2048
+`
+	path := filepath.Join(t.TempDir(), "client.toml")
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatalf("Failed to write client config: %v", err)
+	}
+	profiles, err := LoadProfiles(path)
+	if err != nil {
+		t.Fatalf("LoadProfiles failed: %v", err)
+	}
+	want := []Profile{
+		{
+			Name:          "build",
+			Hosts:         []string{"build-*", "ci.example.com"},
+			Port:          9998,
+			Timeout:       time.Minute,
+			WaitForTunnel: 10 * time.Second,
+		},
+		{Name: "fan out", Ports: []int{9999, 9998}, MaxDataSize: 10 * 1024 * 1024},
+		{Name: "local", NoTunnel: true, MaxDataSize: 2048},
+	}
+	if !reflect.DeepEqual(profiles, want) {
+		t.Fatalf("LoadProfiles = %+v, want %+v", profiles, want)
+	}
+
+	selections := []struct {
+		name  string
+		hosts []string
+		want  string
+	}{
+		{name: "local", hosts: []string{"build-1"}, want: "local"},
+		{hosts: []string{"build-1.example.com", "build-1"}, want: "build"},
+		{hosts: []string{"devbox"}},
+	}
+	for _, s := range selections {
+		p, ok, err := SelectProfile(profiles, s.name, s.hosts)
+		if err != nil || ok != (s.want != "") || p.Name != s.want {
+			t.Errorf("SelectProfile(%q, %v) = %q, %v, %v, want %q", s.name, s.hosts, p.Name, ok, err, s.want)
+		}
+	}
+	if _, _, err := SelectProfile(profiles, "missing", nil); err == nil {
+		t.Error("Expected an error selecting a profile that doesn't exist")
+	}
+
+	profiles, err = LoadProfiles(filepath.Join(t.TempDir(), "missing"))
+	if err != nil || profiles != nil {
+		t.Errorf("Expected no profiles without a client config, got %v, %v", profiles, err)
+	}
+
+	bad := []struct {
+		config string
+		want   string
+	}{
+		{config: "[profile.a]\ntoken = \"secret\"\n", want: `line 2: unknown setting "token"`},
+		{config: "port = 9999\n", want: "line 1: port is outside a [profile.NAME] table"},
+		{config: "[server]\n", want: "unknown table [server]"},
+		{config: "[profile.a]\n[profile.a]\n", want: `line 2: profile "a" is defined twice`},
+		{config: "[profile.a]\nport = 70000\n", want: "invalid port: 70000 is not a port number"},
+		{config: "[profile.a]\ntimeout = 30s\n", want: "invalid timeout: expected a quoted string"},
+		{config: "[profile.a]\nhosts = \"devbox\"\n", want: "invalid hosts: expected an array"},
+		{config: "[profile.a]\nno_tunnel\n", want: "line 2: expected key = value"},
+	}
+	for _, b := range bad {
+		_, err := parseProfiles(strings.NewReader(b.config))
+		if err == nil || !strings.Contains(err.Error(), b.want) {
+			t.Errorf("parseProfiles(%q) error = %v, want %q", b.config, err, b.want)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mquinnv/warpclip/v2/internal/config"
+)
+
+// Profile holds the client settings for one daemon setup, read from a
+// [profile.NAME] table of the client config file. Settings the table leaves
+// out are zero.
+type Profile struct {
+	// Name is the profile's name, as given to --profile
+	Name string
+	// Hosts are ssh-style patterns, such as "build-*", for the machines the
+	// profile applies to when none is named
+	Hosts []string
+	// Port is the local end of the SSH tunnel (or the daemon itself)
+	Port int
+	// Ports lists the daemons a copy fans out to
+	Ports []int
+	// Timeout bounds connecting, writing and waiting for the acknowledgement
+	Timeout time.Duration
+	// WaitForTunnel is how long to keep checking for a tunnel that isn't up yet
+	WaitForTunnel time.Duration
+	// NoTunnel connects straight to a daemon on this machine
+	NoTunnel bool
+	// MaxDataSize is the largest payload the daemon accepts
+	MaxDataSize int64
+}
+
+// ProfilesPath returns the location of the client config file:
+// WARPCLIP_CLIENT_CONFIG when set, else warpclip/client.toml in
+// $XDG_CONFIG_HOME, or in ~/.config without it
+func ProfilesPath() (string, error) {
+	if path := os.Getenv("WARPCLIP_CLIENT_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to find the home directory: %w", err)
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "warpclip", "client.toml"), nil
+}
+
+// LoadProfiles reads the profiles in the client config file at path, in the
+// order they appear. A missing file has none.
+func LoadProfiles(path string) ([]Profile, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	profiles, err := parseProfiles(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return profiles, nil
+}
+
+// SelectProfile returns the profile called name or, without a name, the
+// first whose Hosts match one of hosts, this machine's own names. It
+// reports whether there was one; a name missing from profiles is an error.
+func SelectProfile(profiles []Profile, name string, hosts []string) (Profile, bool, error) {
+	for _, p := range profiles {
+		if name != "" {
+			if p.Name == name {
+				return p, true, nil
+			}
+			continue
+		}
+		for _, host := range hosts {
+			if matchHostPatterns(p.Hosts, host) {
+				return p, true, nil
+			}
+		}
+	}
+	if name != "" {
+		return Profile{}, false, fmt.Errorf("no profile named %q", name)
+	}
+	return Profile{}, false, nil
+}
+
+// parseProfiles reads the subset of TOML the client config file uses:
+// [profile.NAME] tables of key = value lines, whose values are strings,
+// integers, booleans or arrays on one line, and # comments
+func parseProfiles(r io.Reader) ([]Profile, error) {
+	var profiles []Profile
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+		if text == "" {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") {
+			name, err := parseTableHeader(text)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			if seen[name] {
+				return nil, fmt.Errorf("line %d: profile %q is defined twice", line, name)
+			}
+			seen[name] = true
+			profiles = append(profiles, Profile{Name: name})
+			continue
+		}
+
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", line)
+		}
+		key = strings.TrimSpace(key)
+		if len(profiles) == 0 {
+			return nil, fmt.Errorf("line %d: %s is outside a [profile.NAME] table", line, key)
+		}
+		if err := profiles[len(profiles)-1].set(key, strings.TrimSpace(value)); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// set stores the value of the setting key
+func (p *Profile) set(key, value string) error {
+	var err error
+	switch key {
+	case "hosts":
+		p.Hosts, err = parseStringArray(value)
+	case "port":
+		p.Port, err = parsePort(value)
+	case "ports":
+		var items []string
+		if items, err = splitArray(value); err == nil {
+			p.Ports = make([]int, len(items))
+			for i, item := range items {
+				if p.Ports[i], err = parsePort(item); err != nil {
+					break
+				}
+			}
+		}
+	case "timeout":
+		p.Timeout, err = parseDuration(value)
+	case "wait_for_tunnel":
+		p.WaitForTunnel, err = parseDuration(value)
+	case "no_tunnel":
+		p.NoTunnel, err = strconv.ParseBool(value)
+	case "max_data_size":
+		// A size may be a number of bytes or a string with a unit
+		if s, strErr := parseString(value); strErr == nil {
+			value = s
+		}
+		p.MaxDataSize, err = config.ParseSize(value)
+	default:
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return nil
+}
+
+// parseTableHeader returns NAME from a [profile.NAME] line, where NAME may
+// be quoted
+func parseTableHeader(text string) (string, error) {
+	if !strings.HasSuffix(text, "]") {
+		return "", fmt.Errorf("unterminated table header %s", text)
+	}
+	table := strings.TrimSpace(text[1 : len(text)-1])
+	name, ok := strings.CutPrefix(table, "profile.")
+	if !ok {
+		return "", fmt.Errorf("unknown table [%s]; profiles are [profile.NAME]", table)
+	}
+	name = strings.TrimSpace(name)
+	if strings.HasPrefix(name, `"`) {
+		var err error
+		if name, err = parseString(name); err != nil {
+			return "", fmt.Errorf("invalid profile name: %w", err)
+		}
+	}
+	if name == "" {
+		return "", errors.New("empty profile name")
+	}
+	return name, nil
+}
+
+// stripComment removes a # comment from a line, leaving any # inside a
+// string alone
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && c == '\\':
+			// An escaped quote doesn't end the string
+			escaped = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseString parses a basic "..." or literal '...' TOML string
+func parseString(value string) (string, error) {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return value[1 : len(value)-1], nil
+	}
+	if len(value) >= 2 && value[0] == '"' {
+		return strconv.Unquote(value)
+	}
+	return "", fmt.Errorf("expected a quoted string, got %s", value)
+}
+
+// splitArray returns the elements of a one-line [a, b] array, unparsed
+func splitArray(value string) ([]string, error) {
+	if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+		return nil, fmt.Errorf("expected an array, got %s", value)
+	}
+	var items []string
+	for _, item := range strings.Split(value[1:len(value)-1], ",") {
+		// TOML allows a trailing comma
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// parseStringArray parses a one-line array of strings
+func parseStringArray(value string) ([]string, error) {
+	items, err := splitArray(value)
+	if err != nil {
+		return nil, err
+	}
+	for i, item := range items {
+		if items[i], err = parseString(item); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// parsePort parses a TCP port number
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("%s is not a port number", value)
+	}
+	return port, nil
+}
+
+// parseDuration parses a positive duration given as a string, such as "30s"
+func parseDuration(value string) (time.Duration, error) {
+	s, err := parseString(value)
+	if err != nil {
+		return 0, err
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s is not positive", s)
+	}
+	return d, nil
+}
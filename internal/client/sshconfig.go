@@ -0,0 +1,186 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ForwardedPorts returns the remote ports of the RemoteForward directives in
+// the ssh config at path that apply to any of hosts, in the order ssh would
+// set them up. Run on the remote end of a session, hosts are this machine's
+// own names, so a block applies when its Host patterns match one of them or
+// its HostName is one of them. Match blocks can't be evaluated from here and
+// are skipped. A missing config has no forwards.
+func ForwardedPorts(path string, hosts []string) ([]int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseForwardedPorts(f, hosts)
+}
+
+// sshBlock is a Host or Match section of an ssh config
+type sshBlock struct {
+	// patterns are the Host patterns, nil for a Match block
+	patterns []string
+	hostName string
+	ports    []int
+}
+
+// parseForwardedPorts reads an ssh config for ForwardedPorts
+func parseForwardedPorts(r io.Reader, hosts []string) ([]int, error) {
+	// Directives before the first Host apply to every host
+	blocks := []*sshBlock{{patterns: []string{"*"}}}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		keyword, args := splitDirective(scanner.Text())
+		block := blocks[len(blocks)-1]
+		switch keyword {
+		case "host":
+			blocks = append(blocks, &sshBlock{patterns: args})
+		case "match":
+			blocks = append(blocks, &sshBlock{})
+		case "hostname":
+			if len(args) > 0 && block.hostName == "" {
+				block.hostName = args[0]
+			}
+		case "remoteforward":
+			if port, ok := remoteForwardPort(args); ok {
+				block.ports = append(block.ports, port)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var ports []int
+	seen := make(map[int]bool)
+	for _, block := range blocks {
+		if !block.applies(hosts) {
+			continue
+		}
+		for _, port := range block.ports {
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	return ports, nil
+}
+
+// splitDirective splits an ssh config line into its lowercased keyword and
+// arguments, which may be separated from it by whitespace or an "="
+func splitDirective(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil
+	}
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+	var args []string
+	for _, arg := range strings.Fields(rest) {
+		args = append(args, strings.Trim(arg, `"`))
+	}
+	return keyword, args
+}
+
+// applies reports whether the block's settings are for one of hosts
+func (b *sshBlock) applies(hosts []string) bool {
+	if b.patterns == nil {
+		return false
+	}
+	for _, host := range hosts {
+		if b.hostName != "" && strings.EqualFold(b.hostName, host) {
+			return true
+		}
+		if matchHostPatterns(b.patterns, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHostPatterns reports whether host matches the patterns of a Host
+// line: any of them, and none of those negated with "!"
+func matchHostPatterns(patterns []string, host string) bool {
+	host = strings.ToLower(host)
+	matched := false
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if negated := strings.TrimPrefix(pattern, "!"); negated != pattern {
+			if matchWildcard(negated, host) {
+				return false
+			}
+			continue
+		}
+		if matchWildcard(pattern, host) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchWildcard matches name against an ssh pattern, where "*" stands for
+// any run of characters and "?" for any one
+func matchWildcard(pattern, name string) bool {
+	for pattern != "" {
+		switch pattern[0] {
+		case '*':
+			pattern = strings.TrimLeft(pattern, "*")
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchWildcard(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if name == "" {
+				return false
+			}
+		default:
+			if name == "" || name[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return name == ""
+}
+
+// remoteForwardPort returns the remote port of a RemoteForward's arguments,
+// "[BIND:]PORT HOST:HOSTPORT". A forward without a destination is a SOCKS
+// proxy rather than a tunnel to warpclipd, and port 0 is allocated by the
+// server, so neither has a port to use.
+func remoteForwardPort(args []string) (int, bool) {
+	if len(args) < 2 {
+		return 0, false
+	}
+	listen := args[0]
+	if i := strings.LastIndex(listen, ":"); i >= 0 {
+		listen = listen[i+1:]
+	}
+	port, err := strconv.Atoi(listen)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, false
+	}
+	return port, true
+}
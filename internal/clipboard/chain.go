@@ -0,0 +1,119 @@
+package clipboard
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ChainBackend implements Backend by trying several backends in order and
+// using the first that succeeds, for machines where the clipboard tool that
+// works can change, such as with SSH-forwarded X that comes and goes. It
+// only handles plain text.
+type ChainBackend struct {
+	backends []Backend
+
+	mu sync.Mutex
+	// last is the backend that handled the latest operation
+	last Backend
+}
+
+// NewChain creates a backend that tries backends in order
+func NewChain(backends []Backend) *ChainBackend {
+	return &ChainBackend{backends: backends, last: backends[0]}
+}
+
+// Name returns the name of the backend that handled the latest copy or
+// paste, or of the first in the chain before any
+func (c *ChainBackend) Name() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last.Name()
+}
+
+// Names returns the names of the backends in the order they are tried
+func (c *ChainBackend) Names() []string {
+	names := make([]string, len(c.backends))
+	for i, b := range c.backends {
+		names[i] = b.Name()
+	}
+	return names
+}
+
+// Check succeeds if any backend in the chain is usable
+func (c *ChainBackend) Check() error {
+	var errs []error
+	for _, b := range c.backends {
+		err := Check(b)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return fmt.Errorf("no backend in the chain is usable: %w", errors.Join(errs...))
+}
+
+// Copy copies data with the first backend that succeeds
+func (c *ChainBackend) Copy(data []byte) error {
+	return c.try(func(b Backend) error {
+		return b.Copy(data)
+	})
+}
+
+// Paste returns the clipboard contents from the first backend that can read
+// them
+func (c *ChainBackend) Paste() ([]byte, error) {
+	var data []byte
+	err := c.try(func(b Backend) error {
+		var err error
+		data, err = b.Paste()
+		return err
+	})
+	return data, err
+}
+
+// CanPaste reports whether any backend in the chain can read the clipboard
+func (c *ChainBackend) CanPaste() bool {
+	for _, b := range c.backends {
+		if CanPaste(b) {
+			return true
+		}
+	}
+	return false
+}
+
+// try runs op with each backend in turn until one succeeds, remembering
+// which did. If none does, the error lists every failure.
+func (c *ChainBackend) try(op func(b Backend) error) error {
+	var failures []string
+	var errs []error
+	for _, b := range c.backends {
+		err := op(b)
+		if err == nil {
+			c.mu.Lock()
+			c.last = b
+			c.mu.Unlock()
+			return nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", b.Name(), err))
+		errs = append(errs, err)
+	}
+	return &chainError{message: strings.Join(failures, "; "), errs: errs}
+}
+
+// chainError is the failure of every backend in a chain. It matches
+// whatever any of the failures matches, so a missing program or graphical
+// session is still recognized.
+type chainError struct {
+	message string
+	errs    []error
+}
+
+func (e *chainError) Error() string {
+	return "every clipboard backend failed: " + e.message
+}
+
+func (e *chainError) Unwrap() []error {
+	return e.errs
+}
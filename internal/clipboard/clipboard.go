@@ -0,0 +1,142 @@
+package clipboard
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrPasteUnsupported is returned by backends that can only write to the clipboard
+var ErrPasteUnsupported = errors.New("backend does not support reading the clipboard")
+
+// Backend defines the interface for clipboard implementations
+type Backend interface {
+	// Copy replaces the clipboard contents with data
+	Copy(data []byte) error
+	// Paste returns the current clipboard contents
+	Paste() ([]byte, error)
+	// Name returns the registered name of the backend
+	Name() string
+}
+
+// Checker is implemented by backends that depend on something outside the
+// process, such as an external program, and can tell up front whether it
+// is available
+type Checker interface {
+	// Check returns an error describing what is missing, or nil
+	Check() error
+}
+
+// Check reports whether b is usable. Backends that don't implement Checker
+// are assumed to be.
+func Check(b Backend) error {
+	if checker, ok := b.(Checker); ok {
+		return checker.Check()
+	}
+	return nil
+}
+
+// PasteChecker is implemented by backends that may be unable to read the
+// clipboard at all, such as a command backend without a paste command
+type PasteChecker interface {
+	// CanPaste reports whether Paste can succeed
+	CanPaste() bool
+}
+
+// CanPaste reports whether b can read the clipboard. Backends that don't
+// implement PasteChecker are assumed to.
+func CanPaste(b Backend) bool {
+	if checker, ok := b.(PasteChecker); ok {
+		return checker.CanPaste()
+	}
+	return true
+}
+
+// Options holds settings passed to backend factories
+type Options struct {
+	// Command is the shell command used by the custom-command backend
+	Command string
+	// Args are appended to the copy command; the custom-command backend
+	// passes them to its shell command as "$@"
+	Args []string
+	// Timeout bounds each clipboard command invocation
+	Timeout time.Duration
+}
+
+// Factory creates a backend from options
+type Factory func(opts Options) (Backend, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a backend available under name, replacing any previous
+// registration with the same name
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = factory
+}
+
+// New creates the backend registered under name
+func New(name string, opts Options) (Backend, error) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown clipboard backend %q (available: %v)", name, Names())
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	return factory(opts)
+}
+
+// Names returns the sorted names of all registered backends
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Default returns the name of the preferred backend for the given OS
+func Default(goos string) string {
+	switch goos {
+	case "darwin":
+		return "pbcopy"
+	case "windows":
+		return "clip.exe"
+	case "linux":
+		// Prefer Wayland when a compositor is running, then the X11 tools
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			if _, err := exec.LookPath("wl-copy"); err == nil {
+				return "wl-copy"
+			}
+		}
+		if _, err := exec.LookPath("xclip"); err == nil {
+			return "xclip"
+		}
+		if _, err := exec.LookPath("xsel"); err == nil {
+			return "xsel"
+		}
+		// WSL exposes the Windows clipboard through clip.exe
+		if _, err := exec.LookPath("clip.exe"); err == nil {
+			return "clip.exe"
+		}
+		return "xclip"
+	default:
+		return "pbcopy"
+	}
+}
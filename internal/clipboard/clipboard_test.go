@@ -0,0 +1,500 @@
+package clipboard
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	names := Names()
+	for _, want := range []string{"clip.exe", "custom-command", "pbcopy", "wl-copy", "xclip", "xsel"} {
+		found := false
+		for _, name := range names {
+			if name == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Backend %q not registered (have %v)", want, names)
+		}
+	}
+
+	// The memory backend is only for tests, which create it directly
+	if _, err := New("memory", Options{}); err == nil {
+		t.Error("The memory backend is registered")
+	}
+
+	backend, err := New("xclip", Options{})
+	if err != nil {
+		t.Fatalf("New(xclip) failed: %v", err)
+	}
+	if backend.Name() != "xclip" {
+		t.Errorf("Expected backend name xclip, got %s", backend.Name())
+	}
+
+	if _, err := New("no-such-backend", Options{}); err == nil {
+		t.Error("Expected error for unknown backend, got nil")
+	}
+
+	if _, err := New("custom-command", Options{}); err == nil {
+		t.Error("Expected error for custom-command without a command, got nil")
+	}
+}
+
+func TestDefault(t *testing.T) {
+	testCases := []struct {
+		goos     string
+		expected string
+	}{
+		{goos: "darwin", expected: "pbcopy"},
+		{goos: "windows", expected: "clip.exe"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.goos, func(t *testing.T) {
+			if got := Default(tc.goos); got != tc.expected {
+				t.Errorf("Default(%q) = %q, want %q", tc.goos, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	memory := NewMemoryBackend()
+
+	data := []byte("first")
+	if err := memory.Copy(data); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	// Mutating the caller's slice must not change the stored clipboard
+	data[0] = 'X'
+	content, err := memory.Paste()
+	if err != nil {
+		t.Fatalf("Paste failed: %v", err)
+	}
+	if string(content) != "first" {
+		t.Errorf("Paste returned %q, want %q", content, "first")
+	}
+
+	if err := memory.Copy([]byte("second")); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	content, _ = memory.Paste()
+	if string(content) != "second" {
+		t.Errorf("Paste returned %q, want %q", content, "second")
+	}
+	if memory.Copies() != 2 {
+		t.Errorf("Expected 2 copies, got %d", memory.Copies())
+	}
+}
+
+func TestCustomCommandCopy(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	output := filepath.Join(tmpDir, "clipboard")
+	backend, err := New("custom-command", Options{Command: "cat > " + output})
+	if err != nil {
+		t.Fatalf("New(custom-command) failed: %v", err)
+	}
+
+	testData := []byte("Hello, clipboard!")
+	if err := backend.Copy(testData); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+
+	content, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Failed to read command output: %v", err)
+	}
+	if string(content) != string(testData) {
+		t.Errorf("Clipboard data doesn't match: got %q, want %q", content, testData)
+	}
+
+	if _, err := backend.Paste(); !errors.Is(err, ErrPasteUnsupported) {
+		t.Errorf("Expected ErrPasteUnsupported, got %v", err)
+	}
+}
+
+func TestCustomCommandArgs(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	output := filepath.Join(tmpDir, "args")
+	backend, err := New("custom-command", Options{
+		// Read the input first so writing it can't fail with a broken pipe
+		Command: `cat >/dev/null; printf '%s\n' "$@" > ` + output,
+		Args:    []string{"-pboard", "ruler"},
+	})
+	if err != nil {
+		t.Fatalf("New(custom-command) failed: %v", err)
+	}
+	if err := backend.Copy([]byte("data")); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+
+	content, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Failed to read command output: %v", err)
+	}
+	if string(content) != "-pboard\nruler\n" {
+		t.Errorf("Command received arguments %q, want %q", content, "-pboard\nruler\n")
+	}
+}
+
+func TestChainBackend(t *testing.T) {
+	failing, err := New("custom-command", Options{Command: "echo 'Error: cannot open display' >&2; exit 1"})
+	if err != nil {
+		t.Fatalf("New(custom-command) failed: %v", err)
+	}
+	memory := NewMemoryBackend()
+	chain := NewChain([]Backend{failing, memory})
+
+	if err := chain.Copy([]byte("fallback")); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if data, _ := memory.Paste(); string(data) != "fallback" {
+		t.Errorf("Second backend holds %q, want the copy", data)
+	}
+	if chain.Name() != "memory" {
+		t.Errorf("Chain reports %q handled the copy, want memory", chain.Name())
+	}
+	if strings.Join(chain.Names(), ",") != "custom-command,memory" {
+		t.Errorf("Chain names = %v", chain.Names())
+	}
+
+	// The custom command can't paste, so the paste falls through too
+	if data, err := chain.Paste(); err != nil || string(data) != "fallback" {
+		t.Errorf("Paste = %q, %v; want the second backend's contents", data, err)
+	}
+
+	// Every failure is reported, and still recognized for what it is
+	allFailing := NewChain([]Backend{failing, failing})
+	err = allFailing.Copy([]byte("lost"))
+	if err == nil || !errors.Is(err, ErrNoSession) || !strings.Contains(err.Error(), "custom-command: ") {
+		t.Errorf("Copy with every backend failing returned %v, want each failure", err)
+	}
+}
+
+func TestCommandBackendErrorClassification(t *testing.T) {
+	missing := NewCommandBackend("test", []string{"warpclip-no-such-command"}, nil, 0)
+	if err := missing.Copy([]byte("data")); !errors.Is(err, ErrCommandNotFound) {
+		t.Errorf("Expected ErrCommandNotFound, got %v", err)
+	}
+
+	noDisplay := NewCommandBackend("test", []string{"sh", "-c", "cat >/dev/null; echo \"Error: Can't open display: (null)\" >&2; exit 1"}, nil, 0)
+	err := noDisplay.Copy([]byte("data"))
+	if !errors.Is(err, ErrNoSession) {
+		t.Errorf("Expected ErrNoSession, got %v", err)
+	}
+	if err != nil && !strings.Contains(err.Error(), "Can't open display") {
+		t.Errorf("Error does not include the command's stderr: %v", err)
+	}
+
+	// pbcopy started over SSH has no pasteboard rather than no display
+	noPasteboard := NewCommandBackend("test", []string{"sh", "-c", "cat >/dev/null; echo 'pbcopy: Could not access pasteboard' >&2; exit 1"}, nil, 0)
+	if err := noPasteboard.Copy([]byte("data")); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Expected ErrNoSession for a pasteboard failure, got %v", err)
+	}
+
+	failing := NewCommandBackend("test", []string{"sh", "-c", "echo broken >&2; exit 1"}, nil, 0)
+	if err := failing.Copy(nil); err == nil || errors.Is(err, ErrNoSession) || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("Expected unclassified error including stderr, got %v", err)
+	}
+}
+
+func TestCommandBackendPaste(t *testing.T) {
+	backend := NewCommandBackend("test", []string{"true"}, []string{"printf", "pasted"}, 0)
+
+	data, err := backend.Paste()
+	if err != nil {
+		t.Fatalf("Paste failed: %v", err)
+	}
+	if string(data) != "pasted" {
+		t.Errorf("Paste returned %q, want %q", data, "pasted")
+	}
+}
+
+func TestCommandBackendFailures(t *testing.T) {
+	failing := NewCommandBackend("test", []string{"false"}, nil, 0)
+	if err := failing.Copy([]byte("data")); err == nil {
+		t.Error("Expected error from failing command, got nil")
+	}
+
+	slow := NewCommandBackend("test", []string{"sleep", "5"}, nil, 100*time.Millisecond)
+	start := time.Now()
+	if err := slow.Copy(nil); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Timed out command took %v to return", elapsed)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	output, err := Filter("tr a-z A-Z", []byte("hello"), 0)
+	if err != nil {
+		t.Fatalf("Filter failed: %v", err)
+	}
+	if string(output) != "HELLO" {
+		t.Errorf("Filter returned %q, want %q", output, "HELLO")
+	}
+
+	if _, err := Filter("echo 'bad input' >&2; exit 3", []byte("data"), 0); err == nil || !strings.Contains(err.Error(), "bad input") {
+		t.Errorf("Expected failure including stderr, got %v", err)
+	}
+
+	start := time.Now()
+	if _, err := Filter("sleep 5", nil, 100*time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Timed out filter took %v to return", elapsed)
+	}
+}
+
+func TestRead(t *testing.T) {
+	memory := NewMemoryBackend()
+	memory.Copy([]byte("caption"))
+	memory.SetType("image/png", []byte("png bytes"))
+
+	testCases := []struct {
+		name     string
+		accept   []string
+		wantType string
+		wantData string
+		wantErr  bool
+	}{
+		{name: "default", wantType: "text/plain", wantData: "caption"},
+		{name: "image", accept: []string{"image/png"}, wantType: "image/png", wantData: "png bytes"},
+		{name: "first available", accept: []string{"image/tiff", "image/png"}, wantType: "image/png", wantData: "png bytes"},
+		{name: "any", accept: []string{AnyType}, wantType: "text/plain", wantData: "caption"},
+		{name: "missing", accept: []string{"image/tiff"}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			content, err := Read(memory, tc.accept)
+			if tc.wantErr {
+				if !errors.Is(err, ErrTypeUnavailable) {
+					t.Errorf("Expected ErrTypeUnavailable, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Read failed: %v", err)
+			}
+			if content.Type != tc.wantType || string(content.Data) != tc.wantData {
+				t.Errorf("Read returned %s %q, want %s %q", content.Type, content.Data, tc.wantType, tc.wantData)
+			}
+		})
+	}
+
+	// Backends without type support only offer plain text
+	plain := NewCommandBackend("test", []string{"true"}, []string{"printf", "pasted"}, 0)
+	if _, err := Read(plain, []string{"image/png"}); !errors.Is(err, ErrTypeUnavailable) {
+		t.Errorf("Expected ErrTypeUnavailable from untyped backend, got %v", err)
+	}
+	content, err := Read(plain, nil)
+	if err != nil || string(content.Data) != "pasted" {
+		t.Errorf("Read from untyped backend returned %q, %v", content.Data, err)
+	}
+}
+
+func TestReadConvertedToPNG(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 2, color.RGBA{R: 255, A: 255})
+	var gifData bytes.Buffer
+	if err := gif.Encode(&gifData, img, nil); err != nil {
+		t.Fatalf("Failed to encode GIF: %v", err)
+	}
+
+	memory := NewMemoryBackend()
+	memory.Copy([]byte("caption"))
+	memory.SetType("image/gif", gifData.Bytes())
+
+	content, err := Read(memory, []string{"image/png"})
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if content.Type != "image/png" || content.ConvertedFrom != "image/gif" {
+		t.Errorf("Read returned %s converted from %q, want image/png from image/gif", content.Type, content.ConvertedFrom)
+	}
+	decoded, err := png.Decode(bytes.NewReader(content.Data))
+	if err != nil {
+		t.Fatalf("Converted data is not a PNG: %v", err)
+	}
+	if decoded.Bounds() != img.Bounds() {
+		t.Errorf("Converted image is %v, want %v", decoded.Bounds(), img.Bounds())
+	}
+
+	// An image that is really PNG is passed through untouched
+	memory.SetType("image/png", []byte("png bytes"))
+	if content, err := Read(memory, []string{"image/png"}); err != nil || string(content.Data) != "png bytes" || content.ConvertedFrom != "" {
+		t.Errorf("Read returned %q converted from %q, %v; want the PNG as stored", content.Data, content.ConvertedFrom, err)
+	}
+
+	// An image that can't be converted fails clearly rather than as missing
+	broken := NewMemoryBackend()
+	broken.SetType("image/jpeg", []byte("not a jpeg"))
+	if _, err := Read(broken, []string{"image/png"}); !errors.Is(err, ErrConversionFailed) || !strings.Contains(err.Error(), "image/jpeg") {
+		t.Errorf("Expected ErrConversionFailed naming image/jpeg, got %v", err)
+	}
+
+	// Other types are never converted
+	if _, err := Read(broken, []string{"image/gif"}); !errors.Is(err, ErrTypeUnavailable) {
+		t.Errorf("Expected ErrTypeUnavailable for image/gif, got %v", err)
+	}
+
+	// A conversion tool that hangs is killed at the timeout
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sips"), []byte("#!/bin/sh\nexec sleep 5\n"), 0755); err != nil {
+		t.Fatalf("Failed to write fake sips: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	start := time.Now()
+	if _, err := convertToPNG([]byte("tiff"), "image/tiff", 100*time.Millisecond); !errors.Is(err, ErrTimeout) || !errors.Is(err, ErrConversionFailed) {
+		t.Errorf("Expected a conversion ErrTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Hung conversion took %v to give up", elapsed)
+	}
+}
+
+func TestParseTargets(t *testing.T) {
+	output := "TIMESTAMP\nTARGETS\nMULTIPLE\nimage/png\ntext/html\ntext/plain;charset=utf-8\nUTF8_STRING\nSTRING\n"
+	got := strings.Join(parseTargets([]byte(output)), ",")
+	if want := "image/png,text/html,text/plain"; got != want {
+		t.Errorf("parseTargets = %s, want %s", got, want)
+	}
+}
+
+func TestParseClipboardInfo(t *testing.T) {
+	output := "«class PNGf», 2048, «class 8BPS», 100, TIFF picture, 9000, «class utf8», 5, string, 5\n"
+	got := strings.Join(parseClipboardInfo([]byte(output)), ",")
+	if want := "image/png,image/tiff,text/plain"; got != want {
+		t.Errorf("parseClipboardInfo = %s, want %s", got, want)
+	}
+}
+
+func TestDecodeAppleScriptData(t *testing.T) {
+	data, err := decodeAppleScriptData([]byte("«data PNGf89504E47»\n"))
+	if err != nil {
+		t.Fatalf("decodeAppleScriptData failed: %v", err)
+	}
+	if string(data) != "\x89PNG" {
+		t.Errorf("decodeAppleScriptData = %q, want %q", data, "\x89PNG")
+	}
+
+	if _, err := decodeAppleScriptData([]byte("missing value")); err == nil {
+		t.Error("Expected error for non-data output, got nil")
+	}
+}
+
+func TestTypedCommandBackend(t *testing.T) {
+	backend := &TypedCommandBackend{
+		CommandBackend: NewCommandBackend("test", []string{"true"}, []string{"printf", "text"}, 0),
+		types: typeCommands{
+			listCmd:   []string{"printf", "image/png\nUTF8_STRING\n"},
+			parseList: parseTargets,
+			pasteCmd:  func(mimeType string) []string { return []string{"printf", mimeType} },
+		},
+	}
+
+	content, err := Read(backend, []string{"image/png"})
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(content.Data) != "image/png" {
+		t.Errorf("Read returned %q, want output of the type's paste command", content.Data)
+	}
+
+	content, err = Read(backend, []string{"text/plain"})
+	if err != nil || string(content.Data) != "text" {
+		t.Errorf("Read of text returned %q, %v; want the regular paste command", content.Data, err)
+	}
+}
+
+func TestCopyType(t *testing.T) {
+	tempDir := t.TempDir()
+	output := filepath.Join(tempDir, "copied")
+	backend := &TypedCommandBackend{
+		CommandBackend: NewCommandBackend("test", []string{"true"}, nil, 0),
+		types: typeCommands{
+			copyTypes: []string{"application/pdf"},
+			copyCmd: func(mimeType string, data []byte) ([]string, []byte, error) {
+				return []string{"/bin/sh", "-c", `printf '%s:' "$1" > "$2"; cat >> "$2"`, "sh", mimeType, output}, data, nil
+			},
+		},
+	}
+
+	if err := Write(backend, "application/pdf", []byte("%PDF")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if got, _ := os.ReadFile(output); string(got) != "application/pdf:%PDF" {
+		t.Errorf("Copy command got %q, want the type and data", got)
+	}
+
+	if err := Write(backend, "image/png", []byte("\x89PNG")); !errors.Is(err, ErrCopyTypeUnsupported) {
+		t.Errorf("Write of an unlisted type returned %v, want ErrCopyTypeUnsupported", err)
+	}
+
+	xclip, err := New("xclip", Options{})
+	if err != nil {
+		t.Fatalf("New(xclip) failed: %v", err)
+	}
+	if err := CheckCopyType(xclip, "application/pdf"); !errors.Is(err, ErrCopyTypeUnsupported) {
+		t.Errorf("CheckCopyType(xclip) returned %v, want ErrCopyTypeUnsupported", err)
+	}
+	if err := CheckCopyType(xclip, TextType); err != nil {
+		t.Errorf("CheckCopyType(xclip, text) returned %v", err)
+	}
+}
+
+func TestPasteboardCopyCmd(t *testing.T) {
+	for _, mimeType := range pasteboardCopyTypes {
+		if _, ok := pasteboardUTIs[mimeType]; !ok && mimeType != URIListType {
+			t.Errorf("No pasteboard type for %s", mimeType)
+		}
+	}
+
+	args, input, err := pasteboardCopyCmd("application/pdf", []byte("%PDF"))
+	if err != nil || args[len(args)-1] != "com.adobe.pdf" || string(input) != "%PDF" {
+		t.Errorf("pasteboardCopyCmd(pdf) = %q, %q, %v", args[len(args)-1], input, err)
+	}
+
+	file := filepath.Join(t.TempDir(), "report one.pdf")
+	if err := os.WriteFile(file, []byte("%PDF"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	list := "# generated\r\nfile://" + strings.ReplaceAll(file, " ", "%20") + "\r\n"
+	args, input, err = pasteboardCopyCmd(URIListType, []byte(list))
+	if err != nil {
+		t.Fatalf("pasteboardCopyCmd(uri-list) failed: %v", err)
+	}
+	if got := strings.Join(args[len(args)-2:], "|"); got != "files|"+file || input != nil {
+		t.Errorf("pasteboardCopyCmd(uri-list) ended with %q and piped %q, want the file path", got, input)
+	}
+
+	for _, bad := range []string{"", "https://example.com/a.pdf", "file:///no/such/file.pdf", "file://remote-host/a.pdf"} {
+		if err := Validate(URIListType, []byte(bad)); err == nil {
+			t.Errorf("Validate(%q) succeeded, want an error", bad)
+		}
+	}
+}
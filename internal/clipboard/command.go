@@ -0,0 +1,295 @@
+package clipboard
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds a single clipboard command when no timeout is configured
+const DefaultTimeout = 5 * time.Second
+
+var (
+	// ErrCommandNotFound is returned when the clipboard program isn't installed
+	ErrCommandNotFound = errors.New("clipboard command not found")
+	// ErrTimeout is returned when the clipboard program doesn't finish in time
+	ErrTimeout = errors.New("timed out")
+	// ErrNoSession is returned when the clipboard program can't reach a
+	// graphical session, as when the daemon runs outside the user's desktop
+	ErrNoSession = errors.New("no graphical session available")
+)
+
+// noSessionMessages are fragments of the errors clipboard programs print when
+// there is no display or compositor to talk to, or in pbcopy's case no
+// pasteboard, as when it runs over SSH rather than in the desktop session
+var noSessionMessages = []string{
+	"can't open display",
+	"cannot open display",
+	"unable to open display",
+	"failed to connect to a wayland server",
+	"no wayland display",
+	"could not access pasteboard",
+	"unable to access pasteboard",
+	"could not get pasteboard",
+	"couldn't write to pasteboard",
+	"launch_msg",
+}
+
+// execCommand is used to create clipboard commands, replaceable in tests
+var execCommand = exec.Command
+
+// CommandBackend implements Backend by running external programs
+type CommandBackend struct {
+	name     string
+	copyCmd  []string
+	pasteCmd []string
+	timeout  time.Duration
+}
+
+// NewCommandBackend creates a backend that pipes data into copyCmd and reads
+// the clipboard from the output of pasteCmd. A nil pasteCmd disables Paste.
+func NewCommandBackend(name string, copyCmd, pasteCmd []string, timeout time.Duration) *CommandBackend {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &CommandBackend{
+		name:     name,
+		copyCmd:  copyCmd,
+		pasteCmd: pasteCmd,
+		timeout:  timeout,
+	}
+}
+
+func init() {
+	commands := []struct {
+		name     string
+		copyCmd  []string
+		pasteCmd []string
+		// types reads formats other than plain text, where the tools allow it
+		types *typeCommands
+	}{
+		{"pbcopy", []string{"pbcopy"}, []string{"pbpaste"}, &pasteboardTypes},
+		{"xclip", []string{"xclip", "-selection", "clipboard", "-in"}, []string{"xclip", "-selection", "clipboard", "-out"}, &x11Types},
+		{"xsel", []string{"xsel", "--clipboard", "--input"}, []string{"xsel", "--clipboard", "--output"}, nil},
+		{"wl-copy", []string{"wl-copy"}, []string{"wl-paste", "--no-newline"}, &waylandTypes},
+		{"clip.exe", []string{"clip.exe"}, []string{"powershell.exe", "-NoProfile", "-Command", "Get-Clipboard"}, nil},
+	}
+
+	for _, c := range commands {
+		c := c
+		Register(c.name, func(opts Options) (Backend, error) {
+			copyCmd := append(append([]string{}, c.copyCmd...), opts.Args...)
+			backend := NewCommandBackend(c.name, copyCmd, c.pasteCmd, opts.Timeout)
+			if c.types != nil {
+				return &TypedCommandBackend{CommandBackend: backend, types: *c.types}, nil
+			}
+			return backend, nil
+		})
+	}
+
+	Register("custom-command", func(opts Options) (Backend, error) {
+		if opts.Command == "" {
+			return nil, fmt.Errorf("custom-command backend requires a command")
+		}
+		// The word after the command becomes $0, the extra arguments $1 onwards
+		copyCmd := append([]string{"/bin/sh", "-c", opts.Command, "warpclip"}, opts.Args...)
+		return NewCommandBackend("custom-command", copyCmd, nil, opts.Timeout), nil
+	})
+}
+
+// Name returns the registered name of the backend
+func (b *CommandBackend) Name() string {
+	return b.name
+}
+
+// commandTimeout returns how long each of the backend's commands may run,
+// which image conversions for it are held to as well
+func (b *CommandBackend) commandTimeout() time.Duration {
+	return b.timeout
+}
+
+// Check verifies that the copy command is on the PATH
+func (b *CommandBackend) Check() error {
+	if _, err := exec.LookPath(b.copyCmd[0]); err != nil {
+		return fmt.Errorf("%s backend needs %s, which was not found on the PATH", b.name, b.copyCmd[0])
+	}
+	return nil
+}
+
+// Copy pipes data into the copy command
+func (b *CommandBackend) Copy(data []byte) error {
+	return b.input(b.copyCmd, data)
+}
+
+// input runs args with data piped into it
+func (b *CommandBackend) input(args []string, data []byte) error {
+	program := args[0]
+	cmd := execCommand(program, args[1:]...)
+
+	// Get stdin pipe
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get stdin pipe: %w", err)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	// Start the command
+	if err := start(cmd); err != nil {
+		return err
+	}
+
+	// Create a buffered writer for better performance
+	writer := bufio.NewWriter(stdin)
+
+	// Write data to stdin
+	_, err = writer.Write(data)
+	if err != nil {
+		stdin.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+		return classify(fmt.Errorf("failed to write data to %s: %w", program, err), stderr.String())
+	}
+
+	// Flush the buffer
+	if err := writer.Flush(); err != nil {
+		stdin.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+		return classify(fmt.Errorf("failed to flush data to %s: %w", program, err), stderr.String())
+	}
+
+	// Close stdin
+	if err := stdin.Close(); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("failed to close stdin: %w", err)
+	}
+
+	return b.wait(cmd, &stderr)
+}
+
+// Paste runs the paste command and returns its output
+func (b *CommandBackend) Paste() ([]byte, error) {
+	if b.pasteCmd == nil {
+		return nil, ErrPasteUnsupported
+	}
+	return b.output(b.pasteCmd)
+}
+
+// CanPaste reports whether the backend has a paste command
+func (b *CommandBackend) CanPaste() bool {
+	return b.pasteCmd != nil
+}
+
+// output runs args and returns what it printed to stdout
+func (b *CommandBackend) output(args []string) ([]byte, error) {
+	program := args[0]
+	cmd := execCommand(program, args[1:]...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := start(cmd); err != nil {
+		return nil, err
+	}
+
+	if err := b.wait(cmd, &stderr); err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// Filter pipes data through a shell command and returns what it printed to
+// stdout. The command is killed if it runs longer than timeout, and a failure
+// includes what it printed to stderr.
+func Filter(command string, data []byte, timeout time.Duration) ([]byte, error) {
+	b := NewCommandBackend("filter", []string{"/bin/sh", "-c", command}, nil, timeout)
+	cmd := execCommand(b.copyCmd[0], b.copyCmd[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	// Children of the shell that outlive it on a timeout would otherwise
+	// hold stdout open and keep Wait from returning
+	cmd.WaitDelay = time.Second
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := start(cmd); err != nil {
+		return nil, err
+	}
+	if err := b.wait(cmd, &stderr); err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// start starts cmd, identifying a program that isn't installed
+func start(cmd *exec.Cmd) error {
+	err := cmd.Start()
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to start %s: %w: %w", cmd.Args[0], ErrCommandNotFound, err)
+	}
+	return fmt.Errorf("failed to start %s: %w", cmd.Args[0], err)
+}
+
+// wait waits for cmd to finish, killing it if it exceeds the timeout. A
+// failure includes what the command printed to stderr.
+func (b *CommandBackend) wait(cmd *exec.Cmd, stderr *bytes.Buffer) error {
+	program := cmd.Args[0]
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	// Wait for completion or timeout
+	select {
+	case err := <-done:
+		if err != nil {
+			err = fmt.Errorf("%s command failed: %w", program, err)
+			if detail := firstLine(stderr.String()); detail != "" {
+				err = fmt.Errorf("%w: %s", err, detail)
+			}
+			return classify(err, stderr.String())
+		}
+	case <-time.After(b.timeout):
+		// Kill the process if it takes too long
+		cmd.Process.Kill()
+		<-done
+		return fmt.Errorf("%s operation %w after %v", program, ErrTimeout, b.timeout)
+	}
+
+	return nil
+}
+
+// classify marks err as ErrNoSession when the command's stderr shows it
+// couldn't reach a display or compositor
+func classify(err error, stderr string) error {
+	lower := strings.ToLower(stderr)
+	for _, message := range noSessionMessages {
+		if strings.Contains(lower, message) {
+			return fmt.Errorf("%w: %w", ErrNoSession, err)
+		}
+	}
+	return err
+}
+
+// firstLine returns the first non-empty line of s, trimmed
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
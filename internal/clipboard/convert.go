@@ -0,0 +1,89 @@
+package clipboard
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // registers the GIF decoder
+	_ "image/jpeg" // registers the JPEG decoder
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// PNGType is the MIME type of PNG images, which other image formats on the
+// clipboard are converted to when asked for
+const PNGType = "image/png"
+
+// ErrConversionFailed is returned when the clipboard holds an image that
+// can't be converted to the requested format
+var ErrConversionFailed = errors.New("failed to convert clipboard image")
+
+// pngSources are the image types convertible to PNG. macOS apps often put
+// only TIFF on the pasteboard, which Go can't decode, so sips converts it
+// within the timeout.
+var pngSources = map[string]func(data []byte, timeout time.Duration) ([]byte, error){
+	"image/tiff": sipsToPNG,
+	"image/jpeg": decodeToPNG,
+	"image/gif":  decodeToPNG,
+	"image/bmp":  sipsToPNG,
+}
+
+// convertToPNG converts data of mimeType, one of pngSources, to PNG, giving
+// a conversion tool timeout to finish
+func convertToPNG(data []byte, mimeType string, timeout time.Duration) ([]byte, error) {
+	converted, err := pngSources[mimeType](data, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("%w from %s to %s: %w", ErrConversionFailed, mimeType, PNGType, err)
+	}
+	return converted, nil
+}
+
+// decodeToPNG converts an image Go can decode to PNG
+func decodeToPNG(data []byte, _ time.Duration) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sipsToPNG converts an image to PNG with the macOS sips tool, through
+// temporary files as sips doesn't read stdin. sips is killed if it runs
+// longer than timeout.
+func sipsToPNG(data []byte, timeout time.Duration) ([]byte, error) {
+	sips, err := exec.LookPath("sips")
+	if err != nil {
+		return nil, errors.New("sips is not available to convert it (it ships with macOS)")
+	}
+
+	dir, err := os.MkdirTemp("", "warpclip-convert")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in := filepath.Join(dir, "clipboard")
+	out := filepath.Join(dir, "clipboard.png")
+	if err := os.WriteFile(in, data, 0600); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, sips, "-s", "format", "png", in, "--out", out).CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("sips %w after %v", ErrTimeout, timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sips failed: %v: %s", err, bytes.TrimSpace(output))
+	}
+	return os.ReadFile(out)
+}
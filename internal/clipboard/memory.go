@@ -0,0 +1,108 @@
+package clipboard
+
+import "sync"
+
+// MemoryBackend implements Backend by keeping the clipboard in memory. It is
+// intended for tests that need to assert on clipboard contents without
+// touching the system clipboard, so it isn't registered: WARPCLIP_BACKEND
+// can't select it for a real daemon.
+type MemoryBackend struct {
+	mu      sync.Mutex
+	formats []format
+	copies  int
+}
+
+// format is the clipboard contents in one MIME type
+type format struct {
+	mimeType string
+	data     []byte
+}
+
+// NewMemoryBackend creates an empty in-memory clipboard
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{}
+}
+
+// Name returns the registered name of the backend
+func (m *MemoryBackend) Name() string {
+	return "memory"
+}
+
+// Copy stores a copy of data as the plain text clipboard contents, replacing
+// every other format
+func (m *MemoryBackend) Copy(data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.formats = []format{{mimeType: TextType, data: append([]byte{}, data...)}}
+	m.copies++
+	return nil
+}
+
+// Paste returns a copy of the plain text clipboard contents
+func (m *MemoryBackend) Paste() ([]byte, error) {
+	data, err := m.PasteType(TextType)
+	if err == ErrTypeUnavailable {
+		return []byte{}, nil
+	}
+	return data, err
+}
+
+// CopyTypes reports that any MIME type can be copied
+func (m *MemoryBackend) CopyTypes() []string {
+	return []string{AnyType}
+}
+
+// CopyType stores a copy of data as the clipboard contents in mimeType,
+// replacing every other format
+func (m *MemoryBackend) CopyType(mimeType string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.formats = []format{{mimeType: mimeType, data: append([]byte{}, data...)}}
+	m.copies++
+	return nil
+}
+
+// SetType stores data as the clipboard contents in mimeType alongside any
+// other formats, the way an application offers several representations of
+// one copy. It does not count as a copy.
+func (m *MemoryBackend) SetType(mimeType string, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.formats {
+		if m.formats[i].mimeType == mimeType {
+			m.formats[i].data = append([]byte{}, data...)
+			return
+		}
+	}
+	m.formats = append(m.formats, format{mimeType: mimeType, data: append([]byte{}, data...)})
+}
+
+// Types lists the MIME types the clipboard holds in the order they were stored
+func (m *MemoryBackend) Types() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	types := make([]string, 0, len(m.formats))
+	for _, f := range m.formats {
+		types = append(types, f.mimeType)
+	}
+	return types, nil
+}
+
+// PasteType returns a copy of the clipboard contents in mimeType
+func (m *MemoryBackend) PasteType(mimeType string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range m.formats {
+		if f.mimeType == mimeType {
+			return append([]byte{}, f.data...), nil
+		}
+	}
+	return nil, ErrTypeUnavailable
+}
+
+// Copies returns how many times Copy has been called
+func (m *MemoryBackend) Copies() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.copies
+}
@@ -0,0 +1,492 @@
+package clipboard
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// TextType is the MIME type of plain text, the only format backends without
+// type support can read
+const TextType = "text/plain"
+
+// AnyType in an accept list matches whatever format the clipboard holds first
+const AnyType = "*/*"
+
+// URIListType is the MIME type of a list of file URLs, one per line, which
+// is copied as references to the files rather than as text
+const URIListType = "text/uri-list"
+
+var (
+	// ErrTypeUnavailable is returned when the clipboard holds none of the
+	// requested formats
+	ErrTypeUnavailable = errors.New("clipboard does not hold the requested type")
+	// ErrCopyTypeUnsupported is returned when the backend can't write the
+	// requested format to the clipboard
+	ErrCopyTypeUnsupported = errors.New("backend does not support copying this type")
+)
+
+// TypedBackend is implemented by backends that can read the clipboard in
+// formats other than plain text
+type TypedBackend interface {
+	Backend
+	// Types lists the MIME types the clipboard currently holds
+	Types() ([]string, error)
+	// PasteType returns the clipboard contents in mimeType, which should be
+	// one of those reported by Types
+	PasteType(mimeType string) ([]byte, error)
+}
+
+// TypedCopier is implemented by backends that can write the clipboard in
+// formats other than plain text
+type TypedCopier interface {
+	Backend
+	// CopyTypes lists the MIME types CopyType can write. AnyType means all.
+	CopyTypes() []string
+	// CopyType replaces the clipboard contents with data in mimeType
+	CopyType(mimeType string, data []byte) error
+}
+
+// Content is clipboard data read in a particular format
+type Content struct {
+	// Type is the MIME type of Data
+	Type string
+	// Types lists every MIME type the clipboard held
+	Types []string
+	// Data is the clipboard contents in Type
+	Data []byte
+	// ConvertedFrom is the type Data was converted from, when the clipboard
+	// didn't hold Type itself
+	ConvertedFrom string
+}
+
+// Read returns the clipboard contents in the first type of accept that the
+// clipboard holds. An empty accept list asks for plain text. PNG counts as
+// held whenever another image type it can be converted from is, so an image
+// copied as TIFF can still be pasted as PNG.
+func Read(b Backend, accept []string) (Content, error) {
+	if len(accept) == 0 {
+		accept = []string{TextType}
+	}
+
+	typed, ok := b.(TypedBackend)
+	types := []string{TextType}
+	if ok {
+		var err error
+		if types, err = typed.Types(); err != nil {
+			return Content{}, fmt.Errorf("failed to list clipboard types: %w", err)
+		}
+	}
+
+	mimeType, source := selectType(accept, types)
+	if mimeType == "" {
+		available := "none"
+		if len(types) > 0 {
+			available = strings.Join(types, ", ")
+		}
+		return Content{Types: types}, fmt.Errorf("%w: %s (available: %s)", ErrTypeUnavailable, strings.Join(accept, ", "), available)
+	}
+
+	var data []byte
+	var err error
+	if ok {
+		data, err = typed.PasteType(source)
+	} else {
+		data, err = b.Paste()
+	}
+	if err != nil {
+		return Content{Types: types}, err
+	}
+	if source == mimeType {
+		return Content{Type: mimeType, Types: types, Data: data}, nil
+	}
+
+	timeout := DefaultTimeout
+	if timed, ok := b.(interface{ commandTimeout() time.Duration }); ok {
+		timeout = timed.commandTimeout()
+	}
+	if data, err = convertToPNG(data, source, timeout); err != nil {
+		return Content{Types: types}, err
+	}
+	return Content{Type: mimeType, Types: types, Data: data, ConvertedFrom: source}, nil
+}
+
+// CheckCopyType returns ErrCopyTypeUnsupported unless b can write mimeType.
+// Every backend can write plain text.
+func CheckCopyType(b Backend, mimeType string) error {
+	if mimeType == TextType {
+		return nil
+	}
+	if typed, ok := b.(TypedCopier); ok {
+		for _, t := range typed.CopyTypes() {
+			if t == mimeType || t == AnyType {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: %s backend can't copy %s", ErrCopyTypeUnsupported, b.Name(), mimeType)
+}
+
+// CopyTypes lists the MIME types b can write, plain text first. AnyType
+// means all.
+func CopyTypes(b Backend) []string {
+	types := []string{TextType}
+	if typed, ok := b.(TypedCopier); ok {
+		for _, t := range typed.CopyTypes() {
+			types = appendType(types, t)
+		}
+	}
+	return types
+}
+
+// Write replaces the clipboard contents with data in mimeType, going through
+// Copy for plain text
+func Write(b Backend, mimeType string, data []byte) error {
+	if err := CheckCopyType(b, mimeType); err != nil {
+		return err
+	}
+	if mimeType == TextType {
+		return b.Copy(data)
+	}
+	return b.(TypedCopier).CopyType(mimeType, data)
+}
+
+// Validate checks what can be checked of data as mimeType without the
+// clipboard: that a uri-list names existing local files
+func Validate(mimeType string, data []byte) error {
+	if mimeType == URIListType {
+		_, err := parseFileURIs(data)
+		return err
+	}
+	return nil
+}
+
+// selectType returns the first accepted type present in types, and the type
+// to read it from: the same type, or for PNG another image type to convert.
+// Both are "" if there is none.
+func selectType(accept, types []string) (string, string) {
+	for _, want := range accept {
+		for _, have := range types {
+			if want == have || (want == AnyType && have != "") {
+				return have, have
+			}
+		}
+		if want != PNGType {
+			continue
+		}
+		for _, have := range types {
+			if pngSources[have] != nil {
+				return PNGType, have
+			}
+		}
+	}
+	return "", ""
+}
+
+// typeCommands describes how a command backend lists and reads the formats
+// the clipboard holds
+type typeCommands struct {
+	// listCmd prints the available formats
+	listCmd []string
+	// parseList turns the output of listCmd into MIME types
+	parseList func(output []byte) []string
+	// pasteCmd returns the command printing the clipboard as mimeType
+	pasteCmd func(mimeType string) []string
+	// decode converts the output of pasteCmd to raw bytes when it isn't already
+	decode func(output []byte) ([]byte, error)
+	// copyTypes lists the MIME types other than plain text copyCmd can write
+	copyTypes []string
+	// copyCmd returns the command writing data to the clipboard as mimeType,
+	// one of copyTypes, and what to pipe into it
+	copyCmd func(mimeType string, data []byte) ([]string, []byte, error)
+}
+
+// TypedCommandBackend is a CommandBackend that can also read the clipboard
+// in formats other than plain text
+type TypedCommandBackend struct {
+	*CommandBackend
+	types typeCommands
+}
+
+// Types runs the list command and returns the MIME types it reports
+func (b *TypedCommandBackend) Types() ([]string, error) {
+	output, err := b.output(b.types.listCmd)
+	if err != nil {
+		return nil, err
+	}
+	return b.types.parseList(output), nil
+}
+
+// PasteType returns the clipboard contents as mimeType. Plain text goes
+// through the regular paste command.
+func (b *TypedCommandBackend) PasteType(mimeType string) ([]byte, error) {
+	if mimeType == TextType {
+		return b.Paste()
+	}
+
+	output, err := b.output(b.types.pasteCmd(mimeType))
+	if err != nil {
+		return nil, err
+	}
+	if b.types.decode != nil {
+		return b.types.decode(output)
+	}
+	return output, nil
+}
+
+// CopyTypes lists the MIME types other than plain text the tools can write
+func (b *TypedCommandBackend) CopyTypes() []string {
+	return b.types.copyTypes
+}
+
+// CopyType replaces the clipboard contents with data in mimeType. Plain text
+// goes through the regular copy command.
+func (b *TypedCommandBackend) CopyType(mimeType string, data []byte) error {
+	if mimeType == TextType {
+		return b.Copy(data)
+	}
+	if err := CheckCopyType(b, mimeType); err != nil {
+		return err
+	}
+
+	args, input, err := b.types.copyCmd(mimeType, data)
+	if err != nil {
+		return err
+	}
+	return b.input(args, input)
+}
+
+// x11Types lists and reads clipboard formats with xclip
+var x11Types = typeCommands{
+	listCmd:   []string{"xclip", "-selection", "clipboard", "-target", "TARGETS", "-out"},
+	parseList: parseTargets,
+	pasteCmd: func(mimeType string) []string {
+		return []string{"xclip", "-selection", "clipboard", "-target", mimeType, "-out"}
+	},
+}
+
+// waylandTypes lists and reads clipboard formats with wl-paste
+var waylandTypes = typeCommands{
+	listCmd:   []string{"wl-paste", "--list-types"},
+	parseList: parseTargets,
+	pasteCmd: func(mimeType string) []string {
+		return []string{"wl-paste", "--no-newline", "--type", mimeType}
+	},
+}
+
+// pasteboardTypes lists and reads macOS pasteboard formats through AppleScript
+var pasteboardTypes = typeCommands{
+	listCmd:   []string{"osascript", "-e", "clipboard info"},
+	parseList: parseClipboardInfo,
+	pasteCmd: func(mimeType string) []string {
+		return []string{"osascript", "-e", fmt.Sprintf("get the clipboard as «class %s»", appleClasses[mimeType])}
+	},
+	decode:    decodeAppleScriptData,
+	copyTypes: pasteboardCopyTypes,
+	copyCmd:   pasteboardCopyCmd,
+}
+
+// textTargets are the X11 and Wayland names for plain text
+var textTargets = map[string]bool{
+	"UTF8_STRING":   true,
+	"STRING":        true,
+	"TEXT":          true,
+	"COMPOUND_TEXT": true,
+}
+
+// parseTargets turns an X11 TARGETS or wl-paste --list-types listing into
+// MIME types, folding the various text targets into text/plain and skipping
+// atoms that aren't formats
+func parseTargets(output []byte) []string {
+	var types []string
+	for _, line := range strings.Split(string(output), "\n") {
+		target := strings.TrimSpace(line)
+		switch {
+		case textTargets[target]:
+			target = TextType
+		case strings.Contains(target, "/"):
+			// Drop parameters such as "text/plain;charset=utf-8"
+			target, _, _ = strings.Cut(target, ";")
+		default:
+			continue
+		}
+		types = appendType(types, target)
+	}
+	return types
+}
+
+// appleClasses maps MIME types to the four-character pasteboard class codes
+// AppleScript uses for them
+var appleClasses = map[string]string{
+	"image/png":       "PNGf",
+	"image/tiff":      "TIFF",
+	"image/jpeg":      "JPEG",
+	"image/gif":       "GIFf",
+	"application/pdf": "PDF ",
+	"text/html":       "HTML",
+	"text/rtf":        "RTF ",
+}
+
+// appleInfoTypes maps the format names printed by "clipboard info" to MIME types
+var appleInfoTypes = map[string]string{
+	"«class PNGf»": "image/png",
+	"TIFF picture": "image/tiff",
+	"«class TIFF»": "image/tiff",
+	"JPEG picture": "image/jpeg",
+	"«class JPEG»": "image/jpeg",
+	"GIF picture":  "image/gif",
+	"«class GIFf»": "image/gif",
+	"«class PDF »": "application/pdf",
+	"«class HTML»": "text/html",
+	"«class RTF »": "text/rtf",
+	"«class utf8»": TextType,
+	"«class ut16»": TextType,
+	"Unicode text": TextType,
+	"string":       TextType,
+}
+
+// parseClipboardInfo turns the output of AppleScript's "clipboard info",
+// a flat list of format names and sizes such as
+// "«class PNGf», 2048, TIFF picture, 9000", into MIME types
+func parseClipboardInfo(output []byte) []string {
+	var types []string
+	fields := strings.Split(strings.TrimSpace(string(output)), ", ")
+	for i := 0; i < len(fields); i += 2 {
+		if mimeType, ok := appleInfoTypes[fields[i]]; ok {
+			types = appendType(types, mimeType)
+		}
+	}
+	return types
+}
+
+// pasteboardUTIs maps the MIME types written to the macOS pasteboard as data
+// to the pasteboard types Finder and other applications look for
+var pasteboardUTIs = map[string]string{
+	"application/pdf": "com.adobe.pdf",
+	"image/png":       "public.png",
+	"image/tiff":      "public.tiff",
+	"image/jpeg":      "public.jpeg",
+	"image/gif":       "com.compuserve.gif",
+	"text/html":       "public.html",
+	"text/rtf":        "public.rtf",
+}
+
+// pasteboardCopyTypes are the MIME types the pbcopy backend can write: those
+// in pasteboardUTIs, and file URLs
+var pasteboardCopyTypes = []string{
+	URIListType,
+	"application/pdf",
+	"image/png",
+	"image/tiff",
+	"image/jpeg",
+	"image/gif",
+	"text/html",
+	"text/rtf",
+}
+
+// pasteboardScript writes to the macOS pasteboard through AppKit, which
+// AppleScript's "set the clipboard" can't do for arbitrary types. Given
+// "files" and paths it puts file URLs there, so pasting into Finder copies
+// the files; given a pasteboard type it puts stdin there as that type.
+const pasteboardScript = `ObjC.import("AppKit");
+function run(argv) {
+	const pb = $.NSPasteboard.generalPasteboard;
+	pb.clearContents;
+	let ok;
+	if (argv[0] === "files") {
+		ok = pb.writeObjects($(argv.slice(1).map(p => $.NSURL.fileURLWithPath(p))));
+	} else {
+		const data = $.NSFileHandle.fileHandleWithStandardInput.readDataToEndOfFile;
+		ok = pb.setDataForType(data, argv[0]);
+	}
+	if (!ok) {
+		throw new Error("pasteboard refused the data");
+	}
+}`
+
+// pasteboardCopyCmd returns the osascript command writing data to the macOS
+// pasteboard as mimeType
+func pasteboardCopyCmd(mimeType string, data []byte) ([]string, []byte, error) {
+	cmd := []string{"osascript", "-l", "JavaScript", "-e", pasteboardScript}
+	if mimeType == URIListType {
+		paths, err := parseFileURIs(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return append(append(cmd, "files"), paths...), nil, nil
+	}
+	return append(cmd, pasteboardUTIs[mimeType]), data, nil
+}
+
+// parseFileURIs returns the paths of the file URLs in a text/uri-list,
+// which must all name existing local files. Blank lines and comments are
+// skipped.
+func parseFileURIs(data []byte) ([]string, error) {
+	var paths []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		u, err := url.Parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL %q in uri-list: %w", truncate(line, 80), err)
+		}
+		if u.Scheme != "file" || (u.Host != "" && u.Host != "localhost") || !path.IsAbs(u.Path) {
+			return nil, fmt.Errorf("%q is not a local file URL", truncate(line, 80))
+		}
+		if _, err := os.Stat(u.Path); err != nil {
+			return nil, fmt.Errorf("can't copy a reference to %s: %w", u.Path, err)
+		}
+		paths = append(paths, u.Path)
+	}
+	if len(paths) == 0 {
+		return nil, errors.New("uri-list names no files")
+	}
+	return paths, nil
+}
+
+// decodeAppleScriptData extracts the bytes from an AppleScript data literal
+// such as "«data PNGf89504E47»"
+func decodeAppleScriptData(output []byte) ([]byte, error) {
+	literal := string(bytes.TrimSpace(output))
+	const prefix = "«data "
+	if !strings.HasPrefix(literal, prefix) || !strings.HasSuffix(literal, "»") {
+		return nil, fmt.Errorf("unexpected osascript output %q", truncate(literal, 40))
+	}
+
+	// Skip the four-character class code
+	body := strings.TrimSuffix(strings.TrimPrefix(literal, prefix), "»")
+	if len(body) < 4 {
+		return nil, fmt.Errorf("unexpected osascript output %q", literal)
+	}
+	data, err := hex.DecodeString(body[4:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode osascript data: %w", err)
+	}
+	return data, nil
+}
+
+// appendType appends mimeType to types unless it is already present
+func appendType(types []string, mimeType string) []string {
+	for _, t := range types {
+		if t == mimeType {
+			return types
+		}
+	}
+	return append(types, mimeType)
+}
+
+// truncate shortens s to at most n bytes for use in error messages
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
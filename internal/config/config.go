@@ -2,12 +2,64 @@ package config
 
 import (
 	"fmt"
+	"math"
+	"net"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/mquinnv/warpclip/v2/internal/eol"
+	"github.com/mquinnv/warpclip/v2/internal/protocol"
+	"github.com/mquinnv/warpclip/v2/internal/trim"
+)
+
+const (
+	// DefaultPort is the port warpclipd listens on
+	DefaultPort = 8888
+	// minPort and maxPort bound the port, leaving out the privileged ones
+	minPort = 1024
+	maxPort = 65535
+)
+
+const (
+	// DefaultMaxDataSize is the largest payload copied, in bytes
+	DefaultMaxDataSize = 1024 * 1024
+	// minDataSize and maxDataSize bound the payload size limit
+	minDataSize = 1024
+	maxDataSize = 100 * 1024 * 1024
+)
+
+// DefaultReadBufferSize is the size of the buffer used to read payloads
+const DefaultReadBufferSize = 32 * 1024
+
+const (
+	// DefaultBreakerThreshold is the number of consecutive failed copies
+	// after which the clipboard backend is considered unhealthy
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerCooldown is how long copies are refused once the backend
+	// is unhealthy, before one is let through to probe it
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+const (
+	// DefaultReadTimeout is how long a connection may go without sending
+	// anything before it is closed
+	DefaultReadTimeout = 5 * time.Second
+	// DefaultConnTimeout is how long a copy connection may stay open in total,
+	// or a follow or session connection may take over one record
+	DefaultConnTimeout = 10 * time.Minute
+	// DefaultShutdownTimeout is how long shutdown waits for open connections
+	// to finish
+	DefaultShutdownTimeout = 30 * time.Second
 )
 
+// DefaultPullInterval is how often the daemon checks WARPCLIP_PULL_FROM for a
+// waiting copy
+const DefaultPullInterval = time.Second
+
 // Config holds the configuration for the warpclipd service
 type Config struct {
 	// Port to listen on
@@ -26,8 +78,83 @@ type Config struct {
 	PidFile string
 	// Last activity file path
 	LastFile string
+	// Copy history file path
+	HistoryFile string
+	// Where the clipboard is saved at shutdown for WARPCLIP_RESTORE_ON_START
+	ClipboardFile string
 	// Maximum data size (in bytes)
 	MaxDataSize int64
+	// Total payload bytes held across concurrent connections (zero doesn't
+	// limit it)
+	MemoryBudget int64
+	// Lowest protocol version a client may speak; older clients are refused
+	// (zero accepts any)
+	MinClientVersion int
+	// Size of the buffer payloads are read through (in bytes)
+	ReadBufferSize int
+	// Clipboard backend name (empty selects the platform default)
+	Backend string
+	// Clipboard backends to try in order, using the first that succeeds
+	// (empty uses Backend alone)
+	BackendChain []string
+	// Shell command used by the custom-command backend
+	ClipboardCommand string
+	// Extra arguments appended to the backend's copy command
+	ClipboardArgs []string
+	// Shell command every payload is piped through before it is copied
+	TransformCommand string
+	// Idle period after which the daemon exits (zero runs forever)
+	IdleTimeout time.Duration
+	// Line ending conversion applied before writing to the clipboard
+	NormalizeEOL eol.Mode
+	// Remove ANSI escape sequences, such as colors, before writing to the clipboard
+	StripANSI bool
+	// Whitespace removed before writing to the clipboard
+	TrimPolicy trim.Policy
+	// Extra regular expression masked in logs, on top of the built-in patterns
+	Redact string
+	// Only log warnings and errors
+	Silent bool
+	// Drop DEBUG messages instead of writing a separate debug log
+	NoDebugLog bool
+	// Keep ERROR messages off stderr, for service managers that capture it
+	// into the log file
+	NoStderrMirror bool
+	// Log connections opening and closing at DEBUG, leaving copies at INFO
+	QuietConnLogs bool
+	// Save the clipboard at shutdown and put it back at the next start
+	RestoreOnStart bool
+	// Consecutive failed copies that mark the backend unhealthy (zero uses
+	// the default, negative never does)
+	BreakerThreshold int
+	// How long an unhealthy backend is left alone before it is probed again
+	// (zero uses the default)
+	BreakerCooldown time.Duration
+	// How long a connection may send nothing before it is closed (zero uses
+	// the default)
+	ReadTimeout time.Duration
+	// How long a copy connection may stay open in total, however steadily it
+	// sends (zero uses the default). Follow and session connections may wait
+	// between records for as long as they like, but each record is held to
+	// this once it starts.
+	ConnTimeout time.Duration
+	// How long a graceful shutdown waits for open connections to finish
+	// before exiting without them (zero uses the default)
+	ShutdownTimeout time.Duration
+	// Window in which copies are coalesced so only the latest reaches the
+	// clipboard (zero copies each one straight away)
+	Debounce time.Duration
+	// Local feedback for each copy: "on" for the default sounds, the
+	// success and failure sounds as "NAME[,NAME]", or "notification" for a
+	// notification banner (empty gives none)
+	NotifySound string
+	// Address, such as "localhost:9998", polled for copies waiting on a
+	// remote "warpclip --listen" (empty only accepts pushed copies)
+	PullFrom string
+	// How often PullFrom is polled (zero uses the default)
+	PullInterval time.Duration
+	// Check the connection and clipboard path once at startup
+	SelfTest bool
 }
 
 // Load loads the configuration from environment variables
@@ -37,27 +164,33 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("failed to get user home directory: %w", err)
 	}
 
-	// Default configuration
+	// Default configuration. Any file left in the home directory by an
+	// older version keeps them all there, so an install never has some
+	// files in one place and some in the other.
+	legacy := hasLegacyFiles(homeDir)
 	cfg := &Config{
-		Port:         8888,
-		BindAddress:  "127.0.0.1",
-		LogFile:      filepath.Join(homeDir, ".warpclip.log"),
-		DebugFile:    filepath.Join(homeDir, ".warpclip.debug.log"),
-		OutLogFile:   filepath.Join(homeDir, ".warpclip.out.log"),
-		ErrorLogFile: filepath.Join(homeDir, ".warpclip.error.log"),
-		PidFile:      filepath.Join(homeDir, ".warpclip.pid"),
-		LastFile:     filepath.Join(homeDir, ".warpclip.last"),
-		MaxDataSize:  1048576, // 1MB
+		Port:           DefaultPort,
+		BindAddress:    "127.0.0.1",
+		LogFile:        statePath(homeDir, "log", legacy),
+		DebugFile:      statePath(homeDir, "debug.log", legacy),
+		OutLogFile:     statePath(homeDir, "out.log", legacy),
+		ErrorLogFile:   statePath(homeDir, "error.log", legacy),
+		PidFile:        runtimePath(homeDir, "pid", legacy),
+		LastFile:       statePath(homeDir, "last", legacy),
+		HistoryFile:    statePath(homeDir, "history", legacy),
+		ClipboardFile:  statePath(homeDir, "clipboard", legacy),
+		MaxDataSize:    DefaultMaxDataSize,
+		ReadBufferSize: DefaultReadBufferSize,
 	}
 
 	// Override with environment variables if present
 	if portStr := os.Getenv("WARPCLIP_LOCAL_PORT"); portStr != "" {
 		port, err := strconv.Atoi(portStr)
 		if err != nil {
-			return nil, fmt.Errorf("invalid WARPCLIP_LOCAL_PORT value: %w", err)
+			return nil, fmt.Errorf("invalid WARPCLIP_LOCAL_PORT value %q: want a port number from %d to %d, such as the default %d", portStr, minPort, maxPort, DefaultPort)
 		}
-		if port < 1024 || port > 65535 {
-			return nil, fmt.Errorf("WARPCLIP_LOCAL_PORT must be between 1024 and 65535")
+		if err := checkPort("WARPCLIP_LOCAL_PORT", port); err != nil {
+			return nil, err
 		}
 		cfg.Port = port
 	}
@@ -78,18 +211,276 @@ func Load() (*Config, error) {
 		cfg.ErrorLogFile = expandPath(errorLogFile, homeDir)
 	}
 
+	if historyFile := os.Getenv("WARPCLIP_HISTORY_FILE"); historyFile != "" {
+		cfg.HistoryFile = expandPath(historyFile, homeDir)
+	}
+
+	if clipboardFile := os.Getenv("WARPCLIP_CLIPBOARD_FILE"); clipboardFile != "" {
+		cfg.ClipboardFile = expandPath(clipboardFile, homeDir)
+	}
+
 	if maxDataSizeStr := os.Getenv("WARPCLIP_MAX_DATA_SIZE"); maxDataSizeStr != "" {
-		maxDataSize, err := strconv.ParseInt(maxDataSizeStr, 10, 64)
+		maxDataSize, err := ParseSize(maxDataSizeStr)
 		if err != nil {
-			return nil, fmt.Errorf("invalid WARPCLIP_MAX_DATA_SIZE value: %w", err)
+			return nil, fmt.Errorf("invalid WARPCLIP_MAX_DATA_SIZE value: %w; the default is %d (1MB)", err, DefaultMaxDataSize)
 		}
-		// Set reasonable limits - minimum 1KB, maximum 100MB
-		if maxDataSize < 1024 || maxDataSize > 104857600 {
-			return nil, fmt.Errorf("WARPCLIP_MAX_DATA_SIZE must be between 1024 and 104857600 bytes")
+		if err := checkDataSize("WARPCLIP_MAX_DATA_SIZE", maxDataSize); err != nil {
+			return nil, err
 		}
 		cfg.MaxDataSize = maxDataSize
 	}
 
+	if budgetStr := os.Getenv("WARPCLIP_MEMORY_BUDGET"); budgetStr != "" {
+		budget, err := ParseSize(budgetStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_MEMORY_BUDGET value: %w", err)
+		}
+		cfg.MemoryBudget = budget
+	}
+
+	if minVersionStr := os.Getenv("WARPCLIP_MIN_CLIENT_VERSION"); minVersionStr != "" {
+		minVersion, err := strconv.Atoi(minVersionStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_MIN_CLIENT_VERSION value: %w", err)
+		}
+		if minVersion < 0 || minVersion > protocol.Version {
+			return nil, fmt.Errorf("WARPCLIP_MIN_CLIENT_VERSION=%d is not a protocol version this warpclipd speaks: want 0 (any) to %d", minVersion, protocol.Version)
+		}
+		cfg.MinClientVersion = minVersion
+	}
+
+	if readBufferStr := os.Getenv("WARPCLIP_READ_BUFFER"); readBufferStr != "" {
+		readBuffer, err := strconv.Atoi(readBufferStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_READ_BUFFER value: %w", err)
+		}
+		// Below 512 bytes every read is a syscall for little data; above 16MB
+		// the buffer costs more memory than it saves
+		if readBuffer < 512 || readBuffer > 16777216 {
+			return nil, fmt.Errorf("WARPCLIP_READ_BUFFER must be between 512 and 16777216 bytes")
+		}
+		cfg.ReadBufferSize = readBuffer
+	}
+
+	if backend := os.Getenv("WARPCLIP_BACKEND"); backend != "" {
+		cfg.Backend = backend
+	}
+
+	if chain := os.Getenv("WARPCLIP_BACKEND_CHAIN"); chain != "" {
+		if cfg.Backend != "" {
+			return nil, fmt.Errorf("WARPCLIP_BACKEND and WARPCLIP_BACKEND_CHAIN cannot both be set")
+		}
+		for _, name := range strings.Split(chain, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				return nil, fmt.Errorf("invalid WARPCLIP_BACKEND_CHAIN value %q: empty backend name", chain)
+			}
+			cfg.BackendChain = append(cfg.BackendChain, name)
+		}
+	}
+
+	if clipboardCmd := os.Getenv("WARPCLIP_CLIPBOARD_CMD"); clipboardCmd != "" {
+		cfg.ClipboardCommand = clipboardCmd
+		// A custom command implies the custom-command backend unless one was chosen
+		if cfg.Backend == "" && len(cfg.BackendChain) == 0 {
+			cfg.Backend = "custom-command"
+		}
+	}
+
+	if clipboardArgs := os.Getenv("WARPCLIP_CLIPBOARD_ARGS"); clipboardArgs != "" {
+		args := strings.Fields(clipboardArgs)
+		if err := validateClipboardArgs(args); err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_CLIPBOARD_ARGS value: %w", err)
+		}
+		cfg.ClipboardArgs = args
+	}
+
+	if transformCmd := os.Getenv("WARPCLIP_TRANSFORM_CMD"); transformCmd != "" {
+		cfg.TransformCommand = transformCmd
+	}
+
+	if idleTimeoutStr := os.Getenv("WARPCLIP_IDLE_TIMEOUT"); idleTimeoutStr != "" {
+		idleTimeout, err := time.ParseDuration(idleTimeoutStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_IDLE_TIMEOUT value: %w", err)
+		}
+		if idleTimeout < 0 {
+			return nil, fmt.Errorf("WARPCLIP_IDLE_TIMEOUT must not be negative")
+		}
+		cfg.IdleTimeout = idleTimeout
+	}
+
+	if normalizeEOL := os.Getenv("WARPCLIP_NORMALIZE_EOL"); normalizeEOL != "" {
+		mode, err := eol.ParseMode(normalizeEOL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_NORMALIZE_EOL value: %w", err)
+		}
+		cfg.NormalizeEOL = mode
+	}
+
+	if stripANSI := os.Getenv("WARPCLIP_STRIP_ANSI"); stripANSI != "" {
+		value, err := strconv.ParseBool(stripANSI)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_STRIP_ANSI value: %w", err)
+		}
+		cfg.StripANSI = value
+	}
+
+	if trimPolicy := os.Getenv("WARPCLIP_TRIM_POLICY"); trimPolicy != "" {
+		policy, err := trim.ParsePolicy(trimPolicy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_TRIM_POLICY value: %w", err)
+		}
+		cfg.TrimPolicy = policy
+	}
+
+	if redact := os.Getenv("WARPCLIP_REDACT"); redact != "" {
+		if _, err := regexp.Compile(redact); err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_REDACT value: %w", err)
+		}
+		cfg.Redact = redact
+	}
+
+	if silent := os.Getenv("WARPCLIP_SILENT"); silent != "" {
+		value, err := strconv.ParseBool(silent)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_SILENT value: %w", err)
+		}
+		cfg.Silent = value
+	}
+
+	if noDebugLog := os.Getenv("WARPCLIP_NO_DEBUG_LOG"); noDebugLog != "" {
+		value, err := strconv.ParseBool(noDebugLog)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_NO_DEBUG_LOG value: %w", err)
+		}
+		cfg.NoDebugLog = value
+	}
+
+	if noStderrMirror := os.Getenv("WARPCLIP_NO_STDERR_MIRROR"); noStderrMirror != "" {
+		value, err := strconv.ParseBool(noStderrMirror)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_NO_STDERR_MIRROR value: %w", err)
+		}
+		cfg.NoStderrMirror = value
+	}
+
+	if quietConnLogs := os.Getenv("WARPCLIP_QUIET_CONN_LOGS"); quietConnLogs != "" {
+		value, err := strconv.ParseBool(quietConnLogs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_QUIET_CONN_LOGS value: %w", err)
+		}
+		cfg.QuietConnLogs = value
+	}
+
+	if restoreOnStart := os.Getenv("WARPCLIP_RESTORE_ON_START"); restoreOnStart != "" {
+		value, err := strconv.ParseBool(restoreOnStart)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_RESTORE_ON_START value: %w", err)
+		}
+		cfg.RestoreOnStart = value
+	}
+
+	if thresholdStr := os.Getenv("WARPCLIP_BREAKER_THRESHOLD"); thresholdStr != "" {
+		threshold, err := strconv.Atoi(thresholdStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_BREAKER_THRESHOLD value: %w", err)
+		}
+		if threshold < 0 {
+			return nil, fmt.Errorf("WARPCLIP_BREAKER_THRESHOLD must not be negative")
+		}
+		// Zero in the environment turns the breaker off
+		if threshold == 0 {
+			threshold = -1
+		}
+		cfg.BreakerThreshold = threshold
+	}
+
+	if cooldownStr := os.Getenv("WARPCLIP_BREAKER_COOLDOWN"); cooldownStr != "" {
+		cooldown, err := time.ParseDuration(cooldownStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_BREAKER_COOLDOWN value: %w", err)
+		}
+		if cooldown <= 0 {
+			return nil, fmt.Errorf("WARPCLIP_BREAKER_COOLDOWN must be positive")
+		}
+		cfg.BreakerCooldown = cooldown
+	}
+
+	if readTimeoutStr := os.Getenv("WARPCLIP_READ_TIMEOUT"); readTimeoutStr != "" {
+		readTimeout, err := time.ParseDuration(readTimeoutStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_READ_TIMEOUT value: %w", err)
+		}
+		if readTimeout <= 0 {
+			return nil, fmt.Errorf("WARPCLIP_READ_TIMEOUT must be positive")
+		}
+		cfg.ReadTimeout = readTimeout
+	}
+
+	if connTimeoutStr := os.Getenv("WARPCLIP_CONN_TIMEOUT"); connTimeoutStr != "" {
+		connTimeout, err := time.ParseDuration(connTimeoutStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_CONN_TIMEOUT value: %w", err)
+		}
+		if connTimeout <= 0 {
+			return nil, fmt.Errorf("WARPCLIP_CONN_TIMEOUT must be positive")
+		}
+		cfg.ConnTimeout = connTimeout
+	}
+
+	if shutdownTimeoutStr := os.Getenv("WARPCLIP_SHUTDOWN_TIMEOUT"); shutdownTimeoutStr != "" {
+		shutdownTimeout, err := time.ParseDuration(shutdownTimeoutStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_SHUTDOWN_TIMEOUT value: %w", err)
+		}
+		if shutdownTimeout <= 0 {
+			return nil, fmt.Errorf("WARPCLIP_SHUTDOWN_TIMEOUT must be positive")
+		}
+		cfg.ShutdownTimeout = shutdownTimeout
+	}
+
+	if debounceStr := os.Getenv("WARPCLIP_DEBOUNCE"); debounceStr != "" {
+		debounce, err := time.ParseDuration(debounceStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_DEBOUNCE value: %w", err)
+		}
+		if debounce < 0 {
+			return nil, fmt.Errorf("WARPCLIP_DEBOUNCE must not be negative")
+		}
+		cfg.Debounce = debounce
+	}
+
+	if notifySound := os.Getenv("WARPCLIP_NOTIFY_SOUND"); notifySound != "" {
+		cfg.NotifySound = notifySound
+	}
+
+	if pullFrom := os.Getenv("WARPCLIP_PULL_FROM"); pullFrom != "" {
+		if _, _, err := net.SplitHostPort(pullFrom); err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_PULL_FROM value: %w", err)
+		}
+		cfg.PullFrom = pullFrom
+	}
+
+	if pullIntervalStr := os.Getenv("WARPCLIP_PULL_INTERVAL"); pullIntervalStr != "" {
+		pullInterval, err := time.ParseDuration(pullIntervalStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_PULL_INTERVAL value: %w", err)
+		}
+		if pullInterval <= 0 {
+			return nil, fmt.Errorf("WARPCLIP_PULL_INTERVAL must be positive")
+		}
+		cfg.PullInterval = pullInterval
+	}
+
+	if selfTest := os.Getenv("WARPCLIP_SELFTEST"); selfTest != "" {
+		value, err := strconv.ParseBool(selfTest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARPCLIP_SELFTEST value: %w", err)
+		}
+		cfg.SelfTest = value
+	}
+
 	// Validate configuration
 	if err := validateConfig(cfg); err != nil {
 		return nil, err
@@ -98,6 +489,261 @@ func Load() (*Config, error) {
 	return cfg, nil
 }
 
+// Overrides holds settings given on the daemon's command line, which take
+// precedence over the environment. Zero fields leave the loaded value alone.
+type Overrides struct {
+	Port        int
+	MaxDataSize int64
+	LogFile     string
+}
+
+// Apply sets the overridden fields and validates the result
+func (c *Config) Apply(o Overrides) error {
+	if o.Port != 0 {
+		c.Port = o.Port
+	}
+	if o.MaxDataSize != 0 {
+		if err := checkDataSize("--max-size", o.MaxDataSize); err != nil {
+			return err
+		}
+		c.MaxDataSize = o.MaxDataSize
+	}
+	if o.LogFile != "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("failed to get user home directory: %w", err)
+		}
+		c.LogFile = expandPath(o.LogFile, homeDir)
+	}
+	return validateConfig(c)
+}
+
+// legacyNames are the files the daemon kept in the home directory, as
+// ~/.warpclip.NAME, before it used the XDG base directories
+var legacyNames = []string{"log", "debug.log", "out.log", "error.log", "pid", "last", "history", "clipboard"}
+
+// hasLegacyFiles reports whether any of the daemon's files is at its home
+// directory path. Then they all stay there, so an upgrade neither loses the
+// history nor misses a running daemon's PID file.
+func hasLegacyFiles(homeDir string) bool {
+	for _, name := range legacyNames {
+		if _, err := os.Stat(filepath.Join(homeDir, ".warpclip."+name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// statePath returns the default location of the file called warpclip.NAME
+// that persists between runs, such as a log: in $XDG_STATE_HOME/warpclip
+// when that is set, else ~/.warpclip.NAME
+func statePath(homeDir, name string, legacy bool) string {
+	return xdgPath(homeDir, "XDG_STATE_HOME", name, legacy)
+}
+
+// runtimePath returns the default location of the file called warpclip.NAME
+// that only matters while the daemon runs, such as its PID file: in
+// $XDG_RUNTIME_DIR/warpclip when that is set, else ~/.warpclip.NAME
+func runtimePath(homeDir, name string, legacy bool) string {
+	return xdgPath(homeDir, "XDG_RUNTIME_DIR", name, legacy)
+}
+
+// xdgPath places warpclip.NAME in the base directory named by the variable
+// xdgVar, or at ~/.warpclip.NAME for a legacy install
+func xdgPath(homeDir, xdgVar, name string, legacy bool) string {
+	home := filepath.Join(homeDir, ".warpclip."+name)
+	// The specification says to ignore a relative base directory
+	base := os.Getenv(xdgVar)
+	if legacy || base == "" || !filepath.IsAbs(base) {
+		return home
+	}
+	return filepath.Join(base, "warpclip", "warpclip."+name)
+}
+
+// checkPort reports a port the setting called name can't use, suggesting a
+// usable one
+func checkPort(name string, port int) error {
+	switch {
+	case port > 0 && port < minPort:
+		return fmt.Errorf("%s=%d is a privileged port, which only root may listen on: use %d or above, such as the default %d", name, port, minPort, DefaultPort)
+	case port < minPort || port > maxPort:
+		return fmt.Errorf("%s=%d is not a valid port: want %d to %d, such as the default %d", name, port, minPort, maxPort, DefaultPort)
+	}
+	return nil
+}
+
+// sizeUnits are the suffixes ParseSize accepts and their multipliers, longest
+// first so that KB isn't read as a number ending in B
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+	{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+// ParseSize parses a size in bytes, given either as a plain number or with a
+// unit in any case: K, KB or KiB for 1024 bytes, M, MB or MiB for 1024 KB,
+// and G, GB or GiB for 1024 MB. So 1048576, 1MB, 1mib and 1024K are equal.
+func ParseSize(s string) (int64, error) {
+	value := strings.TrimSpace(s)
+	multiplier := int64(1)
+	upper := strings.ToUpper(value)
+	for _, unit := range sizeUnits {
+		if number, ok := strings.CutSuffix(upper, unit.suffix); ok {
+			value = strings.TrimSpace(value[:len(number)])
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a size: want a number of bytes, optionally with a unit such as 512KB or 10MB", s)
+	}
+	if n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("%q is too large", s)
+	}
+	return n * multiplier, nil
+}
+
+// checkDataSize reports a size limit the setting called name can't use. A
+// number too small to be meant as bytes is probably megabytes, so that is
+// suggested.
+func checkDataSize(name string, size int64) error {
+	if size >= minDataSize && size <= maxDataSize {
+		return nil
+	}
+	if size > 0 && size < minDataSize && size*1024*1024 <= maxDataSize {
+		return fmt.Errorf("%s=%d is below the minimum of %d bytes (1KB); a plain number is in bytes, so for %dMB use %dMB", name, size, minDataSize, size, size)
+	}
+	return fmt.Errorf("%s=%d is out of range: want a size in bytes from %d (1KB) to %d (100MB); the default is %d (1MB)", name, size, minDataSize, maxDataSize, DefaultMaxDataSize)
+}
+
+// Environ returns the configuration as WARPCLIP_* environment variable
+// assignments, suitable for passing to a service manager so that a
+// supervised daemon resolves the same configuration as the current process
+func (c *Config) Environ() []string {
+	env := []string{
+		fmt.Sprintf("WARPCLIP_LOCAL_PORT=%d", c.Port),
+		fmt.Sprintf("WARPCLIP_LOG_FILE=%s", c.LogFile),
+		fmt.Sprintf("WARPCLIP_DEBUG_FILE=%s", c.DebugFile),
+		fmt.Sprintf("WARPCLIP_OUT_LOG=%s", c.OutLogFile),
+		fmt.Sprintf("WARPCLIP_ERROR_LOG=%s", c.ErrorLogFile),
+		fmt.Sprintf("WARPCLIP_MAX_DATA_SIZE=%d", c.MaxDataSize),
+	}
+	if c.HistoryFile != "" {
+		env = append(env, fmt.Sprintf("WARPCLIP_HISTORY_FILE=%s", c.HistoryFile))
+	}
+	if c.ClipboardFile != "" {
+		env = append(env, fmt.Sprintf("WARPCLIP_CLIPBOARD_FILE=%s", c.ClipboardFile))
+	}
+	if c.MemoryBudget > 0 {
+		env = append(env, fmt.Sprintf("WARPCLIP_MEMORY_BUDGET=%d", c.MemoryBudget))
+	}
+	if c.MinClientVersion > 0 {
+		env = append(env, fmt.Sprintf("WARPCLIP_MIN_CLIENT_VERSION=%d", c.MinClientVersion))
+	}
+	if c.ReadBufferSize > 0 && c.ReadBufferSize != DefaultReadBufferSize {
+		env = append(env, fmt.Sprintf("WARPCLIP_READ_BUFFER=%d", c.ReadBufferSize))
+	}
+	if c.Backend != "" {
+		env = append(env, fmt.Sprintf("WARPCLIP_BACKEND=%s", c.Backend))
+	}
+	if len(c.BackendChain) > 0 {
+		env = append(env, fmt.Sprintf("WARPCLIP_BACKEND_CHAIN=%s", strings.Join(c.BackendChain, ",")))
+	}
+	if c.ClipboardCommand != "" {
+		env = append(env, fmt.Sprintf("WARPCLIP_CLIPBOARD_CMD=%s", c.ClipboardCommand))
+	}
+	if len(c.ClipboardArgs) > 0 {
+		env = append(env, fmt.Sprintf("WARPCLIP_CLIPBOARD_ARGS=%s", strings.Join(c.ClipboardArgs, " ")))
+	}
+	if c.TransformCommand != "" {
+		env = append(env, fmt.Sprintf("WARPCLIP_TRANSFORM_CMD=%s", c.TransformCommand))
+	}
+	if c.IdleTimeout > 0 {
+		env = append(env, fmt.Sprintf("WARPCLIP_IDLE_TIMEOUT=%s", c.IdleTimeout))
+	}
+	if c.NormalizeEOL != eol.None {
+		env = append(env, fmt.Sprintf("WARPCLIP_NORMALIZE_EOL=%s", c.NormalizeEOL))
+	}
+	if c.StripANSI {
+		env = append(env, "WARPCLIP_STRIP_ANSI=1")
+	}
+	if c.TrimPolicy != trim.None {
+		env = append(env, fmt.Sprintf("WARPCLIP_TRIM_POLICY=%s", c.TrimPolicy))
+	}
+	if c.Redact != "" {
+		env = append(env, fmt.Sprintf("WARPCLIP_REDACT=%s", c.Redact))
+	}
+	if c.Silent {
+		env = append(env, "WARPCLIP_SILENT=1")
+	}
+	if c.NoDebugLog {
+		env = append(env, "WARPCLIP_NO_DEBUG_LOG=1")
+	}
+	if c.NoStderrMirror {
+		env = append(env, "WARPCLIP_NO_STDERR_MIRROR=1")
+	}
+	if c.QuietConnLogs {
+		env = append(env, "WARPCLIP_QUIET_CONN_LOGS=1")
+	}
+	if c.RestoreOnStart {
+		env = append(env, "WARPCLIP_RESTORE_ON_START=1")
+	}
+	if c.BreakerThreshold < 0 {
+		env = append(env, "WARPCLIP_BREAKER_THRESHOLD=0")
+	} else if c.BreakerThreshold > 0 && c.BreakerThreshold != DefaultBreakerThreshold {
+		env = append(env, fmt.Sprintf("WARPCLIP_BREAKER_THRESHOLD=%d", c.BreakerThreshold))
+	}
+	if c.BreakerCooldown > 0 && c.BreakerCooldown != DefaultBreakerCooldown {
+		env = append(env, fmt.Sprintf("WARPCLIP_BREAKER_COOLDOWN=%s", c.BreakerCooldown))
+	}
+	if c.ReadTimeout > 0 && c.ReadTimeout != DefaultReadTimeout {
+		env = append(env, fmt.Sprintf("WARPCLIP_READ_TIMEOUT=%s", c.ReadTimeout))
+	}
+	if c.ConnTimeout > 0 && c.ConnTimeout != DefaultConnTimeout {
+		env = append(env, fmt.Sprintf("WARPCLIP_CONN_TIMEOUT=%s", c.ConnTimeout))
+	}
+	if c.ShutdownTimeout > 0 && c.ShutdownTimeout != DefaultShutdownTimeout {
+		env = append(env, fmt.Sprintf("WARPCLIP_SHUTDOWN_TIMEOUT=%s", c.ShutdownTimeout))
+	}
+	if c.Debounce > 0 {
+		env = append(env, fmt.Sprintf("WARPCLIP_DEBOUNCE=%s", c.Debounce))
+	}
+	if c.NotifySound != "" {
+		env = append(env, fmt.Sprintf("WARPCLIP_NOTIFY_SOUND=%s", c.NotifySound))
+	}
+	if c.PullFrom != "" {
+		env = append(env, fmt.Sprintf("WARPCLIP_PULL_FROM=%s", c.PullFrom))
+	}
+	if c.PullInterval > 0 && c.PullInterval != DefaultPullInterval {
+		env = append(env, fmt.Sprintf("WARPCLIP_PULL_INTERVAL=%s", c.PullInterval))
+	}
+	if c.SelfTest {
+		env = append(env, "WARPCLIP_SELFTEST=1")
+	}
+	return env
+}
+
+// shellMetacharacters are refused in clipboard arguments. The arguments are
+// passed as argv rather than through a shell, so these would not do what a
+// user writing them expects.
+const shellMetacharacters = "|&;<>()$`\\\"'*?[]#~{}!"
+
+// validateClipboardArgs checks that no argument contains shell metacharacters
+func validateClipboardArgs(args []string) error {
+	for _, arg := range args {
+		if i := strings.IndexAny(arg, shellMetacharacters); i >= 0 {
+			return fmt.Errorf("argument %q contains shell metacharacter %q", arg, arg[i])
+		}
+	}
+	return nil
+}
+
 // expandPath expands the path with home directory if needed
 func expandPath(path string, homeDir string) string {
 	if strings.HasPrefix(path, "~/") {
@@ -109,8 +755,8 @@ func expandPath(path string, homeDir string) string {
 // validateConfig performs validation on the configuration
 func validateConfig(cfg *Config) error {
 	// Validate port is in valid range
-	if cfg.Port < 1024 || cfg.Port > 65535 {
-		return fmt.Errorf("port must be between 1024 and 65535")
+	if err := checkPort("port", cfg.Port); err != nil {
+		return err
 	}
 
 	// Validate bind address is localhost
@@ -123,6 +769,21 @@ func validateConfig(cfg *Config) error {
 		return fmt.Errorf("maximum data size must be at least 1024 bytes")
 	}
 
+	// A budget smaller than one payload would turn away every copy
+	if cfg.MemoryBudget > 0 && cfg.MemoryBudget < cfg.MaxDataSize {
+		return fmt.Errorf("WARPCLIP_MEMORY_BUDGET=%d must be at least the maximum data size of %d bytes", cfg.MemoryBudget, cfg.MaxDataSize)
+	}
+
+	// Validate the custom-command backend has something to run
+	if cfg.Backend == "custom-command" && cfg.ClipboardCommand == "" {
+		return fmt.Errorf("custom-command backend requires WARPCLIP_CLIPBOARD_CMD")
+	}
+	for _, name := range cfg.BackendChain {
+		if name == "custom-command" && cfg.ClipboardCommand == "" {
+			return fmt.Errorf("custom-command backend in WARPCLIP_BACKEND_CHAIN requires WARPCLIP_CLIPBOARD_CMD")
+		}
+	}
+
 	// Ensure parent directories for log files exist
 	filePaths := []string{
 		cfg.LogFile,
@@ -131,6 +792,8 @@ func validateConfig(cfg *Config) error {
 		cfg.ErrorLogFile,
 		cfg.PidFile,
 		cfg.LastFile,
+		cfg.HistoryFile,
+		cfg.ClipboardFile,
 	}
 
 	for _, path := range filePaths {
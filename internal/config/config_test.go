@@ -3,10 +3,18 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/mquinnv/warpclip/v2/internal/eol"
+	"github.com/mquinnv/warpclip/v2/internal/trim"
 )
 
 func TestDefaultConfig(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("XDG_RUNTIME_DIR", "")
+
 	// Load default configuration
 	cfg, err := Load()
 	if err != nil {
@@ -28,12 +36,75 @@ func TestDefaultConfig(t *testing.T) {
 		t.Errorf("Expected log file %s, got %s", expectedLogFile, cfg.LogFile)
 	}
 
+	if expected := filepath.Join(homeDir, ".warpclip.history"); cfg.HistoryFile != expected {
+		t.Errorf("Expected history file %s, got %s", expected, cfg.HistoryFile)
+	}
+
 	// Check max data size is 1MB
 	if cfg.MaxDataSize != 1048576 {
 		t.Errorf("Expected max data size 1048576, got %d", cfg.MaxDataSize)
 	}
 }
 
+func TestXDGPaths(t *testing.T) {
+	home := t.TempDir()
+	state := t.TempDir()
+	runtime := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_STATE_HOME", state)
+	t.Setenv("XDG_RUNTIME_DIR", runtime)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	for _, tc := range []struct{ name, got, want string }{
+		{"log file", cfg.LogFile, filepath.Join(state, "warpclip", "warpclip.log")},
+		{"debug file", cfg.DebugFile, filepath.Join(state, "warpclip", "warpclip.debug.log")},
+		{"error log", cfg.ErrorLogFile, filepath.Join(state, "warpclip", "warpclip.error.log")},
+		{"history file", cfg.HistoryFile, filepath.Join(state, "warpclip", "warpclip.history")},
+		{"PID file", cfg.PidFile, filepath.Join(runtime, "warpclip", "warpclip.pid")},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("Expected %s %s, got %s", tc.name, tc.want, tc.got)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(state, "warpclip")); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("Expected a private state directory, got %v, %v", info, err)
+	}
+
+	// Any file from before the move keeps them all where they were
+	legacyLog := filepath.Join(home, ".warpclip.log")
+	if err := os.WriteFile(legacyLog, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	for _, tc := range []struct{ name, got, want string }{
+		{"log file", cfg.LogFile, legacyLog},
+		{"debug file", cfg.DebugFile, filepath.Join(home, ".warpclip.debug.log")},
+		{"history file", cfg.HistoryFile, filepath.Join(home, ".warpclip.history")},
+		{"PID file", cfg.PidFile, filepath.Join(home, ".warpclip.pid")},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("Expected %s %s with a legacy log, got %s", tc.name, tc.want, tc.got)
+		}
+	}
+	if err := os.Remove(legacyLog); err != nil {
+		t.Fatal(err)
+	}
+
+	// Relative base directories are ignored, as the specification says
+	t.Setenv("XDG_RUNTIME_DIR", "run")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if want := filepath.Join(home, ".warpclip.pid"); cfg.PidFile != want {
+		t.Errorf("Expected PID file %s, got %s", want, cfg.PidFile)
+	}
+}
+
 func TestEnvironmentOverrides(t *testing.T) {
 	// Save original environment to restore later
 	origPort := os.Getenv("WARPCLIP_LOCAL_PORT")
@@ -70,6 +141,706 @@ func TestEnvironmentOverrides(t *testing.T) {
 	}
 }
 
+func TestIdleTimeout(t *testing.T) {
+	t.Setenv("WARPCLIP_IDLE_TIMEOUT", "")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.IdleTimeout != 0 {
+		t.Errorf("Expected idle timeout disabled by default, got %v", cfg.IdleTimeout)
+	}
+
+	t.Setenv("WARPCLIP_IDLE_TIMEOUT", "30m")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Failed to load config with idle timeout: %v", err)
+	}
+	if cfg.IdleTimeout != 30*time.Minute {
+		t.Errorf("Expected idle timeout 30m, got %v", cfg.IdleTimeout)
+	}
+	if env := cfg.Environ(); env[len(env)-1] != "WARPCLIP_IDLE_TIMEOUT=30m0s" {
+		t.Errorf("Environ() missing idle timeout: %v", env)
+	}
+
+	for _, value := range []string{"soon", "-5m"} {
+		t.Setenv("WARPCLIP_IDLE_TIMEOUT", value)
+		if _, err := Load(); err == nil {
+			t.Errorf("Expected error for WARPCLIP_IDLE_TIMEOUT=%q, got nil", value)
+		}
+	}
+}
+
+func TestConnectionTimeouts(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.ReadTimeout != 0 || cfg.ConnTimeout != 0 || cfg.ShutdownTimeout != 0 {
+		t.Errorf("Expected the default timeouts, got %v, %v and %v", cfg.ReadTimeout, cfg.ConnTimeout, cfg.ShutdownTimeout)
+	}
+
+	t.Setenv("WARPCLIP_READ_TIMEOUT", "30s")
+	t.Setenv("WARPCLIP_CONN_TIMEOUT", "1h")
+	t.Setenv("WARPCLIP_SHUTDOWN_TIMEOUT", "5s")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Failed to load config with timeouts: %v", err)
+	}
+	if cfg.ReadTimeout != 30*time.Second || cfg.ConnTimeout != time.Hour || cfg.ShutdownTimeout != 5*time.Second {
+		t.Errorf("Expected timeouts 30s, 1h and 5s, got %v, %v and %v", cfg.ReadTimeout, cfg.ConnTimeout, cfg.ShutdownTimeout)
+	}
+	env := strings.Join(cfg.Environ(), "\n")
+	if !strings.Contains(env, "WARPCLIP_READ_TIMEOUT=30s") || !strings.Contains(env, "WARPCLIP_CONN_TIMEOUT=1h0m0s") || !strings.Contains(env, "WARPCLIP_SHUTDOWN_TIMEOUT=5s") {
+		t.Errorf("Environ missing timeouts:\n%s", env)
+	}
+
+	for _, tc := range []struct{ name, value string }{
+		{"WARPCLIP_READ_TIMEOUT", "0s"},
+		{"WARPCLIP_READ_TIMEOUT", "slow"},
+		{"WARPCLIP_CONN_TIMEOUT", "-1m"},
+		{"WARPCLIP_SHUTDOWN_TIMEOUT", "0"},
+	} {
+		t.Setenv("WARPCLIP_READ_TIMEOUT", "")
+		t.Setenv("WARPCLIP_CONN_TIMEOUT", "")
+		t.Setenv("WARPCLIP_SHUTDOWN_TIMEOUT", "")
+		t.Setenv(tc.name, tc.value)
+		if _, err := Load(); err == nil {
+			t.Errorf("Expected error for %s=%q, got nil", tc.name, tc.value)
+		}
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	t.Setenv("WARPCLIP_DEBOUNCE", "")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Debounce != 0 {
+		t.Errorf("Expected debounce disabled by default, got %v", cfg.Debounce)
+	}
+
+	t.Setenv("WARPCLIP_DEBOUNCE", "150ms")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Failed to load config with debounce: %v", err)
+	}
+	if cfg.Debounce != 150*time.Millisecond {
+		t.Errorf("Expected debounce 150ms, got %v", cfg.Debounce)
+	}
+	if env := strings.Join(cfg.Environ(), "\n"); !strings.Contains(env, "WARPCLIP_DEBOUNCE=150ms") {
+		t.Errorf("Environ missing debounce:\n%s", env)
+	}
+
+	for _, value := range []string{"briefly", "-1s"} {
+		t.Setenv("WARPCLIP_DEBOUNCE", value)
+		if _, err := Load(); err == nil {
+			t.Errorf("Expected error for WARPCLIP_DEBOUNCE=%q, got nil", value)
+		}
+	}
+}
+
+func TestPullFrom(t *testing.T) {
+	t.Setenv("WARPCLIP_PULL_FROM", "")
+	t.Setenv("WARPCLIP_PULL_INTERVAL", "")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.PullFrom != "" || cfg.PullInterval != 0 {
+		t.Errorf("Expected pulling disabled by default, got %q every %v", cfg.PullFrom, cfg.PullInterval)
+	}
+
+	t.Setenv("WARPCLIP_PULL_FROM", "localhost:9998")
+	t.Setenv("WARPCLIP_PULL_INTERVAL", "5s")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Failed to load config with pulling: %v", err)
+	}
+	if cfg.PullFrom != "localhost:9998" || cfg.PullInterval != 5*time.Second {
+		t.Errorf("Expected pulling from localhost:9998 every 5s, got %q every %v", cfg.PullFrom, cfg.PullInterval)
+	}
+	env := strings.Join(cfg.Environ(), "\n")
+	if !strings.Contains(env, "WARPCLIP_PULL_FROM=localhost:9998") || !strings.Contains(env, "WARPCLIP_PULL_INTERVAL=5s") {
+		t.Errorf("Environ missing pull settings:\n%s", env)
+	}
+
+	t.Setenv("WARPCLIP_PULL_INTERVAL", "")
+	for _, value := range []string{"9998", "localhost"} {
+		t.Setenv("WARPCLIP_PULL_FROM", value)
+		if _, err := Load(); err == nil {
+			t.Errorf("Expected error for WARPCLIP_PULL_FROM=%q, got nil", value)
+		}
+	}
+	t.Setenv("WARPCLIP_PULL_FROM", "")
+	for _, value := range []string{"often", "0s", "-1s"} {
+		t.Setenv("WARPCLIP_PULL_INTERVAL", value)
+		if _, err := Load(); err == nil {
+			t.Errorf("Expected error for WARPCLIP_PULL_INTERVAL=%q, got nil", value)
+		}
+	}
+}
+
+func TestNotifySound(t *testing.T) {
+	t.Setenv("WARPCLIP_NOTIFY_SOUND", "")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.NotifySound != "" {
+		t.Errorf("Expected no notifications by default, got %q", cfg.NotifySound)
+	}
+
+	t.Setenv("WARPCLIP_NOTIFY_SOUND", "Glass,Sosumi")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Failed to load config with notify sound: %v", err)
+	}
+	if cfg.NotifySound != "Glass,Sosumi" {
+		t.Errorf("Expected notify sound %q, got %q", "Glass,Sosumi", cfg.NotifySound)
+	}
+	if env := strings.Join(cfg.Environ(), "\n"); !strings.Contains(env, "WARPCLIP_NOTIFY_SOUND=Glass,Sosumi") {
+		t.Errorf("Environ missing notify sound:\n%s", env)
+	}
+}
+
+func TestQuietConnLogs(t *testing.T) {
+	t.Setenv("WARPCLIP_QUIET_CONN_LOGS", "")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.QuietConnLogs {
+		t.Error("Expected connections logged at INFO by default")
+	}
+
+	t.Setenv("WARPCLIP_QUIET_CONN_LOGS", "1")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Failed to load config with quiet connection logs: %v", err)
+	}
+	if !cfg.QuietConnLogs {
+		t.Error("Expected quiet connection logs with WARPCLIP_QUIET_CONN_LOGS=1")
+	}
+	if env := strings.Join(cfg.Environ(), "\n"); !strings.Contains(env, "WARPCLIP_QUIET_CONN_LOGS=1") {
+		t.Errorf("Environ missing quiet connection logs:\n%s", env)
+	}
+
+	t.Setenv("WARPCLIP_QUIET_CONN_LOGS", "sometimes")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for an invalid WARPCLIP_QUIET_CONN_LOGS value")
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	t.Setenv("WARPCLIP_SELFTEST", "")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.SelfTest {
+		t.Error("Expected no self-test by default")
+	}
+
+	t.Setenv("WARPCLIP_SELFTEST", "1")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Failed to load config with the self-test: %v", err)
+	}
+	if !cfg.SelfTest {
+		t.Error("Expected a self-test with WARPCLIP_SELFTEST=1")
+	}
+	if env := strings.Join(cfg.Environ(), "\n"); !strings.Contains(env, "WARPCLIP_SELFTEST=1") {
+		t.Errorf("Environ missing the self-test:\n%s", env)
+	}
+
+	t.Setenv("WARPCLIP_SELFTEST", "sometimes")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for an invalid WARPCLIP_SELFTEST value")
+	}
+}
+
+func TestRestoreOnStart(t *testing.T) {
+	t.Setenv("WARPCLIP_RESTORE_ON_START", "")
+	t.Setenv("WARPCLIP_CLIPBOARD_FILE", "")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.RestoreOnStart {
+		t.Error("Expected the clipboard not to be restored by default")
+	}
+
+	t.Setenv("WARPCLIP_RESTORE_ON_START", "1")
+	t.Setenv("WARPCLIP_CLIPBOARD_FILE", "/tmp/warpclip-test.clipboard")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Failed to load config with restore on start: %v", err)
+	}
+	if !cfg.RestoreOnStart {
+		t.Error("Expected restore on start with WARPCLIP_RESTORE_ON_START=1")
+	}
+	if cfg.ClipboardFile != "/tmp/warpclip-test.clipboard" {
+		t.Errorf("Expected clipboard file %q, got %q", "/tmp/warpclip-test.clipboard", cfg.ClipboardFile)
+	}
+	env := strings.Join(cfg.Environ(), "\n")
+	if !strings.Contains(env, "WARPCLIP_RESTORE_ON_START=1") || !strings.Contains(env, "WARPCLIP_CLIPBOARD_FILE=/tmp/warpclip-test.clipboard") {
+		t.Errorf("Environ missing restore on start:\n%s", env)
+	}
+
+	t.Setenv("WARPCLIP_RESTORE_ON_START", "sometimes")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for an invalid WARPCLIP_RESTORE_ON_START value")
+	}
+}
+
+func TestMemoryBudget(t *testing.T) {
+	t.Setenv("WARPCLIP_MEMORY_BUDGET", "")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.MemoryBudget != 0 {
+		t.Errorf("Expected no memory budget by default, got %d", cfg.MemoryBudget)
+	}
+
+	t.Setenv("WARPCLIP_MEMORY_BUDGET", "8MB")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Failed to load config with a memory budget: %v", err)
+	}
+	if cfg.MemoryBudget != 8*1024*1024 {
+		t.Errorf("Expected a memory budget of 8MB, got %d", cfg.MemoryBudget)
+	}
+	if env := strings.Join(cfg.Environ(), "\n"); !strings.Contains(env, "WARPCLIP_MEMORY_BUDGET=8388608") {
+		t.Errorf("Environ missing memory budget:\n%s", env)
+	}
+
+	// Below the maximum data size no copy could ever fit
+	t.Setenv("WARPCLIP_MEMORY_BUDGET", "512KB")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for a memory budget below the maximum data size")
+	}
+
+	t.Setenv("WARPCLIP_MEMORY_BUDGET", "lots")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for an invalid WARPCLIP_MEMORY_BUDGET value")
+	}
+}
+
+func TestNormalizeEOL(t *testing.T) {
+	t.Setenv("WARPCLIP_NORMALIZE_EOL", "crlf")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config with line ending mode: %v", err)
+	}
+	if cfg.NormalizeEOL != eol.CRLF {
+		t.Errorf("Expected line ending mode crlf, got %v", cfg.NormalizeEOL)
+	}
+
+	t.Setenv("WARPCLIP_NORMALIZE_EOL", "mac")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for invalid WARPCLIP_NORMALIZE_EOL, got nil")
+	}
+}
+
+func TestMinClientVersion(t *testing.T) {
+	t.Setenv("WARPCLIP_MIN_CLIENT_VERSION", "4")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config with WARPCLIP_MIN_CLIENT_VERSION: %v", err)
+	}
+	if cfg.MinClientVersion != 4 {
+		t.Errorf("Expected minimum client version 4, got %d", cfg.MinClientVersion)
+	}
+	if env := strings.Join(cfg.Environ(), "\n"); !strings.Contains(env, "WARPCLIP_MIN_CLIENT_VERSION=4") {
+		t.Errorf("Environ missing minimum client version:\n%s", env)
+	}
+
+	for _, value := range []string{"latest", "-1", "99"} {
+		t.Setenv("WARPCLIP_MIN_CLIENT_VERSION", value)
+		if _, err := Load(); err == nil {
+			t.Errorf("Expected error for WARPCLIP_MIN_CLIENT_VERSION=%q, got nil", value)
+		}
+	}
+}
+
+func TestStripANSI(t *testing.T) {
+	t.Setenv("WARPCLIP_STRIP_ANSI", "1")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config with WARPCLIP_STRIP_ANSI: %v", err)
+	}
+	if !cfg.StripANSI {
+		t.Error("Expected StripANSI to be set")
+	}
+	if env := strings.Join(cfg.Environ(), "\n"); !strings.Contains(env, "WARPCLIP_STRIP_ANSI=1") {
+		t.Errorf("Environ missing strip ANSI:\n%s", env)
+	}
+
+	t.Setenv("WARPCLIP_STRIP_ANSI", "colors")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for invalid WARPCLIP_STRIP_ANSI, got nil")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	t.Setenv("WARPCLIP_REDACT", `sk-[a-z0-9]+|internal\.example\.com`)
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config with redaction pattern: %v", err)
+	}
+	if cfg.Redact != `sk-[a-z0-9]+|internal\.example\.com` {
+		t.Errorf("Unexpected redaction pattern %q", cfg.Redact)
+	}
+
+	t.Setenv("WARPCLIP_REDACT", "([unclosed")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for invalid WARPCLIP_REDACT, got nil")
+	}
+}
+
+func TestClipboardArgs(t *testing.T) {
+	t.Setenv("WARPCLIP_CLIPBOARD_ARGS", "-pboard  ruler")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config with clipboard args: %v", err)
+	}
+	if strings.Join(cfg.ClipboardArgs, "|") != "-pboard|ruler" {
+		t.Errorf("Unexpected clipboard args %q", cfg.ClipboardArgs)
+	}
+
+	for _, value := range []string{"-pboard ruler; rm -rf ~", "$(whoami)", "`id`", "a|b", "> /tmp/x"} {
+		t.Setenv("WARPCLIP_CLIPBOARD_ARGS", value)
+		if _, err := Load(); err == nil {
+			t.Errorf("Expected error for WARPCLIP_CLIPBOARD_ARGS=%q, got nil", value)
+		}
+	}
+}
+
+func TestBackendChain(t *testing.T) {
+	t.Setenv("WARPCLIP_BACKEND_CHAIN", "pbcopy, xclip,custom-command")
+	t.Setenv("WARPCLIP_CLIPBOARD_CMD", "cat > /tmp/clip")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config with a backend chain: %v", err)
+	}
+	if strings.Join(cfg.BackendChain, "|") != "pbcopy|xclip|custom-command" {
+		t.Errorf("Unexpected backend chain %q", cfg.BackendChain)
+	}
+	// The command is for the chain, not a backend of its own
+	if cfg.Backend != "" {
+		t.Errorf("Expected no single backend alongside the chain, got %q", cfg.Backend)
+	}
+	if env := strings.Join(cfg.Environ(), "\n"); !strings.Contains(env, "WARPCLIP_BACKEND_CHAIN=pbcopy,xclip,custom-command") {
+		t.Errorf("Environ missing backend chain:\n%s", env)
+	}
+
+	t.Setenv("WARPCLIP_CLIPBOARD_CMD", "")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for custom-command in the chain without a command, got nil")
+	}
+
+	t.Setenv("WARPCLIP_BACKEND_CHAIN", "pbcopy,,xclip")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for an empty name in the chain, got nil")
+	}
+
+	t.Setenv("WARPCLIP_BACKEND_CHAIN", "pbcopy,xclip")
+	t.Setenv("WARPCLIP_BACKEND", "xsel")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for WARPCLIP_BACKEND with WARPCLIP_BACKEND_CHAIN, got nil")
+	}
+}
+
+func TestTransformCommand(t *testing.T) {
+	t.Setenv("WARPCLIP_TRANSFORM_CMD", `tr -d '\0'`)
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config with transform command: %v", err)
+	}
+	if cfg.TransformCommand != `tr -d '\0'` {
+		t.Errorf("Unexpected transform command %q", cfg.TransformCommand)
+	}
+	if env := strings.Join(cfg.Environ(), "\n"); !strings.Contains(env, `WARPCLIP_TRANSFORM_CMD=tr -d '\0'`) {
+		t.Errorf("Environ missing transform command:\n%s", env)
+	}
+}
+
+func TestReadBufferSize(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.ReadBufferSize != DefaultReadBufferSize {
+		t.Errorf("Expected default read buffer of %d bytes, got %d", DefaultReadBufferSize, cfg.ReadBufferSize)
+	}
+
+	t.Setenv("WARPCLIP_READ_BUFFER", "65536")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Failed to load config with WARPCLIP_READ_BUFFER: %v", err)
+	}
+	if cfg.ReadBufferSize != 65536 {
+		t.Errorf("Expected read buffer of 65536 bytes, got %d", cfg.ReadBufferSize)
+	}
+
+	for _, value := range []string{"100", "99999999", "lots"} {
+		t.Setenv("WARPCLIP_READ_BUFFER", value)
+		if _, err := Load(); err == nil {
+			t.Errorf("Expected error for WARPCLIP_READ_BUFFER=%q, got nil", value)
+		}
+	}
+}
+
+func TestTrimPolicy(t *testing.T) {
+	t.Setenv("WARPCLIP_TRIM_POLICY", "trailing-ws")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config with WARPCLIP_TRIM_POLICY: %v", err)
+	}
+	if cfg.TrimPolicy != trim.TrailingWhitespace {
+		t.Errorf("Expected trim policy trailing-ws, got %v", cfg.TrimPolicy)
+	}
+	if env := strings.Join(cfg.Environ(), "\n"); !strings.Contains(env, "WARPCLIP_TRIM_POLICY=trailing-ws") {
+		t.Errorf("Environ missing trim policy:\n%s", env)
+	}
+
+	t.Setenv("WARPCLIP_TRIM_POLICY", "everything")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for invalid WARPCLIP_TRIM_POLICY, got nil")
+	}
+}
+
+func TestNoStderrMirror(t *testing.T) {
+	t.Setenv("WARPCLIP_NO_STDERR_MIRROR", "true")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config with WARPCLIP_NO_STDERR_MIRROR: %v", err)
+	}
+	if !cfg.NoStderrMirror {
+		t.Error("Expected NoStderrMirror to be set")
+	}
+	if env := strings.Join(cfg.Environ(), "\n"); !strings.Contains(env, "WARPCLIP_NO_STDERR_MIRROR=1") {
+		t.Errorf("Environ missing stderr mirror setting:\n%s", env)
+	}
+
+	t.Setenv("WARPCLIP_NO_STDERR_MIRROR", "sometimes")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for invalid WARPCLIP_NO_STDERR_MIRROR, got nil")
+	}
+}
+
+func TestSilent(t *testing.T) {
+	t.Setenv("WARPCLIP_SILENT", "1")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config with WARPCLIP_SILENT: %v", err)
+	}
+	if !cfg.Silent {
+		t.Error("Expected Silent to be set")
+	}
+
+	t.Setenv("WARPCLIP_SILENT", "sometimes")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for invalid WARPCLIP_SILENT, got nil")
+	}
+}
+
+func TestNoDebugLog(t *testing.T) {
+	t.Setenv("WARPCLIP_NO_DEBUG_LOG", "1")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config with WARPCLIP_NO_DEBUG_LOG: %v", err)
+	}
+	if !cfg.NoDebugLog {
+		t.Error("Expected NoDebugLog to be set")
+	}
+
+	t.Setenv("WARPCLIP_NO_DEBUG_LOG", "never")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for invalid WARPCLIP_NO_DEBUG_LOG, got nil")
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.BreakerThreshold != 0 || cfg.BreakerCooldown != 0 {
+		t.Errorf("Expected the default breaker settings, got %d and %v", cfg.BreakerThreshold, cfg.BreakerCooldown)
+	}
+
+	t.Setenv("WARPCLIP_BREAKER_THRESHOLD", "3")
+	t.Setenv("WARPCLIP_BREAKER_COOLDOWN", "1m")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Failed to load config with breaker settings: %v", err)
+	}
+	if cfg.BreakerThreshold != 3 || cfg.BreakerCooldown != time.Minute {
+		t.Errorf("Expected threshold 3 and cooldown 1m, got %d and %v", cfg.BreakerThreshold, cfg.BreakerCooldown)
+	}
+	env := strings.Join(cfg.Environ(), "\n")
+	if !strings.Contains(env, "WARPCLIP_BREAKER_THRESHOLD=3") || !strings.Contains(env, "WARPCLIP_BREAKER_COOLDOWN=1m0s") {
+		t.Errorf("Environ missing breaker settings:\n%s", env)
+	}
+
+	// Zero turns the breaker off, which must survive Environ
+	t.Setenv("WARPCLIP_BREAKER_THRESHOLD", "0")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Failed to load config with WARPCLIP_BREAKER_THRESHOLD=0: %v", err)
+	}
+	if cfg.BreakerThreshold >= 0 {
+		t.Errorf("Expected a disabled breaker, got threshold %d", cfg.BreakerThreshold)
+	}
+	if env := strings.Join(cfg.Environ(), "\n"); !strings.Contains(env, "WARPCLIP_BREAKER_THRESHOLD=0") {
+		t.Errorf("Environ missing disabled breaker:\n%s", env)
+	}
+
+	for _, tc := range []struct{ name, value string }{
+		{"WARPCLIP_BREAKER_THRESHOLD", "-1"},
+		{"WARPCLIP_BREAKER_THRESHOLD", "few"},
+		{"WARPCLIP_BREAKER_COOLDOWN", "0s"},
+		{"WARPCLIP_BREAKER_COOLDOWN", "later"},
+	} {
+		t.Setenv("WARPCLIP_BREAKER_THRESHOLD", "")
+		t.Setenv("WARPCLIP_BREAKER_COOLDOWN", "")
+		t.Setenv(tc.name, tc.value)
+		if _, err := Load(); err == nil {
+			t.Errorf("Expected error for %s=%q, got nil", tc.name, tc.value)
+		}
+	}
+}
+
+func TestValidationHints(t *testing.T) {
+	testCases := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "WARPCLIP_LOCAL_PORT", value: "http", want: "port number from 1024 to 65535, such as the default 8888"},
+		{name: "WARPCLIP_LOCAL_PORT", value: "80", want: "privileged port"},
+		{name: "WARPCLIP_LOCAL_PORT", value: "70000", want: "want 1024 to 65535"},
+		{name: "WARPCLIP_MAX_DATA_SIZE", value: "10", want: "for 10MB use 10MB"},
+		{name: "WARPCLIP_MAX_DATA_SIZE", value: "1GB", want: "from 1024 (1KB) to 104857600 (100MB)"},
+		{name: "WARPCLIP_MAX_DATA_SIZE", value: "200000000", want: "from 1024 (1KB) to 104857600 (100MB)"},
+		{name: "WARPCLIP_MAX_DATA_SIZE", value: "lots", want: "the default is 1048576 (1MB)"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name+"="+tc.value, func(t *testing.T) {
+			t.Setenv(tc.name, tc.value)
+			_, err := Load()
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Expected error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	testCases := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "1048576", want: 1048576},
+		{value: "1MB", want: 1048576},
+		{value: "1mib", want: 1048576},
+		{value: "10M", want: 10 * 1048576},
+		{value: "512KB", want: 512 * 1024},
+		{value: "512 k", want: 512 * 1024},
+		{value: "2048B", want: 2048},
+		{value: "1GiB", want: 1 << 30},
+		{value: "", wantErr: true},
+		{value: "MB", wantErr: true},
+		{value: "1.5MB", wantErr: true},
+		{value: "-1MB", wantErr: true},
+		{value: "10 megabytes", wantErr: true},
+		{value: "99999999999999G", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := ParseSize(tc.value)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, got %d", got)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Errorf("ParseSize(%q) = %d, %v; want %d", tc.value, got, err, tc.want)
+			}
+		})
+	}
+
+	t.Setenv("WARPCLIP_MAX_DATA_SIZE", "2MB")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.MaxDataSize != 2*1048576 {
+		t.Errorf("WARPCLIP_MAX_DATA_SIZE=2MB gave a limit of %d bytes, want %d", cfg.MaxDataSize, 2*1048576)
+	}
+}
+
+func TestApply(t *testing.T) {
+	t.Setenv("WARPCLIP_LOCAL_PORT", "8890")
+	t.Setenv("WARPCLIP_MAX_DATA_SIZE", "2048")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	// Zero overrides keep what the environment set
+	if err := cfg.Apply(Overrides{}); err != nil {
+		t.Fatalf("Apply with no overrides failed: %v", err)
+	}
+	if cfg.Port != 8890 || cfg.MaxDataSize != 2048 {
+		t.Errorf("Expected environment values to survive, got port %d and max size %d", cfg.Port, cfg.MaxDataSize)
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Apply(Overrides{Port: 8891, MaxDataSize: 4096, LogFile: "~/alt.log"}); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if cfg.Port != 8891 || cfg.MaxDataSize != 4096 || cfg.LogFile != filepath.Join(homeDir, "alt.log") {
+		t.Errorf("Overrides not applied: port %d, max size %d, log file %s", cfg.Port, cfg.MaxDataSize, cfg.LogFile)
+	}
+
+	for _, o := range []Overrides{{Port: 80}, {MaxDataSize: 10}, {MaxDataSize: 1 << 30}} {
+		if err := cfg.Apply(o); err == nil {
+			t.Errorf("Expected error applying %+v, got nil", o)
+		}
+	}
+}
+
+func TestEnviron(t *testing.T) {
+	cfg := &Config{
+		Port:         8890,
+		LogFile:      "/tmp/warpclip.log",
+		DebugFile:    "/tmp/warpclip.debug.log",
+		OutLogFile:   "/tmp/warpclip.out.log",
+		ErrorLogFile: "/tmp/warpclip.error.log",
+		MaxDataSize:  2048,
+	}
+
+	env := cfg.Environ()
+	expected := []string{
+		"WARPCLIP_LOCAL_PORT=8890",
+		"WARPCLIP_LOG_FILE=/tmp/warpclip.log",
+		"WARPCLIP_DEBUG_FILE=/tmp/warpclip.debug.log",
+		"WARPCLIP_OUT_LOG=/tmp/warpclip.out.log",
+		"WARPCLIP_ERROR_LOG=/tmp/warpclip.error.log",
+		"WARPCLIP_MAX_DATA_SIZE=2048",
+	}
+
+	if len(env) != len(expected) {
+		t.Fatalf("Expected %d environment entries, got %d: %v", len(expected), len(env), env)
+	}
+	for i, want := range expected {
+		if env[i] != want {
+			t.Errorf("Environ()[%d] = %q, want %q", i, env[i], want)
+		}
+	}
+}
+
 func TestExpandPath(t *testing.T) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -0,0 +1,133 @@
+package eol
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Mode selects how line endings are rewritten
+type Mode int
+
+const (
+	// None leaves data untouched
+	None Mode = iota
+	// LF converts CRLF line endings to LF
+	LF
+	// CRLF converts bare LF line endings to CRLF
+	CRLF
+)
+
+// ParseMode parses a mode name as used in flags and environment variables
+func ParseMode(s string) (Mode, error) {
+	switch strings.ToLower(s) {
+	case "", "none", "off":
+		return None, nil
+	case "lf":
+		return LF, nil
+	case "crlf":
+		return CRLF, nil
+	default:
+		return None, fmt.Errorf("unknown line ending mode %q (want lf, crlf or none)", s)
+	}
+}
+
+// String returns the name of the mode
+func (m Mode) String() string {
+	switch m {
+	case LF:
+		return "lf"
+	case CRLF:
+		return "crlf"
+	default:
+		return "none"
+	}
+}
+
+// reader rewrites line endings as data streams through it
+type reader struct {
+	r    io.Reader
+	mode Mode
+	in   []byte
+	buf  []byte // backing storage for out
+	out  []byte // converted bytes not yet returned
+	err  error
+	// pendingCR holds back a CR at the end of a chunk in LF mode until we
+	// know whether an LF follows it
+	pendingCR bool
+	// prevCR records whether the last byte seen in CRLF mode was a CR
+	prevCR bool
+}
+
+// NewReader returns a reader that rewrites the line endings of r according to
+// mode. Conversion is streaming, so line endings split across reads of r are
+// handled without buffering the whole input.
+func NewReader(r io.Reader, mode Mode) io.Reader {
+	if mode == None {
+		return r
+	}
+	return &reader{r: r, mode: mode, in: make([]byte, 32*1024)}
+}
+
+// Convert rewrites the line endings of data according to mode
+func Convert(data []byte, mode Mode) []byte {
+	if mode == None {
+		return data
+	}
+	t := &reader{mode: mode}
+	out := t.transform(make([]byte, 0, len(data)), data)
+	if t.pendingCR {
+		out = append(out, '\r')
+	}
+	return out
+}
+
+func (t *reader) Read(p []byte) (int, error) {
+	for len(t.out) == 0 {
+		if t.err != nil {
+			if !t.pendingCR {
+				return 0, t.err
+			}
+			// A trailing CR with nothing after it is not a line ending
+			t.pendingCR = false
+			t.out = append(t.buf[:0], '\r')
+			break
+		}
+		n, err := t.r.Read(t.in)
+		t.out = t.transform(t.buf[:0], t.in[:n])
+		t.buf = t.out
+		t.err = err
+	}
+
+	n := copy(p, t.out)
+	t.out = t.out[n:]
+	return n, nil
+}
+
+// transform appends the converted form of chunk to out
+func (t *reader) transform(out, chunk []byte) []byte {
+	for _, b := range chunk {
+		switch t.mode {
+		case LF:
+			if t.pendingCR {
+				t.pendingCR = false
+				if b == '\n' {
+					out = append(out, '\n')
+					continue
+				}
+				out = append(out, '\r')
+			}
+			if b == '\r' {
+				t.pendingCR = true
+				continue
+			}
+		case CRLF:
+			if b == '\n' && !t.prevCR {
+				out = append(out, '\r')
+			}
+			t.prevCR = b == '\r'
+		}
+		out = append(out, b)
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package eol
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseMode(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Mode
+	}{
+		{input: "", expected: None},
+		{input: "none", expected: None},
+		{input: "LF", expected: LF},
+		{input: "crlf", expected: CRLF},
+	}
+
+	for _, tc := range testCases {
+		mode, err := ParseMode(tc.input)
+		if err != nil {
+			t.Errorf("ParseMode(%q) failed: %v", tc.input, err)
+			continue
+		}
+		if mode != tc.expected {
+			t.Errorf("ParseMode(%q) = %v, want %v", tc.input, mode, tc.expected)
+		}
+	}
+
+	if _, err := ParseMode("cr"); err == nil {
+		t.Error("Expected error for unknown mode, got nil")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mode     Mode
+		input    string
+		expected string
+	}{
+		{name: "none", mode: None, input: "a\r\nb\n", expected: "a\r\nb\n"},
+		{name: "lf", mode: LF, input: "a\r\nb\r\n", expected: "a\nb\n"},
+		{name: "lf keeps lone cr", mode: LF, input: "a\rb\r\r\nc\r", expected: "a\rb\r\nc\r"},
+		{name: "lf mixed", mode: LF, input: "a\nb\r\n", expected: "a\nb\n"},
+		{name: "crlf", mode: CRLF, input: "a\nb\n", expected: "a\r\nb\r\n"},
+		{name: "crlf keeps existing", mode: CRLF, input: "a\r\nb\nc", expected: "a\r\nb\r\nc"},
+		{name: "empty", mode: LF, input: "", expected: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := string(Convert([]byte(tc.input), tc.mode)); got != tc.expected {
+				t.Errorf("Convert(%q) = %q, want %q", tc.input, got, tc.expected)
+			}
+
+			// Reading one byte at a time splits every CRLF across reads
+			got, err := io.ReadAll(NewReader(iotest.OneByteReader(strings.NewReader(tc.input)), tc.mode))
+			if err != nil {
+				t.Fatalf("ReadAll failed: %v", err)
+			}
+			if string(got) != tc.expected {
+				t.Errorf("NewReader(%q) produced %q, want %q", tc.input, got, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxEntries is the number of entries kept when the history file is compacted
+const MaxEntries = 1000
+
+// compactSize is the file size beyond which Append drops all but the newest
+// MaxEntries, roughly twice what they take up
+const compactSize = 256 * 1024
+
+// Entry records one copy. Only what was copied is described, never the
+// content itself.
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Bytes is the size of the data written to the clipboard
+	Bytes int64 `json:"bytes"`
+	// Type is the MIME type detected from the data
+	Type string `json:"type"`
+	// Source is how the copy arrived: copy, follow or session
+	Source string `json:"source"`
+	// Expires is when the entry is purged from the history, zero to keep
+	// it until compaction drops it
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// MarshalJSON encodes the entry, leaving out Expires when it is zero as
+// omitempty can't for a time
+func (e Entry) MarshalJSON() ([]byte, error) {
+	type entry Entry
+	var expires *time.Time
+	if !e.Expires.IsZero() {
+		expires = &e.Expires
+	}
+	return json.Marshal(struct {
+		entry
+		Expires *time.Time `json:"expires,omitempty"`
+	}{entry(e), expires})
+}
+
+// Expired reports whether the entry's time to live had run out by now
+func (e Entry) Expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+// Append adds entry to the history file at path, one JSON object per line
+func Append(path string, entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode history entry: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+	info, err := file.Stat()
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to check history file: %w", err)
+	}
+
+	if info.Size() > compactSize {
+		return compact(path)
+	}
+	return nil
+}
+
+// compact rewrites the history file with only its newest MaxEntries, leaving
+// out any that have expired
+func compact(path string) error {
+	entries, err := Read(path)
+	if err != nil {
+		return err
+	}
+	entries = Unexpired(entries, time.Now())
+	if len(entries) > MaxEntries {
+		entries = entries[len(entries)-MaxEntries:]
+	}
+	return rewrite(path, entries)
+}
+
+// Purge rewrites the history file at path without the entries that had
+// expired by now, returning how many it dropped. The file is left alone when
+// none had.
+func Purge(path string, now time.Time) (int, error) {
+	entries, err := Read(path)
+	if err != nil {
+		return 0, err
+	}
+	kept := Unexpired(entries, now)
+	if len(kept) == len(entries) {
+		return 0, nil
+	}
+	if err := rewrite(path, kept); err != nil {
+		return 0, err
+	}
+	return len(entries) - len(kept), nil
+}
+
+// rewrite replaces the history file at path with entries
+func rewrite(path string, entries []Entry) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return fmt.Errorf("failed to encode history entry: %w", err)
+		}
+	}
+
+	// Replace the file in one step so a reader never sees it half written
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to rewrite history file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to rewrite history file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to rewrite history file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to rewrite history file: %w", err)
+	}
+	return nil
+}
+
+// Read returns the entries in the history file at path, oldest first. A
+// missing file is an empty history, and lines that can't be decoded, such as
+// one cut short by a crash, are skipped.
+func Read(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history file: %w", err)
+	}
+	return entries, nil
+}
+
+// Unexpired returns the entries that hadn't expired by now
+func Unexpired(entries []Entry, now time.Time) []Entry {
+	var kept []Entry
+	for _, entry := range entries {
+		if !entry.Expired(now) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// Filter returns the newest limit entries made at or after since, oldest
+// first. A zero since or limit doesn't restrict the entries.
+func Filter(entries []Entry, since time.Time, limit int) []Entry {
+	start := 0
+	for start < len(entries) && entries[start].Time.Before(since) {
+		start++
+	}
+	entries = entries[start:]
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries
+}
+
+// WriteJSON writes entries to w as one indented JSON array, with times in
+// RFC 3339, the ISO 8601 profile JSON tools expect
+func WriteJSON(w io.Writer, entries []Entry) error {
+	if entries == nil {
+		entries = []Entry{}
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode history: %w", err)
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// WriteCSV writes entries to w as CSV with a header row, with times in RFC
+// 3339 and an empty expiry for entries kept until compaction
+func WriteCSV(w io.Writer, entries []Entry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "bytes", "type", "source", "expires"})
+	for _, entry := range entries {
+		expires := ""
+		if !entry.Expires.IsZero() {
+			expires = entry.Expires.Format(time.RFC3339)
+		}
+		cw.Write([]string{entry.Time.Format(time.RFC3339), strconv.FormatInt(entry.Bytes, 10), entry.Type, entry.Source, expires})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// sinceLayouts are the absolute times accepted by ParseSince, in local time
+var sinceLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseSince parses the start of a time range relative to now: either a
+// duration to look back, such as 90m, 1h or 7d, or an absolute date or time
+// such as 2024-01-01 or "2024-01-01 09:30"
+func ParseSince(s string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("invalid time %q: a duration to look back must not be negative", s)
+		}
+		return now.Add(-d), nil
+	}
+	for _, layout := range sinceLayouts {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: want a duration such as 1h or 7d, or a date such as 2024-01-01", s)
+}
@@ -0,0 +1,240 @@
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+
+	entries, err := Read(path)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("Read of a missing file returned %v, %v; want an empty history", entries, err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	first := Entry{Time: now.Add(-time.Minute), Bytes: 12, Type: "text/plain", Source: "copy"}
+	second := Entry{Time: now, Bytes: 2048, Type: "image/png", Source: "session"}
+	for _, entry := range []Entry{first, second} {
+		if err := Append(path, entry); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	// A line cut short by a crash is skipped
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"time":"2024-`)
+	file.Close()
+
+	entries, err = Read(path)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if len(entries) != 2 || !entries[0].Time.Equal(first.Time) || entries[1].Type != "image/png" || entries[1].Bytes != 2048 {
+		t.Errorf("Read returned %+v, want the two appended entries", entries)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("History file has mode %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestWriteFormats(t *testing.T) {
+	at := time.Date(2024, 1, 1, 9, 30, 12, 0, time.UTC)
+	entries := []Entry{
+		{Time: at, Bytes: 7, Type: "text/plain", Source: "copy"},
+		{Time: at.Add(time.Minute), Bytes: 24, Type: "text/plain", Source: "copy", Expires: at.Add(6 * time.Minute)},
+	}
+
+	var out bytes.Buffer
+	if err := WriteJSON(&out, entries); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	var decoded []map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("WriteJSON wrote invalid JSON: %v\n%s", err, out.String())
+	}
+	if len(decoded) != 2 || decoded[0]["time"] != "2024-01-01T09:30:12Z" || decoded[1]["expires"] != "2024-01-01T09:36:12Z" {
+		t.Errorf("WriteJSON wrote %s", out.String())
+	}
+	if _, ok := decoded[0]["expires"]; ok {
+		t.Errorf("WriteJSON included a zero expiry: %s", out.String())
+	}
+
+	out.Reset()
+	if err := WriteCSV(&out, entries); err != nil {
+		t.Fatalf("WriteCSV failed: %v", err)
+	}
+	want := "time,bytes,type,source,expires\n" +
+		"2024-01-01T09:30:12Z,7,text/plain,copy,\n" +
+		"2024-01-01T09:31:12Z,24,text/plain,copy,2024-01-01T09:36:12Z\n"
+	if out.String() != want {
+		t.Errorf("WriteCSV wrote:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	// An empty history is still valid output
+	out.Reset()
+	if err := WriteJSON(&out, nil); err != nil || strings.TrimSpace(out.String()) != "[]" {
+		t.Errorf("WriteJSON of no entries wrote %q, %v; want []", out.String(), err)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+
+	// Each entry takes under 100 bytes, so this writes well past compactSize
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	count := 2 * compactSize / 100
+	for i := 0; i < count; i++ {
+		if err := Append(path, Entry{Time: start.Add(time.Duration(i) * time.Second), Bytes: 1, Type: "text/plain", Source: "copy"}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	entries, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if len(entries) >= count || len(entries) < MaxEntries {
+		t.Errorf("History holds %d entries after %d appends, want it compacted to no fewer than %d", len(entries), count, MaxEntries)
+	}
+	if last := entries[len(entries)-1].Time; !last.Equal(start.Add(time.Duration(count-1) * time.Second)) {
+		t.Errorf("Newest entry is from %v, want the last one appended", last)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	now := time.Now().Truncate(time.Second)
+	entries := []Entry{
+		{Time: now.Add(-time.Hour), Bytes: 1, Expires: now.Add(-time.Minute)},
+		{Time: now.Add(-time.Minute), Bytes: 2},
+		{Time: now, Bytes: 3, Expires: now.Add(time.Minute)},
+	}
+	for _, entry := range entries {
+		if err := Append(path, entry); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	// Entries without a TTL don't record one
+	if content, _ := os.ReadFile(path); strings.Count(string(content), `"expires"`) != 2 {
+		t.Errorf("Expected only the two entries with a TTL to record one:\n%s", content)
+	}
+
+	if kept := Unexpired(entries, now); len(kept) != 2 || kept[0].Bytes != 2 {
+		t.Errorf("Unexpired returned %+v, want the last two entries", kept)
+	}
+
+	purged, err := Purge(path, now)
+	if err != nil || purged != 1 {
+		t.Fatalf("Purge returned %d, %v; want 1 entry dropped", purged, err)
+	}
+	entries, err = Read(path)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Bytes != 2 || !entries[1].Expires.Equal(now.Add(time.Minute)) {
+		t.Errorf("History after purge is %+v, want the unexpired entries with their TTL", entries)
+	}
+
+	// Nothing more has expired, so the file is left alone
+	info, _ := os.Stat(path)
+	if purged, err := Purge(path, now); err != nil || purged != 0 {
+		t.Errorf("Second purge returned %d, %v; want nothing dropped", purged, err)
+	}
+	if after, _ := os.Stat(path); !os.SameFile(info, after) {
+		t.Error("Purge rewrote a history with nothing expired")
+	}
+
+	// The last entry goes once its time to live has run out
+	if purged, err := Purge(path, now.Add(time.Minute)); err != nil || purged != 1 {
+		t.Errorf("Purge at expiry returned %d, %v; want 1 entry dropped", purged, err)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var entries []Entry
+	for i := 0; i < 5; i++ {
+		entries = append(entries, Entry{Time: base.Add(time.Duration(i) * time.Hour), Bytes: int64(i)})
+	}
+
+	testCases := []struct {
+		name  string
+		since time.Time
+		limit int
+		want  []int64
+	}{
+		{name: "all", want: []int64{0, 1, 2, 3, 4}},
+		{name: "since", since: base.Add(3 * time.Hour), want: []int64{3, 4}},
+		{name: "limit", limit: 2, want: []int64{3, 4}},
+		{name: "since and limit", since: base.Add(time.Hour), limit: 3, want: []int64{2, 3, 4}},
+		{name: "nothing newer", since: base.Add(24 * time.Hour), want: nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Filter(entries, tc.since, tc.limit)
+			if len(got) != len(tc.want) {
+				t.Fatalf("Filter returned %d entries, want %d", len(got), len(tc.want))
+			}
+			for i, entry := range got {
+				if entry.Bytes != tc.want[i] {
+					t.Errorf("Entry %d is %d, want %d", i, entry.Bytes, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 3, 10, 15, 30, 0, 0, time.Local)
+
+	testCases := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "1h", want: now.Add(-time.Hour)},
+		{value: "90m", want: now.Add(-90 * time.Minute)},
+		{value: "7d", want: now.AddDate(0, 0, -7)},
+		{value: "2024-01-01", want: time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)},
+		{value: "2024-01-01 09:30", want: time.Date(2024, 1, 1, 9, 30, 0, 0, time.Local)},
+		{value: "2024-01-01T09:30:15Z", want: time.Date(2024, 1, 1, 9, 30, 15, 0, time.UTC)},
+		{value: "-1h", wantErr: true},
+		{value: "yesterday", wantErr: true},
+		{value: "d", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := ParseSince(tc.value, now)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSince failed: %v", err)
+			}
+			if !got.Equal(tc.want) {
+				t.Errorf("ParseSince(%q) = %v, want %v", tc.value, got, tc.want)
+			}
+		})
+	}
+}
@@ -55,12 +55,48 @@ type Logger interface {
 type FileLogger struct {
 	logFile    *os.File
 	debugFile  *os.File
+	logPath    string
+	// debugPath is empty when the debug log is disabled
+	debugPath  string
 	maxFileSize int64
+	redactor   *Redactor
+	level      LogLevel
+	// mirrorErrors copies ERROR messages to stderr as well
+	mirrorErrors bool
+	// logFailure and debugFailure track writes failing on each file
+	logFailure   writeFailure
+	debugFailure writeFailure
 	mutex      sync.Mutex
 }
 
+// writeFailure tracks a log file that writes are failing on, such as on a
+// full disk, so the failure costs one message on stderr rather than one per
+// log line
+type writeFailure struct {
+	// retryAt is when to reopen the file, zero while writes succeed
+	retryAt time.Time
+	// dropped counts the messages lost since writes started failing
+	dropped int
+}
+
+// logRetryInterval is how long a log file whose writes fail is left before
+// it is reopened
+const logRetryInterval = 30 * time.Second
+
+// Options tunes how a FileLogger is set up
+type Options struct {
+	// NoDebugLog drops DEBUG messages instead of opening a debug log file
+	NoDebugLog bool
+}
+
 // New creates a new FileLogger that writes to the specified file
 func New(logFilePath string) (*FileLogger, error) {
+	return NewWithOptions(logFilePath, Options{})
+}
+
+// NewWithOptions creates a new FileLogger that writes to the specified file,
+// configured by opts
+func NewWithOptions(logFilePath string, opts Options) (*FileLogger, error) {
 	// Get the directory from the log file path
 	dir := filepath.Dir(logFilePath)
 	
@@ -75,32 +111,69 @@ func New(logFilePath string) (*FileLogger, error) {
 		return nil, fmt.Errorf("failed to open log file: %w", err)
 	}
 	
-	// Create a default debug file path based on the log file path
-	debugFilePath := logFilePath
-	if ext := filepath.Ext(logFilePath); ext != "" {
-		debugFilePath = logFilePath[:len(logFilePath)-len(ext)] + ".debug" + ext
-	} else {
-		debugFilePath = logFilePath + ".debug"
-	}
-	
-	// Open the debug file with secure permissions
-	debugFile, err := os.OpenFile(debugFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
-	if err != nil {
-		// Close the already opened log file
-		logFile.Close()
-		return nil, fmt.Errorf("failed to open debug log file: %w", err)
-	}
-	
 	logger := &FileLogger{
 		logFile:    logFile,
-		debugFile:  debugFile,
+		logPath:    logFilePath,
 		maxFileSize: 10 * 1024 * 1024, // 10MB default max file size
+		mirrorErrors: true,
 		mutex:      sync.Mutex{},
 	}
 	
+	if !opts.NoDebugLog {
+		// Create a default debug file path based on the log file path
+		debugFilePath := logFilePath
+		if ext := filepath.Ext(logFilePath); ext != "" {
+			debugFilePath = logFilePath[:len(logFilePath)-len(ext)] + ".debug" + ext
+		} else {
+			debugFilePath = logFilePath + ".debug"
+		}
+		
+		// Open the debug file with secure permissions
+		debugFile, err := os.OpenFile(debugFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			// Close the already opened log file
+			logFile.Close()
+			return nil, fmt.Errorf("failed to open debug log file: %w", err)
+		}
+		logger.debugFile = debugFile
+		logger.debugPath = debugFilePath
+	}
+	
+	// Secrets matching the default patterns are always masked
+	redactor, err := NewRedactor("")
+	if err != nil {
+		logger.Close()
+		return nil, err
+	}
+	logger.redactor = redactor
+	
 	return logger, nil
 }
 
+// SetRedactor replaces the redactor applied to every message before it is
+// written
+func (l *FileLogger) SetRedactor(redactor *Redactor) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.redactor = redactor
+}
+
+// SetLevel discards messages below level. Everything is logged by default.
+func (l *FileLogger) SetLevel(level LogLevel) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.level = level
+}
+
+// SetMirrorErrorsToStderr controls whether ERROR messages are written to
+// stderr as well as the log, as they are by default. A service manager that
+// captures stderr into the log file would otherwise record each error twice.
+func (l *FileLogger) SetMirrorErrorsToStderr(mirror bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.mirrorErrors = mirror
+}
+
 // Debug logs a message at DEBUG level
 func (l *FileLogger) Debug(message string) {
 	l.log(DEBUG, sanitizeInput(message))
@@ -160,6 +233,18 @@ func (l *FileLogger) log(level LogLevel, message string) {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 	
+	if level < l.level {
+		return
+	}
+	
+	// Without a debug log there is nowhere for DEBUG messages to go
+	if level == DEBUG && l.debugPath == "" {
+		return
+	}
+	
+	// Mask secrets before the message can reach any file or stderr
+	message = l.redactor.Redact(message)
+	
 	timestamp := time.Now().Format("2006-01-02 15:04:05")
 	logLine := fmt.Sprintf("[%s] [%s] %s\n", timestamp, level.String(), message)
 	
@@ -172,41 +257,82 @@ func (l *FileLogger) log(level LogLevel, message string) {
 	// Write to appropriate file(s)
 	if level == DEBUG {
 		// Debug messages go only to debug file
-		if l.debugFile != nil {
-			_, err := l.debugFile.WriteString(logLine)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error writing to debug log: %v\n", err)
-			}
-		}
+		l.write(&l.debugFile, l.debugPath, &l.debugFailure, logLine, "debug log")
 	} else {
 		// All other messages go to main log file
-		if l.logFile != nil {
-			_, err := l.logFile.WriteString(logLine)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error writing to log: %v\n", err)
-			}
-		}
+		l.write(&l.logFile, l.logPath, &l.logFailure, logLine, "log")
 		
-		// Errors also go to stderr
-		if level == ERROR {
+		// Errors also go to stderr, unless that is the log file too
+		if level == ERROR && l.mirrorErrors {
 			fmt.Fprint(os.Stderr, logLine)
 		}
 	}
 }
 
+// write appends line to file. Once a write fails, later lines are dropped
+// and counted until the file is reopened after logRetryInterval, and the
+// first line written then says how many were lost.
+func (l *FileLogger) write(file **os.File, path string, failure *writeFailure, line, name string) {
+	if !failure.retryAt.IsZero() {
+		if time.Now().Before(failure.retryAt) {
+			failure.dropped++
+			return
+		}
+
+		// The directory may have been removed, or the file replaced
+		if *file != nil {
+			(*file).Close()
+			*file = nil
+		}
+		reopened, err := openLogFile(path)
+		if err != nil {
+			failure.dropped++
+			failure.retryAt = time.Now().Add(logRetryInterval)
+			return
+		}
+		*file = reopened
+		timestamp := time.Now().Format("2006-01-02 15:04:05")
+		line = fmt.Sprintf("[%s] [%s] Writing to the %s failed, so %d messages were lost\n", timestamp, WARNING, name, failure.dropped) + line
+	}
+
+	if *file == nil {
+		return
+	}
+	if _, err := (*file).WriteString(line); err != nil {
+		// Only the first failure is reported, or a full disk would print
+		// every message
+		if failure.retryAt.IsZero() {
+			fmt.Fprintf(os.Stderr, "Error writing to %s: %v (dropping messages until it can be reopened)\n", name, err)
+		}
+		failure.dropped++
+		failure.retryAt = time.Now().Add(logRetryInterval)
+		return
+	}
+	*failure = writeFailure{}
+}
+
+// openLogFile opens the log file at path for appending, creating it and its
+// directory as needed
+func openLogFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+}
+
 // ensureLogFilesExist checks if log files exist and recreates them if needed
 func (l *FileLogger) ensureLogFilesExist() {
 	if l.logFile == nil {
 		// Try to recreate the log file
-		logFile, err := os.OpenFile(l.logFile.Name(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		logFile, err := os.OpenFile(l.logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 		if err == nil {
 			l.logFile = logFile
 		}
 	}
 	
-	if l.debugFile == nil {
+	if l.debugFile == nil && l.debugPath != "" {
 		// Try to recreate the debug file
-		debugFile, err := os.OpenFile(l.debugFile.Name(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		debugFile, err := os.OpenFile(l.debugPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 		if err == nil {
 			l.debugFile = debugFile
 		}
@@ -223,8 +349,7 @@ func (l *FileLogger) checkRotation() {
 			l.logFile.Close()
 			
 			// Create new name with timestamp
-			timestamp := time.Now().Format("20060102150405")
-			newName := fmt.Sprintf("%s.%s", l.logFile.Name(), timestamp)
+			newName := rotatedName(l.logFile.Name(), rotationTime())
 			
 			// Rename old file
 			os.Rename(l.logFile.Name(), newName)
@@ -245,8 +370,7 @@ func (l *FileLogger) checkRotation() {
 			l.debugFile.Close()
 			
 			// Create new name with timestamp
-			timestamp := time.Now().Format("20060102150405")
-			newName := fmt.Sprintf("%s.%s", l.debugFile.Name(), timestamp)
+			newName := rotatedName(l.debugFile.Name(), rotationTime())
 			
 			// Rename old file
 			os.Rename(l.debugFile.Name(), newName)
@@ -260,6 +384,23 @@ func (l *FileLogger) checkRotation() {
 	}
 }
 
+// rotationTime returns the time rotated files are named after, replaceable in tests
+var rotationTime = time.Now
+
+// rotatedName returns the name a log file at path is rotated to: the path
+// with a timestamp appended, plus a counter when a file rotated within the
+// same second already has that name, so one rotation never overwrites another
+func rotatedName(path string, now time.Time) string {
+	name := fmt.Sprintf("%s.%s", path, now.Format("20060102150405"))
+	candidate := name
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s.%d", name, i)
+	}
+}
+
 // sanitizeInput removes control characters from the log message to prevent log injection
 func sanitizeInput(input string) string {
 	// Remove or replace control characters
@@ -0,0 +1,57 @@
+package log
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// redactedText replaces every match of a redaction pattern
+const redactedText = "[REDACTED]"
+
+// DefaultRedactPatterns match common secrets that should never reach a log
+var DefaultRedactPatterns = []string{
+	// AWS access key IDs
+	`\b(AKIA|ASIA)[0-9A-Z]{16}\b`,
+	// GitHub tokens
+	`\bgh[pousr]_[A-Za-z0-9]{36,}\b`,
+	// key=value or key: value assignments of credentials
+	`(?i)\b(password|passwd|secret|token|api[_-]?key)\s*[=:]\s*\S+`,
+	// PEM private key blocks, or the start of one if truncated
+	`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?(-----END [A-Z ]*PRIVATE KEY-----|$)`,
+}
+
+// Redactor masks text matching a set of regular expressions
+type Redactor struct {
+	patterns []*regexp.Regexp
+}
+
+// NewRedactor creates a redactor for DefaultRedactPatterns plus extra, a
+// user-supplied regular expression (combine several with |). An empty extra
+// adds nothing.
+func NewRedactor(extra string) (*Redactor, error) {
+	sources := append([]string{}, DefaultRedactPatterns...)
+	if extra != "" {
+		sources = append(sources, extra)
+	}
+
+	r := &Redactor{}
+	for _, source := range sources {
+		pattern, err := regexp.Compile(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", source, err)
+		}
+		r.patterns = append(r.patterns, pattern)
+	}
+	return r, nil
+}
+
+// Redact replaces everything in s matching one of the patterns
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, pattern := range r.patterns {
+		s = pattern.ReplaceAllLiteralString(s, redactedText)
+	}
+	return s
+}
@@ -0,0 +1,214 @@
+package protocol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxChunkAttempts is how many times SendChunked sends a chunk before giving
+// up on it
+const MaxChunkAttempts = 5
+
+// maxChunkHeaderLength bounds the line announcing a chunk
+const maxChunkHeaderLength = 48
+
+// ErrChunkCorrupt is returned by ReadChunk when a chunk's data doesn't match
+// its checksum. The chunk's sequence number is still returned so the server
+// can ask for it again.
+var ErrChunkCorrupt = errors.New("chunk failed its checksum")
+
+// ErrChunkUnacknowledged is returned by SendChunked when a chunk still isn't
+// acknowledged after MaxChunkAttempts sends
+var ErrChunkUnacknowledged = errors.New("chunk was not acknowledged")
+
+// Replies to a chunk: "ACK SEQ" once it has been stored, or "NAK SEQ" to have
+// it sent again
+const (
+	chunkAck  = "ACK"
+	chunkNack = "NAK"
+)
+
+// WriteChunk writes the chunk numbered seq, counting from 1: a "SEQ SIZE
+// CRC32" line, then the data itself
+func WriteChunk(w io.Writer, seq int, data []byte) error {
+	if _, err := fmt.Fprintf(w, "%d %d %08x\n", seq, len(data), crc32.ChecksumIEEE(data)); err != nil {
+		return fmt.Errorf("failed to write chunk header: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to write chunk data: %w", err)
+	}
+	return nil
+}
+
+// ReadChunk reads a chunk written by WriteChunk and returns its sequence
+// number and data. It returns ErrFrameTooLarge when the chunk exceeds
+// maxSize and ErrChunkCorrupt when the data doesn't match the checksum;
+// any other error leaves the stream unusable.
+func ReadChunk(r *bufio.Reader, maxSize int64) (int, []byte, error) {
+	header, err := readBoundedLine(r, maxChunkHeaderLength)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read chunk header: %w", err)
+	}
+
+	fields := strings.Fields(header)
+	if len(fields) != 3 {
+		return 0, nil, fmt.Errorf("invalid chunk header %q", header)
+	}
+	seq, seqErr := strconv.Atoi(fields[0])
+	size, sizeErr := strconv.ParseInt(fields[1], 10, 64)
+	sum, sumErr := strconv.ParseUint(fields[2], 16, 32)
+	if seqErr != nil || sizeErr != nil || sumErr != nil || seq < 1 || size < 0 {
+		return 0, nil, fmt.Errorf("invalid chunk header %q", header)
+	}
+	if size > maxSize {
+		return seq, nil, fmt.Errorf("%w: chunk %d is %d > %d bytes", ErrFrameTooLarge, seq, size, maxSize)
+	}
+
+	data := make([]byte, size)
+	if n, err := io.ReadFull(r, data); err != nil {
+		return seq, nil, fmt.Errorf("chunk %d truncated after %d of %d bytes: %w", seq, n, size, err)
+	}
+	if crc32.ChecksumIEEE(data) != uint32(sum) {
+		return seq, nil, fmt.Errorf("%w: chunk %d", ErrChunkCorrupt, seq)
+	}
+	return seq, data, nil
+}
+
+// WriteChunkReply acknowledges the chunk numbered seq, or asks for it again
+// when ok is false
+func WriteChunkReply(w io.Writer, seq int, ok bool) error {
+	reply := chunkAck
+	if !ok {
+		reply = chunkNack
+	}
+	_, err := fmt.Fprintf(w, "%s %d\n", reply, seq)
+	return err
+}
+
+// SendChunked sends data over conn as a chunked payload of chunkSize byte
+// chunks, announced with ChunkedPreamble, and returns the server's Ack.
+// Each chunk gets up to timeout to be written and each reply, read through
+// r, up to timeout to arrive. A chunk the server reports as corrupt, or
+// doesn't acknowledge in time, is sent again, up to MaxChunkAttempts times.
+// conn is left without deadlines.
+func SendChunked(conn net.Conn, r *bufio.Reader, data []byte, chunkSize int, timeout time.Duration) (Ack, error) {
+	if chunkSize < 1 {
+		return Ack{}, fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+	defer conn.SetDeadline(time.Time{})
+	if err := conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return Ack{}, fmt.Errorf("failed to set write deadline: %w", err)
+	}
+	if _, err := io.WriteString(conn, ChunkedPreamble); err != nil {
+		return Ack{}, fmt.Errorf("failed to announce chunks: %w", err)
+	}
+
+	// A reply cut short by the timeout is finished by the next read
+	var partial string
+	readReply := func() (string, error) {
+		if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return "", fmt.Errorf("failed to set read deadline: %w", err)
+		}
+		part, err := r.ReadString('\n')
+		partial += part
+		if err != nil {
+			return "", err
+		}
+		line := strings.TrimRight(partial, "\r\n")
+		partial = ""
+		return line, nil
+	}
+
+	// The empty chunk after the last one ends the payload
+	for seq, offset := 1, 0; ; seq++ {
+		end := offset + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := data[offset:end]
+		offset = end
+
+		acked, err := sendChunk(conn, seq, chunk, timeout, readReply)
+		if err != nil || !acked.OK {
+			return acked, err
+		}
+		if len(chunk) == 0 {
+			break
+		}
+	}
+
+	line, err := readReply()
+	if err != nil {
+		return Ack{}, fmt.Errorf("failed to read acknowledgement: %w", err)
+	}
+	return parseAck(line)
+}
+
+// sendChunk sends one chunk until the server acknowledges it, returning an
+// OK Ack once it has. Should the server give up on the payload instead, its
+// final Ack is returned.
+func sendChunk(conn net.Conn, seq int, chunk []byte, timeout time.Duration, readReply func() (string, error)) (Ack, error) {
+	for attempt := 1; attempt <= MaxChunkAttempts; attempt++ {
+		if err := conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			return Ack{}, fmt.Errorf("failed to set write deadline: %w", err)
+		}
+		if err := WriteChunk(conn, seq, chunk); err != nil {
+			return Ack{}, fmt.Errorf("failed to send chunk %d: %w", seq, err)
+		}
+
+	replies:
+		for {
+			line, err := readReply()
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			if err != nil {
+				return Ack{}, fmt.Errorf("failed to read reply to chunk %d: %w", seq, err)
+			}
+
+			reply, value, _ := strings.Cut(line, " ")
+			if reply != chunkAck && reply != chunkNack {
+				return parseAck(line)
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return Ack{}, fmt.Errorf("invalid chunk reply %q", line)
+			}
+			switch {
+			case n < seq:
+				// A late reply to a chunk already sent again
+			case n > seq:
+				return Ack{}, fmt.Errorf("reply %q to a chunk not yet sent", line)
+			case reply == chunkAck:
+				return Ack{OK: true}, nil
+			default:
+				break replies
+			}
+		}
+	}
+	return Ack{}, fmt.Errorf("%w: chunk %d after %d attempts", ErrChunkUnacknowledged, seq, MaxChunkAttempts)
+}
+
+// readBoundedLine reads a newline-terminated line of at most limit bytes
+func readBoundedLine(r *bufio.Reader, limit int) (string, error) {
+	var line strings.Builder
+	for line.Len() <= limit {
+		b, err := r.ReadByte()
+		if err != nil {
+			return line.String(), err
+		}
+		if b == '\n' {
+			return line.String(), nil
+		}
+		line.WriteByte(b)
+	}
+	return "", fmt.Errorf("line exceeds %d bytes", limit)
+}
@@ -0,0 +1,582 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version is the protocol version this implementation speaks
+const Version = 8
+
+// ClearVersion is the first version whose servers understand ClearDirective.
+// Older ones would copy the directive itself, so clients must check first.
+const ClearVersion = 3
+
+// ChunkedVersion is the first version whose servers understand
+// ChunkedPreamble
+const ChunkedVersion = 4
+
+// HistoryTTLVersion is the first version whose servers understand
+// HistoryTTLHeader. Older ones would copy the header line with the payload.
+const HistoryTTLVersion = 5
+
+// ClipboardHashVersion is the first version whose servers answer a
+// PasteDirective preceded by VerifyDirective with only a SHA-256 of the
+// clipboard. Older ones would send the contents, or copy the request.
+const ClipboardHashVersion = 6
+
+// ContentTypeVersion is the first version whose servers understand
+// ContentTypeHeader. Older ones would copy the header line with the payload.
+const ContentTypeVersion = 7
+
+// CapsVersion is the first version whose servers answer CapsDirective.
+// Older ones would copy the directive itself, so clients must check first.
+const CapsVersion = 8
+
+// LegacyVersion is the version spoken by servers that predate the handshake
+const LegacyVersion = 1
+
+// HandshakePrefix starts the line a client sends first to negotiate a
+// protocol version, such as "WARPCLIP/2". The server answers with the
+// version it speaks in the same form before the client sends anything else.
+const HandshakePrefix = "WARPCLIP/"
+
+// ErrHandshakeUnsupported is returned by Handshake when the server doesn't
+// answer the handshake, as servers predating it wait for the whole payload
+var ErrHandshakeUnsupported = errors.New("server does not support version negotiation")
+
+// WriteHandshake writes a handshake line announcing version
+func WriteHandshake(w io.Writer, version int) error {
+	_, err := fmt.Fprintf(w, "%s%d\n", HandshakePrefix, version)
+	return err
+}
+
+// Handshake announces Version to the server on conn and waits up to timeout
+// for its answer, returning the version both sides speak. Replies are read
+// through r, which the caller should keep using for the connection. conn is
+// left without a read deadline.
+func Handshake(conn net.Conn, r *bufio.Reader, timeout time.Duration) (int, error) {
+	if err := WriteHandshake(conn, Version); err != nil {
+		return 0, fmt.Errorf("failed to send handshake: %w", err)
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return 0, fmt.Errorf("failed to set read deadline: %w", err)
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	line, err := readLine(r)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return 0, ErrHandshakeUnsupported
+		}
+		return 0, fmt.Errorf("failed to read handshake: %w", err)
+	}
+	version, ok := parseHandshake(line)
+	if !ok {
+		return 0, fmt.Errorf("%w: unexpected reply %q", ErrHandshakeUnsupported, line)
+	}
+
+	// A newer server still speaks our version
+	if version > Version {
+		version = Version
+	}
+	return version, nil
+}
+
+// abandonPadding is longer than anything a server peeks at while looking for
+// directives
+const abandonPadding = 4096
+
+// Abandon discards a connection whose handshake went unanswered. Older
+// servers treat everything they receive as the payload and copy it once the
+// connection closes cleanly. Padding the request past what they peek at
+// while looking for directives means the reset that follows surfaces as a
+// read error, which they report instead of copying.
+func Abandon(conn net.Conn) error {
+	padding := bytes.Repeat([]byte{'\n'}, abandonPadding)
+	if _, err := conn.Write(padding); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to pad abandoned request: %w", err)
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if err := tcpConn.SetLinger(0); err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to reset connection: %w", err)
+		}
+	}
+	return conn.Close()
+}
+
+// parseHandshake returns the version in a handshake line without its newline
+func parseHandshake(line string) (int, bool) {
+	if !strings.HasPrefix(line, HandshakePrefix) {
+		return 0, false
+	}
+	version, err := strconv.Atoi(line[len(HandshakePrefix):])
+	if err != nil || version < 1 {
+		return 0, false
+	}
+	return version, true
+}
+
+// FollowPreamble is sent by clients before a stream of frames, each of which
+// is a separate clipboard update. Connections without it carry a single raw
+// payload terminated by EOF.
+const FollowPreamble = "WARPCLIP-FOLLOW\n"
+
+// SessionPreamble is sent by clients that want to make several copies over
+// one connection. Like follow mode each copy is a frame, but the server
+// acknowledges every frame before reading the next.
+const SessionPreamble = "WARPCLIP-SESSION\n"
+
+// ChunkedPreamble is sent by clients that split a single payload into
+// chunks, each carrying a checksum and acknowledged before the next is sent,
+// so a corrupted or unacknowledged chunk is sent again rather than failing
+// the whole copy. An empty chunk ends the payload; the server then copies it
+// and replies with an Ack as for any other copy.
+const ChunkedPreamble = "WARPCLIP-CHUNKED\n"
+
+// VerifyDirective asks the server to include a SHA-256 of the received
+// payload in its acknowledgement
+const VerifyDirective = "WARPCLIP-VERIFY\n"
+
+// StatusDirective asks the server to reply with its runtime counters as a
+// Status instead of copying anything
+const StatusDirective = "WARPCLIP-STATUS\n"
+
+// PasteDirective asks the server to send the clipboard contents back instead
+// of copying anything. It may be followed by an Accept line. After
+// VerifyDirective, only the acknowledgement is sent, with the SHA-256 of the
+// contents.
+const PasteDirective = "WARPCLIP-PASTE\n"
+
+// ClearDirective asks the server to empty the clipboard. Nothing follows it;
+// an empty payload without it is still refused as a mistake.
+const ClearDirective = "WARPCLIP-CLEAR\n"
+
+// CapsDirective asks the server to reply with what it supports as
+// Capabilities instead of copying anything
+const CapsDirective = "WARPCLIP-CAPS\n"
+
+// AcceptHeader starts an "Accept: TYPE, TYPE" line listing the MIME types a
+// paste request wants, most preferred first
+const AcceptHeader = "Accept: "
+
+// WriteAccept writes an Accept line for the given MIME types
+func WriteAccept(w io.Writer, types []string) error {
+	_, err := fmt.Fprintf(w, "%s%s\n", AcceptHeader, strings.Join(types, ", "))
+	return err
+}
+
+// ContentLengthHeader starts a "Content-Length: N" line announcing the exact
+// size of the payload that follows, so the server can detect a short write
+const ContentLengthHeader = "Content-Length: "
+
+// WriteContentLength writes a Content-Length line for a payload of size bytes
+func WriteContentLength(w io.Writer, size int64) error {
+	_, err := fmt.Fprintf(w, "%s%d\n", ContentLengthHeader, size)
+	return err
+}
+
+// ClearAfterHeader starts a "Clear-After: DURATION" line asking the server
+// to clear the clipboard that long after copying the payload, unless another
+// copy replaces it first
+const ClearAfterHeader = "Clear-After: "
+
+// WriteClearAfter writes a Clear-After line for a clear after d
+func WriteClearAfter(w io.Writer, d time.Duration) error {
+	_, err := fmt.Fprintf(w, "%s%s\n", ClearAfterHeader, d)
+	return err
+}
+
+// HistoryTTLHeader starts a "History-TTL: DURATION" line asking the server
+// to purge the copy from its history that long after making it
+const HistoryTTLHeader = "History-TTL: "
+
+// WriteHistoryTTL writes a History-TTL line for a time to live of d
+func WriteHistoryTTL(w io.Writer, d time.Duration) error {
+	_, err := fmt.Fprintf(w, "%s%s\n", HistoryTTLHeader, d)
+	return err
+}
+
+// ContentTypeHeader starts a "Content-Type: TYPE" line giving the MIME type
+// to put the payload on the clipboard as, instead of plain text
+const ContentTypeHeader = "Content-Type: "
+
+// WriteContentType writes a Content-Type line for mimeType
+func WriteContentType(w io.Writer, mimeType string) error {
+	_, err := fmt.Fprintf(w, "%s%s\n", ContentTypeHeader, mimeType)
+	return err
+}
+
+// maxHeaderLength bounds the length line of a frame
+const maxHeaderLength = 20
+
+// ErrFrameTooLarge is returned when a frame exceeds the permitted size
+var ErrFrameTooLarge = errors.New("frame exceeds maximum size")
+
+// ErrPasteUnsupported is returned by ReadPaste when the server treated the
+// paste request as a payload, as servers predating it do
+var ErrPasteUnsupported = errors.New("server does not support paste requests")
+
+// ErrStatusUnsupported is returned by ReadStatus when the server treated the
+// status request as a payload, as servers predating it do
+var ErrStatusUnsupported = errors.New("server does not support status requests")
+
+// ErrCapsUnsupported is returned by ReadCapabilities when the server
+// answered the request with an acknowledgement instead
+var ErrCapsUnsupported = errors.New("server does not support capabilities requests")
+
+// WriteFrame writes data as a length-prefixed frame: the decimal byte count,
+// a newline, then the data itself
+func WriteFrame(w io.Writer, data []byte) error {
+	if _, err := fmt.Fprintf(w, "%d\n", len(data)); err != nil {
+		return fmt.Errorf("failed to write frame header: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to write frame data: %w", err)
+	}
+	return nil
+}
+
+// ReadFrame reads a single length-prefixed frame. It returns io.EOF when the
+// stream ends cleanly between frames and ErrFrameTooLarge when the announced
+// length exceeds maxSize.
+func ReadFrame(r *bufio.Reader, maxSize int64) ([]byte, error) {
+	header, err := readLine(r)
+	if err != nil {
+		if err == io.EOF && header == "" {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("failed to read frame header: %w", err)
+	}
+
+	size, err := strconv.ParseInt(header, 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("invalid frame header %q", header)
+	}
+	if size > maxSize {
+		return nil, fmt.Errorf("%w: %d > %d bytes", ErrFrameTooLarge, size, maxSize)
+	}
+
+	data := make([]byte, size)
+	if n, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("frame truncated after %d of %d bytes: %w", n, size, err)
+	}
+	return data, nil
+}
+
+// readLine reads a newline-terminated line of bounded length
+func readLine(r *bufio.Reader) (string, error) {
+	return readBoundedLine(r, maxHeaderLength)
+}
+
+// Error categories let clients suggest a fix for common failures
+const (
+	// CategoryNoSession means the clipboard program couldn't reach a
+	// graphical session, typically because the daemon runs outside it
+	CategoryNoSession = "no-gui-session"
+	// CategoryBackendMissing means the clipboard program isn't installed
+	CategoryBackendMissing = "backend-missing"
+	// CategoryTimeout means the clipboard program didn't finish in time
+	CategoryTimeout = "timeout"
+	// CategoryTooLarge means the payload exceeds the server's size limit
+	CategoryTooLarge = "too-large"
+	// CategoryClientTooOld means the server requires a newer protocol
+	// version than the client speaks
+	CategoryClientTooOld = "client-too-old"
+)
+
+// Ack is the status line the server sends after processing a payload
+type Ack struct {
+	// OK reports whether the payload reached the clipboard
+	OK bool
+	// Bytes is the number of bytes received by the server
+	Bytes int64
+	// SHA256 is the hex digest of the received payload, when requested
+	SHA256 string
+	// Backend is the name of the clipboard backend that performed the copy
+	Backend string
+	// Type is the MIME type of the content that follows a paste reply
+	Type string
+	// Types lists the MIME types the clipboard held when pasting
+	Types []string
+	// Error describes why the copy failed when OK is false
+	Error string
+	// Category classifies the failure, when the server recognized it
+	Category string
+	// ClearAt is when the server will clear the clipboard, when the copy
+	// asked for that
+	ClearAt time.Time
+}
+
+// WriteAck writes ack as a single line: "OK bytes=N [backend=NAME]
+// [sha256=HEX] [type=MIME types=MIME,MIME] [clear_at=RFC3339]" on success or "ERR [CATEGORY]
+// message" on failure
+func WriteAck(w io.Writer, ack Ack) error {
+	var line string
+	if ack.OK {
+		line = fmt.Sprintf("OK bytes=%d", ack.Bytes)
+		if ack.Backend != "" {
+			line += " backend=" + ack.Backend
+		}
+		if ack.SHA256 != "" {
+			line += " sha256=" + ack.SHA256
+		}
+		if ack.Type != "" {
+			line += " type=" + ack.Type
+		}
+		if len(ack.Types) > 0 {
+			line += " types=" + strings.Join(ack.Types, ",")
+		}
+		if !ack.ClearAt.IsZero() {
+			line += " clear_at=" + ack.ClearAt.Format(time.RFC3339)
+		}
+		line += "\n"
+	} else {
+		message := strings.ReplaceAll(ack.Error, "\n", " ")
+		if ack.Category != "" {
+			message = "[" + ack.Category + "] " + message
+		}
+		line = fmt.Sprintf("ERR %s\n", message)
+	}
+	_, err := io.WriteString(w, line)
+	return err
+}
+
+// ReadAck reads a status line written by WriteAck. It returns io.EOF if the
+// server closed the connection without acknowledging, as servers predating
+// acknowledgements do. Unknown fields are ignored so the line can grow.
+func ReadAck(r *bufio.Reader) (Ack, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line == "" {
+			return Ack{}, io.EOF
+		}
+		return Ack{}, fmt.Errorf("failed to read acknowledgement: %w", err)
+	}
+	return parseAck(strings.TrimRight(line, "\r\n"))
+}
+
+// parseAck parses a status line without its newline
+func parseAck(line string) (Ack, error) {
+	status, rest, _ := strings.Cut(line, " ")
+	switch status {
+	case "OK":
+		ack := Ack{OK: true}
+		for _, field := range strings.Fields(rest) {
+			key, value, _ := strings.Cut(field, "=")
+			switch key {
+			case "bytes":
+				ack.Bytes, _ = strconv.ParseInt(value, 10, 64)
+			case "sha256":
+				ack.SHA256 = value
+			case "backend":
+				ack.Backend = value
+			case "type":
+				ack.Type = value
+			case "types":
+				ack.Types = strings.Split(value, ",")
+			case "clear_at":
+				ack.ClearAt, _ = time.Parse(time.RFC3339, value)
+			}
+		}
+		return ack, nil
+	case "ERR":
+		ack := Ack{Error: rest}
+		if strings.HasPrefix(rest, "[") {
+			if category, message, found := strings.Cut(rest[1:], "] "); found && !strings.Contains(category, " ") {
+				ack.Category, ack.Error = category, message
+			}
+		}
+		return ack, nil
+	default:
+		return Ack{}, fmt.Errorf("invalid acknowledgement %q", line)
+	}
+}
+
+// ReadPaste reads the reply to a paste request: an acknowledgement naming
+// the type of the content, followed by the content itself. A failed paste
+// returns the acknowledgement with no data.
+func ReadPaste(r *bufio.Reader) (Ack, []byte, error) {
+	ack, err := ReadAck(r)
+	if err != nil {
+		return ack, nil, err
+	}
+	if !ack.OK {
+		return ack, nil, nil
+	}
+
+	// Older servers copy the request to the clipboard and acknowledge it
+	// without saying what type they are returning
+	if ack.Type == "" {
+		return ack, nil, ErrPasteUnsupported
+	}
+
+	// Grow the buffer as data arrives rather than trusting the announced size
+	var buf bytes.Buffer
+	if n, err := io.CopyN(&buf, r, ack.Bytes); err != nil {
+		return ack, nil, fmt.Errorf("paste truncated after %d of %d bytes: %w", n, ack.Bytes, err)
+	}
+	return ack, buf.Bytes(), nil
+}
+
+// Status holds a server's runtime counters
+type Status struct {
+	// Accepted is the number of connections accepted since startup
+	Accepted uint64 `json:"accepted"`
+	// Dropped is the number of accepted connections closed without being
+	// handled, such as those still queued at shutdown
+	Dropped uint64 `json:"dropped"`
+	// Queued is the number of accepted connections waiting to be handled
+	Queued int `json:"queued"`
+	// Active is the number of connections being handled, not counting the
+	// one carrying the status request
+	Active int `json:"active"`
+	// Copies is the number of clipboard updates since startup
+	Copies uint64 `json:"copies"`
+	// Bytes is the total size of those updates
+	Bytes uint64 `json:"bytes"`
+	// LastCopy is the time of the latest update, zero before the first
+	LastCopy time.Time `json:"last_copy"`
+	// ClearAt is when a copy made with --clear-after will be cleared, zero
+	// while none is pending
+	ClearAt time.Time `json:"clear_at"`
+	// BackendFailures is the number of consecutive failed copies
+	BackendFailures int `json:"backend_failures,omitempty"`
+	// BackendUnhealthy is set while those failures have stopped the server
+	// from attempting copies
+	BackendUnhealthy bool `json:"backend_unhealthy,omitempty"`
+	// BackendError is the most recent failure, while there are any
+	BackendError string `json:"backend_error,omitempty"`
+	// BackendCategory classifies that failure like an Ack's Category, such
+	// as CategoryNoSession when the daemon runs outside the desktop
+	BackendCategory string `json:"backend_category,omitempty"`
+	// CopyLatency summarizes how long the latest clipboard writes took,
+	// absent before the first
+	CopyLatency *Latency `json:"copy_latency,omitempty"`
+	// Hostname is the name of the machine the daemon runs on, so a client
+	// can tell whether its tunnel leads where it should. Older servers
+	// leave it empty.
+	Hostname string `json:"hostname,omitempty"`
+}
+
+// Latency summarizes the durations of recent clipboard writes
+type Latency struct {
+	// Samples is how many writes the summary covers
+	Samples int `json:"samples"`
+	// AvgMS is their mean duration in milliseconds
+	AvgMS float64 `json:"avg_ms"`
+	// P50MS and P95MS are the median and 95th percentile durations
+	P50MS float64 `json:"p50_ms"`
+	P95MS float64 `json:"p95_ms"`
+	// MaxMS is the longest duration
+	MaxMS float64 `json:"max_ms"`
+}
+
+// WriteStatus writes status as a single line of JSON
+func WriteStatus(w io.Writer, status Status) error {
+	return json.NewEncoder(w).Encode(status)
+}
+
+// ReadStatus reads a status line written by WriteStatus
+func ReadStatus(r *bufio.Reader) (Status, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && !(err == io.EOF && line != "") {
+		return Status{}, fmt.Errorf("failed to read status: %w", err)
+	}
+
+	// Older servers copy the request to the clipboard and acknowledge it
+	if strings.HasPrefix(line, "OK") || strings.HasPrefix(line, "ERR") {
+		return Status{}, ErrStatusUnsupported
+	}
+
+	var status Status
+	if err := json.Unmarshal([]byte(line), &status); err != nil {
+		return Status{}, fmt.Errorf("invalid status %q: %w", strings.TrimSpace(line), err)
+	}
+	return status, nil
+}
+
+// Features that depend on the protocol version, each with the first version
+// whose servers support it
+var versionFeatures = []struct {
+	version int
+	name    string
+}{
+	{ClearVersion, "clear"},
+	{ChunkedVersion, "chunked"},
+	{HistoryTTLVersion, "history-ttl"},
+	{ClipboardHashVersion, "clipboard-hash"},
+	{ContentTypeVersion, "content-type"},
+	{CapsVersion, "caps"},
+}
+
+// Features lists the optional protocol features a server speaking version
+// supports, oldest first
+func Features(version int) []string {
+	features := []string{}
+	for _, f := range versionFeatures {
+		if version >= f.version {
+			features = append(features, f.name)
+		}
+	}
+	return features
+}
+
+// Capabilities describes what a server supports, so users can tell which
+// features work across mixed client and server versions
+type Capabilities struct {
+	// Version is the protocol version the server speaks
+	Version int `json:"version"`
+	// Features lists the optional protocol features it supports, as
+	// returned by Features
+	Features []string `json:"features"`
+	// Backend is the name of its clipboard backend. Servers predating
+	// CapsVersion don't report this or anything below.
+	Backend string `json:"backend,omitempty"`
+	// WriteOnly is set when the backend can't read the clipboard, so paste
+	// requests fail
+	WriteOnly bool `json:"write_only,omitempty"`
+	// CopyTypes lists the MIME types it can copy. AnyType means all.
+	CopyTypes []string `json:"copy_types,omitempty"`
+	// MaxDataSize is the largest payload it accepts, in bytes
+	MaxDataSize int64 `json:"max_data_size,omitempty"`
+	// MinClientVersion is the oldest client protocol version it accepts,
+	// zero for any
+	MinClientVersion int `json:"min_client_version,omitempty"`
+}
+
+// WriteCapabilities writes caps as a single line of JSON
+func WriteCapabilities(w io.Writer, caps Capabilities) error {
+	return json.NewEncoder(w).Encode(caps)
+}
+
+// ReadCapabilities reads a line written by WriteCapabilities
+func ReadCapabilities(r *bufio.Reader) (Capabilities, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && !(err == io.EOF && line != "") {
+		return Capabilities{}, fmt.Errorf("failed to read capabilities: %w", err)
+	}
+
+	// A server refusing the client answers with an acknowledgement
+	if strings.HasPrefix(line, "OK") || strings.HasPrefix(line, "ERR") {
+		return Capabilities{}, fmt.Errorf("%w: %s", ErrCapsUnsupported, strings.TrimSpace(line))
+	}
+
+	var caps Capabilities
+	if err := json.Unmarshal([]byte(line), &caps); err != nil {
+		return Capabilities{}, fmt.Errorf("invalid capabilities %q: %w", strings.TrimSpace(line), err)
+	}
+	return caps, nil
+}
@@ -0,0 +1,342 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	records := []string{"first", "", "third record\nwith newline"}
+
+	var buf bytes.Buffer
+	for _, record := range records {
+		if err := WriteFrame(&buf, []byte(record)); err != nil {
+			t.Fatalf("WriteFrame failed: %v", err)
+		}
+	}
+
+	reader := bufio.NewReader(&buf)
+	for _, want := range records {
+		data, err := ReadFrame(reader, 1024)
+		if err != nil {
+			t.Fatalf("ReadFrame failed: %v", err)
+		}
+		if string(data) != want {
+			t.Errorf("ReadFrame returned %q, want %q", data, want)
+		}
+	}
+
+	if _, err := ReadFrame(reader, 1024); err != io.EOF {
+		t.Errorf("Expected io.EOF after last frame, got %v", err)
+	}
+}
+
+func TestReadFrameErrors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+	}{
+		{name: "truncated data", input: "10\nshort"},
+		{name: "invalid header", input: "abc\ndata"},
+		{name: "negative size", input: "-1\n"},
+		{name: "missing newline", input: "5"},
+		{name: "oversized header", input: strings.Repeat("9", 64)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ReadFrame(bufio.NewReader(strings.NewReader(tc.input)), 1024)
+			if err == nil || err == io.EOF {
+				t.Errorf("Expected error for %q, got %v", tc.input, err)
+			}
+		})
+	}
+
+	_, err := ReadFrame(bufio.NewReader(strings.NewReader("2048\n")), 1024)
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("Expected ErrFrameTooLarge, got %v", err)
+	}
+}
+
+func TestChunkRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	WriteChunk(&buf, 1, []byte("first"))
+	WriteChunk(&buf, 2, []byte("second"))
+	WriteChunk(&buf, 3, nil)
+	// Flip a bit in the data of the second chunk
+	corrupt := bytes.Replace(buf.Bytes(), []byte("second"), []byte("sebond"), 1)
+
+	reader := bufio.NewReader(bytes.NewReader(corrupt))
+	testCases := []struct {
+		seq     int
+		data    string
+		wantErr error
+	}{
+		{seq: 1, data: "first"},
+		{seq: 2, wantErr: ErrChunkCorrupt},
+		{seq: 3, data: ""},
+	}
+	for _, tc := range testCases {
+		seq, data, err := ReadChunk(reader, 1024)
+		if seq != tc.seq || string(data) != tc.data || !errors.Is(err, tc.wantErr) {
+			t.Errorf("ReadChunk returned %d, %q, %v; want %d, %q, %v", seq, data, err, tc.seq, tc.data, tc.wantErr)
+		}
+	}
+
+	// A chunk over the limit is refused before its data is read
+	buf.Reset()
+	WriteChunk(&buf, 1, []byte("too long"))
+	if _, _, err := ReadChunk(bufio.NewReader(&buf), 4); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("Expected ErrFrameTooLarge, got %v", err)
+	}
+	if _, _, err := ReadChunk(bufio.NewReader(strings.NewReader("1 x 0\n")), 1024); err == nil {
+		t.Error("Expected an error for an invalid chunk header")
+	}
+}
+
+func TestAckRoundTrip(t *testing.T) {
+	testCases := []struct {
+		name string
+		ack  Ack
+		line string
+	}{
+		{name: "success", ack: Ack{OK: true, Bytes: 42}, line: "OK bytes=42\n"},
+		{name: "checksum", ack: Ack{OK: true, Bytes: 5, SHA256: "abc123"}, line: "OK bytes=5 sha256=abc123\n"},
+		{name: "backend", ack: Ack{OK: true, Bytes: 5, Backend: "pbcopy", SHA256: "abc123"}, line: "OK bytes=5 backend=pbcopy sha256=abc123\n"},
+		{name: "paste", ack: Ack{OK: true, Bytes: 8, Type: "image/png", Types: []string{"image/png", "text/plain"}}, line: "OK bytes=8 type=image/png types=image/png,text/plain\n"},
+		{name: "clear at", ack: Ack{OK: true, Bytes: 6, ClearAt: time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)}, line: "OK bytes=6 clear_at=2024-05-01T09:30:00Z\n"},
+		{name: "failure", ack: Ack{Error: "clipboard failed"}, line: "ERR clipboard failed\n"},
+		{name: "categorized failure", ack: Ack{Error: "xclip command failed", Category: CategoryNoSession}, line: "ERR [no-gui-session] xclip command failed\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteAck(&buf, tc.ack); err != nil {
+				t.Fatalf("WriteAck failed: %v", err)
+			}
+			if buf.String() != tc.line {
+				t.Errorf("WriteAck wrote %q, want %q", buf.String(), tc.line)
+			}
+
+			ack, err := ReadAck(bufio.NewReader(&buf))
+			if err != nil {
+				t.Fatalf("ReadAck failed: %v", err)
+			}
+			if !reflect.DeepEqual(ack, tc.ack) {
+				t.Errorf("ReadAck returned %+v, want %+v", ack, tc.ack)
+			}
+		})
+	}
+
+	// Unknown fields from newer servers are ignored
+	ack, err := ReadAck(bufio.NewReader(strings.NewReader("OK bytes=3 future=yes\n")))
+	if err != nil || !ack.OK || ack.Bytes != 3 {
+		t.Errorf("ReadAck with extra fields returned %+v, %v", ack, err)
+	}
+
+	// Servers without acknowledgements just close the connection
+	if _, err := ReadAck(bufio.NewReader(strings.NewReader(""))); err != io.EOF {
+		t.Errorf("Expected io.EOF for missing ack, got %v", err)
+	}
+
+	if _, err := ReadAck(bufio.NewReader(strings.NewReader("garbage\n"))); err == nil {
+		t.Error("Expected error for invalid ack, got nil")
+	}
+}
+
+func TestReadPaste(t *testing.T) {
+	ack, data, err := ReadPaste(bufio.NewReader(strings.NewReader("OK bytes=5 type=text/html types=text/html,text/plain\n<b/>\nextra")))
+	if err != nil {
+		t.Fatalf("ReadPaste failed: %v", err)
+	}
+	if ack.Type != "text/html" || string(data) != "<b/>\n" {
+		t.Errorf("ReadPaste returned %+v, %q", ack, data)
+	}
+
+	ack, data, err = ReadPaste(bufio.NewReader(strings.NewReader("ERR no image\n")))
+	if err != nil || ack.OK || ack.Error != "no image" || data != nil {
+		t.Errorf("ReadPaste of failure returned %+v, %q, %v", ack, data, err)
+	}
+
+	// Older servers acknowledge the request as if it were a payload
+	if _, _, err := ReadPaste(bufio.NewReader(strings.NewReader("OK bytes=15\n"))); !errors.Is(err, ErrPasteUnsupported) {
+		t.Errorf("Expected ErrPasteUnsupported, got %v", err)
+	}
+
+	if _, _, err := ReadPaste(bufio.NewReader(strings.NewReader("OK bytes=10 type=text/plain\nshort"))); err == nil {
+		t.Error("Expected error for truncated paste, got nil")
+	}
+}
+
+func TestStatusRoundTrip(t *testing.T) {
+	status := Status{Accepted: 7, Dropped: 1, Queued: 2, Active: 3}
+
+	var buf bytes.Buffer
+	if err := WriteStatus(&buf, status); err != nil {
+		t.Fatalf("WriteStatus failed: %v", err)
+	}
+	got, err := ReadStatus(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("ReadStatus failed: %v", err)
+	}
+	if got != status {
+		t.Errorf("ReadStatus returned %+v, want %+v", got, status)
+	}
+
+	// Older servers acknowledge the request as if it were a payload
+	if _, err := ReadStatus(bufio.NewReader(strings.NewReader("OK bytes=16\n"))); !errors.Is(err, ErrStatusUnsupported) {
+		t.Errorf("Expected ErrStatusUnsupported, got %v", err)
+	}
+
+	if _, err := ReadStatus(bufio.NewReader(strings.NewReader(""))); err == nil {
+		t.Error("Expected error for missing status, got nil")
+	}
+}
+
+func TestCapabilitiesRoundTrip(t *testing.T) {
+	caps := Capabilities{
+		Version:     Version,
+		Features:    Features(Version),
+		Backend:     "xclip",
+		CopyTypes:   []string{"text/plain", "image/png"},
+		MaxDataSize: 1048576,
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCapabilities(&buf, caps); err != nil {
+		t.Fatalf("WriteCapabilities failed: %v", err)
+	}
+	got, err := ReadCapabilities(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("ReadCapabilities failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, caps) {
+		t.Errorf("ReadCapabilities returned %+v, want %+v", got, caps)
+	}
+
+	if _, err := ReadCapabilities(bufio.NewReader(strings.NewReader("ERR [client-too-old] upgrade\n"))); !errors.Is(err, ErrCapsUnsupported) {
+		t.Errorf("Expected ErrCapsUnsupported, got %v", err)
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	if got := Features(LegacyVersion); len(got) != 0 {
+		t.Errorf("Features(%d) = %v, want none", LegacyVersion, got)
+	}
+	if got, want := Features(ClearVersion), []string{"clear"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Features(%d) = %v, want %v", ClearVersion, got, want)
+	}
+	got := Features(Version)
+	if got[len(got)-1] != "caps" {
+		t.Errorf("Features(%d) = %v, want caps last", Version, got)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	testCases := []struct {
+		name    string
+		reply   string
+		version int
+		wantErr error
+	}{
+		{name: "same version", reply: "WARPCLIP/8\n", version: 8},
+		{name: "newer server", reply: "WARPCLIP/9\n", version: Version},
+		{name: "older server", reply: "WARPCLIP/7\n", version: 7},
+		{name: "oldest server", reply: "WARPCLIP/1\n", version: 1},
+		{name: "unexpected reply", reply: "OK bytes=11\n", wantErr: ErrHandshakeUnsupported},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			received := make(chan string, 1)
+			go func() {
+				line, _ := bufio.NewReader(server).ReadString('\n')
+				received <- line
+				server.Write([]byte(tc.reply))
+			}()
+
+			version, err := Handshake(client, bufio.NewReader(client), time.Second)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("Expected %v, got %v", tc.wantErr, err)
+				}
+			} else if err != nil || version != tc.version {
+				t.Errorf("Handshake returned %d, %v; want %d", version, err, tc.version)
+			}
+			if line := <-received; line != "WARPCLIP/8\n" {
+				t.Errorf("Server received %q, want the handshake line", line)
+			}
+		})
+	}
+}
+
+// legacyServer handles connections the way servers predating the handshake
+// do: it peeks for directives, then reads the payload until EOF and reports
+// it as copied only if the read ended cleanly
+func legacyServer(t *testing.T) (string, <-chan []byte) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	copied := make(chan []byte, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			reader.Peek(len(ContentLengthHeader) + 20)
+			data, err := io.ReadAll(reader)
+			if err != nil {
+				data = nil
+			}
+			copied <- data
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String(), copied
+}
+
+func TestHandshakeLegacyServer(t *testing.T) {
+	address, copied := legacyServer(t)
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	if _, err := Handshake(conn, bufio.NewReader(conn), 100*time.Millisecond); !errors.Is(err, ErrHandshakeUnsupported) {
+		t.Fatalf("Expected ErrHandshakeUnsupported, got %v", err)
+	}
+	if err := Abandon(conn); err != nil {
+		t.Fatalf("Abandon failed: %v", err)
+	}
+	if data := <-copied; data != nil {
+		t.Errorf("Legacy server copied the abandoned handshake: %q", data)
+	}
+
+	// The fallback connection speaks the legacy protocol
+	conn, err = net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	conn.Write([]byte("payload"))
+	conn.Close()
+	if data := <-copied; string(data) != "payload" {
+		t.Errorf("Legacy server copied %q, want %q", data, "payload")
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// errBackendUnhealthy is reported for copies refused while the breaker is open
+var errBackendUnhealthy = errors.New("clipboard backend unhealthy")
+
+// breaker stops copies to a clipboard backend that keeps failing, such as
+// when the desktop session is locked, so they fail fast rather than each
+// retrying a command that can't succeed. Once the cooldown has passed, one
+// copy is let through as a probe; if it succeeds the breaker closes again.
+type breaker struct {
+	// threshold is the number of consecutive failures that opens the
+	// breaker; zero or less never does
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	lastErr  error
+	// openedAt is when the breaker last opened, zero while it is closed
+	openedAt time.Time
+	// probing is set while a copy let through after the cooldown is running
+	probing bool
+}
+
+// newBreaker creates a closed breaker
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow returns nil if a copy may be attempted, or the error to fail it with
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return nil
+	}
+	wait := b.cooldown - time.Since(b.openedAt)
+	if wait <= 0 && !b.probing {
+		b.probing = true
+		return nil
+	}
+	if wait < 0 {
+		return fmt.Errorf("%w after %d consecutive failures, checking it now: %w", errBackendUnhealthy, b.failures, b.lastErr)
+	}
+	return fmt.Errorf("%w after %d consecutive failures, retrying in %v: %w",
+		errBackendUnhealthy, b.failures, wait.Round(time.Second), b.lastErr)
+}
+
+// record notes the outcome of an allowed copy. It reports whether the copy
+// opened the breaker or closed it again.
+func (b *breaker) record(err error) (opened, closed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasOpen := !b.openedAt.IsZero()
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		b.lastErr = nil
+		b.openedAt = time.Time{}
+		return false, wasOpen
+	}
+
+	b.failures++
+	b.lastErr = err
+	if b.threshold > 0 && b.failures >= b.threshold {
+		// A failed probe starts another cooldown
+		b.openedAt = time.Now()
+		return !wasOpen, false
+	}
+	return false, false
+}
+
+// state returns the number of consecutive failures, whether they opened the
+// breaker, and the most recent of them
+func (b *breaker) state() (failures int, open bool, lastErr error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures, !b.openedAt.IsZero(), b.lastErr
+}
@@ -0,0 +1,47 @@
+package server
+
+import "sync"
+
+// memoryBudget caps the payload bytes held across all connections at once,
+// so a burst of large concurrent copies is turned away rather than running
+// the daemon out of memory. Each connection reserves what it may hold before
+// reading and releases it once its data has been delivered.
+type memoryBudget struct {
+	// limit is the most that may be reserved at once, zero for no limit
+	limit int64
+
+	mu       sync.Mutex
+	reserved int64
+}
+
+// newMemoryBudget creates a memoryBudget of limit bytes
+func newMemoryBudget(limit int64) *memoryBudget {
+	return &memoryBudget{limit: limit}
+}
+
+// reserve takes n bytes from the budget, reporting false without taking any
+// when fewer than n are left
+func (b *memoryBudget) reserve(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.limit > 0 && b.reserved+n > b.limit {
+		return false
+	}
+	b.reserved += n
+	return true
+}
+
+// release returns n reserved bytes to the budget
+func (b *memoryBudget) release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reserved -= n
+}
+
+// inUse returns how many bytes are reserved
+func (b *memoryBudget) inUse() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.reserved
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mquinnv/warpclip/v2/internal/clipboard"
+	"github.com/mquinnv/warpclip/v2/internal/protocol"
+)
+
+// Capabilities returns what the server supports: its protocol features and
+// what its clipboard backend and configuration allow
+func (s *Server) Capabilities() protocol.Capabilities {
+	return protocol.Capabilities{
+		Version:          protocol.Version,
+		Features:         protocol.Features(protocol.Version),
+		Backend:          s.backend.Name(),
+		WriteOnly:        !clipboard.CanPaste(s.backend),
+		CopyTypes:        clipboard.CopyTypes(s.backend),
+		MaxDataSize:      s.cfg.MaxDataSize,
+		MinClientVersion: s.cfg.MinClientVersion,
+	}
+}
+
+// sendCapabilities replies to a capabilities request
+func (s *Server) sendCapabilities(conn net.Conn) {
+	if err := conn.SetWriteDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		s.logger.Debug(fmt.Sprintf("Failed to set write deadline: %v", err))
+		return
+	}
+	if err := protocol.WriteCapabilities(conn, s.Capabilities()); err != nil {
+		s.logger.Warning(fmt.Sprintf("Failed to send capabilities to %s: %v", conn.RemoteAddr(), err))
+	}
+}
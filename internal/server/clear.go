@@ -0,0 +1,82 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// autoClear clears the clipboard a while after a copy made with
+// --clear-after, so a pasted secret doesn't linger. Only one clear is ever
+// pending: any later copy replaces what it would have cleared, so it cancels
+// the clear, and a later --clear-after copy schedules its own.
+type autoClear struct {
+	// clear empties the clipboard
+	clear func()
+
+	mu    sync.Mutex
+	timer *time.Timer
+	// at is when the pending clear runs, zero while none is
+	at time.Time
+}
+
+// newAutoClear creates an autoClear that empties the clipboard with clear
+func newAutoClear(clear func()) *autoClear {
+	return &autoClear{clear: clear}
+}
+
+// schedule replaces any pending clear with one after d and returns when it
+// will run
+func (a *autoClear) schedule(d time.Duration) time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		// A timer that fired just as it was replaced must not run
+		a.mu.Lock()
+		if a.timer != timer {
+			a.mu.Unlock()
+			return
+		}
+		a.timer = nil
+		a.at = time.Time{}
+		a.mu.Unlock()
+		a.clear()
+	})
+	a.timer = timer
+	a.at = time.Now().Add(d)
+	return a.at
+}
+
+// cancel drops the pending clear, reporting whether there was one
+func (a *autoClear) cancel() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.timer == nil {
+		return false
+	}
+	a.timer.Stop()
+	a.timer = nil
+	a.at = time.Time{}
+	return true
+}
+
+// flush runs the pending clear straight away, reporting whether there was one
+func (a *autoClear) flush() bool {
+	if !a.cancel() {
+		return false
+	}
+	a.clear()
+	return true
+}
+
+// pending returns when the pending clear runs, or zero if none is
+func (a *autoClear) pending() time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.at
+}
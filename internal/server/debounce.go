@@ -0,0 +1,73 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// debouncer coalesces copies that arrive close together, so a script copying
+// in a loop updates the clipboard once with its last value rather than
+// hammering a pasteboard that may then hand out a stale one. The window opens
+// with the first copy and isn't extended by later ones, so a steady stream
+// still reaches the clipboard every window.
+type debouncer struct {
+	window time.Duration
+	// copy writes the latest data of a batch of count copies to the clipboard
+	copy func(data []byte, source string, ttl time.Duration, count int) error
+
+	// flushMu keeps batches reaching the clipboard in the order they arrived
+	flushMu sync.Mutex
+
+	mu      sync.Mutex
+	pending *debounceBatch
+}
+
+// debounceBatch is the copies coalesced within one window
+type debounceBatch struct {
+	data   []byte
+	source string
+	ttl    time.Duration
+	count  int
+	err    error
+	done   chan struct{}
+}
+
+// newDebouncer creates a debouncer that passes batches to copy
+func newDebouncer(window time.Duration, copy func(data []byte, source string, ttl time.Duration, count int) error) *debouncer {
+	return &debouncer{window: window, copy: copy}
+}
+
+// submit adds data to the current batch, opening one if needed, and waits
+// until the batch has been copied. Every copy in a batch gets its result.
+func (d *debouncer) submit(data []byte, source string, ttl time.Duration) error {
+	d.mu.Lock()
+	b := d.pending
+	if b == nil {
+		b = &debounceBatch{done: make(chan struct{})}
+		d.pending = b
+		time.AfterFunc(d.window, d.flush)
+	}
+	b.data = data
+	b.source = source
+	b.ttl = ttl
+	b.count++
+	d.mu.Unlock()
+
+	<-b.done
+	return b.err
+}
+
+// flush copies the pending batch. A batch still waiting for an earlier, slow
+// copy to finish keeps taking newer data until its turn comes.
+func (d *debouncer) flush() {
+	d.flushMu.Lock()
+	defer d.flushMu.Unlock()
+
+	d.mu.Lock()
+	b := d.pending
+	d.pending = nil
+	d.mu.Unlock()
+
+	b.err = d.copy(b.data, b.source, b.ttl, b.count)
+	close(b.done)
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mquinnv/warpclip/v2/internal/protocol"
+)
+
+// latencySamples is how many of the latest clipboard writes the latency
+// summary covers
+const latencySamples = 100
+
+// latencyWindow keeps the durations of the latest clipboard writes, so a
+// pasteboard that has become slow shows up in the status while an early
+// slow write doesn't skew it forever
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	// next is where the following sample goes once the window is full
+	next int
+}
+
+// newLatencyWindow creates an empty latencyWindow
+func newLatencyWindow() *latencyWindow {
+	return &latencyWindow{samples: make([]time.Duration, 0, latencySamples)}
+}
+
+// record adds the duration of one clipboard write, replacing the oldest
+// once the window is full
+func (w *latencyWindow) record(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.samples) < latencySamples {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % latencySamples
+}
+
+// summary returns the average, percentiles and maximum of the window, or
+// nil before the first write
+func (w *latencyWindow) summary() *protocol.Latency {
+	w.mu.Lock()
+	sorted := append([]time.Duration(nil), w.samples...)
+	w.mu.Unlock()
+	if len(sorted) == 0 {
+		return nil
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	// Nearest-rank percentiles: the smallest sample at least p% of them
+	// don't exceed
+	percentile := func(p int) time.Duration {
+		rank := (p*len(sorted) + 99) / 100
+		return sorted[rank-1]
+	}
+	return &protocol.Latency{
+		Samples: len(sorted),
+		AvgMS:   milliseconds(total / time.Duration(len(sorted))),
+		P50MS:   milliseconds(percentile(50)),
+		P95MS:   milliseconds(percentile(95)),
+		MaxMS:   milliseconds(sorted[len(sorted)-1]),
+	}
+}
+
+// milliseconds returns d in milliseconds, keeping the fraction
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
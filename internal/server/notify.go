@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// defaultSuccessSound and defaultFailureSound are macOS system sounds
+	defaultSuccessSound = "Glass"
+	defaultFailureSound = "Basso"
+	// systemSounds is where macOS keeps the sounds named without a path
+	systemSounds = "/System/Library/Sounds"
+)
+
+// notifier gives local feedback for each clipboard write, so a copy made on
+// a remote machine is noticed where it lands: a sound played with afplay, or
+// a notification banner posted with osascript. Commands run through run,
+// which tests replace.
+type notifier struct {
+	// banner posts notifications instead of playing sounds
+	banner bool
+	// success and failure are the sound files played for each outcome
+	success, failure string
+	run              func(name string, args ...string) error
+}
+
+// newNotifier creates a notifier from a WARPCLIP_NOTIFY_SOUND value, or
+// returns nil when spec is empty. "on" (or any true value) plays the default
+// sounds, "notification" posts banners, and anything else names the success
+// sound and optionally, after a comma, the failure sound. A name without a
+// slash is one of the macOS system sounds.
+func newNotifier(spec string) *notifier {
+	n := &notifier{
+		success: defaultSuccessSound,
+		failure: defaultFailureSound,
+		run:     runCommand,
+	}
+	switch strings.ToLower(strings.TrimSpace(spec)) {
+	case "", "0", "false", "off", "no":
+		return nil
+	case "1", "true", "on", "yes":
+	case "notification":
+		n.banner = true
+	default:
+		success, failure, _ := strings.Cut(spec, ",")
+		if success = strings.TrimSpace(success); success != "" {
+			n.success = success
+		}
+		if failure = strings.TrimSpace(failure); failure != "" {
+			n.failure = failure
+		}
+	}
+	n.success = soundPath(n.success)
+	n.failure = soundPath(n.failure)
+	return n
+}
+
+// soundPath returns the file for a sound given by name or path
+func soundPath(sound string) string {
+	if strings.Contains(sound, "/") {
+		return sound
+	}
+	return systemSounds + "/" + sound + ".aiff"
+}
+
+// runCommand runs a notification command and waits for it to finish
+func runCommand(name string, args ...string) error {
+	return exec.Command(name, args...).Run()
+}
+
+// notify gives the feedback for a write of size bytes that failed with err,
+// or succeeded if err is nil
+func (n *notifier) notify(size int, err error) error {
+	if n.banner {
+		script := fmt.Sprintf("display notification %s with title \"WarpClip\"", appleScriptString(fmt.Sprintf("Copied %d bytes", size)))
+		if err != nil {
+			script = fmt.Sprintf("display notification %s with title \"WarpClip copy failed\" sound name \"%s\"", appleScriptString(err.Error()), defaultFailureSound)
+		}
+		return n.run("osascript", "-e", script)
+	}
+	if err != nil {
+		return n.run("afplay", n.failure)
+	}
+	return n.run("afplay", n.success)
+}
+
+// appleScriptString quotes s as an AppleScript string literal
+func appleScriptString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
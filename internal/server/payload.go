@@ -0,0 +1,84 @@
+package server
+
+const (
+	// minSegment and maxSegment bound the pieces a payloadBuffer reads into
+	minSegment = 32 * 1024
+	maxSegment = 4 * 1024 * 1024
+)
+
+// payloadBuffer collects a payload in segments that double in size up to
+// maxSegment and joins them once it is complete. Unlike a bytes.Buffer it
+// never copies what it already holds to grow, which for a payload of
+// hundreds of megabytes means one copy instead of one per doubling, and the
+// joined payload has no spare capacity left pinned for as long as it is held.
+type payloadBuffer struct {
+	segments [][]byte
+	size     int
+}
+
+// newPayloadBuffer creates a payloadBuffer for a payload expected to be
+// about size bytes, or of unknown size if size is negative. An expected size
+// is allocated up front, so a payload of that size is never copied.
+func newPayloadBuffer(size int64) *payloadBuffer {
+	p := &payloadBuffer{}
+	if size > 0 {
+		p.segments = [][]byte{make([]byte, 0, size)}
+	}
+	return p
+}
+
+// Write appends data to the payload
+func (p *payloadBuffer) Write(data []byte) (int, error) {
+	n := len(data)
+	for len(data) > 0 {
+		last := len(p.segments) - 1
+		if last < 0 || len(p.segments[last]) == cap(p.segments[last]) {
+			p.segments = append(p.segments, make([]byte, 0, p.nextSegment()))
+			last++
+		}
+		segment := p.segments[last]
+		copied := copy(segment[len(segment):cap(segment)], data)
+		p.segments[last] = segment[:len(segment)+copied]
+		data = data[copied:]
+	}
+	p.size += n
+	return n, nil
+}
+
+// nextSegment returns the capacity of the segment after the last one
+func (p *payloadBuffer) nextSegment() int {
+	if len(p.segments) == 0 {
+		return minSegment
+	}
+	size := 2 * cap(p.segments[len(p.segments)-1])
+	if size < minSegment {
+		size = minSegment
+	}
+	if size > maxSegment {
+		size = maxSegment
+	}
+	return size
+}
+
+// Len returns the size of the payload so far
+func (p *payloadBuffer) Len() int {
+	return p.size
+}
+
+// Bytes returns the payload. A payload that fit in one segment is returned
+// as it is; otherwise the segments are joined into an exactly sized slice and
+// released.
+func (p *payloadBuffer) Bytes() []byte {
+	switch len(p.segments) {
+	case 0:
+		return nil
+	case 1:
+		return p.segments[0]
+	}
+	joined := make([]byte, 0, p.size)
+	for _, segment := range p.segments {
+		joined = append(joined, segment...)
+	}
+	p.segments = [][]byte{joined}
+	return joined
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// PidInfo is what a running daemon records about itself beside its PID
+// file. The commands that find the daemon read the address from here rather
+// than from their own configuration, which may not match the environment
+// the daemon was started in.
+type PidInfo struct {
+	// PID is the daemon's process ID, the same as in the PID file
+	PID int `json:"pid"`
+	// BindAddress is the address the daemon listens on
+	BindAddress string `json:"bind_address"`
+	// Port is the port the daemon listens on
+	Port int `json:"port"`
+	// Started is when the daemon started listening
+	Started time.Time `json:"started"`
+}
+
+// PidInfoFile returns the path of the sidecar written beside pidFile
+func PidInfoFile(pidFile string) string {
+	return pidFile + ".json"
+}
+
+// ReadPidInfo reads the sidecar beside pidFile. Only a sidecar whose PID
+// matches the PID file's describes the daemon it names.
+func ReadPidInfo(pidFile string) (PidInfo, error) {
+	data, err := os.ReadFile(PidInfoFile(pidFile))
+	if err != nil {
+		return PidInfo{}, err
+	}
+	var info PidInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return PidInfo{}, fmt.Errorf("invalid PID sidecar: %w", err)
+	}
+	return info, nil
+}
+
+// writePidInfo records info in the sidecar beside the PID file, replacing it
+// atomically like the PID file itself
+func (s *Server) writePidInfo(info PidInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to encode PID sidecar: %w", err)
+	}
+
+	path := PidInfoFile(s.cfg.PidFile)
+	tempFile := fmt.Sprintf("%s.%d", path, info.PID)
+	if err := os.WriteFile(tempFile, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write temporary PID sidecar: %w", err)
+	}
+	if err := os.Rename(tempFile, path); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to rename PID sidecar: %w", err)
+	}
+	return nil
+}
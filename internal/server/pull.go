@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/mquinnv/warpclip/v2/internal/config"
+)
+
+// pullTimeout bounds connecting to the pull address and waiting for a client
+// there to start talking. The address is normally a local tunnel endpoint,
+// so either takes a moment at most.
+const pullTimeout = 2 * time.Second
+
+// PullFrom polls addr every WARPCLIP_PULL_INTERVAL for a copy waiting on a
+// remote "warpclip --listen", for setups where a reverse tunnel to the
+// daemon is hard to get but a forward one to the remote machine isn't. A
+// client found waiting is handled as if it had connected to the daemon, so
+// everything after the connection is the same as for a pushed copy. It
+// returns once ctx is done or the server shuts down, after finishing any
+// copy in progress.
+func (s *Server) PullFrom(ctx context.Context, addr string) {
+	interval := s.pullInterval()
+	s.logger.Info(fmt.Sprintf("Pulling copies from %s every %v", addr, interval))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.shutdownSignal:
+			return
+		case <-ticker.C:
+		}
+
+		conn, ok := s.pull(addr)
+		if !ok {
+			continue
+		}
+		s.accepted.Add(1)
+		s.trackConn(1)
+		s.handleConnection(conn)
+		s.trackConn(-1)
+	}
+}
+
+// pull connects to addr and returns the connection if a client there starts
+// talking. A forward tunnel accepts the connection even when nothing listens
+// behind it, then closes it, so a connection that ends or stays silent means
+// no copy is waiting.
+func (s *Server) pull(addr string) (net.Conn, bool) {
+	conn, err := net.DialTimeout("tcp", addr, pullTimeout)
+	if err != nil {
+		s.logger.Debug(fmt.Sprintf("Nothing to pull from %s: %v", addr, err))
+		return nil, false
+	}
+
+	// A waiting client announces itself straight away
+	first := make([]byte, 1)
+	if err := conn.SetReadDeadline(time.Now().Add(pullTimeout)); err != nil {
+		conn.Close()
+		s.logger.Debug(fmt.Sprintf("Failed to set read deadline: %v", err))
+		return nil, false
+	}
+	if _, err := io.ReadFull(conn, first); err != nil {
+		conn.Close()
+		s.logger.Debug(fmt.Sprintf("Nothing to pull from %s: %v", addr, err))
+		return nil, false
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		conn.Close()
+		s.logger.Debug(fmt.Sprintf("Failed to clear read deadline: %v", err))
+		return nil, false
+	}
+	return &pulledConn{Conn: conn, r: io.MultiReader(bytes.NewReader(first), conn)}, true
+}
+
+// pullInterval returns how often to poll the pull address
+func (s *Server) pullInterval() time.Duration {
+	if s.cfg.PullInterval > 0 {
+		return s.cfg.PullInterval
+	}
+	return config.DefaultPullInterval
+}
+
+// pulledConn is a pulled connection with the byte that showed a client was
+// waiting put back in front of the rest
+type pulledConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *pulledConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
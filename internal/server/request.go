@@ -0,0 +1,248 @@
+package server
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mquinnv/warpclip/v2/internal/protocol"
+)
+
+// request describes the options a client announced ahead of its payload
+type request struct {
+	// follow streams framed records instead of a single payload
+	follow bool
+	// session sends framed copies, each acknowledged, instead of a single payload
+	session bool
+	// chunked sends the payload in acknowledged chunks instead of raw
+	chunked bool
+	// verify returns a SHA-256 of the payload in the acknowledgement
+	verify bool
+	// status asks for the server's counters instead of copying
+	status bool
+	// caps asks for what the server supports instead of copying
+	caps bool
+	// paste asks for the clipboard contents instead of copying
+	paste bool
+	// clear asks for the clipboard to be emptied instead of copying
+	clear bool
+	// accept lists the MIME types a paste request wants, most preferred first
+	accept []string
+	// contentLength is the announced payload size, or -1 if not announced
+	contentLength int64
+	// clearAfter is how long after the copy to clear the clipboard, or zero
+	clearAfter time.Duration
+	// historyTTL is how long the copy stays in the history, or zero
+	historyTTL time.Duration
+	// contentType is the MIME type to copy the payload as, or "" for plain text
+	contentType string
+}
+
+// readRequest consumes any protocol directives preceding the payload. Clients
+// that send none get the legacy behavior of a single raw payload. The follow
+// and session preambles and the status, capabilities and clear requests
+// end the directives, as none is followed by a raw payload, and so do the
+// chunked preamble and a paste request after its optional Accept line.
+func readRequest(reader *bufio.Reader) request {
+	req := request{contentLength: -1}
+	for {
+		switch {
+		case consumeLine(reader, protocol.FollowPreamble):
+			req.follow = true
+			return req
+		case consumeLine(reader, protocol.SessionPreamble):
+			req.session = true
+			return req
+		case consumeLine(reader, protocol.ChunkedPreamble):
+			req.chunked = true
+			return req
+		case consumeLine(reader, protocol.StatusDirective):
+			req.status = true
+			return req
+		case consumeLine(reader, protocol.CapsDirective):
+			req.caps = true
+			return req
+		case consumeLine(reader, protocol.ClearDirective):
+			req.clear = true
+			return req
+		case consumeLine(reader, protocol.PasteDirective):
+			req.paste = true
+			req.accept = consumeAccept(reader)
+			return req
+		case consumeLine(reader, protocol.VerifyDirective):
+			req.verify = true
+		case consumeContentLength(reader, &req.contentLength):
+		case consumeDuration(reader, protocol.ClearAfterHeader, &req.clearAfter):
+		case consumeDuration(reader, protocol.HistoryTTLHeader, &req.historyTTL):
+		case consumeContentType(reader, &req.contentType):
+		default:
+			return req
+		}
+	}
+}
+
+// maxVersionDigits bounds the version number in a handshake line
+const maxVersionDigits = 9
+
+// consumeHandshake parses and discards a handshake line if the stream starts
+// with one, returning the version the client announced. Like consumeLine it
+// peeks a byte at a time, since the client waits for the reply before
+// sending anything after the newline.
+func consumeHandshake(reader *bufio.Reader) (int, bool) {
+	prefix := len(protocol.HandshakePrefix)
+	for i := 1; i <= prefix+maxVersionDigits+1; i++ {
+		peeked, err := reader.Peek(i)
+		if err != nil {
+			return 0, false
+		}
+		b := peeked[i-1]
+		switch {
+		case i <= prefix:
+			if b != protocol.HandshakePrefix[i-1] {
+				return 0, false
+			}
+		case b == '\n':
+			version, err := strconv.Atoi(string(peeked[prefix : i-1]))
+			if err != nil || version < 1 {
+				return 0, false
+			}
+			reader.Discard(i)
+			return version, true
+		case b < '0' || b > '9':
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// consumeContentLength parses and discards a Content-Length line if the
+// stream starts with one, storing the announced size in size
+func consumeContentLength(reader *bufio.Reader, size *int64) bool {
+	// The header, up to 19 digits of int64, and the newline
+	peeked, _ := reader.Peek(len(protocol.ContentLengthHeader) + 20)
+	line := string(peeked)
+	if !strings.HasPrefix(line, protocol.ContentLengthHeader) {
+		return false
+	}
+
+	value, _, found := strings.Cut(line[len(protocol.ContentLengthHeader):], "\n")
+	if !found {
+		return false
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return false
+	}
+
+	*size = n
+	reader.Discard(len(protocol.ContentLengthHeader) + len(value) + 1)
+	return true
+}
+
+// maxDurationLength bounds the duration in a Clear-After or History-TTL line
+const maxDurationLength = 32
+
+// consumeDuration parses and discards a line of header followed by a
+// positive duration, such as a Clear-After line, if the stream starts with
+// one, storing the duration in d
+func consumeDuration(reader *bufio.Reader, header string, d *time.Duration) bool {
+	// Only wait for the whole line once the stream is known to hold one, so
+	// a payload of a few bytes isn't held up
+	if !startsWith(reader, header) {
+		return false
+	}
+	peeked, _ := reader.Peek(len(header) + maxDurationLength + 1)
+	line := string(peeked)
+
+	value, _, found := strings.Cut(line[len(header):], "\n")
+	if !found {
+		return false
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		return false
+	}
+
+	*d = parsed
+	reader.Discard(len(header) + len(value) + 1)
+	return true
+}
+
+// maxContentTypeLength bounds the type in a Content-Type line, which RFC
+// 6838 limits to 127 characters on either side of the slash
+const maxContentTypeLength = 255
+
+// consumeContentType parses and discards a Content-Type line if the stream
+// starts with one, storing the type without any parameters in mimeType
+func consumeContentType(reader *bufio.Reader, mimeType *string) bool {
+	if !startsWith(reader, protocol.ContentTypeHeader) {
+		return false
+	}
+	peeked, _ := reader.Peek(len(protocol.ContentTypeHeader) + maxContentTypeLength + 1)
+	line := string(peeked)
+
+	value, _, found := strings.Cut(line[len(protocol.ContentTypeHeader):], "\n")
+	if !found {
+		return false
+	}
+	t, _, _ := strings.Cut(value, ";")
+	t = strings.ToLower(strings.TrimSpace(t))
+	if !strings.Contains(t, "/") {
+		return false
+	}
+
+	*mimeType = t
+	reader.Discard(len(protocol.ContentTypeHeader) + len(value) + 1)
+	return true
+}
+
+// maxAcceptLength bounds the list of types in an Accept line
+const maxAcceptLength = 1024
+
+// consumeAccept parses and discards an Accept line if the stream starts with
+// one, returning the listed types
+func consumeAccept(reader *bufio.Reader) []string {
+	peeked, _ := reader.Peek(len(protocol.AcceptHeader) + maxAcceptLength + 1)
+	line := string(peeked)
+	if !strings.HasPrefix(line, protocol.AcceptHeader) {
+		return nil
+	}
+
+	value, _, found := strings.Cut(line[len(protocol.AcceptHeader):], "\n")
+	if !found {
+		return nil
+	}
+	reader.Discard(len(protocol.AcceptHeader) + len(value) + 1)
+
+	var types []string
+	for _, t := range strings.Split(value, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// consumeLine discards line from reader if the stream starts with it
+func consumeLine(reader *bufio.Reader, line string) bool {
+	if !startsWith(reader, line) {
+		return false
+	}
+	reader.Discard(len(line))
+	return true
+}
+
+// startsWith reports whether the stream starts with prefix without consuming
+// anything. It compares a byte at a time so that a shorter directive the
+// client has finished sending is never stuck waiting for bytes a longer one
+// would need.
+func startsWith(reader *bufio.Reader, prefix string) bool {
+	for i := 1; i <= len(prefix); i++ {
+		peeked, err := reader.Peek(i)
+		if err != nil || peeked[i-1] != prefix[i-1] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/mquinnv/warpclip/v2/internal/clipboard"
+)
+
+// saveClipboard keeps the clipboard's text in WARPCLIP_CLIPBOARD_FILE as the
+// daemon shuts down, so restoreClipboard can put it back when it starts
+// again. On X11 the clipboard goes with the program holding it, so without
+// this a restart empties it.
+func (s *Server) saveClipboard() {
+	data, err := s.backend.Paste()
+	if errors.Is(err, clipboard.ErrPasteUnsupported) {
+		s.logger.Debug("Not saving the clipboard for restore as the backend can't read it")
+		return
+	}
+	if err != nil {
+		s.logger.Warning(fmt.Sprintf("Failed to read the clipboard to save for restore: %v", err))
+		return
+	}
+	if len(data) == 0 {
+		return
+	}
+	if int64(len(data)) > s.cfg.MaxDataSize {
+		s.logger.Info(fmt.Sprintf("Not saving the clipboard for restore as its %d bytes exceed WARPCLIP_MAX_DATA_SIZE", len(data)))
+		return
+	}
+
+	// Replace the file atomically, so a crash mid-write can't leave half a
+	// clipboard to restore
+	tempFile := fmt.Sprintf("%s.%d", s.cfg.ClipboardFile, os.Getpid())
+	if err := os.WriteFile(tempFile, data, 0600); err != nil {
+		s.logger.Warning(fmt.Sprintf("Failed to save the clipboard for restore: %v", err))
+		return
+	}
+	if err := os.Rename(tempFile, s.cfg.ClipboardFile); err != nil {
+		os.Remove(tempFile)
+		s.logger.Warning(fmt.Sprintf("Failed to save the clipboard for restore: %v", err))
+		return
+	}
+	s.logger.Info(fmt.Sprintf("Saved %d bytes of clipboard to restore at the next start", len(data)))
+}
+
+// restoreClipboard copies the text saved by saveClipboard back to the
+// clipboard and removes the file, so it is only restored once. The clipboard
+// is left alone if it isn't empty, as whatever is on it was copied since.
+func (s *Server) restoreClipboard() {
+	data, err := os.ReadFile(s.cfg.ClipboardFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	defer os.Remove(s.cfg.ClipboardFile)
+	if err != nil {
+		s.logger.Warning(fmt.Sprintf("Failed to read saved clipboard: %v", err))
+		return
+	}
+	if int64(len(data)) > s.cfg.MaxDataSize {
+		s.logger.Warning(fmt.Sprintf("Not restoring the saved clipboard as its %d bytes exceed WARPCLIP_MAX_DATA_SIZE", len(data)))
+		return
+	}
+
+	if current, err := s.backend.Paste(); err == nil && len(current) > 0 {
+		s.logger.Info("Not restoring the saved clipboard as something has been copied since")
+		return
+	}
+	if err := s.copyThroughBreaker(data, clipboard.TextType); err != nil {
+		s.logger.Warning(fmt.Sprintf("Failed to restore saved clipboard: %v", err))
+		return
+	}
+	s.logger.Info(fmt.Sprintf("Restored %d bytes of clipboard saved at the last shutdown", len(data)))
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mquinnv/warpclip/v2/internal/clipboard"
+	"github.com/mquinnv/warpclip/v2/internal/protocol"
+)
+
+// selfTestTimeout bounds each network step of the self-test. The daemon is
+// talking to itself, so anything slower means something is wrong.
+const selfTestTimeout = 5 * time.Second
+
+// selfTestMarker is copied to the clipboard and read back by the self-test
+const selfTestMarker = "warpclip self-test"
+
+// runSelfTest checks the whole copy path once the daemon is accepting
+// connections, for WARPCLIP_SELFTEST, so a misconfigured backend shows up in
+// the log at startup instead of on the first real copy
+func (s *Server) runSelfTest(addr net.Addr) {
+	if err := s.selfTest(addr); err != nil {
+		s.logger.Error(fmt.Sprintf("Self-test failed: %v", err))
+		return
+	}
+	s.logger.Info("Self-test passed")
+}
+
+// selfTest connects to the daemon's own port and does the version
+// handshake, then, if the backend can read the clipboard, writes a marker to
+// it and checks it comes back
+func (s *Server) selfTest(addr net.Addr) error {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("unexpected listener address %v", addr)
+	}
+	// A daemon listening on every interface is reached through loopback
+	target := *tcpAddr
+	if target.IP == nil || target.IP.IsUnspecified() {
+		target.IP = net.IPv4(127, 0, 0, 1)
+	}
+
+	conn, err := net.DialTimeout("tcp", target.String(), selfTestTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", target.String(), err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	version, err := protocol.Handshake(conn, reader, selfTestTimeout)
+	if err != nil {
+		return fmt.Errorf("handshake failed: %w", err)
+	}
+	if version != protocol.Version {
+		return fmt.Errorf("handshake agreed on version %d, expected %d", version, protocol.Version)
+	}
+
+	// A status request ends the connection without touching the clipboard
+	if err := conn.SetDeadline(time.Now().Add(selfTestTimeout)); err != nil {
+		return fmt.Errorf("failed to set deadline: %w", err)
+	}
+	if _, err := conn.Write([]byte(protocol.StatusDirective)); err != nil {
+		return fmt.Errorf("failed to request status: %w", err)
+	}
+	if _, err := protocol.ReadStatus(reader); err != nil {
+		return fmt.Errorf("failed to read status: %w", err)
+	}
+
+	return s.selfTestClipboard()
+}
+
+// selfTestClipboard writes the marker to the clipboard and reads it back,
+// then puts back the text the clipboard held. Without a way to read the
+// clipboard, neither the check nor the restore is possible, so it is left
+// alone.
+func (s *Server) selfTestClipboard() error {
+	previous, err := s.backend.Paste()
+	if errors.Is(err, clipboard.ErrPasteUnsupported) {
+		s.logger.Info("Self-test skipped the clipboard, as the backend can't read it back")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read clipboard: %w", err)
+	}
+
+	if err := s.copyToClipboardOnce([]byte(selfTestMarker), clipboard.TextType); err != nil {
+		return fmt.Errorf("failed to write clipboard: %w", err)
+	}
+	defer func() {
+		if err := s.copyToClipboardOnce(previous, clipboard.TextType); err != nil {
+			s.logger.Warning(fmt.Sprintf("Failed to restore the clipboard after the self-test: %v", err))
+		}
+	}()
+
+	data, err := s.backend.Paste()
+	if err != nil {
+		return fmt.Errorf("failed to read back clipboard: %w", err)
+	}
+	// Some tools add a trailing newline on paste
+	if !bytes.Equal(bytes.TrimRight(data, "\r\n"), []byte(selfTestMarker)) {
+		return fmt.Errorf("read back %q from the clipboard after writing %q", data, selfTestMarker)
+	}
+	return nil
+}
@@ -4,23 +4,60 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net"
+	"net/http"
 	"os"
-	"os/exec"
+	"runtime"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/mquinnv/warpclip/v2/internal/ansi"
+	"github.com/mquinnv/warpclip/v2/internal/clipboard"
 	"github.com/mquinnv/warpclip/v2/internal/config"
+	"github.com/mquinnv/warpclip/v2/internal/eol"
+	"github.com/mquinnv/warpclip/v2/internal/history"
 	"github.com/mquinnv/warpclip/v2/internal/log"
+	"github.com/mquinnv/warpclip/v2/internal/protocol"
+	"github.com/mquinnv/warpclip/v2/internal/trim"
+)
+
+var (
+	// errTrimmedEmpty is reported when the trim policy leaves nothing to copy
+	errTrimmedEmpty = errors.New("nothing left to copy after trimming whitespace")
+	// errTooLarge is reported when an announced payload exceeds the size limit
+	errTooLarge = errors.New("payload too large")
+	// errAlreadyRunning is reported when another warpclipd holds the port
+	errAlreadyRunning = errors.New("another warpclipd is already running")
+	// errPortInUse is reported when some other program holds the port
+	errPortInUse = errors.New("port in use by another process")
+	// errTransformedEmpty is reported when the transform command prints nothing
+	errTransformedEmpty = errors.New("transform command produced no output")
+	// errNeedsGUISession is reported when the clipboard program can't reach
+	// the desktop, as happens to pbcopy when warpclipd is started over SSH
+	errNeedsGUISession = errors.New("clipboard requires a GUI login session; run warpclipd under your desktop session, not over SSH")
+	// errBudgetExhausted is reported when concurrent payloads already hold
+	// all of WARPCLIP_MEMORY_BUDGET
+	errBudgetExhausted = errors.New("server memory budget exhausted by concurrent copies; try again shortly")
+	// errClientTooOld is reported to clients speaking an older protocol
+	// version than WARPCLIP_MIN_CLIENT_VERSION
+	errClientTooOld = errors.New("client is too old")
 )
 
 // Server represents the warpclipd TCP server
 type Server struct {
 	cfg            *config.Config
 	logger         log.Logger
+	backend        clipboard.Backend
 	listener       net.Listener
 	activeConns    sync.WaitGroup
 	shutdownSignal chan struct{}
@@ -28,45 +65,196 @@ type Server struct {
 	// Track connections by remote address to handle multiple connections
 	connMutex      sync.Mutex
 	activeAddrs    map[string]time.Time
+
+	// Idle tracking for WARPCLIP_IDLE_TIMEOUT
+	idleMutex    sync.Mutex
+	lastActivity time.Time
+	openConns    int
+
+	// Accepted connections waiting for a handler, and counters reported by status
+	connCh   chan net.Conn
+	accepted atomic.Uint64
+	dropped  atomic.Uint64
+
+	// Clipboard updates reported by status
+	copyMutex   sync.Mutex
+	copies      uint64
+	bytesCopied uint64
+	lastCopy    time.Time
+
+	// Serializes writes to the history file
+	historyMutex sync.Mutex
+	// How often entries whose time to live has run out are purged from it
+	historyPurgeInterval time.Duration
+
+	// Stops copies to a backend that keeps failing
+	breaker *breaker
+	// Coalesces bursts of copies, nil unless WARPCLIP_DEBOUNCE is set
+	debouncer *debouncer
+	// Clears the clipboard after copies made with --clear-after
+	autoClear *autoClear
+	// Plays a sound or posts a notification for each copy, nil unless
+	// WARPCLIP_NOTIFY_SOUND is set
+	notifier *notifier
+	// Caps the payload bytes held across connections (WARPCLIP_MEMORY_BUDGET)
+	budget *memoryBudget
+	// Durations of the latest clipboard writes, for the status
+	latency *latencyWindow
+}
+
+// defaultHistoryPurgeInterval is how often expired history entries are purged
+const defaultHistoryPurgeInterval = time.Minute
+
+// connQueueSize is the number of accepted connections that may wait for a handler
+const connQueueSize = 10
+
+// New creates a new Server instance using the clipboard backend selected by
+// the configuration, or the platform default when none is configured. A
+// configured chain of backends is tried in order on every copy.
+func New(cfg *config.Config, logger log.Logger) (*Server, error) {
+	opts := clipboard.Options{Command: cfg.ClipboardCommand, Args: cfg.ClipboardArgs}
+	if len(cfg.BackendChain) > 0 {
+		backends := make([]clipboard.Backend, 0, len(cfg.BackendChain))
+		for _, name := range cfg.BackendChain {
+			backend, err := clipboard.New(name, opts)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create clipboard backend: %w", err)
+			}
+			backends = append(backends, backend)
+		}
+		return NewWithBackend(cfg, logger, clipboard.NewChain(backends)), nil
+	}
+
+	name := cfg.Backend
+	if name == "" {
+		name = clipboard.Default(runtime.GOOS)
+	}
+
+	backend, err := clipboard.New(name, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clipboard backend: %w", err)
+	}
+
+	return NewWithBackend(cfg, logger, backend), nil
 }
 
-// New creates a new Server instance
-func New(cfg *config.Config, logger log.Logger) *Server {
-	return &Server{
+// NewWithBackend creates a new Server instance that copies using backend
+func NewWithBackend(cfg *config.Config, logger log.Logger, backend clipboard.Backend) *Server {
+	threshold := cfg.BreakerThreshold
+	if threshold == 0 {
+		threshold = config.DefaultBreakerThreshold
+	}
+	cooldown := cfg.BreakerCooldown
+	if cooldown <= 0 {
+		cooldown = config.DefaultBreakerCooldown
+	}
+
+	s := &Server{
 		cfg:            cfg,
 		logger:         logger,
+		backend:        backend,
 		shutdownSignal: make(chan struct{}),
 		activeAddrs:    make(map[string]time.Time),
+		breaker:        newBreaker(threshold, cooldown),
+
+		historyPurgeInterval: defaultHistoryPurgeInterval,
 	}
+	if cfg.Debounce > 0 {
+		s.debouncer = newDebouncer(cfg.Debounce, s.deliverBatch)
+	}
+	s.autoClear = newAutoClear(s.clearClipboard)
+	s.notifier = newNotifier(cfg.NotifySound)
+	s.budget = newMemoryBudget(cfg.MemoryBudget)
+	s.latency = newLatencyWindow()
+	return s
 }
 
 // Start starts the TCP server
 func (s *Server) Start(ctx context.Context) error {
+	// Refuse to start rather than accept copies that can only fail
+	if err := clipboard.Check(s.backend); err != nil {
+		return fmt.Errorf("clipboard backend unavailable: %w (install it or choose another with WARPCLIP_BACKEND)", err)
+	}
+
 	// Create a TCP listener
 	address := fmt.Sprintf("%s:%d", s.cfg.BindAddress, s.cfg.Port)
 	listener, err := net.Listen("tcp", address)
 	if err != nil {
-		return fmt.Errorf("failed to create listener: %w", err)
+		return s.listenError(address, err)
 	}
 	s.listener = listener
 	defer s.listener.Close()
 
 	s.logger.Info(fmt.Sprintf("Server listening on %s", address))
+	if chain, ok := s.backend.(*clipboard.ChainBackend); ok {
+		s.logger.Info(fmt.Sprintf("Using clipboard backends in order: %s", strings.Join(chain.Names(), ", ")))
+	} else {
+		s.logger.Info(fmt.Sprintf("Using clipboard backend: %s", s.backend.Name()))
+	}
 
-	// Write PID file
+	// Write PID file. Copies work without one, so a read-only home
+	// directory only costs the commands that find the daemon through it.
 	if err := s.writePidFile(); err != nil {
-		return fmt.Errorf("failed to write PID file: %w", err)
+		s.logger.Warning(fmt.Sprintf("Running without a PID file, so warpclipd stop and status won't find this daemon: %v", err))
+	} else {
+		defer os.Remove(s.cfg.PidFile)
+
+		// Without the sidecar, status and stop assume the configured port
+		info := PidInfo{
+			PID:         os.Getpid(),
+			BindAddress: s.cfg.BindAddress,
+			Port:        listener.Addr().(*net.TCPAddr).Port,
+			Started:     time.Now(),
+		}
+		if err := s.writePidInfo(info); err != nil {
+			s.logger.Warning(fmt.Sprintf("Failed to write PID sidecar: %v", err))
+		} else {
+			defer os.Remove(PidInfoFile(s.cfg.PidFile))
+		}
+	}
+
+	// Put back what the clipboard held when the last daemon shut down, before
+	// any copy can arrive to replace it
+	if s.cfg.RestoreOnStart {
+		s.restoreClipboard()
+	}
+
+	// Periodically check whether the daemon has been idle long enough to exit
+	s.markActivity()
+	var idleCheck <-chan time.Time
+	if s.cfg.IdleTimeout > 0 {
+		ticker := time.NewTicker(idleCheckInterval(s.cfg.IdleTimeout))
+		defer ticker.Stop()
+		idleCheck = ticker.C
+		s.logger.Info(fmt.Sprintf("Idle shutdown enabled after %v without activity", s.cfg.IdleTimeout))
+	}
+
+	// Purge history entries as their time to live runs out, starting with
+	// any that did while the daemon was down
+	purgeDone := make(chan struct{})
+	defer close(purgeDone)
+	go s.maintainHistory(purgeDone)
+
+	// Shutdown waits for a pulled copy like any other connection
+	if s.cfg.PullFrom != "" {
+		s.activeConns.Add(1)
+		go func() {
+			defer s.activeConns.Done()
+			s.PullFrom(ctx, s.cfg.PullFrom)
+		}()
 	}
-	defer os.Remove(s.cfg.PidFile)
 
 	// Channel for accept errors
 	errorCh := make(chan error, 1)
 
 	// Channel for new connections
-	connCh := make(chan net.Conn, 10)
+	connCh := make(chan net.Conn, connQueueSize)
+	s.connCh = connCh
 
 	// Start accepting connections in a separate goroutine
+	acceptDone := make(chan struct{})
 	go func() {
+		defer close(acceptDone)
 		for {
 			conn, err := listener.Accept()
 			if err != nil {
@@ -81,65 +269,286 @@ func (s *Server) Start(ctx context.Context) error {
 					return
 				}
 			}
+			s.accepted.Add(1)
+
+			// Don't queue new work once shutdown has begun
+			select {
+			case <-ctx.Done():
+				s.dropConn(conn, "server shutting down")
+				return
+			case <-s.shutdownSignal:
+				s.dropConn(conn, "server shutting down")
+				return
+			default:
+			}
+
+			select {
+			case connCh <- conn:
+				// Connection sent for processing
+				continue
+			default:
+				s.logger.Warning(fmt.Sprintf("Connection queue full (%d pending), delaying connection from %s", len(connCh), conn.RemoteAddr()))
+			}
 
 			select {
 			case connCh <- conn:
 				// Connection sent for processing
 			case <-ctx.Done():
-				conn.Close()
+				s.dropConn(conn, "server shutting down")
 				return
 			case <-s.shutdownSignal:
-				conn.Close()
+				s.dropConn(conn, "server shutting down")
 				return
 			}
 		}
 	}()
 
+	// The self-test connects like a client, so it needs the accept loop
+	// running, and shutdown waits for it like any other connection
+	if s.cfg.SelfTest {
+		s.activeConns.Add(1)
+		go func() {
+			defer s.activeConns.Done()
+			s.runSelfTest(listener.Addr())
+		}()
+	}
+
 	// Process connections and handle shutdown
 	for {
 		select {
 		case <-ctx.Done():
 			s.logger.Info("Context cancelled, shutting down server...")
-			close(s.shutdownSignal)
-			s.listener.Close()
-			s.activeConns.Wait() // Wait for active connections to finish
-			s.logger.Info("Server shutdown complete")
+			s.shutdown(acceptDone)
 			return nil
 
+		case <-idleCheck:
+			if idle, ok := s.idleFor(); ok && idle >= s.cfg.IdleTimeout {
+				s.logger.Info(fmt.Sprintf("No activity for %v, shutting down server...", idle.Round(time.Second)))
+				s.shutdown(acceptDone)
+				return nil
+			}
+
 		case err := <-errorCh:
 			s.logger.Error(fmt.Sprintf("Error accepting connection: %v", err))
 			return err
 
 		case conn := <-connCh:
 			s.activeConns.Add(1)
+			s.trackConn(1)
 			go func(c net.Conn) {
 				defer s.activeConns.Done()
+				defer s.trackConn(-1)
 				s.handleConnection(c)
 			}(conn)
 		}
 	}
 }
 
+// shutdown stops accepting connections, drops any still queued, and waits
+// for active ones to finish
+func (s *Server) shutdown(acceptDone <-chan struct{}) {
+	close(s.shutdownSignal)
+	s.listener.Close()
+
+	// Once the accept loop has exited nothing else can be queued
+	<-acceptDone
+	for len(s.connCh) > 0 {
+		s.dropConn(<-s.connCh, "server shutting down")
+	}
+
+	// Wait for active connections to finish, but not forever: a client
+	// stuck mid-copy shouldn't keep a service manager waiting
+	drained := make(chan struct{})
+	go func() {
+		s.activeConns.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(s.shutdownTimeout()):
+		s.logger.Warning(fmt.Sprintf("Connections still open after %v, shutting down without them", s.shutdownTimeout()))
+	}
+
+	// Don't leave data that was meant to be cleared behind, nor save it to
+	// restore
+	if !s.autoClear.pending().IsZero() {
+		s.logger.Info("Clearing the clipboard early as the server is shutting down")
+		s.autoClear.flush()
+	} else if s.cfg.RestoreOnStart {
+		s.saveClipboard()
+	}
+	if dropped := s.dropped.Load(); dropped > 0 {
+		s.logger.Warning(fmt.Sprintf("%d connections were dropped without being handled", dropped))
+	}
+	s.logger.Info("Server shutdown complete")
+}
+
+// listenError explains a failure to listen on address. When the port is
+// taken it works out whether the occupant is another warpclipd.
+func (s *Server) listenError(address string, err error) error {
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		return fmt.Errorf("failed to create listener: %w", err)
+	}
+
+	if probeDaemon(address) {
+		return fmt.Errorf("%w on port %d; stop it with 'warpclipd stop' or choose another port with --port", errAlreadyRunning, s.cfg.Port)
+	}
+	// Daemons predating the handshake can't be probed, but leave their PID
+	if pid, ok := runningPid(s.cfg.PidFile); ok {
+		return fmt.Errorf("%w on port %d (PID %d); stop it with 'warpclipd stop' or choose another port with --port", errAlreadyRunning, s.cfg.Port, pid)
+	}
+	return fmt.Errorf("%w: port %d is taken; free it or choose another port with --port or WARPCLIP_LOCAL_PORT", errPortInUse, s.cfg.Port)
+}
+
+// probeDaemon reports whether a warpclipd is listening on address, asking
+// for its status once it has answered the handshake
+func probeDaemon(address string) bool {
+	conn, err := net.DialTimeout("tcp", address, time.Second)
+	if err != nil {
+		return false
+	}
+	reader := bufio.NewReader(conn)
+	if _, err := protocol.Handshake(conn, reader, time.Second); err != nil {
+		// Don't leave whatever is listening waiting for the rest of a request
+		protocol.Abandon(conn)
+		return false
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(time.Second)); err != nil {
+		return false
+	}
+	if _, err := conn.Write([]byte(protocol.StatusDirective)); err != nil {
+		return false
+	}
+	_, err = protocol.ReadStatus(reader)
+	return err == nil
+}
+
+// runningPid returns the PID recorded in path if that process is alive and
+// isn't this one
+func runningPid(path string) (int, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 || pid == os.Getpid() {
+		return 0, false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return 0, false
+	}
+	// Signal 0 checks that the process exists without affecting it
+	if err := process.Signal(syscall.Signal(0)); err != nil {
+		return 0, false
+	}
+	return pid, true
+}
+
+// dropConn closes a connection that won't be handled and counts it, so
+// refused connections show up in logs and status rather than vanishing
+func (s *Server) dropConn(conn net.Conn, reason string) {
+	s.dropped.Add(1)
+	s.logger.Warning(fmt.Sprintf("Dropped connection from %s: %s", conn.RemoteAddr(), reason))
+	conn.Close()
+}
+
+// Status returns the server's runtime counters
+func (s *Server) Status() protocol.Status {
+	s.idleMutex.Lock()
+	active := s.openConns
+	s.idleMutex.Unlock()
+
+	s.copyMutex.Lock()
+	status := protocol.Status{
+		Accepted: s.accepted.Load(),
+		Dropped:  s.dropped.Load(),
+		Queued:   len(s.connCh),
+		Active:   active,
+		Copies:   s.copies,
+		Bytes:    s.bytesCopied,
+		LastCopy: s.lastCopy,
+	}
+	s.copyMutex.Unlock()
+	status.ClearAt = s.autoClear.pending()
+	failures, open, lastErr := s.breaker.state()
+	status.BackendFailures = failures
+	status.BackendUnhealthy = open
+	if lastErr != nil {
+		status.BackendError = lastErr.Error()
+		status.BackendCategory = errorAck(lastErr).Category
+	}
+	status.CopyLatency = s.latency.summary()
+	status.Hostname, _ = os.Hostname()
+	return status
+}
+
+// idleCheckInterval returns how often to check for an idle timeout, so that
+// shutdown happens reasonably close to the configured time
+func idleCheckInterval(timeout time.Duration) time.Duration {
+	interval := timeout / 10
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	return interval
+}
+
+// markActivity records a successful copy for idle tracking
+func (s *Server) markActivity() {
+	s.idleMutex.Lock()
+	defer s.idleMutex.Unlock()
+	s.lastActivity = time.Now()
+}
+
+// countCopy records a clipboard update of size bytes for status
+func (s *Server) countCopy(size int) {
+	s.copyMutex.Lock()
+	defer s.copyMutex.Unlock()
+	s.copies++
+	s.bytesCopied += uint64(size)
+	s.lastCopy = time.Now()
+}
+
+// trackConn adjusts the number of open connections by delta
+func (s *Server) trackConn(delta int) {
+	s.idleMutex.Lock()
+	defer s.idleMutex.Unlock()
+	s.openConns += delta
+}
+
+// idleFor returns how long it has been since the last successful copy. It
+// reports false while any connection is open, since the server isn't idle.
+func (s *Server) idleFor() (time.Duration, bool) {
+	s.idleMutex.Lock()
+	defer s.idleMutex.Unlock()
+	if s.openConns > 0 {
+		return 0, false
+	}
+	return time.Since(s.lastActivity), true
+}
+
 // handleConnection processes a single client connection
 func (s *Server) handleConnection(conn net.Conn) {
 	defer conn.Close()
 
 	remoteAddr := conn.RemoteAddr().String()
-	s.logger.Info(fmt.Sprintf("New connection from %s", remoteAddr))
 
-	// Set read deadline to prevent hanging
-	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
-		s.logger.Error(fmt.Sprintf("Failed to set read deadline: %v", err))
-		return
-	}
+	// Close connections that stall or drag on, while letting a large copy
+	// take as long as it needs within the overall limit
+	timeouts := newDeadlineReader(conn, s.readTimeout(), s.connTimeout())
 
 	// Read just one byte to check connection type
 	firstByte := make([]byte, 1)
-	n, err := conn.Read(firstByte)
+	n, err := timeouts.Read(firstByte)
 
 	// If we got EOF or zero bytes, this is a control connection
 	if err == io.EOF || n == 0 {
-		s.logger.Info(fmt.Sprintf("Control connection from %s, closing", remoteAddr))
+		s.logConn(fmt.Sprintf("Control connection from %s, closing", remoteAddr))
 		return
 	}
 
@@ -149,43 +558,828 @@ func (s *Server) handleConnection(conn net.Conn) {
 		return
 	}
 
-	// This is a data connection, read the rest of the data
-	var buf bytes.Buffer
-	buf.Write(firstByte) // Don't forget our first byte
+	// Rejoin the first byte with the rest of the stream
+	reader := bufio.NewReaderSize(io.MultiReader(bytes.NewReader(firstByte), timeouts), s.readBufferSize())
 
-	// Create a limited reader to prevent memory exhaustion
-	limitReader := io.LimitReader(conn, s.cfg.MaxDataSize-1) // -1 because we already read one byte
-	_, err = io.Copy(&buf, limitReader)
-	if err != nil {
-		s.logger.Error(fmt.Sprintf("Error reading data: %v", err))
+	// Clients negotiating a version wait for the answer before going on;
+	// everything after it is the same request an older client would send
+	clientVersion := protocol.LegacyVersion
+	if version, ok := consumeHandshake(reader); ok {
+		clientVersion = version
+		s.logger.Debug(fmt.Sprintf("Client %s speaks protocol version %d", remoteAddr, version))
+		if err := conn.SetWriteDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			s.logger.Debug(fmt.Sprintf("Failed to set write deadline: %v", err))
+			return
+		}
+		if err := protocol.WriteHandshake(conn, protocol.Version); err != nil {
+			s.logger.Warning(fmt.Sprintf("Failed to answer handshake from %s: %v", remoteAddr, err))
+			return
+		}
+	}
+
+	// Clients in follow mode announce a stream of framed records
+	req := readRequest(reader)
+
+	// Operators can insist on clients new enough to have a feature they rely on
+	if clientVersion < s.cfg.MinClientVersion {
+		err := fmt.Errorf("%w: it speaks protocol version %d but this warpclipd requires at least version %d; upgrade warpclip on the remote machine", errClientTooOld, clientVersion, s.cfg.MinClientVersion)
+		s.logger.Warning(fmt.Sprintf("Rejecting connection from %s: %v", remoteAddr, err))
+		s.sendAck(conn, errorAck(err))
+		return
+	}
+
+	// warpclipd top asks for the status every second, so only log it at DEBUG
+	if req.status {
+		s.logger.Debug(fmt.Sprintf("Status request from %s", remoteAddr))
+		s.sendStatus(conn)
+		return
+	}
+	if req.caps {
+		s.logger.Debug(fmt.Sprintf("Capabilities request from %s", remoteAddr))
+		s.sendCapabilities(conn)
+		return
+	}
+	s.logConn(fmt.Sprintf("New connection from %s", remoteAddr))
+	if req.clear {
+		s.handleClear(conn)
+		return
+	}
+
+	if req.paste {
+		s.handlePaste(conn, req.accept, req.verify)
+		return
+	}
+
+	// Refuse a type the backend can't write before reading the payload
+	if req.contentType != "" {
+		if err := clipboard.CheckCopyType(s.backend, req.contentType); err != nil {
+			s.logger.Error(fmt.Sprintf("Rejecting data from %s: %v", remoteAddr, err))
+			s.sendAck(conn, errorAck(err))
+			return
+		}
+	}
+
+	// Refuse an announced payload that could never fit
+	if req.contentLength > s.cfg.MaxDataSize {
+		err := fmt.Errorf("%w: %d bytes exceeds maximum size of %d bytes", errTooLarge, req.contentLength, s.cfg.MaxDataSize)
+		s.logger.Error(fmt.Sprintf("Rejecting data from %s: %v", remoteAddr, err))
+		s.sendAck(conn, errorAck(err))
 		return
 	}
 
-	data := buf.Bytes()
+	// Follow and session connections may sit idle between records for as
+	// long as the client likes. Each record is held to the idle deadline and
+	// WARPCLIP_CONN_TIMEOUT once it starts, and reserves its memory budget
+	// only while it is read and copied.
+	if req.follow {
+		s.handleFollow(conn, reader, timeouts)
+		return
+	}
+	if req.session {
+		s.handleSession(conn, reader, timeouts, req.verify)
+		return
+	}
+
+	// Hold back what the payload may take before reading any of it
+	reservation := s.cfg.MaxDataSize
+	if req.contentLength >= 0 {
+		reservation = req.contentLength
+	}
+	if !s.budget.reserve(reservation) {
+		s.logger.Warning(fmt.Sprintf("Rejecting connection from %s: %d bytes would exceed the memory budget of %d bytes (%d in use)", remoteAddr, reservation, s.cfg.MemoryBudget, s.budget.inUse()))
+		s.sendAck(conn, errorAck(errBudgetExhausted))
+		return
+	}
+	defer s.budget.release(reservation)
+
+	// This is a data connection, read the rest of the data. The checksum
+	// covers the payload as the client sent it, before line ending
+	// conversion, ANSI stripping and trimming, as the client hashes what it
+	// sent and can't know what those changed.
+	var digest hash.Hash
+	if req.verify {
+		digest = sha256.New()
+	}
+	var data []byte
+	var truncated bool
+	if req.chunked {
+		data, err = s.readChunks(conn, reader, digest)
+	} else {
+		data, truncated, err = s.readData(reader, req.contentLength, digest)
+	}
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("Error reading data from %s: %v", remoteAddr, err))
+		s.sendAck(conn, errorAck(err))
+		return
+	}
 	if len(data) == 0 {
 		s.logger.Warning("Received empty data, nothing to copy")
+		s.sendAck(conn, protocol.Ack{Error: "received empty data"})
 		return
 	}
 
 	// Check if we hit the size limit
-	if int64(len(data)) >= s.cfg.MaxDataSize {
+	if truncated {
 		s.logger.Warning(fmt.Sprintf("Data exceeded maximum size limit (%d bytes), truncated", s.cfg.MaxDataSize))
 	}
 
-	// Copy data to clipboard
-	if err := s.copyToClipboard(data); err != nil {
-		s.logger.Error(fmt.Sprintf("Failed to copy to clipboard: %v", err))
+	// Trimming on the server keeps the policy the same whichever client sent
+	// the data. Typed copies, such as a PDF, are copied exactly as they
+	// arrived.
+	typed := req.contentType != "" && req.contentType != clipboard.TextType
+	if !typed {
+		if data = trim.Apply(data, s.cfg.TrimPolicy); len(data) == 0 {
+			s.logger.Warning("Received only whitespace, nothing to copy")
+			s.sendAck(conn, errorAck(errTrimmedEmpty))
+			return
+		}
+	}
+
+	// A copy to be cleared from the clipboard is one that shouldn't linger
+	// in the history either, unless the client asked otherwise
+	ttl := req.historyTTL
+	if ttl == 0 {
+		ttl = req.clearAfter
+	}
+	if typed {
+		err = s.deliverTyped(data, req.contentType, ttl)
+	} else {
+		err = s.deliverExpiring(data, sourceCopy, ttl)
+	}
+	if err != nil {
+		s.logger.Error(err.Error())
+		s.sendAck(conn, errorAck(err))
 		return
 	}
 
+	ack := protocol.Ack{OK: true, Bytes: int64(len(data)), Backend: s.backend.Name()}
+	if digest != nil {
+		ack.SHA256 = hex.EncodeToString(digest.Sum(nil))
+	}
+	if req.clearAfter > 0 {
+		ack.ClearAt = s.autoClear.schedule(req.clearAfter)
+		s.logger.Info(fmt.Sprintf("Clipboard will clear at %s", ack.ClearAt.Format("15:04:05")))
+	}
+	s.sendAck(conn, ack)
+}
+
+// logConn logs a connection opening or closing, at DEBUG rather than INFO
+// with WARPCLIP_QUIET_CONN_LOGS so busy users' logs keep to the copies
+func (s *Server) logConn(message string) {
+	if s.cfg.QuietConnLogs {
+		s.logger.Debug(message)
+		return
+	}
+	s.logger.Info(message)
+}
+
+// sendAck reports the outcome of a copy to the client. Clients that close
+// their end right after sending (including ones predating acknowledgements)
+// make the write fail with a broken pipe or reset; the copy has already been
+// handled by then, so that case is only logged at DEBUG.
+func (s *Server) sendAck(conn net.Conn, ack protocol.Ack) {
+	if err := conn.SetWriteDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		s.logger.Debug(fmt.Sprintf("Failed to set write deadline: %v", err))
+		return
+	}
+
+	err := protocol.WriteAck(conn, ack)
+	switch {
+	case err == nil:
+	case errors.Is(err, syscall.EPIPE), errors.Is(err, syscall.ECONNRESET), errors.Is(err, net.ErrClosed):
+		s.logger.Debug(fmt.Sprintf("Client %s disconnected before acknowledgement", conn.RemoteAddr()))
+	default:
+		s.logger.Warning(fmt.Sprintf("Failed to send acknowledgement to %s: %v", conn.RemoteAddr(), err))
+	}
+}
+
+// errorAck builds the acknowledgement for a failure, categorizing errors
+// the client can suggest a fix for
+func errorAck(err error) protocol.Ack {
+	ack := protocol.Ack{Error: err.Error()}
+	switch {
+	case errors.Is(err, clipboard.ErrNoSession):
+		ack.Category = protocol.CategoryNoSession
+	case errors.Is(err, clipboard.ErrCommandNotFound):
+		ack.Category = protocol.CategoryBackendMissing
+	case errors.Is(err, clipboard.ErrTimeout):
+		ack.Category = protocol.CategoryTimeout
+	case errors.Is(err, errTooLarge), errors.Is(err, protocol.ErrFrameTooLarge):
+		ack.Category = protocol.CategoryTooLarge
+	case errors.Is(err, errClientTooOld):
+		ack.Category = protocol.CategoryClientTooOld
+	}
+	return ack
+}
+
+// sendStatus replies to a status request with the server's counters
+func (s *Server) sendStatus(conn net.Conn) {
+	status := s.Status()
+	// Don't count the connection asking for the status
+	status.Active--
+
+	if err := conn.SetWriteDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		s.logger.Debug(fmt.Sprintf("Failed to set write deadline: %v", err))
+		return
+	}
+	if err := protocol.WriteStatus(conn, status); err != nil {
+		s.logger.Warning(fmt.Sprintf("Failed to send status to %s: %v", conn.RemoteAddr(), err))
+	}
+}
+
+// handleClear empties the clipboard at a client's request. An explicit clear
+// replaces whatever a pending --clear-after would have cleared, so that is
+// canceled.
+func (s *Server) handleClear(conn net.Conn) {
+	if err := s.copyThroughBreaker([]byte{}, clipboard.TextType); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to clear clipboard: %v", err))
+		s.sendAck(conn, errorAck(err))
+		return
+	}
+	s.autoClear.cancel()
+	s.markActivity()
+	s.logger.Info("Cleared clipboard at the client's request")
+	s.sendAck(conn, protocol.Ack{OK: true, Backend: s.backend.Name()})
+}
+
+// handlePaste sends the clipboard contents back to the client in the first
+// of the accepted types the clipboard holds, or with hashOnly just their
+// SHA-256 in the acknowledgement
+func (s *Server) handlePaste(conn net.Conn, accept []string, hashOnly bool) {
+	remoteAddr := conn.RemoteAddr().String()
+
+	content, err := clipboard.Read(s.backend, accept)
+	if err != nil {
+		err = fmt.Errorf("failed to read clipboard: %w", err)
+		s.logger.Error(fmt.Sprintf("Paste for %s: %v", remoteAddr, err))
+		s.sendAck(conn, errorAck(err))
+		return
+	}
+	s.markActivity()
+
+	ack := protocol.Ack{
+		OK:      true,
+		Bytes:   int64(len(content.Data)),
+		Backend: s.backend.Name(),
+		Type:    content.Type,
+		Types:   content.Types,
+	}
+	// Clients polling to copy only what has changed just need the hash; it
+	// is only logged at DEBUG as they may ask every second
+	if hashOnly {
+		sum := sha256.Sum256(content.Data)
+		ack.SHA256 = hex.EncodeToString(sum[:])
+		s.sendAck(conn, ack)
+		s.logger.Debug(fmt.Sprintf("Sent SHA-256 of %d bytes of %s from clipboard to %s", len(content.Data), content.Type, remoteAddr))
+		return
+	}
+	if err := conn.SetWriteDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		s.logger.Debug(fmt.Sprintf("Failed to set write deadline: %v", err))
+		return
+	}
+	if err := protocol.WriteAck(conn, ack); err != nil {
+		s.logger.Warning(fmt.Sprintf("Failed to send paste to %s: %v", remoteAddr, err))
+		return
+	}
+	if _, err := conn.Write(content.Data); err != nil {
+		s.logger.Warning(fmt.Sprintf("Failed to send paste to %s: %v", remoteAddr, err))
+		return
+	}
+
+	sent := content.Type
+	if content.ConvertedFrom != "" {
+		sent += " (converted from " + content.ConvertedFrom + ")"
+	}
+	s.logger.Info(fmt.Sprintf("Sent %d bytes of %s from clipboard to %s", len(content.Data), sent, remoteAddr))
+}
+
+// handleFollow copies each framed record from a follow-mode client until the
+// client closes the connection or the server shuts down
+func (s *Server) handleFollow(conn net.Conn, reader *bufio.Reader, timeouts *deadlineReader) {
+	remoteAddr := conn.RemoteAddr().String()
+	s.logConn(fmt.Sprintf("Follow mode connection from %s", remoteAddr))
+
+	// Follow connections may sit idle between records
+	stop := s.closeOnShutdown(conn)
+	defer stop()
+
+	records := 0
+	for {
+		err := s.awaitRecord(reader, timeouts)
+		if err == nil {
+			var copied bool
+			if copied, err = s.followRecord(reader); copied {
+				records++
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			select {
+			case <-s.shutdownSignal:
+				s.logConn(fmt.Sprintf("Closing follow mode connection from %s for shutdown", remoteAddr))
+			default:
+				s.logger.Error(fmt.Sprintf("Error reading record from %s: %v", remoteAddr, err))
+			}
+			break
+		}
+	}
+
+	s.logConn(fmt.Sprintf("Follow mode connection from %s closed after %d records", remoteAddr, records))
+}
+
+// followRecord reads one record from a follow connection and copies it,
+// then releases the budget awaitRecord reserved for it. It reports whether
+// the record was copied; a failed copy is logged and leaves the connection
+// open, while an error means the stream can't go on.
+func (s *Server) followRecord(reader *bufio.Reader) (bool, error) {
+	defer s.budget.release(s.cfg.MaxDataSize)
+
+	data, err := protocol.ReadFrame(reader, s.cfg.MaxDataSize)
+	if err != nil {
+		return false, err
+	}
+	data = trim.Apply(s.convert(data), s.cfg.TrimPolicy)
+	if len(data) == 0 {
+		return false, nil
+	}
+	if err := s.deliver(data, sourceFollow); err != nil {
+		s.logger.Error(err.Error())
+		return false, nil
+	}
+	return true, nil
+}
+
+// handleSession copies each framed record from a session client, replying to
+// every one with an acknowledgement, until the client closes the connection
+// or the server shuts down
+func (s *Server) handleSession(conn net.Conn, reader *bufio.Reader, timeouts *deadlineReader, verify bool) {
+	remoteAddr := conn.RemoteAddr().String()
+	s.logConn(fmt.Sprintf("Session connection from %s", remoteAddr))
+
+	// Sessions may sit idle between copies
+	stop := s.closeOnShutdown(conn)
+	defer stop()
+
+	copies := 0
+	for {
+		err := s.awaitRecord(reader, timeouts)
+		if err == nil {
+			var copied bool
+			if copied, err = s.sessionRecord(conn, reader, verify); copied {
+				copies++
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			select {
+			case <-s.shutdownSignal:
+				s.logConn(fmt.Sprintf("Closing session connection from %s for shutdown", remoteAddr))
+			default:
+				// The stream can't be resynchronized after a bad frame
+				s.logger.Error(fmt.Sprintf("Error reading copy from %s: %v", remoteAddr, err))
+				s.sendAck(conn, errorAck(err))
+			}
+			break
+		}
+	}
+
+	s.logConn(fmt.Sprintf("Session connection from %s closed after %d copies", remoteAddr, copies))
+}
+
+// sessionRecord reads one copy from a session connection, copies it and
+// acknowledges it, then releases the budget awaitRecord reserved for it. It
+// reports whether the copy succeeded; a failed copy is acknowledged as such
+// and leaves the session open, while an error means the stream can't go on.
+func (s *Server) sessionRecord(conn net.Conn, reader *bufio.Reader, verify bool) (bool, error) {
+	defer s.budget.release(s.cfg.MaxDataSize)
+
+	data, err := protocol.ReadFrame(reader, s.cfg.MaxDataSize)
+	if err != nil {
+		return false, err
+	}
+	if len(data) == 0 {
+		s.sendAck(conn, protocol.Ack{Error: "received empty data"})
+		return false, nil
+	}
+
+	// The checksum covers the copy as sent, before conversion and trimming
+	received := data
+	data = trim.Apply(s.convert(data), s.cfg.TrimPolicy)
+	if len(data) == 0 {
+		s.sendAck(conn, errorAck(errTrimmedEmpty))
+		return false, nil
+	}
+	if err := s.deliver(data, sourceSession); err != nil {
+		s.logger.Error(err.Error())
+		s.sendAck(conn, errorAck(err))
+		return false, nil
+	}
+
+	ack := protocol.Ack{OK: true, Bytes: int64(len(data)), Backend: s.backend.Name()}
+	if verify {
+		sum := sha256.Sum256(received)
+		ack.SHA256 = hex.EncodeToString(sum[:])
+	}
+	s.sendAck(conn, ack)
+	return true, nil
+}
+
+// awaitRecord waits, for as long as it takes, for the next record on a
+// follow or session connection to start. Once it has, the rest of the record
+// is held to the idle deadline and WARPCLIP_CONN_TIMEOUT like a single copy,
+// and the record reserves its memory budget, which the caller releases once
+// done with it. It returns io.EOF once the client closes the connection.
+func (s *Server) awaitRecord(reader *bufio.Reader, timeouts *deadlineReader) error {
+	// A record already buffered has started
+	if reader.Buffered() == 0 {
+		timeouts.waitForRecord()
+	}
+	if _, err := reader.Peek(1); err != nil {
+		return err
+	}
+	timeouts.startRecord()
+
+	if !s.budget.reserve(s.cfg.MaxDataSize) {
+		return errBudgetExhausted
+	}
+	return nil
+}
+
+// closeOnShutdown closes a long-lived connection when the server shuts down,
+// to unblock a read waiting for its next record. Call the returned function
+// once done with conn.
+func (s *Server) closeOnShutdown(conn net.Conn) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-s.shutdownSignal:
+			conn.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// How a copy arrived, as recorded in the history
+const (
+	sourceCopy    = "copy"
+	sourceFollow  = "follow"
+	sourceSession = "session"
+)
+
+// deliver copies data that arrived from source to the clipboard, first
+// waiting out the debounce window when one is configured
+func (s *Server) deliver(data []byte, source string) error {
+	return s.deliverExpiring(data, source, 0)
+}
+
+// deliverExpiring is deliver for a copy kept in the history for only ttl,
+// or for good if ttl is zero
+func (s *Server) deliverExpiring(data []byte, source string, ttl time.Duration) error {
+	if s.debouncer != nil {
+		return s.debouncer.submit(data, source, ttl)
+	}
+	return s.deliverNow(data, source, ttl)
+}
+
+// deliverBatch copies the latest of count copies coalesced by the debouncer
+func (s *Server) deliverBatch(data []byte, source string, ttl time.Duration, count int) error {
+	if count > 1 {
+		s.logger.Info(fmt.Sprintf("Coalesced %d copies received within %v, copying only the latest", count, s.cfg.Debounce))
+	}
+	return s.deliverNow(data, source, ttl)
+}
+
+// deliverNow copies data to the clipboard and records the activity. Copies
+// fail straight away while the breaker has the backend marked unhealthy.
+func (s *Server) deliverNow(data []byte, source string, ttl time.Duration) (err error) {
+	defer func() { s.notify(len(data), err) }()
+
+	if s.cfg.TransformCommand != "" {
+		transformed, err := s.transform(data)
+		if err != nil {
+			return fmt.Errorf("failed to transform clipboard data: %w", err)
+		}
+		data = transformed
+	}
+
+	if err := s.copyThroughBreaker(data, clipboard.TextType); err != nil {
+		return err
+	}
+	s.recordCopy(data, clipboard.TextType, source, ttl)
+	return nil
+}
+
+// deliverTyped copies data to the clipboard as mimeType and records the
+// activity. The debounce window and transform command are meant for text,
+// so neither applies.
+func (s *Server) deliverTyped(data []byte, mimeType string, ttl time.Duration) (err error) {
+	defer func() { s.notify(len(data), err) }()
+
+	// A bad file list is the client's mistake, not the backend failing
+	if err := clipboard.Validate(mimeType, data); err != nil {
+		return fmt.Errorf("invalid %s: %w", mimeType, err)
+	}
+	if err := s.copyThroughBreaker(data, mimeType); err != nil {
+		return err
+	}
+	s.recordCopy(data, mimeType, sourceCopy, ttl)
+	return nil
+}
+
+// recordCopy updates the counters, last activity file and history after a
+// copy of data from source reached the clipboard as mimeType
+func (s *Server) recordCopy(data []byte, mimeType string, source string, ttl time.Duration) {
+	s.markActivity()
+	s.countCopy(len(data))
+	if s.autoClear.cancel() {
+		s.logger.Info("Canceled the pending clipboard clear, a new copy replaced the data")
+	}
+
 	// Update last activity file
 	if err := s.updateLastActivityFile(len(data)); err != nil {
 		s.logger.Warning(fmt.Sprintf("Failed to update last activity file: %v", err))
 	}
+	if err := s.recordHistory(data, mimeType, source, ttl); err != nil {
+		s.logger.Warning(fmt.Sprintf("Failed to record copy in history: %v", err))
+	}
 
+	if mimeType != clipboard.TextType {
+		s.logger.Info(fmt.Sprintf("Successfully copied %d bytes to clipboard as %s", len(data), mimeType))
+		return
+	}
 	s.logger.Info(fmt.Sprintf("Successfully copied %d bytes to clipboard", len(data)))
 }
 
+// notify gives the configured local feedback for a write of size bytes that
+// failed with err, in the background so it never holds up the reply
+func (s *Server) notify(size int, err error) {
+	if s.notifier == nil {
+		return
+	}
+	go func() {
+		if runErr := s.notifier.notify(size, err); runErr != nil {
+			s.logger.Debug(fmt.Sprintf("Failed to notify of the copy: %v", runErr))
+		}
+	}()
+}
+
+// copyThroughBreaker copies data to the clipboard as mimeType unless the
+// breaker has the backend marked unhealthy, recording the outcome with the
+// breaker
+func (s *Server) copyThroughBreaker(data []byte, mimeType string) error {
+	if err := s.breaker.allow(); err != nil {
+		return fmt.Errorf("failed to copy to clipboard: %w", err)
+	}
+
+	err := s.copyToClipboard(data, mimeType)
+	opened, closed := s.breaker.record(err)
+	switch {
+	case opened:
+		s.logger.Error(fmt.Sprintf("Clipboard backend marked unhealthy after %d consecutive failures, refusing copies for %v", s.breaker.threshold, s.breaker.cooldown))
+	case closed:
+		s.logger.Info("Clipboard backend recovered, accepting copies again")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy to clipboard: %w", err)
+	}
+	return nil
+}
+
+// readData reads a raw payload from a data connection. On a mid-stream failure
+// the returned error records how many bytes had been received, so a truncated
+// copy can be told apart from one that never started. When the client
+// announced the payload size (expected >= 0), receiving any other amount is
+// an error. Payloads longer than MaxDataSize are cut to exactly that size and
+// reported as truncated. A non-nil digest receives the payload as sent,
+// before any conversion.
+func (s *Server) readData(r io.Reader, expected int64, digest io.Writer) ([]byte, bool, error) {
+	// An announced payload is read straight into a buffer of its size
+	capacity := expected
+	if capacity > s.cfg.MaxDataSize {
+		capacity = s.cfg.MaxDataSize
+	}
+	buf := newPayloadBuffer(capacity)
+
+	// Count the bytes as sent, before any line ending conversion; reading one
+	// byte past the announced size is enough to detect an overlong payload
+	var counter *countingReader
+	if expected >= 0 {
+		counter = &countingReader{r: io.LimitReader(r, expected+1)}
+		r = counter
+	}
+	if digest != nil {
+		r = io.TeeReader(r, digest)
+	}
+
+	// Create a limited reader to prevent memory exhaustion; the limit applies
+	// to the data as it will be written to the clipboard. Reading one byte
+	// past it tells a payload of exactly MaxDataSize from a longer one.
+	limitReader := io.LimitReader(s.convertReader(r), s.cfg.MaxDataSize+1)
+	totalRead, err := io.CopyBuffer(buf, limitReader, make([]byte, s.readBufferSize()))
+	if err != nil {
+		return nil, false, fmt.Errorf("read failed after %d bytes: %w", totalRead, err)
+	}
+
+	// Converting line endings can take an announced payload that fits over
+	// the limit, which stops the read short of the announced size
+	if counter != nil && totalRead > s.cfg.MaxDataSize {
+		return nil, false, fmt.Errorf("%w: %d bytes exceeds maximum size of %d bytes once line endings are converted", errTooLarge, expected, s.cfg.MaxDataSize)
+	}
+	if counter != nil && counter.n != expected {
+		return nil, false, fmt.Errorf("expected %d bytes but received %d", expected, counter.n)
+	}
+
+	s.logger.Debug(fmt.Sprintf("Read %d bytes", totalRead))
+	if totalRead > s.cfg.MaxDataSize {
+		return buf.Bytes()[:s.cfg.MaxDataSize], true, nil
+	}
+	return buf.Bytes(), false, nil
+}
+
+// readChunks assembles a chunked payload, acknowledging each chunk once it
+// is stored and asking again for any that arrive corrupt. A chunk sent again
+// after it was stored is acknowledged again, as it was the first reply that
+// went missing. The payload is converted once it is complete, after a
+// non-nil digest receives it as sent.
+func (s *Server) readChunks(conn net.Conn, reader *bufio.Reader, digest io.Writer) ([]byte, error) {
+	buf := newPayloadBuffer(-1)
+	next := 1
+	for {
+		seq, chunk, err := protocol.ReadChunk(reader, s.cfg.MaxDataSize)
+		if errors.Is(err, protocol.ErrChunkCorrupt) {
+			s.logger.Debug(fmt.Sprintf("Chunk %d arrived corrupt, asking for it again", seq))
+			if err := s.sendChunkReply(conn, seq, false); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		done := false
+		switch {
+		case seq > next:
+			return nil, fmt.Errorf("chunk %d arrived before chunk %d", seq, next)
+		case seq < next:
+			s.logger.Debug(fmt.Sprintf("Chunk %d arrived again, acknowledging it again", seq))
+		case int64(buf.Len()+len(chunk)) > s.cfg.MaxDataSize:
+			return nil, fmt.Errorf("%w: chunks exceed maximum size of %d bytes", errTooLarge, s.cfg.MaxDataSize)
+		default:
+			// The empty chunk after the last one ends the payload
+			done = len(chunk) == 0
+			buf.Write(chunk)
+			next++
+		}
+		if err := s.sendChunkReply(conn, seq, true); err != nil {
+			return nil, err
+		}
+		if done {
+			break
+		}
+	}
+
+	s.logger.Debug(fmt.Sprintf("Read %d bytes in %d chunks", buf.Len(), next-2))
+	if digest != nil {
+		digest.Write(buf.Bytes())
+	}
+	return s.convert(buf.Bytes()), nil
+}
+
+// convert strips ANSI escape sequences and converts line endings in data as
+// configured
+func (s *Server) convert(data []byte) []byte {
+	if s.cfg.StripANSI {
+		data = ansi.Strip(data)
+	}
+	return eol.Convert(data, s.cfg.NormalizeEOL)
+}
+
+// convertReader is convert for data streamed from r
+func (s *Server) convertReader(r io.Reader) io.Reader {
+	if s.cfg.StripANSI {
+		r = ansi.NewReader(r)
+	}
+	return eol.NewReader(r, s.cfg.NormalizeEOL)
+}
+
+// sendChunkReply acknowledges the chunk numbered seq, or asks for it again
+func (s *Server) sendChunkReply(conn net.Conn, seq int, ok bool) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return fmt.Errorf("failed to set write deadline: %w", err)
+	}
+	if err := protocol.WriteChunkReply(conn, seq, ok); err != nil {
+		return fmt.Errorf("failed to reply to chunk %d: %w", seq, err)
+	}
+	return nil
+}
+
+// readBufferSize returns the configured read buffer size, or the default for
+// configurations built without one
+func (s *Server) readBufferSize() int {
+	if s.cfg.ReadBufferSize > 0 {
+		return s.cfg.ReadBufferSize
+	}
+	return config.DefaultReadBufferSize
+}
+
+// readTimeout returns how long a connection may go without sending anything
+func (s *Server) readTimeout() time.Duration {
+	if s.cfg.ReadTimeout > 0 {
+		return s.cfg.ReadTimeout
+	}
+	return config.DefaultReadTimeout
+}
+
+// shutdownTimeout returns how long shutdown waits for connections to finish
+func (s *Server) shutdownTimeout() time.Duration {
+	if s.cfg.ShutdownTimeout > 0 {
+		return s.cfg.ShutdownTimeout
+	}
+	return config.DefaultShutdownTimeout
+}
+
+// connTimeout returns how long a copy connection may stay open in total
+func (s *Server) connTimeout() time.Duration {
+	if s.cfg.ConnTimeout > 0 {
+		return s.cfg.ConnTimeout
+	}
+	return config.DefaultConnTimeout
+}
+
+// deadlineReader reads from a connection, failing a read that waits longer
+// than the idle timeout or runs past the connection's overall deadline. Each
+// read gets a fresh idle timeout, so a slow but steady sender isn't cut off.
+type deadlineReader struct {
+	conn     net.Conn
+	idle     time.Duration
+	limit    time.Duration
+	deadline time.Time
+	waiting  bool
+}
+
+// newDeadlineReader creates a deadlineReader for conn, which may stay open
+// for limit from now
+func newDeadlineReader(conn net.Conn, idle, limit time.Duration) *deadlineReader {
+	return &deadlineReader{conn: conn, idle: idle, limit: limit, deadline: time.Now().Add(limit)}
+}
+
+// waitForRecord lets the next read wait without a deadline, for a
+// connection between records
+func (r *deadlineReader) waitForRecord() {
+	r.waiting = true
+}
+
+// startRecord gives a record that has started the whole limit from now
+func (r *deadlineReader) startRecord() {
+	r.waiting = false
+	r.deadline = time.Now().Add(r.limit)
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	if r.waiting {
+		if err := r.conn.SetReadDeadline(time.Time{}); err != nil {
+			return 0, fmt.Errorf("failed to clear read deadline: %w", err)
+		}
+		r.waiting = false
+		return r.conn.Read(p)
+	}
+
+	deadline := time.Now().Add(r.idle)
+	if deadline.After(r.deadline) {
+		deadline = r.deadline
+	}
+	if err := r.conn.SetReadDeadline(deadline); err != nil {
+		return 0, fmt.Errorf("failed to set read deadline: %w", err)
+	}
+
+	n, err := r.conn.Read(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		if !time.Now().Before(r.deadline) {
+			return n, fmt.Errorf("connection open longer than the %v limit: %w", r.limit, err)
+		}
+		return n, fmt.Errorf("nothing received for %v: %w", r.idle, err)
+	}
+	return n, err
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
 // cleanupOldConnections removes stale connection records periodically
 func (s *Server) cleanupOldConnections() {
 	s.connMutex.Lock()
@@ -199,8 +1393,9 @@ func (s *Server) cleanupOldConnections() {
 	}
 }
 
-// copyToClipboard copies data to the system clipboard using pbcopy
-func (s *Server) copyToClipboard(data []byte) error {
+// copyToClipboard copies data to the system clipboard as mimeType using the
+// configured backend
+func (s *Server) copyToClipboard(data []byte, mimeType string) error {
 	// Add retry logic for reliability
 	maxRetries := 3
 	var lastErr error
@@ -211,9 +1406,13 @@ func (s *Server) copyToClipboard(data []byte) error {
 			time.Sleep(time.Duration(100*attempt) * time.Millisecond) // Backoff
 		}
 		
-		if err := s.copyToClipboardOnce(data); err != nil {
+		if err := s.copyToClipboardOnce(data, mimeType); err != nil {
 			lastErr = err
 			s.logger.Warning(fmt.Sprintf("Clipboard operation failed: %v", err))
+			// Retrying won't put the daemon in a desktop session
+			if errors.Is(err, errNeedsGUISession) {
+				return err
+			}
 			continue
 		}
 		
@@ -223,62 +1422,32 @@ func (s *Server) copyToClipboard(data []byte) error {
 	return fmt.Errorf("failed after %d attempts: %w", maxRetries, lastErr)
 }
 
-// copyToClipboardOnce performs a single clipboard operation
-func (s *Server) copyToClipboardOnce(data []byte) error {
-	// Create pbcopy command
-	cmd := exec.Command("pbcopy")
-	
-	// Get stdin pipe
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return fmt.Errorf("failed to get stdin pipe: %w", err)
-	}
-	
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start pbcopy: %w", err)
-	}
-	
-	// Create a buffered writer for better performance
-	writer := bufio.NewWriter(stdin)
-	
-	// Write data to stdin
-	_, err = writer.Write(data)
-	if err != nil {
-		stdin.Close()
-		return fmt.Errorf("failed to write data to pbcopy: %w", err)
-	}
-	
-	// Flush the buffer
-	if err := writer.Flush(); err != nil {
-		stdin.Close()
-		return fmt.Errorf("failed to flush data to pbcopy: %w", err)
+// clearClipboard empties the clipboard once a --clear-after delay is up
+func (s *Server) clearClipboard() {
+	if err := s.copyThroughBreaker([]byte{}, clipboard.TextType); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to clear clipboard: %v", err))
+		return
 	}
-	
-	// Close stdin
-	if err := stdin.Close(); err != nil {
-		return fmt.Errorf("failed to close stdin: %w", err)
+	s.logger.Info("Cleared clipboard as requested with --clear-after")
+}
+
+// copyToClipboardOnce performs a single clipboard operation. A backend that
+// can't reach the desktop gets an error saying where warpclipd must run, as
+// the program's own message rarely makes that clear.
+func (s *Server) copyToClipboardOnce(data []byte, mimeType string) error {
+	// Failed writes count too, as a backend timing out is the slowest case
+	start := time.Now()
+	err := clipboard.Write(s.backend, mimeType, data)
+	elapsed := time.Since(start)
+	s.latency.record(elapsed)
+	s.logger.Debug(fmt.Sprintf("Clipboard write of %d bytes took %v", len(data), elapsed.Round(time.Microsecond)))
+	if chain, ok := s.backend.(*clipboard.ChainBackend); ok && err == nil {
+		s.logger.Info(fmt.Sprintf("Clipboard write handled by %s", chain.Name()))
 	}
-	
-	// Wait for the command to finish with timeout
-	done := make(chan error, 1)
-	go func() {
-		done <- cmd.Wait()
-	}()
-	
-	// Wait for completion or timeout
-	select {
-	case err := <-done:
-		if err != nil {
-			return fmt.Errorf("pbcopy command failed: %w", err)
-		}
-	case <-time.After(5 * time.Second):
-		// Kill the process if it takes too long
-		cmd.Process.Kill()
-		return fmt.Errorf("pbcopy operation timed out after 5 seconds")
+	if errors.Is(err, clipboard.ErrNoSession) {
+		return fmt.Errorf("%w: %w", errNeedsGUISession, err)
 	}
-	
-	return nil
+	return err
 }
 
 // updateLastActivityFile updates the last activity file with timestamp and data size
@@ -300,6 +1469,82 @@ func (s *Server) updateLastActivityFile(dataSize int) error {
 	return nil
 }
 
+// transform pipes data through WARPCLIP_TRANSFORM_CMD and returns its output,
+// which must be neither empty nor over the size limit
+func (s *Server) transform(data []byte) ([]byte, error) {
+	output, err := clipboard.Filter(s.cfg.TransformCommand, data, clipboard.DefaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if len(output) == 0 {
+		return nil, errTransformedEmpty
+	}
+	if int64(len(output)) > s.cfg.MaxDataSize {
+		return nil, fmt.Errorf("%w: transform command printed %d bytes, more than the maximum of %d", errTooLarge, len(output), s.cfg.MaxDataSize)
+	}
+	s.logger.Debug(fmt.Sprintf("Transform command turned %d bytes into %d", len(data), len(output)))
+	return output, nil
+}
+
+// recordHistory adds a copy of data from source to the history file, to be
+// purged after ttl unless that is zero. The type recorded is mimeType, or
+// for plain text the one detected from the data. The content itself is
+// never written there.
+func (s *Server) recordHistory(data []byte, mimeType string, source string, ttl time.Duration) error {
+	if s.cfg.HistoryFile == "" {
+		return nil
+	}
+	if mimeType == clipboard.TextType {
+		mimeType, _, _ = strings.Cut(http.DetectContentType(data), ";")
+	}
+	entry := history.Entry{
+		Time:   time.Now(),
+		Bytes:  int64(len(data)),
+		Type:   mimeType,
+		Source: source,
+	}
+	if ttl > 0 {
+		entry.Expires = entry.Time.Add(ttl)
+	}
+
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+	return history.Append(s.cfg.HistoryFile, entry)
+}
+
+// maintainHistory purges expired entries from the history file every
+// historyPurgeInterval until done is closed
+func (s *Server) maintainHistory(done <-chan struct{}) {
+	if s.cfg.HistoryFile == "" {
+		return
+	}
+	ticker := time.NewTicker(s.historyPurgeInterval)
+	defer ticker.Stop()
+	for {
+		s.purgeHistory()
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// purgeHistory drops the entries whose time to live has run out from the
+// history file
+func (s *Server) purgeHistory() {
+	s.historyMutex.Lock()
+	purged, err := history.Purge(s.cfg.HistoryFile, time.Now())
+	s.historyMutex.Unlock()
+	if err != nil {
+		s.logger.Warning(fmt.Sprintf("Failed to purge expired history entries: %v", err))
+		return
+	}
+	if purged > 0 {
+		s.logger.Info(fmt.Sprintf("Purged %d expired entries from the history", purged))
+	}
+}
+
 // writePidFile writes the current process ID to the PID file
 func (s *Server) writePidFile() error {
 	// Get current process ID
@@ -1,19 +1,31 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strconv"
+	"strings"
+	"syscall"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/mquinnv/warpclip/v2/internal/clipboard"
 	"github.com/mquinnv/warpclip/v2/internal/config"
+	"github.com/mquinnv/warpclip/v2/internal/eol"
+	"github.com/mquinnv/warpclip/v2/internal/history"
+	"github.com/mquinnv/warpclip/v2/internal/protocol"
+	"github.com/mquinnv/warpclip/v2/internal/trim"
 )
 
 // MockLogger is a simple test implementation of the Logger interface
@@ -62,51 +74,6 @@ func (m *MockLogger) GetLogs() []string {
 	return append([]string{}, m.logs...) // Return a copy
 }
 
-// MockCmd simulates the pbcopy command for testing
-type MockCmd struct {
-	data      []byte
-	dataStore *[]byte
-}
-
-func NewMockCmd(dataStore *[]byte) *MockCmd {
-	return &MockCmd{
-		data:      make([]byte, 0),
-		dataStore: dataStore,
-	}
-}
-
-func (m *MockCmd) StdinPipe() (io.WriteCloser, error) {
-	return &MockStdinPipe{mockCmd: m}, nil
-}
-
-func (m *MockCmd) Start() error {
-	return nil
-}
-
-func (m *MockCmd) Wait() error {
-	*m.dataStore = m.data
-	return nil
-}
-
-// MockStdinPipe simulates an io.WriteCloser for testing
-type MockStdinPipe struct {
-	mockCmd *MockCmd
-	closed  bool
-}
-
-func (m *MockStdinPipe) Write(p []byte) (n int, err error) {
-	if m.closed {
-		return 0, io.ErrClosedPipe
-	}
-	m.mockCmd.data = append(m.mockCmd.data, p...)
-	return len(p), nil
-}
-
-func (m *MockStdinPipe) Close() error {
-	m.closed = true
-	return nil
-}
-
 // TestServer tests the server creation and basic functionality
 func TestServer(t *testing.T) {
 	// Create temporary directory for test files
@@ -119,6 +86,7 @@ func TestServer(t *testing.T) {
 	// Create test configuration
 	cfg := &config.Config{
 		Port:        12345, // Use high port for testing
+		BindAddress: "127.0.0.1",
 		LogFile:     filepath.Join(tempDir, "test.log"),
 		PidFile:     filepath.Join(tempDir, "test.pid"),
 		LastFile:    filepath.Join(tempDir, "test.last"),
@@ -129,7 +97,8 @@ func TestServer(t *testing.T) {
 	logger := NewMockLogger()
 
 	// Create server
-	srv := New(cfg, logger)
+	backend := clipboard.NewMemoryBackend()
+	srv := NewWithBackend(cfg, logger, backend)
 
 	// Create context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -162,6 +131,14 @@ func TestServer(t *testing.T) {
 		}
 	}
 
+	// Test the sidecar records where the daemon listens
+	info, err := ReadPidInfo(cfg.PidFile)
+	if err != nil {
+		t.Errorf("Failed to read PID sidecar: %v", err)
+	} else if info.PID != os.Getpid() || info.BindAddress != cfg.BindAddress || info.Port != cfg.Port || info.Started.IsZero() {
+		t.Errorf("Unexpected PID sidecar: %+v", info)
+	}
+
 	// Connect to server
 	addr := fmt.Sprintf("127.0.0.1:%d", cfg.Port)
 	conn, err := net.Dial("tcp", addr)
@@ -184,7 +161,7 @@ func TestServer(t *testing.T) {
 	logs := logger.GetLogs()
 	foundConnLog := false
 	for _, log := range logs {
-		if fmt.Sprintf("INFO: New connection from") {
+		if strings.HasPrefix(log, "INFO: New connection from") {
 			foundConnLog = true
 			break
 		}
@@ -193,6 +170,11 @@ func TestServer(t *testing.T) {
 		t.Error("No log entry for connection found")
 	}
 
+	// Check the data reached the clipboard backend
+	if copied, _ := backend.Paste(); string(copied) != testData {
+		t.Errorf("Clipboard data doesn't match: got %q, want %q", string(copied), testData)
+	}
+
 	// Check for last activity file
 	if _, err := os.Stat(cfg.LastFile); os.IsNotExist(err) {
 		t.Errorf("Last activity file not created: %v", err)
@@ -201,7 +183,7 @@ func TestServer(t *testing.T) {
 		lastData, err := os.ReadFile(cfg.LastFile)
 		if err != nil {
 			t.Errorf("Failed to read last activity file: %v", err)
-		} else if !fmt.Sprintf("%d bytes", len(testData)) {
+		} else if !strings.Contains(string(lastData), fmt.Sprintf("%d bytes", len(testData))) {
 			t.Errorf("Last activity file doesn't contain expected data size")
 		}
 	}
@@ -223,50 +205,34 @@ func TestServer(t *testing.T) {
 	if _, err := os.Stat(cfg.PidFile); !os.IsNotExist(err) {
 		t.Error("PID file not removed after shutdown")
 	}
+	if _, err := os.Stat(PidInfoFile(cfg.PidFile)); !os.IsNotExist(err) {
+		t.Error("PID sidecar not removed after shutdown")
+	}
 }
 
 // TestCopyToClipboard tests clipboard integration
 func TestCopyToClipboard(t *testing.T) {
-	// Skip test in CI environment
-	if os.Getenv("CI") != "" {
-		t.Skip("Skipping clipboard test in CI environment")
-	}
-
 	// Mock configuration
 	cfg := &config.Config{}
-	
+
 	// Mock logger
 	logger := NewMockLogger()
-	
+
 	// Create server
-	srv := New(cfg, logger)
-	
-	// Mock clipboard data
-	clipboardData := []byte{}
-	
-	// Save original exec.Command function and restore at the end
-	origExecCommand := execCommand
-	defer func() { execCommand = origExecCommand }()
-	
-	// Mock the exec.Command function to return our mock
-	execCommand = func(name string, args ...string) *exec.Cmd {
-		if name != "pbcopy" {
-			t.Errorf("Expected pbcopy command, got %s", name)
-		}
-		mockCmd := NewMockCmd(&clipboardData)
-		return mockCmd
-	}
-	
+	backend := clipboard.NewMemoryBackend()
+	srv := NewWithBackend(cfg, logger, backend)
+
 	// Test data
 	testData := []byte("Hello, clipboard!")
-	
+
 	// Call copyToClipboard
-	err := srv.copyToClipboard(testData)
+	err := srv.copyToClipboard(testData, clipboard.TextType)
 	if err != nil {
 		t.Fatalf("copyToClipboard failed: %v", err)
 	}
-	
+
 	// Verify data was copied to clipboard
+	clipboardData, _ := backend.Paste()
 	if string(clipboardData) != string(testData) {
 		t.Errorf("Clipboard data doesn't match: got %q, want %q", string(clipboardData), string(testData))
 	}
@@ -291,7 +257,7 @@ func TestUpdateLastActivityFile(t *testing.T) {
 	logger := NewMockLogger()
 	
 	// Create server
-	srv := New(cfg, logger)
+	srv := NewWithBackend(cfg, logger, clipboard.NewMemoryBackend())
 	
 	// Test updating last activity file
 	dataSize := 123
@@ -312,7 +278,7 @@ func TestUpdateLastActivityFile(t *testing.T) {
 	}
 	
 	// Verify content contains data size
-	if fmt.Sprintf("%d bytes", dataSize) {
+	if !strings.Contains(string(content), fmt.Sprintf("%d bytes", dataSize)) {
 		t.Errorf("Last activity file doesn't contain expected data size")
 	}
 	
@@ -327,3 +293,2852 @@ func TestUpdateLastActivityFile(t *testing.T) {
 	}
 }
 
+
+// startTestServer starts srv in the background and returns a function that
+// shuts it down and waits for Start to return
+func startTestServer(t *testing.T, srv *Server) func() {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- srv.Start(ctx)
+	}()
+
+	// Wait a bit for server to start
+	time.Sleep(100 * time.Millisecond)
+
+	return func() {
+		cancel()
+		select {
+		case err := <-serverErr:
+			if err != nil {
+				t.Errorf("Server returned error: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Server didn't shut down within timeout")
+		}
+	}
+}
+
+// newTestConfig returns a configuration that keeps all state in tempDir
+func newTestConfig(tempDir string, port int) *config.Config {
+	return &config.Config{
+		Port:        port,
+		BindAddress: "127.0.0.1",
+		LogFile:     filepath.Join(tempDir, "test.log"),
+		PidFile:     filepath.Join(tempDir, "test.pid"),
+		LastFile:    filepath.Join(tempDir, "test.last"),
+		HistoryFile: filepath.Join(tempDir, "test.history"),
+		ClipboardFile: filepath.Join(tempDir, "test.clipboard"),
+		MaxDataSize: 1024,
+	}
+}
+
+// TestFollowMode tests that framed records on one connection each update the clipboard
+func TestFollowMode(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12346)
+	backend := clipboard.NewMemoryBackend()
+	srv := NewWithBackend(cfg, NewMockLogger(), backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+
+	if _, err := conn.Write([]byte(protocol.FollowPreamble)); err != nil {
+		t.Fatalf("Failed to send preamble: %v", err)
+	}
+	for _, record := range []string{"one", "two", "three"} {
+		if err := protocol.WriteFrame(conn, []byte(record)); err != nil {
+			t.Fatalf("Failed to send record: %v", err)
+		}
+	}
+	conn.Close()
+
+	// Wait a bit for data processing
+	time.Sleep(100 * time.Millisecond)
+
+	if backend.Copies() != 3 {
+		t.Errorf("Expected 3 clipboard updates, got %d", backend.Copies())
+	}
+	if data, _ := backend.Paste(); string(data) != "three" {
+		t.Errorf("Clipboard data doesn't match: got %q, want %q", data, "three")
+	}
+}
+
+// TestFollowModeShutdown tests that an idle follow connection doesn't block shutdown
+func TestFollowModeShutdown(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12347)
+	srv := NewWithBackend(cfg, NewMockLogger(), clipboard.NewMemoryBackend())
+	stop := startTestServer(t, srv)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(protocol.FollowPreamble)); err != nil {
+		t.Fatalf("Failed to send preamble: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	stop()
+}
+
+// TestAcknowledgement tests that the server reports the copied size to the client
+func TestAcknowledgement(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12348)
+	srv := NewWithBackend(cfg, NewMockLogger(), clipboard.NewMemoryBackend())
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	testData := "Test clipboard data"
+	if _, err := conn.Write([]byte(testData)); err != nil {
+		t.Fatalf("Failed to send data: %v", err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	ack, err := protocol.ReadAck(bufio.NewReader(conn))
+	if err != nil {
+		t.Fatalf("Failed to read acknowledgement: %v", err)
+	}
+	if !ack.OK || ack.Bytes != int64(len(testData)) || ack.Backend != "memory" {
+		t.Errorf("Unexpected acknowledgement: %+v", ack)
+	}
+}
+
+// slowBackend delays each copy so tests can act while a copy is in progress
+type slowBackend struct {
+	*clipboard.MemoryBackend
+	delay time.Duration
+}
+
+func (b *slowBackend) Copy(data []byte) error {
+	time.Sleep(b.delay)
+	return b.MemoryBackend.Copy(data)
+}
+
+// TestClientDisconnectBeforeAck tests that a client vanishing before the
+// acknowledgement doesn't fail the copy or log an error
+func TestClientDisconnectBeforeAck(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12349)
+	logger := NewMockLogger()
+	backend := &slowBackend{MemoryBackend: clipboard.NewMemoryBackend(), delay: 200 * time.Millisecond}
+	srv := NewWithBackend(cfg, logger, backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	if _, err := conn.Write([]byte("disconnecting client")); err != nil {
+		t.Fatalf("Failed to send data: %v", err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+
+	// Vanish while the copy is in progress; discarding the socket with no
+	// linger resets the connection so the acknowledgement write fails
+	time.Sleep(50 * time.Millisecond)
+	conn.(*net.TCPConn).SetLinger(0)
+	conn.Close()
+
+	// Wait for the copy and acknowledgement attempt
+	time.Sleep(400 * time.Millisecond)
+
+	if data, _ := backend.Paste(); string(data) != "disconnecting client" {
+		t.Errorf("Clipboard data doesn't match: got %q", data)
+	}
+	for _, entry := range logger.GetLogs() {
+		if strings.HasPrefix(entry, "ERROR:") || strings.HasPrefix(entry, "WARNING:") {
+			t.Errorf("Unexpected log entry: %s", entry)
+		}
+	}
+}
+
+// TestVerifyChecksum tests that a verify request is acknowledged with the payload's SHA-256
+func TestVerifyChecksum(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12350)
+	backend := clipboard.NewMemoryBackend()
+	srv := NewWithBackend(cfg, NewMockLogger(), backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	testData := "verified clipboard data"
+	if _, err := conn.Write([]byte(protocol.VerifyDirective + testData)); err != nil {
+		t.Fatalf("Failed to send data: %v", err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	ack, err := protocol.ReadAck(bufio.NewReader(conn))
+	if err != nil {
+		t.Fatalf("Failed to read acknowledgement: %v", err)
+	}
+
+	sum := sha256.Sum256([]byte(testData))
+	if ack.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Acknowledged checksum %q doesn't match payload", ack.SHA256)
+	}
+	if data, _ := backend.Paste(); string(data) != testData {
+		t.Errorf("Clipboard data doesn't match: got %q, want %q", data, testData)
+	}
+}
+
+// TestVerifyConverted tests that the checksum covers the payload as sent
+// when the server converts line endings and strips escape sequences, for a
+// plain, a chunked and a session copy
+func TestVerifyConverted(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12389)
+	cfg.NormalizeEOL = eol.LF
+	cfg.StripANSI = true
+	backend := clipboard.NewMemoryBackend()
+	srv := NewWithBackend(cfg, NewMockLogger(), backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	payload := "\x1b[31mred\x1b[0m line\r\nplain line\r\n"
+	sum := sha256.Sum256([]byte(payload))
+	want := hex.EncodeToString(sum[:])
+	copied := "red line\nplain line\n"
+
+	send := map[string]func(conn net.Conn, reader *bufio.Reader) (protocol.Ack, error){
+		"plain": func(conn net.Conn, reader *bufio.Reader) (protocol.Ack, error) {
+			conn.Write([]byte(protocol.VerifyDirective + payload))
+			conn.(*net.TCPConn).CloseWrite()
+			return protocol.ReadAck(reader)
+		},
+		"chunked": func(conn net.Conn, reader *bufio.Reader) (protocol.Ack, error) {
+			conn.Write([]byte(protocol.VerifyDirective))
+			return protocol.SendChunked(conn, reader, []byte(payload), 8, 2*time.Second)
+		},
+		"session": func(conn net.Conn, reader *bufio.Reader) (protocol.Ack, error) {
+			conn.Write([]byte(protocol.VerifyDirective + protocol.SessionPreamble))
+			if err := protocol.WriteFrame(conn, []byte(payload)); err != nil {
+				return protocol.Ack{}, err
+			}
+			return protocol.ReadAck(reader)
+		},
+	}
+	for name, fn := range send {
+		t.Run(name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+			if err != nil {
+				t.Fatalf("Failed to connect to server: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+			ack, err := fn(conn, bufio.NewReader(conn))
+			if err != nil {
+				t.Fatalf("Copy failed: %v", err)
+			}
+			if !ack.OK || ack.SHA256 != want {
+				t.Errorf("Acknowledged %+v, want the checksum of the payload as sent", ack)
+			}
+			if data, _ := backend.Paste(); string(data) != copied {
+				t.Errorf("Clipboard holds %q, want %q", data, copied)
+			}
+		})
+	}
+}
+
+// TestIdleShutdown tests that the server exits after the idle timeout, but
+// not while a connection is open
+func TestIdleShutdown(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12351)
+	cfg.IdleTimeout = 300 * time.Millisecond
+	srv := NewWithBackend(cfg, NewMockLogger(), clipboard.NewMemoryBackend())
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- srv.Start(context.Background())
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// An open follow connection keeps the server alive past the timeout
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	if _, err := conn.Write([]byte(protocol.FollowPreamble)); err != nil {
+		t.Fatalf("Failed to send preamble: %v", err)
+	}
+
+	select {
+	case err := <-serverErr:
+		t.Fatalf("Server exited with a connection open: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	conn.Close()
+
+	select {
+	case err := <-serverErr:
+		if err != nil {
+			t.Errorf("Server returned error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Server didn't shut down after idle timeout")
+	}
+
+	if _, err := os.Stat(cfg.PidFile); !os.IsNotExist(err) {
+		t.Errorf("PID file not removed after idle shutdown: %v", err)
+	}
+}
+
+// TestNormalizeEOL tests that the server rewrites line endings before copying
+func TestNormalizeEOL(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12352)
+	cfg.NormalizeEOL = eol.LF
+	backend := clipboard.NewMemoryBackend()
+	srv := NewWithBackend(cfg, NewMockLogger(), backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("line one\r\nline two\r\n")); err != nil {
+		t.Fatalf("Failed to send data: %v", err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	ack, err := protocol.ReadAck(bufio.NewReader(conn))
+	if err != nil {
+		t.Fatalf("Failed to read acknowledgement: %v", err)
+	}
+
+	expected := "line one\nline two\n"
+	if data, _ := backend.Paste(); string(data) != expected {
+		t.Errorf("Clipboard data doesn't match: got %q, want %q", data, expected)
+	}
+	if ack.Bytes != int64(len(expected)) {
+		t.Errorf("Acknowledged %d bytes, want %d", ack.Bytes, len(expected))
+	}
+}
+
+// TestConvertedTooLarge tests that a payload which fits the limit as sent
+// but not once its line endings are converted is refused as too large
+func TestConvertedTooLarge(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12391)
+	cfg.NormalizeEOL = eol.CRLF
+	backend := clipboard.NewMemoryBackend()
+	srv := NewWithBackend(cfg, NewMockLogger(), backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	// Exactly the limit as sent, half as much again as "a\r\n" lines
+	payload := strings.Repeat("a\n", int(cfg.MaxDataSize)/2)
+	protocol.WriteContentLength(conn, int64(len(payload)))
+	if _, err := conn.Write([]byte(payload)); err != nil {
+		t.Fatalf("Failed to send data: %v", err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	ack, err := protocol.ReadAck(bufio.NewReader(conn))
+	if err != nil {
+		t.Fatalf("Failed to read acknowledgement: %v", err)
+	}
+	if ack.OK || ack.Category != protocol.CategoryTooLarge || !strings.Contains(ack.Error, "once line endings are converted") {
+		t.Errorf("Acknowledged %+v, want it refused as too large", ack)
+	}
+	if backend.Copies() != 0 {
+		t.Errorf("Expected no clipboard updates, got %d", backend.Copies())
+	}
+}
+
+// TestStripANSI tests that colorized output is copied as plain text when
+// the server strips escape sequences, for both a plain and a chunked payload
+func TestStripANSI(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12378)
+	cfg.StripANSI = true
+	backend := clipboard.NewMemoryBackend()
+	srv := NewWithBackend(cfg, NewMockLogger(), backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	colored := "\x1b[01;34mdocs\x1b[0m  \x1b[01;32mbuild.sh\x1b[0m\n"
+	expected := "docs  build.sh\n"
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+	// Splitting the writes inside a sequence exercises the streaming filter
+	conn.Write([]byte(colored[:3]))
+	time.Sleep(50 * time.Millisecond)
+	conn.Write([]byte(colored[3:]))
+	conn.(*net.TCPConn).CloseWrite()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	ack, err := protocol.ReadAck(bufio.NewReader(conn))
+	if err != nil {
+		t.Fatalf("Failed to read acknowledgement: %v", err)
+	}
+	if data, _ := backend.Paste(); string(data) != expected {
+		t.Errorf("Clipboard data doesn't match: got %q, want %q", data, expected)
+	}
+	if ack.Bytes != int64(len(expected)) {
+		t.Errorf("Acknowledged %d bytes, want %d", ack.Bytes, len(expected))
+	}
+
+	chunked, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer chunked.Close()
+	ack, err = protocol.SendChunked(chunked, bufio.NewReader(chunked), []byte("\x1b[1mbold\x1b[22m"), 4, 2*time.Second)
+	if err != nil || !ack.OK {
+		t.Fatalf("Chunked copy failed: %v %+v", err, ack)
+	}
+	if data, _ := backend.Paste(); string(data) != "bold" {
+		t.Errorf("Clipboard data doesn't match: got %q, want %q", data, "bold")
+	}
+}
+
+// TestTrimPolicy tests that whitespace is trimmed on the server while the
+// checksum still covers what the client sent
+func TestTrimPolicy(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12360)
+	cfg.TrimPolicy = trim.BothEnds
+	backend := clipboard.NewMemoryBackend()
+	srv := NewWithBackend(cfg, NewMockLogger(), backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	send := func(payload string) protocol.Ack {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+		if err != nil {
+			t.Fatalf("Failed to connect to server: %v", err)
+		}
+		defer conn.Close()
+		conn.Write([]byte(protocol.VerifyDirective + payload))
+		conn.(*net.TCPConn).CloseWrite()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		ack, err := protocol.ReadAck(bufio.NewReader(conn))
+		if err != nil {
+			t.Fatalf("Failed to read acknowledgement: %v", err)
+		}
+		return ack
+	}
+
+	payload := "\n  trimmed text \n\n"
+	ack := send(payload)
+	if data, _ := backend.Paste(); string(data) != "trimmed text" {
+		t.Errorf("Clipboard holds %q, want %q", data, "trimmed text")
+	}
+	sum := sha256.Sum256([]byte(payload))
+	if !ack.OK || ack.Bytes != int64(len("trimmed text")) || ack.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Unexpected acknowledgement: %+v", ack)
+	}
+
+	if ack := send(" \t\n"); ack.OK {
+		t.Error("Expected whitespace-only payload to be rejected")
+	}
+	if data, _ := backend.Paste(); string(data) != "trimmed text" {
+		t.Errorf("Whitespace-only payload replaced the clipboard with %q", data)
+	}
+}
+
+// TestStatus tests that a status request reports connection counters
+func TestStatus(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12353)
+	backend := clipboard.NewMemoryBackend()
+	srv := NewWithBackend(cfg, NewMockLogger(), backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	address := fmt.Sprintf("127.0.0.1:%d", cfg.Port)
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	conn.Write([]byte("Test clipboard data"))
+	conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err = net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(protocol.StatusDirective)); err != nil {
+		t.Fatalf("Failed to send status request: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	status, err := protocol.ReadStatus(bufio.NewReader(conn))
+	if err != nil {
+		t.Fatalf("Failed to read status: %v", err)
+	}
+
+	if time.Since(status.LastCopy) > time.Minute {
+		t.Errorf("Status reports last copy at %v, want just now", status.LastCopy)
+	}
+	status.LastCopy = time.Time{}
+	if status.CopyLatency == nil || status.CopyLatency.Samples != 1 {
+		t.Errorf("Status reports write latency %+v, want one write", status.CopyLatency)
+	}
+	status.CopyLatency = nil
+	if hostname, _ := os.Hostname(); status.Hostname != hostname {
+		t.Errorf("Status reports host %q, want %q", status.Hostname, hostname)
+	}
+	status.Hostname = ""
+	expected := protocol.Status{Accepted: 2, Copies: 1, Bytes: uint64(len("Test clipboard data"))}
+	if status != expected {
+		t.Errorf("Status = %+v, want %+v", status, expected)
+	}
+	// The status request must not reach the clipboard
+	if backend.Copies() != 1 {
+		t.Errorf("Expected 1 clipboard update, got %d", backend.Copies())
+	}
+}
+
+// TestCapabilities tests that a capabilities request is answered with what
+// the server supports and copies nothing
+func TestCapabilities(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12386)
+	cfg.MinClientVersion = protocol.ClearVersion
+	backend := clipboard.NewMemoryBackend()
+	srv := NewWithBackend(cfg, NewMockLogger(), backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	if _, err := protocol.Handshake(conn, reader, 2*time.Second); err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+	if _, err := conn.Write([]byte(protocol.CapsDirective)); err != nil {
+		t.Fatalf("Failed to send capabilities request: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	caps, err := protocol.ReadCapabilities(reader)
+	if err != nil {
+		t.Fatalf("Failed to read capabilities: %v", err)
+	}
+	expected := protocol.Capabilities{
+		Version:          protocol.Version,
+		Features:         protocol.Features(protocol.Version),
+		Backend:          "memory",
+		CopyTypes:        []string{clipboard.TextType, clipboard.AnyType},
+		MaxDataSize:      cfg.MaxDataSize,
+		MinClientVersion: protocol.ClearVersion,
+	}
+	if !reflect.DeepEqual(caps, expected) {
+		t.Errorf("Capabilities = %+v, want %+v", caps, expected)
+	}
+	if backend.Copies() != 0 {
+		t.Errorf("Expected no clipboard updates, got %d", backend.Copies())
+	}
+}
+
+// TestShutdownDropsQueuedConnections tests that connections still queued at
+// shutdown are closed, counted and logged
+func TestShutdownDropsQueuedConnections(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	logger := NewMockLogger()
+	srv := NewWithBackend(newTestConfig(tempDir, 0), logger, clipboard.NewMemoryBackend())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	srv.listener = listener
+	srv.connCh = make(chan net.Conn, connQueueSize)
+
+	client, queued := net.Pipe()
+	defer client.Close()
+	srv.connCh <- queued
+
+	acceptDone := make(chan struct{})
+	close(acceptDone)
+	srv.shutdown(acceptDone)
+
+	if dropped := srv.Status().Dropped; dropped != 1 {
+		t.Errorf("Expected 1 dropped connection, got %d", dropped)
+	}
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected queued connection to be closed, got %v", err)
+	}
+
+	found := false
+	for _, entry := range logger.GetLogs() {
+		if strings.HasPrefix(entry, "WARNING: Dropped connection") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Dropped connection not logged: %v", logger.GetLogs())
+	}
+}
+
+// TestContentLength tests that an announced payload size is enforced
+func TestContentLength(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12354)
+	backend := clipboard.NewMemoryBackend()
+	srv := NewWithBackend(cfg, NewMockLogger(), backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	testCases := []struct {
+		name    string
+		payload string
+		wantErr string
+	}{
+		{name: "exact", payload: "Content-Length: 5\nhello"},
+		{name: "short write", payload: "Content-Length: 10\nhello", wantErr: "expected 10 bytes but received 5"},
+		{name: "overlong", payload: "Content-Length: 3\nhello", wantErr: "expected 3 bytes but received 4"},
+		{name: "too large", payload: "Content-Length: 4096\nhello", wantErr: "exceeds maximum size"},
+		{name: "with verify", payload: protocol.VerifyDirective + "Content-Length: 5\nhello"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+			if err != nil {
+				t.Fatalf("Failed to connect to server: %v", err)
+			}
+			defer conn.Close()
+
+			if _, err := conn.Write([]byte(tc.payload)); err != nil {
+				t.Fatalf("Failed to send data: %v", err)
+			}
+			conn.(*net.TCPConn).CloseWrite()
+
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			ack, err := protocol.ReadAck(bufio.NewReader(conn))
+			if err != nil {
+				t.Fatalf("Failed to read acknowledgement: %v", err)
+			}
+
+			if tc.wantErr == "" {
+				if !ack.OK || ack.Bytes != 5 {
+					t.Errorf("Unexpected acknowledgement: %+v", ack)
+				}
+				if data, _ := backend.Paste(); string(data) != "hello" {
+					t.Errorf("Clipboard data doesn't match: got %q, want %q", data, "hello")
+				}
+				return
+			}
+			if ack.OK || !strings.Contains(ack.Error, tc.wantErr) {
+				t.Errorf("Expected error containing %q, got %+v", tc.wantErr, ack)
+			}
+		})
+	}
+
+	if backend.Copies() != 2 {
+		t.Errorf("Expected 2 clipboard updates, got %d", backend.Copies())
+	}
+}
+
+// textOnlyBackend hides every method of the wrapped backend beyond Backend,
+// like a backend that can only copy text
+type textOnlyBackend struct {
+	clipboard.Backend
+}
+
+// TestContentType tests that a Content-Type header copies the payload as
+// that type, untrimmed, and is refused by a backend that only copies text
+func TestContentType(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12382)
+	cfg.TrimPolicy = trim.BothEnds
+	backend := clipboard.NewMemoryBackend()
+	srv := NewWithBackend(cfg, NewMockLogger(), backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	textCfg := newTestConfig(tempDir, 12383)
+	textOnly := NewWithBackend(textCfg, NewMockLogger(), textOnlyBackend{clipboard.NewMemoryBackend()})
+	stopTextOnly := startTestServer(t, textOnly)
+	defer stopTextOnly()
+
+	pdf := "%PDF-1.4\n%%EOF\n"
+	sendPDF := func(port int) protocol.Ack {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			t.Fatalf("Failed to connect to server: %v", err)
+		}
+		defer conn.Close()
+
+		if _, err := conn.Write([]byte(protocol.ContentTypeHeader + "application/pdf\n" + pdf)); err != nil {
+			t.Fatalf("Failed to send data: %v", err)
+		}
+		conn.(*net.TCPConn).CloseWrite()
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		ack, err := protocol.ReadAck(bufio.NewReader(conn))
+		if err != nil {
+			t.Fatalf("Failed to read acknowledgement: %v", err)
+		}
+		return ack
+	}
+
+	ack := sendPDF(cfg.Port)
+	if !ack.OK || ack.Bytes != int64(len(pdf)) {
+		t.Fatalf("Unexpected acknowledgement: %+v", ack)
+	}
+	if types, _ := backend.Types(); len(types) != 1 || types[0] != "application/pdf" {
+		t.Errorf("Clipboard holds %v, want only application/pdf", types)
+	}
+	if data, _ := backend.PasteType("application/pdf"); string(data) != pdf {
+		t.Errorf("Clipboard data = %q, want %q", data, pdf)
+	}
+
+	ack = sendPDF(textCfg.Port)
+	if ack.OK || !strings.Contains(ack.Error, "does not support copying this type") {
+		t.Errorf("Expected an unsupported type error, got %+v", ack)
+	}
+}
+
+// TestPaste tests that a paste request returns the clipboard in the most
+// preferred type it holds
+func TestPaste(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12355)
+	backend := clipboard.NewMemoryBackend()
+	backend.Copy([]byte("image.png"))
+	backend.SetType("image/png", []byte("\x89PNG\r\n"))
+	srv := NewWithBackend(cfg, NewMockLogger(), backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	testCases := []struct {
+		name     string
+		request  string
+		wantType string
+		wantData string
+		wantErr  string
+	}{
+		{name: "default text", request: protocol.PasteDirective, wantType: "text/plain", wantData: "image.png"},
+		{name: "image", request: protocol.PasteDirective + "Accept: image/png\n", wantType: "image/png", wantData: "\x89PNG\r\n"},
+		{name: "preference order", request: protocol.PasteDirective + "Accept: image/gif, image/png, text/plain\n", wantType: "image/png", wantData: "\x89PNG\r\n"},
+		{name: "missing type", request: protocol.PasteDirective + "Accept: image/gif\n", wantErr: "does not hold the requested type: image/gif (available: text/plain, image/png)"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+			if err != nil {
+				t.Fatalf("Failed to connect to server: %v", err)
+			}
+			defer conn.Close()
+
+			if _, err := conn.Write([]byte(tc.request)); err != nil {
+				t.Fatalf("Failed to send request: %v", err)
+			}
+			conn.(*net.TCPConn).CloseWrite()
+
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			ack, data, err := protocol.ReadPaste(bufio.NewReader(conn))
+			if err != nil {
+				t.Fatalf("Failed to read paste: %v", err)
+			}
+
+			if tc.wantErr != "" {
+				if ack.OK || !strings.Contains(ack.Error, tc.wantErr) {
+					t.Errorf("Expected error containing %q, got %+v", tc.wantErr, ack)
+				}
+				return
+			}
+			if !ack.OK || ack.Type != tc.wantType || string(data) != tc.wantData {
+				t.Errorf("Paste returned %+v, %q; want %s %q", ack, data, tc.wantType, tc.wantData)
+			}
+			if strings.Join(ack.Types, ",") != "text/plain,image/png" {
+				t.Errorf("Expected types text/plain,image/png, got %v", ack.Types)
+			}
+		})
+	}
+
+	// With VerifyDirective only the hash comes back, for --if-changed
+	t.Run("hash only", func(t *testing.T) {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+		if err != nil {
+			t.Fatalf("Failed to connect to server: %v", err)
+		}
+		defer conn.Close()
+
+		conn.Write([]byte(protocol.VerifyDirective + protocol.PasteDirective))
+		conn.(*net.TCPConn).CloseWrite()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		reader := bufio.NewReader(conn)
+		ack, err := protocol.ReadAck(reader)
+		if err != nil {
+			t.Fatalf("Failed to read acknowledgement: %v", err)
+		}
+		sum := sha256.Sum256([]byte("image.png"))
+		if !ack.OK || ack.SHA256 != hex.EncodeToString(sum[:]) || ack.Bytes != 9 {
+			t.Errorf("Hash request returned %+v, want the SHA-256 of the text", ack)
+		}
+		if rest, _ := io.ReadAll(reader); len(rest) != 0 {
+			t.Errorf("Expected no contents after the hash, got %q", rest)
+		}
+	})
+
+	// Pasting must never change the clipboard
+	if backend.Copies() != 1 {
+		t.Errorf("Expected 1 clipboard update, got %d", backend.Copies())
+	}
+}
+
+// TestMissingBackend tests that the server refuses to start when the
+// backend's copy command is not installed
+func TestMissingBackend(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12356)
+	backend := clipboard.NewCommandBackend("pbcopy", []string{"warpclip-no-such-command"}, nil, 0)
+	srv := NewWithBackend(cfg, NewMockLogger(), backend)
+
+	err = srv.Start(context.Background())
+	if err == nil {
+		t.Fatal("Expected Start to fail with a missing backend, got nil")
+	}
+	if !strings.Contains(err.Error(), "warpclip-no-such-command") {
+		t.Errorf("Error does not name the missing command: %v", err)
+	}
+
+	// Nothing should be listening
+	if conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port)); err == nil {
+		conn.Close()
+		t.Error("Server accepted a connection despite failing to start")
+	}
+}
+
+// TestMaxDataSizeBoundary tests payloads at and just past the size limit
+func TestMaxDataSizeBoundary(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12357)
+	backend := clipboard.NewMemoryBackend()
+	logger := NewMockLogger()
+	srv := NewWithBackend(cfg, logger, backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	testCases := []struct {
+		name       string
+		size       int64
+		wantCopied int64
+	}{
+		{name: "exactly the limit", size: cfg.MaxDataSize, wantCopied: cfg.MaxDataSize},
+		{name: "one byte over", size: cfg.MaxDataSize + 1, wantCopied: cfg.MaxDataSize},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+			if err != nil {
+				t.Fatalf("Failed to connect to server: %v", err)
+			}
+			defer conn.Close()
+
+			if _, err := conn.Write([]byte(strings.Repeat("x", int(tc.size)))); err != nil {
+				t.Fatalf("Failed to send data: %v", err)
+			}
+			conn.(*net.TCPConn).CloseWrite()
+
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			ack, err := protocol.ReadAck(bufio.NewReader(conn))
+			if err != nil {
+				t.Fatalf("Failed to read acknowledgement: %v", err)
+			}
+			if !ack.OK || ack.Bytes != tc.wantCopied {
+				t.Errorf("Unexpected acknowledgement: %+v", ack)
+			}
+			if data, _ := backend.Paste(); int64(len(data)) != tc.wantCopied {
+				t.Errorf("Copied %d bytes, want %d", len(data), tc.wantCopied)
+			}
+		})
+	}
+
+	// Only the oversize payload may be reported as truncated
+	warnings := 0
+	for _, entry := range logger.GetLogs() {
+		if strings.Contains(entry, "exceeded maximum size") {
+			warnings++
+		}
+	}
+	if warnings != 1 {
+		t.Errorf("Expected 1 truncation warning, got %d: %v", warnings, logger.GetLogs())
+	}
+}
+
+// TestSession tests that each frame of a session is copied and acknowledged
+// before the next is sent
+func TestSession(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12358)
+	backend := clipboard.NewMemoryBackend()
+	srv := NewWithBackend(cfg, NewMockLogger(), backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(protocol.VerifyDirective + protocol.SessionPreamble)); err != nil {
+		t.Fatalf("Failed to send preamble: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	for _, record := range []string{"one", "two", "three"} {
+		if err := protocol.WriteFrame(conn, []byte(record)); err != nil {
+			t.Fatalf("Failed to send frame: %v", err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		ack, err := protocol.ReadAck(reader)
+		if err != nil {
+			t.Fatalf("Failed to read acknowledgement for %q: %v", record, err)
+		}
+		sum := sha256.Sum256([]byte(record))
+		if !ack.OK || ack.Bytes != int64(len(record)) || ack.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("Unexpected acknowledgement for %q: %+v", record, ack)
+		}
+		if data, _ := backend.Paste(); string(data) != record {
+			t.Errorf("Clipboard holds %q after acknowledgement, want %q", data, record)
+		}
+	}
+
+	if backend.Copies() != 3 {
+		t.Errorf("Expected 3 clipboard updates, got %d", backend.Copies())
+	}
+}
+
+// TestHandshake tests that clients negotiating a version and clients
+// predating the handshake are both served
+func TestHandshake(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12359)
+	backend := clipboard.NewMemoryBackend()
+	srv := NewWithBackend(cfg, NewMockLogger(), backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	testCases := []struct {
+		name      string
+		handshake string
+		payload   string
+	}{
+		{name: "new client", handshake: "WARPCLIP/2\n", payload: "negotiated"},
+		{name: "newer client", handshake: "WARPCLIP/9\n", payload: "from the future"},
+		{name: "old client", payload: "legacy"},
+		{name: "not a handshake", payload: "WARPCLIP/2 is not a version line"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+			if err != nil {
+				t.Fatalf("Failed to connect to server: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+			reader := bufio.NewReader(conn)
+
+			if tc.handshake != "" {
+				conn.Write([]byte(tc.handshake))
+				reply, err := reader.ReadString('\n')
+				if err != nil || reply != "WARPCLIP/8\n" {
+					t.Fatalf("Handshake reply %q, %v; want the server's version", reply, err)
+				}
+			}
+
+			conn.Write([]byte(tc.payload))
+			conn.(*net.TCPConn).CloseWrite()
+			ack, err := protocol.ReadAck(reader)
+			if err != nil || !ack.OK {
+				t.Fatalf("Copy failed: %+v, %v", ack, err)
+			}
+			if data, _ := backend.Paste(); string(data) != tc.payload {
+				t.Errorf("Clipboard holds %q, want %q", data, tc.payload)
+			}
+		})
+	}
+
+	// A client that gave up waiting for the answer abandons the connection
+	// without anything being copied
+	copies := backend.Copies()
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	conn.Write([]byte("WARPCLIP/2\n"))
+	if err := protocol.Abandon(conn); err != nil {
+		t.Fatalf("Abandon failed: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if backend.Copies() != copies {
+		t.Errorf("Abandoned connection was copied to the clipboard")
+	}
+}
+
+// TestMinClientVersion tests that clients older than the configured minimum
+// are refused with a categorized error, while current ones still copy
+func TestMinClientVersion(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12379)
+	cfg.MinClientVersion = protocol.ChunkedVersion
+	backend := clipboard.NewMemoryBackend()
+	logger := NewMockLogger()
+	srv := NewWithBackend(cfg, logger, backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	testCases := []struct {
+		name      string
+		handshake string
+		wantOK    bool
+	}{
+		{name: "below minimum", handshake: "WARPCLIP/3\n"},
+		{name: "no handshake"},
+		{name: "at minimum", handshake: "WARPCLIP/4\n", wantOK: true},
+		{name: "current", handshake: fmt.Sprintf("WARPCLIP/%d\n", protocol.Version), wantOK: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+			if err != nil {
+				t.Fatalf("Failed to connect to server: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+			reader := bufio.NewReader(conn)
+
+			if tc.handshake != "" {
+				conn.Write([]byte(tc.handshake))
+				if _, err := reader.ReadString('\n'); err != nil {
+					t.Fatalf("Failed to read handshake reply: %v", err)
+				}
+			}
+			conn.Write([]byte(tc.name))
+			conn.(*net.TCPConn).CloseWrite()
+
+			ack, err := protocol.ReadAck(reader)
+			if err != nil {
+				t.Fatalf("Failed to read acknowledgement: %v", err)
+			}
+			if tc.wantOK {
+				if !ack.OK {
+					t.Errorf("Copy refused: %+v", ack)
+				}
+				return
+			}
+			if ack.OK || ack.Category != protocol.CategoryClientTooOld || !strings.Contains(ack.Error, "at least version 4") {
+				t.Errorf("Expected a client-too-old rejection, got %+v", ack)
+			}
+			if data, _ := backend.Paste(); string(data) == tc.name {
+				t.Errorf("Refused client's data reached the clipboard")
+			}
+		})
+	}
+
+	if logs := strings.Join(logger.GetLogs(), "\n"); !strings.Contains(logs, "WARNING: Rejecting connection from") {
+		t.Errorf("Expected rejections to be logged, got:\n%s", logs)
+	}
+}
+
+// TestLatencyWindow tests the summary of clipboard write durations, and
+// that only the latest writes are kept
+func TestLatencyWindow(t *testing.T) {
+	w := newLatencyWindow()
+	if w.summary() != nil {
+		t.Error("Expected no summary before the first write")
+	}
+
+	for i := 1; i <= 20; i++ {
+		w.record(time.Duration(i) * time.Millisecond)
+	}
+	got := w.summary()
+	want := protocol.Latency{Samples: 20, AvgMS: 10.5, P50MS: 10, P95MS: 19, MaxMS: 20}
+	if got == nil || *got != want {
+		t.Errorf("summary() = %+v, want %+v", got, want)
+	}
+
+	// A full window of fast writes pushes the slow ones out
+	for i := 0; i < latencySamples; i++ {
+		w.record(time.Millisecond)
+	}
+	if got := w.summary(); got.Samples != latencySamples || got.MaxMS != 1 {
+		t.Errorf("summary() = %+v, want %d samples of 1ms", got, latencySamples)
+	}
+
+	// Copies through the server are timed and reported in its status
+	srv := NewWithBackend(newTestConfig(t.TempDir(), 0), NewMockLogger(), clipboard.NewMemoryBackend())
+	if err := srv.deliver([]byte("timed"), sourceCopy); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if latency := srv.Status().CopyLatency; latency == nil || latency.Samples != 1 {
+		t.Errorf("Expected one write in the status latency, got %+v", latency)
+	}
+}
+
+// failingBackend fails every copy with err while it is set
+type failingBackend struct {
+	*clipboard.MemoryBackend
+	mu       sync.Mutex
+	err      error
+	attempts int
+}
+
+func (b *failingBackend) Copy(data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.attempts++
+	if b.err != nil {
+		return b.err
+	}
+	return b.MemoryBackend.Copy(data)
+}
+
+func (b *failingBackend) setErr(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.err = err
+}
+
+func (b *failingBackend) attemptCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.attempts
+}
+
+// TestHistoryTTL tests that copies made with a time to live, or to be
+// cleared from the clipboard, are purged from the history once it runs out
+func TestHistoryTTL(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12375)
+	logger := NewMockLogger()
+	srv := NewWithBackend(cfg, logger, clipboard.NewMemoryBackend())
+	srv.historyPurgeInterval = 50 * time.Millisecond
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	for _, request := range []string{
+		"kept",
+		protocol.HistoryTTLHeader + "200ms\nsecret token",
+		protocol.ClearAfterHeader + "200ms\nanother secret",
+	} {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+		if err != nil {
+			t.Fatalf("Failed to connect to server: %v", err)
+		}
+		conn.Write([]byte(request))
+		conn.(*net.TCPConn).CloseWrite()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		ack, err := protocol.ReadAck(bufio.NewReader(conn))
+		conn.Close()
+		if err != nil || !ack.OK {
+			t.Fatalf("Copy failed: %+v, %v", ack, err)
+		}
+	}
+
+	entries, err := history.Read(cfg.HistoryFile)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("History holds %+v, %v; want all three copies", entries, err)
+	}
+	if !entries[0].Expires.IsZero() {
+		t.Errorf("Copy without a TTL expires at %v", entries[0].Expires)
+	}
+	for _, entry := range entries[1:] {
+		if ttl := entry.Expires.Sub(entry.Time); ttl != 200*time.Millisecond {
+			t.Errorf("Entry %+v has a TTL of %v, want 200ms", entry, ttl)
+		}
+	}
+
+	// The maintenance goroutine purges the expired entries
+	deadline := time.Now().Add(2 * time.Second)
+	for len(entries) > 1 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		if entries, err = history.Read(cfg.HistoryFile); err != nil {
+			t.Fatalf("Failed to read history: %v", err)
+		}
+	}
+	if len(entries) != 1 || entries[0].Bytes != int64(len("kept")) {
+		t.Errorf("History after the TTL ran out holds %+v, want only the copy without one", entries)
+	}
+	if logs := strings.Join(logger.GetLogs(), "\n"); !strings.Contains(logs, "expired entries from the history") {
+		t.Errorf("Expected a purge log, got:\n%s", logs)
+	}
+}
+
+// TestQuietConnLogs tests that connection logs can be demoted to DEBUG while
+// copies are still logged at INFO
+func TestQuietConnLogs(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12374)
+	cfg.QuietConnLogs = true
+	logger := NewMockLogger()
+	srv := NewWithBackend(cfg, logger, clipboard.NewMemoryBackend())
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	for _, payload := range []string{"quiet copy", ""} {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+		if err != nil {
+			t.Fatalf("Failed to connect to server: %v", err)
+		}
+		conn.Write([]byte(payload))
+		conn.(*net.TCPConn).CloseWrite()
+		io.Copy(io.Discard, conn)
+		conn.Close()
+	}
+
+	logs := strings.Join(logger.GetLogs(), "\n")
+	for _, want := range []string{"DEBUG: New connection from", "DEBUG: Control connection from", "INFO: Successfully copied 10 bytes"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("Expected log %q, got:\n%s", want, logs)
+		}
+	}
+	if strings.Contains(logs, "INFO: New connection") || strings.Contains(logs, "INFO: Control connection") {
+		t.Errorf("Connection logged at INFO despite QuietConnLogs:\n%s", logs)
+	}
+}
+
+// TestNeedsGUISession tests that a clipboard without a desktop session is
+// reported as such, without retrying, and shows in the status
+func TestNeedsGUISession(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12373)
+	backend := &failingBackend{MemoryBackend: clipboard.NewMemoryBackend()}
+	backend.setErr(fmt.Errorf("pbcopy command failed: %w", clipboard.ErrNoSession))
+	srv := NewWithBackend(cfg, NewMockLogger(), backend)
+
+	err = srv.deliver([]byte("data"), sourceCopy)
+	if !errors.Is(err, errNeedsGUISession) || !strings.Contains(err.Error(), "not over SSH") {
+		t.Fatalf("Expected errNeedsGUISession, got %v", err)
+	}
+	if ack := errorAck(err); ack.Category != protocol.CategoryNoSession {
+		t.Errorf("Copy acknowledged with category %q, want %q", ack.Category, protocol.CategoryNoSession)
+	}
+	if backend.attemptCount() != 1 {
+		t.Errorf("Copy was tried %d times, want no retries", backend.attemptCount())
+	}
+	if status := srv.Status(); status.BackendCategory != protocol.CategoryNoSession {
+		t.Errorf("Status = %+v, want the missing session reported", status)
+	}
+
+	// Other failures are still retried and leave no category
+	backend.setErr(errors.New("pbcopy command failed: exit status 1"))
+	srv.deliver([]byte("data"), sourceCopy)
+	if backend.attemptCount() != 4 {
+		t.Errorf("Backend tried %d times in all, want 3 more for an unclassified failure", backend.attemptCount())
+	}
+	if status := srv.Status(); status.BackendCategory != "" {
+		t.Errorf("Status category %q for an unclassified failure", status.BackendCategory)
+	}
+}
+
+// TestBackendChainFallback tests that a copy the first backend in a chain
+// fails goes to the next, and that the one used is logged
+func TestBackendChainFallback(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	failing, err := clipboard.New("custom-command", clipboard.Options{Command: "exit 1"})
+	if err != nil {
+		t.Fatalf("Failed to create failing backend: %v", err)
+	}
+	memory := clipboard.NewMemoryBackend()
+	logger := NewMockLogger()
+	srv := NewWithBackend(newTestConfig(tempDir, 12384), logger, clipboard.NewChain([]clipboard.Backend{failing, memory}))
+
+	if err := srv.deliver([]byte("data"), sourceCopy); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if data, _ := memory.Paste(); string(data) != "data" {
+		t.Errorf("Fallback backend holds %q, want the copy", data)
+	}
+
+	found := false
+	for _, entry := range logger.GetLogs() {
+		if entry == "INFO: Clipboard write handled by memory" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Backend that handled the copy not logged: %v", logger.GetLogs())
+	}
+}
+
+// TestRestoreOnStart tests that the clipboard saved at shutdown is put back
+// by the next daemon, but not over something copied in between
+func TestRestoreOnStart(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12385)
+	cfg.RestoreOnStart = true
+
+	first := clipboard.NewMemoryBackend()
+	stop := startTestServer(t, NewWithBackend(cfg, NewMockLogger(), first))
+	if err := first.Copy([]byte("saved")); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	stop()
+
+	info, err := os.Stat(cfg.ClipboardFile)
+	if err != nil {
+		t.Fatalf("Clipboard not saved at shutdown: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Saved clipboard has permissions %o, want 600", perm)
+	}
+
+	second := clipboard.NewMemoryBackend()
+	stop = startTestServer(t, NewWithBackend(cfg, NewMockLogger(), second))
+	if data, _ := second.Paste(); string(data) != "saved" {
+		t.Errorf("Restored clipboard holds %q, want %q", data, "saved")
+	}
+	if _, err := os.Stat(cfg.ClipboardFile); !os.IsNotExist(err) {
+		t.Errorf("Saved clipboard not removed after restoring it: %v", err)
+	}
+	stop()
+
+	// Something copied while the daemon was down is kept
+	third := clipboard.NewMemoryBackend()
+	if err := third.Copy([]byte("newer")); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	stop = startTestServer(t, NewWithBackend(cfg, NewMockLogger(), third))
+	if data, _ := third.Paste(); string(data) != "newer" {
+		t.Errorf("Clipboard holds %q after start, want %q kept", data, "newer")
+	}
+	stop()
+
+	// Too large to save
+	cfg.MaxDataSize = 4
+	os.Remove(cfg.ClipboardFile)
+	stop = startTestServer(t, NewWithBackend(cfg, NewMockLogger(), third))
+	stop()
+	if _, err := os.Stat(cfg.ClipboardFile); !os.IsNotExist(err) {
+		t.Errorf("Clipboard larger than the maximum data size saved: %v", err)
+	}
+}
+
+// TestBackendBreaker tests that repeated clipboard failures stop further
+// copies until a probe after the cooldown succeeds
+func TestBackendBreaker(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12361)
+	cfg.BreakerThreshold = 2
+	cfg.BreakerCooldown = 200 * time.Millisecond
+	backend := &failingBackend{MemoryBackend: clipboard.NewMemoryBackend()}
+	backend.setErr(fmt.Errorf("xclip command failed: %w", clipboard.ErrNoSession))
+	logger := NewMockLogger()
+	srv := NewWithBackend(cfg, logger, backend)
+
+	// Failures up to the threshold open the breaker
+	for i := 0; i < cfg.BreakerThreshold; i++ {
+		if err := srv.deliver([]byte("data"), sourceCopy); err == nil || errors.Is(err, errBackendUnhealthy) {
+			t.Fatalf("Copy %d: expected a clipboard failure, got %v", i+1, err)
+		}
+	}
+	status := srv.Status()
+	if !status.BackendUnhealthy || status.BackendFailures != 2 || !strings.Contains(status.BackendError, "xclip command failed") {
+		t.Errorf("Status after repeated failures = %+v, want backend unhealthy", status)
+	}
+
+	// While open, copies fail without touching the backend but keep the
+	// underlying error's category
+	attempts := backend.attemptCount()
+	err = srv.deliver([]byte("data"), sourceCopy)
+	if !errors.Is(err, errBackendUnhealthy) {
+		t.Fatalf("Expected errBackendUnhealthy, got %v", err)
+	}
+	if ack := errorAck(err); ack.Category != protocol.CategoryNoSession {
+		t.Errorf("Refused copy acknowledged with category %q, want %q", ack.Category, protocol.CategoryNoSession)
+	}
+	if backend.attemptCount() != attempts {
+		t.Errorf("Backend was called while the breaker was open")
+	}
+
+	// A failed probe after the cooldown opens it again
+	time.Sleep(cfg.BreakerCooldown)
+	if err := srv.deliver([]byte("data"), sourceCopy); err == nil || errors.Is(err, errBackendUnhealthy) {
+		t.Fatalf("Expected the probe to reach the failing backend, got %v", err)
+	}
+	if err := srv.deliver([]byte("data"), sourceCopy); !errors.Is(err, errBackendUnhealthy) {
+		t.Fatalf("Expected errBackendUnhealthy after a failed probe, got %v", err)
+	}
+
+	// A successful probe closes it
+	backend.setErr(nil)
+	time.Sleep(cfg.BreakerCooldown)
+	if err := srv.deliver([]byte("recovered"), sourceCopy); err != nil {
+		t.Fatalf("Probe copy failed: %v", err)
+	}
+	if status := srv.Status(); status.BackendUnhealthy || status.BackendFailures != 0 || status.BackendError != "" {
+		t.Errorf("Status after recovery = %+v, want backend healthy", status)
+	}
+	if err := srv.deliver([]byte("again"), sourceCopy); err != nil {
+		t.Errorf("Copy after recovery failed: %v", err)
+	}
+
+	logs := strings.Join(logger.GetLogs(), "\n")
+	if strings.Count(logs, "marked unhealthy") != 1 || !strings.Contains(logs, "recovered") {
+		t.Errorf("Expected one unhealthy and one recovery message, got logs:\n%s", logs)
+	}
+}
+
+// TestPortInUse tests that failing to listen says whether the port is held
+// by another warpclipd or by something else
+func TestPortInUse(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12362)
+	stop := startTestServer(t, NewWithBackend(cfg, NewMockLogger(), clipboard.NewMemoryBackend()))
+	defer stop()
+
+	second := newTestConfig(t.TempDir(), cfg.Port)
+	err = NewWithBackend(second, NewMockLogger(), clipboard.NewMemoryBackend()).Start(context.Background())
+	if !errors.Is(err, errAlreadyRunning) || !strings.Contains(err.Error(), "port 12362") {
+		t.Errorf("Expected errAlreadyRunning on port 12362, got %v", err)
+	}
+
+	// Something that never answers the handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:12363")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	other := newTestConfig(t.TempDir(), 12363)
+	err = NewWithBackend(other, NewMockLogger(), clipboard.NewMemoryBackend()).Start(context.Background())
+	if !errors.Is(err, errPortInUse) {
+		t.Errorf("Expected errPortInUse, got %v", err)
+	}
+
+	// An older daemon that can't be probed is recognized by its PID file
+	if err := os.WriteFile(other.PidFile, []byte(strconv.Itoa(os.Getppid())), 0600); err != nil {
+		t.Fatalf("Failed to write PID file: %v", err)
+	}
+	err = NewWithBackend(other, NewMockLogger(), clipboard.NewMemoryBackend()).Start(context.Background())
+	if !errors.Is(err, errAlreadyRunning) || !strings.Contains(err.Error(), fmt.Sprintf("PID %d", os.Getppid())) {
+		t.Errorf("Expected errAlreadyRunning naming the PID, got %v", err)
+	}
+}
+
+// TestErrorAck tests that clipboard failures are categorized for the client
+func TestDebounce(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12364)
+	cfg.Debounce = 200 * time.Millisecond
+	backend := clipboard.NewMemoryBackend()
+	logger := NewMockLogger()
+	srv := NewWithBackend(cfg, logger, backend)
+
+	// A burst of copies reaches the backend once, with the last value
+	errs := make(chan error, 3)
+	for _, value := range []string{"first", "second", "third"} {
+		go func(value string) {
+			errs <- srv.deliver([]byte(value), sourceCopy)
+		}(value)
+		time.Sleep(20 * time.Millisecond)
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Coalesced copy failed: %v", err)
+		}
+	}
+	if backend.Copies() != 1 {
+		t.Errorf("Expected 1 backend copy, got %d", backend.Copies())
+	}
+	if content, _ := backend.Paste(); string(content) != "third" {
+		t.Errorf("Clipboard holds %q, want the latest copy %q", content, "third")
+	}
+	found := false
+	for _, entry := range logger.GetLogs() {
+		if strings.Contains(entry, "Coalesced 3 copies") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Coalesced copies not logged: %v", logger.GetLogs())
+	}
+
+	// A copy after the window has closed starts a new batch
+	if err := srv.deliver([]byte("later"), sourceCopy); err != nil {
+		t.Fatalf("Copy after the window failed: %v", err)
+	}
+	if backend.Copies() != 2 {
+		t.Errorf("Expected 2 backend copies, got %d", backend.Copies())
+	}
+}
+
+func TestHistory(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12365)
+	srv := NewWithBackend(cfg, NewMockLogger(), clipboard.NewMemoryBackend())
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+	testData := "Test clipboard data"
+	conn.Write([]byte(testData))
+	conn.(*net.TCPConn).CloseWrite()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := protocol.ReadAck(bufio.NewReader(conn)); err != nil {
+		t.Fatalf("Failed to read acknowledgement: %v", err)
+	}
+
+	png := []byte("\x89PNG\r\n\x1a\n image data")
+	if err := srv.deliver(png, sourceSession); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+
+	entries, err := history.Read(cfg.HistoryFile)
+	if err != nil {
+		t.Fatalf("Failed to read history: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 history entries, got %+v", entries)
+	}
+	want := []history.Entry{
+		{Bytes: int64(len(testData)), Type: "text/plain", Source: "copy"},
+		{Bytes: int64(len(png)), Type: "image/png", Source: "session"},
+	}
+	for i, entry := range entries {
+		if time.Since(entry.Time) > time.Minute {
+			t.Errorf("Entry %d has time %v, want about now", i, entry.Time)
+		}
+		entry.Time = time.Time{}
+		if entry != want[i] {
+			t.Errorf("Entry %d = %+v, want %+v", i, entry, want[i])
+		}
+	}
+
+	// The history records what was copied, never the content
+	content, _ := os.ReadFile(cfg.HistoryFile)
+	if strings.Contains(string(content), testData) {
+		t.Errorf("History file contains the copied data:\n%s", content)
+	}
+}
+
+func TestUnwritablePidFile(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A regular file where the PID file's directory should be makes it
+	// unwritable even for root
+	notDir := filepath.Join(tempDir, "not-a-directory")
+	if err := os.WriteFile(notDir, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := newTestConfig(tempDir, 12366)
+	cfg.PidFile = filepath.Join(notDir, "test.pid")
+	backend := clipboard.NewMemoryBackend()
+	logger := NewMockLogger()
+	srv := NewWithBackend(cfg, logger, backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		t.Fatalf("Server didn't start without a PID file: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("still copies"))
+	conn.(*net.TCPConn).CloseWrite()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if ack, err := protocol.ReadAck(bufio.NewReader(conn)); err != nil || !ack.OK {
+		t.Fatalf("Copy without a PID file failed: %+v, %v", ack, err)
+	}
+
+	found := false
+	for _, entry := range logger.GetLogs() {
+		if strings.HasPrefix(entry, "WARNING: Running without a PID file") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Missing PID file not logged as a warning: %v", logger.GetLogs())
+	}
+}
+
+func TestTransformCommand(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12367)
+	cfg.TransformCommand = "tr a-z A-Z"
+	backend := clipboard.NewMemoryBackend()
+	srv := NewWithBackend(cfg, NewMockLogger(), backend)
+
+	if err := srv.deliver([]byte("shout"), sourceCopy); err != nil {
+		t.Fatalf("Transformed copy failed: %v", err)
+	}
+	if content, _ := backend.Paste(); string(content) != "SHOUT" {
+		t.Errorf("Clipboard holds %q, want the transform command's output %q", content, "SHOUT")
+	}
+
+	// A failing command, or one that prints nothing or too much, aborts the copy
+	testCases := []struct {
+		command string
+		want    error
+	}{
+		{command: "echo 'not today' >&2; exit 1"},
+		{command: "cat >/dev/null", want: errTransformedEmpty},
+		{command: "cat >/dev/null; head -c 2048 /dev/zero", want: errTooLarge},
+	}
+	for _, tc := range testCases {
+		cfg.TransformCommand = tc.command
+		err := srv.deliver([]byte("data"), sourceCopy)
+		if err == nil || (tc.want != nil && !errors.Is(err, tc.want)) {
+			t.Errorf("Transform %q: expected failure %v, got %v", tc.command, tc.want, err)
+		}
+	}
+	if backend.Copies() != 1 {
+		t.Errorf("Failed transforms reached the backend: %d copies", backend.Copies())
+	}
+}
+
+func TestConnectionTimeouts(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12368)
+	cfg.ReadTimeout = 200 * time.Millisecond
+	cfg.ConnTimeout = time.Second
+	srv := NewWithBackend(cfg, NewMockLogger(), clipboard.NewMemoryBackend())
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	// send writes chunks at the interval until the server stops reading,
+	// closing the write side after count of them, then returns the ack
+	send := func(t *testing.T, count int, interval time.Duration) protocol.Ack {
+		t.Helper()
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+		if err != nil {
+			t.Fatalf("Failed to connect to server: %v", err)
+		}
+		defer conn.Close()
+
+		go func() {
+			for i := 0; i < count; i++ {
+				if i > 0 {
+					time.Sleep(interval)
+				}
+				if _, err := conn.Write([]byte("chunk\n")); err != nil {
+					return
+				}
+			}
+			conn.(*net.TCPConn).CloseWrite()
+		}()
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		ack, err := protocol.ReadAck(bufio.NewReader(conn))
+		if err != nil {
+			t.Fatalf("Failed to read acknowledgement: %v", err)
+		}
+		return ack
+	}
+
+	t.Run("slow but steady", func(t *testing.T) {
+		// Takes longer than the idle timeout, but never pauses that long
+		ack := send(t, 8, 100*time.Millisecond)
+		if !ack.OK || ack.Bytes != 8*int64(len("chunk\n")) {
+			t.Errorf("Steady transfer acknowledged as %+v, want all 8 chunks copied", ack)
+		}
+	})
+
+	t.Run("idle stall", func(t *testing.T) {
+		ack := send(t, 2, 500*time.Millisecond)
+		if ack.OK || !strings.Contains(ack.Error, "nothing received for 200ms") {
+			t.Errorf("Stalled transfer acknowledged as %+v, want an idle timeout", ack)
+		}
+	})
+
+	t.Run("overall limit", func(t *testing.T) {
+		ack := send(t, 30, 100*time.Millisecond)
+		if ack.OK || !strings.Contains(ack.Error, "longer than the 1s limit") {
+			t.Errorf("Endless transfer acknowledged as %+v, want the connection limit", ack)
+		}
+	})
+}
+
+// TestLongLivedTimeouts tests that follow and session connections may wait
+// between records for as long as they like, but each record is held to the
+// idle and overall limits and holds the memory budget only while it is read
+func TestLongLivedTimeouts(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12390)
+	cfg.ReadTimeout = 200 * time.Millisecond
+	cfg.ConnTimeout = time.Second
+	backend := clipboard.NewMemoryBackend()
+	logger := NewMockLogger()
+	srv := NewWithBackend(cfg, logger, backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	// open starts a connection with the preamble, then waits longer than the
+	// idle timeout before returning it, which mustn't close it
+	open := func(t *testing.T, preamble string) net.Conn {
+		t.Helper()
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+		if err != nil {
+			t.Fatalf("Failed to connect to server: %v", err)
+		}
+		if _, err := conn.Write([]byte(preamble)); err != nil {
+			t.Fatalf("Failed to send preamble: %v", err)
+		}
+		time.Sleep(400 * time.Millisecond)
+		if inUse := srv.budget.inUse(); inUse != 0 {
+			t.Errorf("Idle connection holds %d bytes of the memory budget, want none", inUse)
+		}
+		return conn
+	}
+
+	// trickle sends the header of a record, then a byte of it at the
+	// interval until the server stops reading
+	trickle := func(conn net.Conn, interval time.Duration) {
+		conn.Write([]byte("100\n"))
+		go func() {
+			for {
+				time.Sleep(interval)
+				if _, err := conn.Write([]byte("x")); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	// closed waits for the server to close a follow connection
+	closed := func(t *testing.T, conn net.Conn) {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("Read from follow connection returned %v, want the server to close it", err)
+		}
+	}
+
+	// ackError reads the next acknowledgement on a session and returns its
+	// error
+	ackError := func(t *testing.T, conn net.Conn, reader *bufio.Reader) string {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		ack, err := protocol.ReadAck(reader)
+		if err != nil {
+			t.Fatalf("Failed to read acknowledgement: %v", err)
+		}
+		return ack.Error
+	}
+
+	t.Run("follow stall", func(t *testing.T) {
+		conn := open(t, protocol.FollowPreamble)
+		defer conn.Close()
+		if err := protocol.WriteFrame(conn, []byte("one")); err != nil {
+			t.Fatalf("Failed to send record: %v", err)
+		}
+		conn.Write([]byte("5\nab"))
+		closed(t, conn)
+		if !strings.Contains(strings.Join(logger.GetLogs(), "\n"), "nothing received for 200ms") {
+			t.Errorf("Stalled record wasn't logged as an idle timeout: %v", logger.GetLogs())
+		}
+		if data, _ := backend.Paste(); string(data) != "one" {
+			t.Errorf("Clipboard holds %q, want the record sent before the stall", data)
+		}
+	})
+
+	t.Run("follow trickle", func(t *testing.T) {
+		conn := open(t, protocol.FollowPreamble)
+		defer conn.Close()
+		trickle(conn, 100*time.Millisecond)
+		closed(t, conn)
+		if !strings.Contains(strings.Join(logger.GetLogs(), "\n"), "longer than the 1s limit") {
+			t.Errorf("Trickled record wasn't logged as over the limit: %v", logger.GetLogs())
+		}
+	})
+
+	t.Run("session stall", func(t *testing.T) {
+		conn := open(t, protocol.SessionPreamble)
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		if err := protocol.WriteFrame(conn, []byte("two")); err != nil {
+			t.Fatalf("Failed to send record: %v", err)
+		}
+		if msg := ackError(t, conn, reader); msg != "" {
+			t.Fatalf("Record acknowledged with %q, want it copied", msg)
+		}
+		time.Sleep(400 * time.Millisecond)
+		conn.Write([]byte("5\nab"))
+		if msg := ackError(t, conn, reader); !strings.Contains(msg, "nothing received for 200ms") {
+			t.Errorf("Stalled record acknowledged with %q, want an idle timeout", msg)
+		}
+	})
+
+	t.Run("session trickle", func(t *testing.T) {
+		conn := open(t, protocol.SessionPreamble)
+		defer conn.Close()
+		trickle(conn, 100*time.Millisecond)
+		if msg := ackError(t, conn, bufio.NewReader(conn)); !strings.Contains(msg, "longer than the 1s limit") {
+			t.Errorf("Trickled record acknowledged with %q, want the connection limit", msg)
+		}
+	})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.budget.inUse() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Closed connections still hold %d bytes of the memory budget", srv.budget.inUse())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClearAfter(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12369)
+	backend := clipboard.NewMemoryBackend()
+	srv := NewWithBackend(cfg, NewMockLogger(), backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	copyWith := func(t *testing.T, header, data string) protocol.Ack {
+		t.Helper()
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+		if err != nil {
+			t.Fatalf("Failed to connect to server: %v", err)
+		}
+		defer conn.Close()
+
+		if _, err := conn.Write([]byte(header + data)); err != nil {
+			t.Fatalf("Failed to send data: %v", err)
+		}
+		conn.(*net.TCPConn).CloseWrite()
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		ack, err := protocol.ReadAck(bufio.NewReader(conn))
+		if err != nil || !ack.OK {
+			t.Fatalf("Copy failed: %+v, %v", ack, err)
+		}
+		return ack
+	}
+
+	// The ack and status report when the clipboard will clear, and it does
+	before := time.Now()
+	ack := copyWith(t, protocol.ClearAfterHeader+"300ms\n", "secret")
+	if ack.ClearAt.Before(before.Truncate(time.Second)) || ack.ClearAt.After(time.Now().Add(2*time.Second)) {
+		t.Errorf("Ack reports a clear at %v, want about 300ms from now", ack.ClearAt)
+	}
+	if data, _ := backend.Paste(); string(data) != "secret" {
+		t.Errorf("Clipboard holds %q, want the copied data until the clear", data)
+	}
+	if status := srv.Status(); status.ClearAt.IsZero() {
+		t.Error("Status doesn't report the pending clear")
+	}
+	time.Sleep(600 * time.Millisecond)
+	if data, _ := backend.Paste(); len(data) != 0 {
+		t.Errorf("Clipboard holds %q after the delay, want it cleared", data)
+	}
+	if status := srv.Status(); !status.ClearAt.IsZero() {
+		t.Errorf("Status still reports a clear at %v after it ran", status.ClearAt)
+	}
+
+	// A copy arriving before the clear cancels it
+	copyWith(t, protocol.ClearAfterHeader+"300ms\n", "secret")
+	if ack := copyWith(t, "", "newer"); !ack.ClearAt.IsZero() {
+		t.Errorf("Copy without a clear reports one at %v", ack.ClearAt)
+	}
+	if status := srv.Status(); !status.ClearAt.IsZero() {
+		t.Errorf("Status reports a clear at %v after a new copy canceled it", status.ClearAt)
+	}
+	time.Sleep(600 * time.Millisecond)
+	if data, _ := backend.Paste(); string(data) != "newer" {
+		t.Errorf("Clipboard holds %q, want the newer copy left in place", data)
+	}
+}
+
+func TestClear(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12370)
+	backend := clipboard.NewMemoryBackend()
+	srv := NewWithBackend(cfg, NewMockLogger(), backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	send := func(t *testing.T, payload string) (protocol.Ack, error) {
+		t.Helper()
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+		if err != nil {
+			t.Fatalf("Failed to connect to server: %v", err)
+		}
+		defer conn.Close()
+
+		if _, err := conn.Write([]byte(payload)); err != nil {
+			t.Fatalf("Failed to send data: %v", err)
+		}
+		conn.(*net.TCPConn).CloseWrite()
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		return protocol.ReadAck(bufio.NewReader(conn))
+	}
+
+	if ack, err := send(t, protocol.ClearAfterHeader+"1m\n"+"keep me"); err != nil || !ack.OK {
+		t.Fatalf("Copy failed: %+v, %v", ack, err)
+	}
+
+	// Empty payloads are taken for a mistake and leave the clipboard alone
+	for _, payload := range []string{"", protocol.ContentLengthHeader + "0\n"} {
+		if ack, err := send(t, payload); err == nil && ack.OK {
+			t.Errorf("Empty payload %q acknowledged as %+v, want it refused", payload, ack)
+		}
+	}
+	if data, _ := backend.Paste(); string(data) != "keep me" {
+		t.Errorf("Clipboard holds %q after an empty payload, want it unchanged", data)
+	}
+
+	// Asking for a clear empties it, and the --clear-after is no longer pending
+	if ack, err := send(t, protocol.ClearDirective); err != nil || !ack.OK {
+		t.Fatalf("Clear failed: %+v, %v", ack, err)
+	}
+	if data, _ := backend.Paste(); len(data) != 0 {
+		t.Errorf("Clipboard holds %q after a clear, want it empty", data)
+	}
+	if status := srv.Status(); !status.ClearAt.IsZero() {
+		t.Errorf("Status reports a clear at %v after an explicit one", status.ClearAt)
+	}
+	if backend.Copies() != 2 {
+		t.Errorf("Expected the copy and the clear to reach the backend, got %d updates", backend.Copies())
+	}
+}
+
+func TestNotify(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Nothing is played unless asked for
+	cfg := newTestConfig(tempDir, 12371)
+	if srv := NewWithBackend(cfg, NewMockLogger(), clipboard.NewMemoryBackend()); srv.notifier != nil {
+		t.Errorf("Notifier enabled by default: %+v", srv.notifier)
+	}
+
+	testCases := []struct {
+		spec             string
+		success, failure string
+	}{
+		{spec: "on", success: "afplay /System/Library/Sounds/Glass.aiff", failure: "afplay /System/Library/Sounds/Basso.aiff"},
+		{spec: "Ping, /tmp/oops.wav", success: "afplay /System/Library/Sounds/Ping.aiff", failure: "afplay /tmp/oops.wav"},
+		{spec: "notification", success: `osascript -e display notification "Copied 4 bytes" with title "WarpClip"`, failure: "osascript -e display notification"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.spec, func(t *testing.T) {
+			cfg.NotifySound = tc.spec
+			cfg.TransformCommand = ""
+			srv := NewWithBackend(cfg, NewMockLogger(), clipboard.NewMemoryBackend())
+			commands := make(chan string, 2)
+			srv.notifier.run = func(name string, args ...string) error {
+				commands <- strings.Join(append([]string{name}, args...), " ")
+				return nil
+			}
+			next := func() string {
+				select {
+				case command := <-commands:
+					return command
+				case <-time.After(2 * time.Second):
+					t.Fatal("Timed out waiting for the notification")
+					return ""
+				}
+			}
+
+			if err := srv.deliver([]byte("data"), sourceCopy); err != nil {
+				t.Fatalf("Copy failed: %v", err)
+			}
+			if got := next(); got != tc.success {
+				t.Errorf("Successful copy ran %q, want %q", got, tc.success)
+			}
+
+			cfg.TransformCommand = "exit 1"
+			if err := srv.deliver([]byte("data"), sourceCopy); err == nil {
+				t.Fatal("Expected the copy to fail")
+			}
+			if got := next(); !strings.HasPrefix(got, tc.failure) || got == tc.success {
+				t.Errorf("Failed copy ran %q, want %q", got, tc.failure)
+			}
+		})
+	}
+}
+
+// flakyConn is a client connection over a lossy link: it corrupts the
+// write numbered corruptWrite and has the read numbered lostRead time out
+type flakyConn struct {
+	net.Conn
+	writes, reads           int
+	corruptWrite, lostRead int
+}
+
+func (c *flakyConn) Write(p []byte) (int, error) {
+	c.writes++
+	if c.writes == c.corruptWrite {
+		corrupted := append([]byte(nil), p...)
+		corrupted[0] ^= 0xff
+		return c.Conn.Write(corrupted)
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *flakyConn) Read(p []byte) (int, error) {
+	c.reads++
+	if c.reads == c.lostRead {
+		return 0, os.ErrDeadlineExceeded
+	}
+	return c.Conn.Read(p)
+}
+
+func TestChunked(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12372)
+	backend := clipboard.NewMemoryBackend()
+	logger := NewMockLogger()
+	srv := NewWithBackend(cfg, logger, backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	sendChunked := func(t *testing.T, conn net.Conn, data []byte) (protocol.Ack, error) {
+		t.Helper()
+		reader := bufio.NewReader(conn)
+		conn.Write([]byte(protocol.VerifyDirective))
+		return protocol.SendChunked(conn, reader, data, 100, 2*time.Second)
+	}
+	dial := func(t *testing.T) net.Conn {
+		t.Helper()
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+		if err != nil {
+			t.Fatalf("Failed to connect to server: %v", err)
+		}
+		return conn
+	}
+
+	// The preamble and verify directive are the first two writes, so the
+	// fourth is the data of the first chunk. It arrives corrupt, and the
+	// reply to a resend goes missing, so the first chunk is sent three times.
+	payload := []byte(strings.Repeat("0123456789", 35))
+	conn := &flakyConn{Conn: dial(t), corruptWrite: 4, lostRead: 2}
+	defer conn.Close()
+	ack, err := sendChunked(t, conn, payload)
+	if err != nil || !ack.OK {
+		t.Fatalf("Chunked copy failed: %+v, %v", ack, err)
+	}
+	sum := sha256.Sum256(payload)
+	if ack.Bytes != int64(len(payload)) || ack.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Ack %+v doesn't describe the %d byte payload", ack, len(payload))
+	}
+	if content, _ := backend.Paste(); !bytes.Equal(content, payload) {
+		t.Errorf("Clipboard holds %q, want the assembled payload", content)
+	}
+	if backend.Copies() != 1 {
+		t.Errorf("Expected one clipboard write, got %d", backend.Copies())
+	}
+	logs := strings.Join(logger.GetLogs(), "\n")
+	for _, want := range []string{"Chunk 1 arrived corrupt", "Chunk 1 arrived again"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("Expected log %q, got:\n%s", want, logs)
+		}
+	}
+
+	// The assembled payload is bounded by the size limit
+	conn = &flakyConn{Conn: dial(t)}
+	defer conn.Close()
+	ack, err = sendChunked(t, conn, bytes.Repeat([]byte("x"), int(cfg.MaxDataSize)+1))
+	if err != nil || ack.OK || ack.Category != protocol.CategoryTooLarge {
+		t.Errorf("Expected an oversized payload to be refused, got %+v, %v", ack, err)
+	}
+	if backend.Copies() != 1 {
+		t.Errorf("Oversized payload reached the clipboard")
+	}
+}
+
+// TestPayloadBuffer tests that payloads are reassembled intact from
+// segments, and that an announced size is read into a single allocation
+func TestPayloadBuffer(t *testing.T) {
+	payload := make([]byte, 3*maxSegment+12345)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+
+	for _, size := range []int64{-1, 0, 100, int64(len(payload))} {
+		buf := newPayloadBuffer(size)
+		for rest := payload; len(rest) > 0; {
+			n := 1000 + len(rest)%7919
+			if n > len(rest) {
+				n = len(rest)
+			}
+			buf.Write(rest[:n])
+			rest = rest[n:]
+		}
+		data := buf.Bytes()
+		if buf.Len() != len(payload) || !bytes.Equal(data, payload) {
+			t.Errorf("Size %d: payload of %d bytes came back as %d bytes, intact=%v", size, len(payload), len(data), bytes.Equal(data, payload))
+		}
+		if cap(data) != len(data) {
+			t.Errorf("Size %d: payload holds %d bytes of spare capacity", size, cap(data)-len(data))
+		}
+	}
+}
+
+func TestErrorAck(t *testing.T) {
+	testCases := []struct {
+		err      error
+		category string
+	}{
+		{fmt.Errorf("failed to copy to clipboard: %w", clipboard.ErrNoSession), protocol.CategoryNoSession},
+		{fmt.Errorf("failed to start xclip: %w", clipboard.ErrCommandNotFound), protocol.CategoryBackendMissing},
+		{fmt.Errorf("pbcopy operation %w after 5s", clipboard.ErrTimeout), protocol.CategoryTimeout},
+		{fmt.Errorf("%w: 4096 bytes exceeds maximum size of 1024 bytes", errTooLarge), protocol.CategoryTooLarge},
+		{fmt.Errorf("failed to read frame: %w", protocol.ErrFrameTooLarge), protocol.CategoryTooLarge},
+		{fmt.Errorf("read failed"), ""},
+	}
+
+	for _, tc := range testCases {
+		ack := errorAck(tc.err)
+		if ack.OK || ack.Error != tc.err.Error() || ack.Category != tc.category {
+			t.Errorf("errorAck(%v) = %+v, want category %q", tc.err, ack, tc.category)
+		}
+	}
+}
+
+// BenchmarkReadData measures reading a 10MB payload over loopback TCP with
+// different read buffer sizes
+func BenchmarkReadData(b *testing.B) {
+	const payloadSize = 10 * 1024 * 1024
+	payload := []byte(strings.Repeat("x", payloadSize))
+
+	for _, size := range []int{1024, 4 * 1024, 32 * 1024, 128 * 1024} {
+		b.Run(fmt.Sprintf("buffer=%dKB", size/1024), func(b *testing.B) {
+			cfg := &config.Config{MaxDataSize: payloadSize, ReadBufferSize: size}
+			srv := NewWithBackend(cfg, NewMockLogger(), clipboard.NewMemoryBackend())
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatalf("Failed to create listener: %v", err)
+			}
+			defer listener.Close()
+
+			b.SetBytes(payloadSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				go func() {
+					conn, err := net.Dial("tcp", listener.Addr().String())
+					if err != nil {
+						return
+					}
+					conn.Write(payload)
+					conn.Close()
+				}()
+
+				conn, err := listener.Accept()
+				if err != nil {
+					b.Fatalf("Failed to accept: %v", err)
+				}
+				reader := bufio.NewReaderSize(conn, srv.readBufferSize())
+				data, _, err := srv.readData(reader, -1, nil)
+				conn.Close()
+				if err != nil || len(data) != payloadSize {
+					b.Fatalf("readData returned %d bytes, %v", len(data), err)
+				}
+			}
+		})
+	}
+}
+
+// benchmarkSizes are the payload sizes the copy path benchmarks run at
+var benchmarkSizes = []struct {
+	name string
+	size int
+}{
+	{"1KB", 1024},
+	{"1MB", 1024 * 1024},
+	{"10MB", 10 * 1024 * 1024},
+}
+
+// BenchmarkHandleConnection measures a complete copy over loopback TCP:
+// reading the payload, copying it to the in-memory clipboard and
+// acknowledging it
+// BenchmarkReadLargePayload compares reading a 64MB payload into a
+// bytes.Buffer, as readData used to, with a payloadBuffer, whose size is
+// unknown or announced. Run with -benchmem to see the allocations.
+func BenchmarkReadLargePayload(b *testing.B) {
+	const payloadSize = 64 * 1024 * 1024
+	payload := bytes.Repeat([]byte("x"), payloadSize)
+	readBuffer := make([]byte, config.DefaultReadBufferSize)
+
+	type buffer interface {
+		io.Writer
+		Bytes() []byte
+	}
+	buffers := []struct {
+		name string
+		new  func() buffer
+	}{
+		{"bytes.Buffer", func() buffer { return &bytes.Buffer{} }},
+		{"unknown size", func() buffer { return newPayloadBuffer(-1) }},
+		{"announced size", func() buffer { return newPayloadBuffer(payloadSize) }},
+	}
+	for _, bb := range buffers {
+		b.Run(bb.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(payloadSize)
+			for i := 0; i < b.N; i++ {
+				buf := bb.new()
+				// Hide ReadFrom and WriteTo so every buffer gets the same
+				// network-sized writes
+				src := struct{ io.Reader }{bytes.NewReader(payload)}
+				if _, err := io.CopyBuffer(struct{ io.Writer }{buf}, src, readBuffer); err != nil {
+					b.Fatalf("Copy failed: %v", err)
+				}
+				if n := len(buf.Bytes()); n != payloadSize {
+					b.Fatalf("Read %d bytes, want %d", n, payloadSize)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkHandleConnection(b *testing.B) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		b.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, bs := range benchmarkSizes {
+		b.Run(bs.name, func(b *testing.B) {
+			cfg := newTestConfig(tempDir, 0)
+			cfg.MaxDataSize = int64(bs.size)
+			srv := NewWithBackend(cfg, NewMockLogger(), clipboard.NewMemoryBackend())
+			payload := []byte(strings.Repeat("x", bs.size))
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatalf("Failed to create listener: %v", err)
+			}
+			defer listener.Close()
+
+			b.SetBytes(int64(bs.size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				acked := make(chan error, 1)
+				go func() {
+					conn, err := net.Dial("tcp", listener.Addr().String())
+					if err != nil {
+						acked <- err
+						return
+					}
+					defer conn.Close()
+					conn.Write(payload)
+					conn.(*net.TCPConn).CloseWrite()
+					ack, err := protocol.ReadAck(bufio.NewReader(conn))
+					if err == nil && !ack.OK {
+						err = fmt.Errorf("copy failed: %s", ack.Error)
+					}
+					acked <- err
+				}()
+
+				conn, err := listener.Accept()
+				if err != nil {
+					b.Fatalf("Failed to accept: %v", err)
+				}
+				srv.handleConnection(conn)
+				if err := <-acked; err != nil {
+					b.Fatalf("Copy failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkCopyToClipboard measures handing a payload to the in-memory backend
+func BenchmarkCopyToClipboard(b *testing.B) {
+	for _, bs := range benchmarkSizes {
+		b.Run(bs.name, func(b *testing.B) {
+			srv := NewWithBackend(&config.Config{}, NewMockLogger(), clipboard.NewMemoryBackend())
+			payload := []byte(strings.Repeat("x", bs.size))
+
+			b.SetBytes(int64(bs.size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := srv.copyToClipboard(payload, clipboard.TextType); err != nil {
+					b.Fatalf("copyToClipboard failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// TestMemoryBudget tests that concurrent payloads are held to the memory
+// budget, turning away connections that would exceed it until earlier ones
+// have been delivered
+func TestMemoryBudget(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12376)
+	cfg.MemoryBudget = 2 * cfg.MaxDataSize
+	backend := clipboard.NewMemoryBackend()
+	srv := NewWithBackend(cfg, NewMockLogger(), backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	payload := bytes.Repeat([]byte("x"), int(cfg.MaxDataSize))
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+		if err != nil {
+			t.Fatalf("Failed to connect to server: %v", err)
+		}
+		return conn
+	}
+	finish := func(conn net.Conn, rest []byte) protocol.Ack {
+		defer conn.Close()
+		conn.Write(rest)
+		conn.(*net.TCPConn).CloseWrite()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		ack, err := protocol.ReadAck(bufio.NewReader(conn))
+		if err != nil {
+			t.Fatalf("Failed to read acknowledgement: %v", err)
+		}
+		return ack
+	}
+
+	// Two large payloads part way through hold the whole budget
+	var held []net.Conn
+	for i := 0; i < 2; i++ {
+		conn := dial()
+		protocol.WriteContentLength(conn, cfg.MaxDataSize)
+		conn.Write(payload[:100])
+		held = append(held, conn)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.budget.inUse() < cfg.MemoryBudget {
+		if time.Now().After(deadline) {
+			t.Fatalf("Budget in use is %d, want %d", srv.budget.inUse(), cfg.MemoryBudget)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Any more are turned away, however small
+	if ack := finish(dial(), []byte("small")); ack.OK || !strings.Contains(ack.Error, "memory budget exhausted") {
+		t.Errorf("Expected a copy beyond the budget to be rejected, got %+v", ack)
+	}
+
+	// Delivering a held payload makes room for the next
+	if ack := finish(held[0], payload[100:]); !ack.OK {
+		t.Fatalf("Held copy failed: %s", ack.Error)
+	}
+	if ack := finish(dial(), []byte("small")); !ack.OK {
+		t.Errorf("Expected a copy to fit once the budget was released, got %+v", ack)
+	}
+	if ack := finish(held[1], payload[100:]); !ack.OK {
+		t.Fatalf("Held copy failed: %s", ack.Error)
+	}
+
+	for srv.budget.inUse() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Budget still has %d bytes reserved after every copy finished", srv.budget.inUse())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if copies := backend.Copies(); copies != 3 {
+		t.Errorf("Expected 3 copies within the budget, got %d", copies)
+	}
+}
+
+// TestWatchSignals tests that SIGTERM and a first SIGINT start a graceful
+// shutdown, and that only a second SIGINT forces an exit
+func TestWatchSignals(t *testing.T) {
+	testCases := []struct {
+		name     string
+		signals  []os.Signal
+		wantExit int
+		wantLog  string
+	}{
+		{name: "sigterm", signals: []os.Signal{syscall.SIGTERM}, wantExit: -1, wantLog: "INFO: Received SIGTERM, shutting down"},
+		{name: "repeated sigterm", signals: []os.Signal{syscall.SIGTERM, syscall.SIGTERM}, wantExit: -1, wantLog: "INFO: Received SIGTERM, already shutting down"},
+		{name: "sigint", signals: []os.Signal{syscall.SIGINT}, wantExit: -1, wantLog: "press Ctrl-C again to exit now"},
+		{name: "double sigint", signals: []os.Signal{syscall.SIGINT, syscall.SIGINT}, wantExit: forcedExitCode, wantLog: "WARNING: Received a second SIGINT"},
+		{name: "sigint during sigterm", signals: []os.Signal{syscall.SIGTERM, syscall.SIGINT}, wantExit: forcedExitCode, wantLog: "WARNING: Received a second SIGINT"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := NewMockLogger()
+			srv := NewWithBackend(&config.Config{ShutdownTimeout: time.Second}, logger, clipboard.NewMemoryBackend())
+
+			signals := make(chan os.Signal, len(tc.signals))
+			for _, sig := range tc.signals {
+				signals <- sig
+			}
+			close(signals)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			exitCode := -1
+			srv.WatchSignals(signals, cancel, func(code int) { exitCode = code })
+
+			if ctx.Err() == nil {
+				t.Error("Expected the first signal to start a shutdown")
+			}
+			if exitCode != tc.wantExit {
+				t.Errorf("Exit code %d, want %d", exitCode, tc.wantExit)
+			}
+			if logs := strings.Join(logger.GetLogs(), "\n"); !strings.Contains(logs, tc.wantLog) {
+				t.Errorf("Expected log %q, got:\n%s", tc.wantLog, logs)
+			}
+		})
+	}
+}
+
+// TestShutdownTimeout tests that shutdown stops waiting for a connection
+// that doesn't finish once the shutdown timeout has passed
+func TestShutdownTimeout(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12377)
+	cfg.ShutdownTimeout = 200 * time.Millisecond
+	logger := NewMockLogger()
+	backend := clipboard.NewMemoryBackend()
+	srv := NewWithBackend(cfg, logger, backend)
+	stop := startTestServer(t, srv)
+
+	// A copy stuck part way through
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("partial"))
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	stop()
+	if elapsed := time.Since(start); elapsed < cfg.ShutdownTimeout {
+		t.Errorf("Shutdown took %v, expected it to wait %v for the connection", elapsed, cfg.ShutdownTimeout)
+	}
+	if logs := strings.Join(logger.GetLogs(), "\n"); !strings.Contains(logs, "WARNING: Connections still open after 200ms") {
+		t.Errorf("Expected a warning about the abandoned connection, got:\n%s", logs)
+	}
+	if backend.Copies() != 0 {
+		t.Errorf("Expected nothing copied, got %d copies", backend.Copies())
+	}
+}
+
+// TestShutdownDrainsCopy tests that a copy already being written to the
+// clipboard when shutdown begins is finished and acknowledged before Start
+// returns
+func TestShutdownDrainsCopy(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := newTestConfig(tempDir, 12381)
+	logger := NewMockLogger()
+	backend := &slowBackend{MemoryBackend: clipboard.NewMemoryBackend(), delay: 300 * time.Millisecond}
+	srv := NewWithBackend(cfg, logger, backend)
+	stop := startTestServer(t, srv)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("in-flight copy")); err != nil {
+		t.Fatalf("Failed to send data: %v", err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+
+	acks := make(chan protocol.Ack, 1)
+	go func() {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		ack, err := protocol.ReadAck(bufio.NewReader(conn))
+		if err != nil {
+			t.Errorf("Failed to read acknowledgement: %v", err)
+		}
+		acks <- ack
+	}()
+
+	// Shut down while the backend is still writing
+	time.Sleep(100 * time.Millisecond)
+	if backend.Copies() != 0 {
+		t.Fatal("Copy finished before shutdown began; the backend is too fast for this test")
+	}
+	start := time.Now()
+	stop()
+	elapsed := time.Since(start)
+
+	if backend.Copies() != 1 {
+		t.Fatalf("Start returned with %d copies made, want the in-flight copy finished", backend.Copies())
+	}
+	if data, _ := backend.Paste(); string(data) != "in-flight copy" {
+		t.Errorf("Clipboard holds %q, want the in-flight copy", data)
+	}
+	if elapsed < 100*time.Millisecond {
+		t.Errorf("Shutdown took %v, too quick to have waited for the copy", elapsed)
+	}
+	if ack := <-acks; !ack.OK {
+		t.Errorf("In-flight copy wasn't acknowledged: %+v", ack)
+	}
+	if logs := strings.Join(logger.GetLogs(), "\n"); strings.Contains(logs, "Connections still open") {
+		t.Errorf("Shutdown gave up on the copy:\n%s", logs)
+	}
+}
+
+// TestPullFrom tests that the daemon picks up a copy waiting on a listening
+// client, passing over a tunnel with nothing behind it
+func TestPullFrom(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "warpclip-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	remote, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer remote.Close()
+
+	cfg := newTestConfig(tempDir, 12380)
+	cfg.PullFrom = remote.Addr().String()
+	cfg.PullInterval = 50 * time.Millisecond
+	backend := clipboard.NewMemoryBackend()
+	logger := NewMockLogger()
+	srv := NewWithBackend(cfg, logger, backend)
+	stop := startTestServer(t, srv)
+	defer stop()
+
+	// A forward tunnel with no client behind it closes straight away
+	probe, err := remote.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept probe: %v", err)
+	}
+	probe.Close()
+
+	conn, err := remote.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept pull: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	reader := bufio.NewReader(conn)
+	if _, err := protocol.Handshake(conn, reader, time.Second); err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+	conn.Write([]byte("pulled data"))
+	conn.(*net.TCPConn).CloseWrite()
+
+	ack, err := protocol.ReadAck(reader)
+	if err != nil {
+		t.Fatalf("Failed to read acknowledgement: %v", err)
+	}
+	if !ack.OK {
+		t.Fatalf("Pulled copy failed: %+v", ack)
+	}
+	if data, _ := backend.Paste(); string(data) != "pulled data" {
+		t.Errorf("Clipboard holds %q, want the pulled data", data)
+	}
+	if status := srv.Status(); status.Accepted != 1 || status.Copies != 1 {
+		t.Errorf("Status counts %d accepted and %d copies, want one of each", status.Accepted, status.Copies)
+	}
+	if logs := strings.Join(logger.GetLogs(), "\n"); strings.Contains(logs, "Control connection") {
+		t.Errorf("Empty pull was logged as a connection:\n%s", logs)
+	}
+}
+
+// ignoringBackend accepts copies without changing the clipboard, like a
+// tool writing to a different selection than the one read back
+type ignoringBackend struct {
+	*clipboard.MemoryBackend
+}
+
+func (b *ignoringBackend) Copy(data []byte) error {
+	return nil
+}
+
+// TestSelfTest tests that WARPCLIP_SELFTEST checks the clipboard path at
+// startup and puts back what the clipboard held
+func TestSelfTest(t *testing.T) {
+	testCases := []struct {
+		name    string
+		port    int
+		ignore  bool
+		wantLog string
+	}{
+		{name: "passes", port: 12387, wantLog: "Self-test passed"},
+		{name: "copy doesn't reach the clipboard", port: 12388, ignore: true, wantLog: "Self-test failed: read back"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := newTestConfig(t.TempDir(), tc.port)
+			cfg.SelfTest = true
+			memory := clipboard.NewMemoryBackend()
+			memory.Copy([]byte("before"))
+			var backend clipboard.Backend = memory
+			if tc.ignore {
+				backend = &ignoringBackend{MemoryBackend: memory}
+			}
+			logger := NewMockLogger()
+			srv := NewWithBackend(cfg, logger, backend)
+			stop := startTestServer(t, srv)
+			defer stop()
+
+			deadline := time.Now().Add(2 * time.Second)
+			for !strings.Contains(strings.Join(logger.GetLogs(), "\n"), "Self-test") && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if logs := strings.Join(logger.GetLogs(), "\n"); !strings.Contains(logs, tc.wantLog) {
+				t.Fatalf("Expected %q in the log:\n%s", tc.wantLog, logs)
+			}
+			if data, _ := memory.Paste(); string(data) != "before" {
+				t.Errorf("Clipboard holds %q after the self-test, want it restored", data)
+			}
+			if status := srv.Status(); status.Copies != 0 {
+				t.Errorf("Self-test counted as %d copies", status.Copies)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// forcedExitCode is the exit status after a second SIGINT, as a shell
+// reports for a process killed by Ctrl-C
+const forcedExitCode = 130
+
+// WatchSignals shuts the server down on the signals arriving on signals by
+// calling cancel, and returns once signals is closed. SIGTERM, as sent by a
+// service manager or warpclipd stop, waits for open connections for up to
+// the shutdown timeout. So does a first SIGINT, but a second one, from
+// pressing Ctrl-C again, calls exit at once for users who don't want to wait.
+func (s *Server) WatchSignals(signals <-chan os.Signal, cancel context.CancelFunc, exit func(code int)) {
+	shuttingDown := false
+	for sig := range signals {
+		switch {
+		case !shuttingDown && sig == syscall.SIGINT:
+			s.logger.Info(fmt.Sprintf("Received SIGINT, shutting down once open connections finish (up to %v); press Ctrl-C again to exit now", s.shutdownTimeout()))
+		case !shuttingDown:
+			s.logger.Info(fmt.Sprintf("Received %s, shutting down once open connections finish (up to %v)", signalName(sig), s.shutdownTimeout()))
+		case sig == syscall.SIGINT:
+			s.logger.Warning("Received a second SIGINT, exiting without waiting for open connections")
+			exit(forcedExitCode)
+			return
+		default:
+			s.logger.Info(fmt.Sprintf("Received %s, already shutting down", signalName(sig)))
+			continue
+		}
+		shuttingDown = true
+		cancel()
+	}
+}
+
+// signalName returns the conventional name of sig, such as SIGTERM
+func signalName(sig os.Signal) string {
+	switch sig {
+	case syscall.SIGINT:
+		return "SIGINT"
+	case syscall.SIGTERM:
+		return "SIGTERM"
+	}
+	return sig.String()
+}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const (
+	// LaunchdLabel is the label used for the launchd user agent
+	LaunchdLabel = "com.user.warpclip"
+	// SystemdUnitName is the name of the systemd user unit
+	SystemdUnitName = "warpclipd.service"
+)
+
+// execCommand is used to run service manager commands, replaceable in tests
+var execCommand = exec.Command
+
+// Spec describes the daemon process a service definition should run
+type Spec struct {
+	// Executable is the absolute path to the warpclipd binary
+	Executable string
+	// Env holds KEY=VALUE pairs exported to the daemon
+	Env []string
+	// OutLogFile receives the daemon's stdout, appended to
+	OutLogFile string
+	// ErrorLogFile receives the daemon's stderr, appended to
+	ErrorLogFile string
+}
+
+var systemdTemplate = template.Must(template.New("systemd").Funcs(template.FuncMap{
+	"quote":     systemdQuote,
+	"command":   systemdCommand,
+	"specifier": systemdSpecifiers,
+}).Parse(`[Unit]
+Description=WarpClip clipboard daemon
+After=default.target
+
+[Service]
+Type=simple
+ExecStart={{.Executable | command}} start
+{{- range .Env}}
+Environment={{. | quote}}
+{{- end}}
+{{- if .OutLogFile}}
+StandardOutput=append:{{.OutLogFile | specifier}}
+{{- end}}
+{{- if .ErrorLogFile}}
+StandardError=append:{{.ErrorLogFile | specifier}}
+{{- end}}
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=default.target
+`))
+
+var launchdTemplate = template.Must(template.New("launchd").Funcs(template.FuncMap{"xml": xmlEscape}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+    <key>Label</key>
+    <string>{{.Label | xml}}</string>
+    <key>ProgramArguments</key>
+    <array>
+        <string>{{.Executable | xml}}</string>
+        <string>start</string>
+    </array>
+    <key>RunAtLoad</key>
+    <true/>
+    <key>KeepAlive</key>
+    <dict>
+        <key>SuccessfulExit</key>
+        <false/>
+    </dict>
+    <key>StandardErrorPath</key>
+    <string>{{.ErrorLogFile | xml}}</string>
+    <key>StandardOutPath</key>
+    <string>{{.OutLogFile | xml}}</string>
+    <key>ThrottleInterval</key>
+    <integer>10</integer>
+    <key>EnvironmentVariables</key>
+    <dict>
+        <key>PATH</key>
+        <string>/usr/local/bin:/usr/bin:/bin:/usr/sbin:/sbin</string>
+{{- range .Env}}
+        <key>{{.Key | xml}}</key>
+        <string>{{.Value | xml}}</string>
+{{- end}}
+    </dict>
+</dict>
+</plist>
+`))
+
+// systemdSpecifiers escapes the % that would start a unit file specifier
+func systemdSpecifiers(s string) string {
+	return strings.ReplaceAll(s, "%", "%%")
+}
+
+// systemdQuote double-quotes a value for a unit file setting, escaping what
+// systemd would otherwise read as a specifier, an escape or the end of the
+// quotes
+func systemdQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+	return `"` + systemdSpecifiers(s) + `"`
+}
+
+// systemdCommand quotes a value for ExecStart, which also expands $ variables
+func systemdCommand(s string) string {
+	return strings.ReplaceAll(systemdQuote(s), "$", "$$")
+}
+
+// xmlEscape escapes a value for inclusion in the property list
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// SystemdUnit renders a systemd user unit for the daemon
+func SystemdUnit(spec Spec) (string, error) {
+	var buf bytes.Buffer
+	if err := systemdTemplate.Execute(&buf, spec); err != nil {
+		return "", fmt.Errorf("failed to render systemd unit: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// LaunchdPlist renders a launchd user agent property list for the daemon
+func LaunchdPlist(spec Spec) (string, error) {
+	type envVar struct {
+		Key   string
+		Value string
+	}
+
+	data := struct {
+		Spec
+		Label string
+		Env   []envVar
+	}{
+		Spec:  spec,
+		Label: LaunchdLabel,
+	}
+	for _, kv := range spec.Env {
+		key, value, _ := strings.Cut(kv, "=")
+		data.Env = append(data.Env, envVar{Key: key, Value: value})
+	}
+
+	var buf bytes.Buffer
+	if err := launchdTemplate.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render launchd plist: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// Install writes the service definition appropriate for goos and registers
+// it with the platform's user service manager. It returns the path of the
+// written definition.
+func Install(goos string, spec Spec) (string, error) {
+	switch goos {
+	case "darwin":
+		return installLaunchd(spec)
+	case "linux":
+		return installSystemd(spec)
+	default:
+		return "", fmt.Errorf("service installation is not supported on %s", goos)
+	}
+}
+
+// installLaunchd writes the launchd agent and loads it
+func installLaunchd(spec Spec) (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+
+	content, err := LaunchdPlist(spec)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(homeDir, "Library", "LaunchAgents", LaunchdLabel+".plist")
+	if err := writeDefinition(path, content); err != nil {
+		return "", err
+	}
+
+	// Unload any previous definition so the new one takes effect; this fails
+	// harmlessly when the agent was never loaded
+	execCommand("launchctl", "unload", path).Run()
+
+	if err := run("launchctl", "load", "-w", path); err != nil {
+		return path, err
+	}
+	return path, nil
+}
+
+// installSystemd writes the systemd user unit and enables it
+func installSystemd(spec Spec) (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user config directory: %w", err)
+	}
+
+	content, err := SystemdUnit(spec)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(configDir, "systemd", "user", SystemdUnitName)
+	if err := writeDefinition(path, content); err != nil {
+		return "", err
+	}
+
+	if err := run("systemctl", "--user", "daemon-reload"); err != nil {
+		return path, err
+	}
+	if err := run("systemctl", "--user", "enable", "--now", SystemdUnitName); err != nil {
+		return path, err
+	}
+	return path, nil
+}
+
+// writeDefinition writes a service definition file with secure permissions
+func writeDefinition(path, content string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// run executes a service manager command, including its output in any error
+func run(name string, args ...string) error {
+	output, err := execCommand(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testSpec() Spec {
+	return Spec{
+		Executable:   "/usr/local/bin/warpclipd",
+		Env:          []string{"WARPCLIP_LOCAL_PORT=8890", "WARPCLIP_LOG_FILE=/tmp/a&b.log"},
+		OutLogFile:   "/tmp/warpclip.out.log",
+		ErrorLogFile: "/tmp/warpclip.error.log",
+	}
+}
+
+func TestSystemdUnit(t *testing.T) {
+	unit, err := SystemdUnit(testSpec())
+	if err != nil {
+		t.Fatalf("SystemdUnit failed: %v", err)
+	}
+
+	expected := []string{
+		"ExecStart=\"/usr/local/bin/warpclipd\" start\n",
+		"Environment=\"WARPCLIP_LOCAL_PORT=8890\"\n",
+		"Environment=\"WARPCLIP_LOG_FILE=/tmp/a&b.log\"\n",
+		"StandardOutput=append:/tmp/warpclip.out.log\n",
+		"StandardError=append:/tmp/warpclip.error.log\n",
+		"WantedBy=default.target\n",
+	}
+	for _, want := range expected {
+		if !strings.Contains(unit, want) {
+			t.Errorf("Systemd unit missing %q:\n%s", want, unit)
+		}
+	}
+}
+
+// TestSystemdUnitEscaping tests that paths and values systemd would
+// otherwise split, expand or unquote come through intact
+func TestSystemdUnitEscaping(t *testing.T) {
+	spec := Spec{
+		Executable:   "/home/me/My Tools/$bin/warpclipd",
+		Env:          []string{`WARPCLIP_LOG_FILE=/tmp/100% "done"\.log`},
+		OutLogFile:   "/tmp/50%.out.log",
+		ErrorLogFile: "/tmp/50%.error.log",
+	}
+	unit, err := SystemdUnit(spec)
+	if err != nil {
+		t.Fatalf("SystemdUnit failed: %v", err)
+	}
+
+	expected := []string{
+		`ExecStart="/home/me/My Tools/$$bin/warpclipd" start` + "\n",
+		`Environment="WARPCLIP_LOG_FILE=/tmp/100%% \"done\"\\.log"` + "\n",
+		"StandardOutput=append:/tmp/50%%.out.log\n",
+		"StandardError=append:/tmp/50%%.error.log\n",
+	}
+	for _, want := range expected {
+		if !strings.Contains(unit, want) {
+			t.Errorf("Systemd unit missing %q:\n%s", want, unit)
+		}
+	}
+}
+
+func TestLaunchdPlist(t *testing.T) {
+	plist, err := LaunchdPlist(testSpec())
+	if err != nil {
+		t.Fatalf("LaunchdPlist failed: %v", err)
+	}
+
+	expected := []string{
+		"<string>" + LaunchdLabel + "</string>",
+		"<string>/usr/local/bin/warpclipd</string>",
+		"<key>WARPCLIP_LOCAL_PORT</key>\n        <string>8890</string>",
+		"<string>/tmp/a&amp;b.log</string>",
+		"<string>/tmp/warpclip.error.log</string>",
+		// A clean exit (such as an idle shutdown) must not be restarted
+		"<key>SuccessfulExit</key>\n        <false/>",
+	}
+	for _, want := range expected {
+		if !strings.Contains(plist, want) {
+			t.Errorf("Launchd plist missing %q:\n%s", want, plist)
+		}
+	}
+}
+
+func TestInstallSystemd(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+
+	var commands []string
+	origExecCommand := execCommand
+	defer func() { execCommand = origExecCommand }()
+	execCommand = func(name string, args ...string) *exec.Cmd {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		return exec.Command("true")
+	}
+
+	path, err := Install("linux", testSpec())
+	if err != nil {
+		t.Fatalf("Install failed: %v", err)
+	}
+
+	expectedPath := filepath.Join(configDir, "systemd", "user", SystemdUnitName)
+	if path != expectedPath {
+		t.Errorf("Expected unit at %s, got %s", expectedPath, path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Unit file not written: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Unit file has incorrect permissions: %v, expected 0600", info.Mode().Perm())
+	}
+
+	expectedCommands := []string{
+		"systemctl --user daemon-reload",
+		"systemctl --user enable --now " + SystemdUnitName,
+	}
+	if strings.Join(commands, "\n") != strings.Join(expectedCommands, "\n") {
+		t.Errorf("Unexpected commands run: %q", commands)
+	}
+}
+
+func TestInstallUnsupportedOS(t *testing.T) {
+	if _, err := Install("plan9", testSpec()); err == nil {
+		t.Error("Expected error for unsupported OS, got nil")
+	}
+}
@@ -0,0 +1,79 @@
+// Package term prints the messages the command-line tools write to stderr,
+// coloring errors red, warnings and hints yellow and successes green when
+// stderr is a terminal. Color follows the NO_COLOR convention
+// (https://no-color.org) and is never used when stderr is piped or
+// redirected.
+package term
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	red    = "\x1b[31m"
+	green  = "\x1b[32m"
+	yellow = "\x1b[33m"
+	reset  = "\x1b[0m"
+)
+
+// Colors prints messages in color, or plain when color is off. The zero
+// value prints plain.
+type Colors struct {
+	enabled bool
+}
+
+// ForStderr returns the Colors for messages written to stderr: on when it is
+// a terminal, unless noColor is set, such as by --no-color, NO_COLOR is set
+// to anything, or TERM is dumb
+func ForStderr(noColor bool) Colors {
+	if noColor || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return Colors{}
+	}
+	info, err := os.Stderr.Stat()
+	if err != nil {
+		return Colors{}
+	}
+	return Colors{enabled: info.Mode()&os.ModeCharDevice != 0}
+}
+
+// Enabled reports whether messages are colored
+func (c Colors) Enabled() bool {
+	return c.enabled
+}
+
+// Errorf prints an error message in red
+func (c Colors) Errorf(w io.Writer, format string, args ...any) {
+	fmt.Fprint(w, c.paint(red, fmt.Sprintf(format, args...)))
+}
+
+// Warningf prints a warning in yellow
+func (c Colors) Warningf(w io.Writer, format string, args ...any) {
+	fmt.Fprint(w, c.paint(yellow, fmt.Sprintf(format, args...)))
+}
+
+// Hintf prints advice on what to do next, such as how to set up a tunnel,
+// in yellow
+func (c Colors) Hintf(w io.Writer, format string, args ...any) {
+	fmt.Fprint(w, c.paint(yellow, fmt.Sprintf(format, args...)))
+}
+
+// Successf prints a success message in green
+func (c Colors) Successf(w io.Writer, format string, args ...any) {
+	fmt.Fprint(w, c.paint(green, fmt.Sprintf(format, args...)))
+}
+
+// paint wraps message in color, resetting it before a trailing newline so
+// the color can't leak into whatever is printed next
+func (c Colors) paint(color, message string) string {
+	if !c.enabled {
+		return message
+	}
+	body := strings.TrimSuffix(message, "\n")
+	if body == "" {
+		return message
+	}
+	return color + body + reset + message[len(body):]
+}
@@ -0,0 +1,76 @@
+package term
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestColors(t *testing.T) {
+	var b strings.Builder
+	plain := Colors{}
+	plain.Errorf(&b, "Error: %s\n", "no tunnel")
+	if b.String() != "Error: no tunnel\n" {
+		t.Errorf("Plain error = %q", b.String())
+	}
+
+	colored := Colors{enabled: true}
+	testCases := []struct {
+		name     string
+		print    func(c Colors, b *strings.Builder)
+		expected string
+	}{
+		{
+			name:     "error",
+			print:    func(c Colors, b *strings.Builder) { c.Errorf(b, "Error: port %d\n", 9999) },
+			expected: "\x1b[31mError: port 9999\x1b[0m\n",
+		},
+		{
+			name:     "warning",
+			print:    func(c Colors, b *strings.Builder) { c.Warningf(b, "Warning: slow\n") },
+			expected: "\x1b[33mWarning: slow\x1b[0m\n",
+		},
+		{
+			name:     "hint",
+			print:    func(c Colors, b *strings.Builder) { c.Hintf(b, "Run warpclipd start") },
+			expected: "\x1b[33mRun warpclipd start\x1b[0m",
+		},
+		{
+			name:     "success",
+			print:    func(c Colors, b *strings.Builder) { c.Successf(b, "Copied!\n") },
+			expected: "\x1b[32mCopied!\x1b[0m\n",
+		},
+		{
+			name:     "blank line",
+			print:    func(c Colors, b *strings.Builder) { c.Hintf(b, "\n") },
+			expected: "\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var b strings.Builder
+			tc.print(colored, &b)
+			if b.String() != tc.expected {
+				t.Errorf("Got %q, want %q", b.String(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestForStderr(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+	t.Setenv("TERM", "xterm-256color")
+	if ForStderr(true).Enabled() {
+		t.Error("Expected no color with --no-color")
+	}
+
+	t.Setenv("NO_COLOR", "1")
+	if ForStderr(false).Enabled() {
+		t.Error("Expected no color with NO_COLOR set")
+	}
+
+	t.Setenv("NO_COLOR", "")
+	t.Setenv("TERM", "dumb")
+	if ForStderr(false).Enabled() {
+		t.Error("Expected no color with TERM=dumb")
+	}
+}
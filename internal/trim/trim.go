@@ -0,0 +1,72 @@
+package trim
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Policy selects which whitespace is removed before copying
+type Policy int
+
+const (
+	// None leaves data untouched
+	None Policy = iota
+	// TrailingNewline removes the single line ending that ends the data, as
+	// added by echo or the last line of a file
+	TrailingNewline
+	// TrailingWhitespace removes all whitespace, including line endings, from
+	// the end of the data
+	TrailingWhitespace
+	// BothEnds removes all whitespace from the start and end of the data
+	BothEnds
+)
+
+// ParsePolicy parses a policy name as used in environment variables
+func ParsePolicy(s string) (Policy, error) {
+	switch strings.ToLower(s) {
+	case "", "none", "off":
+		return None, nil
+	case "trailing-newline":
+		return TrailingNewline, nil
+	case "trailing-ws":
+		return TrailingWhitespace, nil
+	case "both-ends":
+		return BothEnds, nil
+	default:
+		return None, fmt.Errorf("unknown trim policy %q (want none, trailing-newline, trailing-ws or both-ends)", s)
+	}
+}
+
+// String returns the name of the policy
+func (p Policy) String() string {
+	switch p {
+	case TrailingNewline:
+		return "trailing-newline"
+	case TrailingWhitespace:
+		return "trailing-ws"
+	case BothEnds:
+		return "both-ends"
+	default:
+		return "none"
+	}
+}
+
+// Apply removes whitespace from data according to policy. The result shares
+// data's backing array.
+func Apply(data []byte, policy Policy) []byte {
+	switch policy {
+	case TrailingNewline:
+		if bytes.HasSuffix(data, []byte("\r\n")) {
+			return data[:len(data)-2]
+		}
+		return bytes.TrimSuffix(data, []byte("\n"))
+	case TrailingWhitespace:
+		return bytes.TrimRightFunc(data, unicode.IsSpace)
+	case BothEnds:
+		return bytes.TrimFunc(data, unicode.IsSpace)
+	default:
+		return data
+	}
+}
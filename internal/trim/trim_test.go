@@ -0,0 +1,62 @@
+package trim
+
+import "testing"
+
+func TestParsePolicy(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Policy
+	}{
+		{input: "", expected: None},
+		{input: "none", expected: None},
+		{input: "trailing-newline", expected: TrailingNewline},
+		{input: "Trailing-WS", expected: TrailingWhitespace},
+		{input: "both-ends", expected: BothEnds},
+	}
+
+	for _, tc := range testCases {
+		policy, err := ParsePolicy(tc.input)
+		if err != nil {
+			t.Errorf("ParsePolicy(%q) failed: %v", tc.input, err)
+			continue
+		}
+		if policy != tc.expected {
+			t.Errorf("ParsePolicy(%q) = %v, want %v", tc.input, policy, tc.expected)
+		}
+		if tc.input != "" && policy.String() == "" {
+			t.Errorf("Policy %d has no name", policy)
+		}
+	}
+
+	if _, err := ParsePolicy("leading"); err == nil {
+		t.Error("Expected error for unknown policy, got nil")
+	}
+}
+
+func TestApply(t *testing.T) {
+	testCases := []struct {
+		name     string
+		policy   Policy
+		input    string
+		expected string
+	}{
+		{name: "none keeps bytes", policy: None, input: "  text \n\n", expected: "  text \n\n"},
+		{name: "trailing newline", policy: TrailingNewline, input: "text\n", expected: "text"},
+		{name: "trailing crlf", policy: TrailingNewline, input: "text\r\n", expected: "text"},
+		{name: "only one newline", policy: TrailingNewline, input: "text\n\n", expected: "text\n"},
+		{name: "newline keeps spaces", policy: TrailingNewline, input: " text \n", expected: " text "},
+		{name: "no newline", policy: TrailingNewline, input: "text", expected: "text"},
+		{name: "trailing whitespace", policy: TrailingWhitespace, input: "  a\n b \t\r\n\n", expected: "  a\n b"},
+		{name: "both ends", policy: BothEnds, input: "\n\t a\n b \n", expected: "a\n b"},
+		{name: "unicode space", policy: BothEnds, input: "\u00a0text\u2003", expected: "text"},
+		{name: "all whitespace", policy: BothEnds, input: " \n\t", expected: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := string(Apply([]byte(tc.input), tc.policy)); got != tc.expected {
+				t.Errorf("Apply(%q, %v) = %q, want %q", tc.input, tc.policy, got, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+// Package version holds the release version shared by warpclip and
+// warpclipd. Release builds stamp it, and the commit and build date, with
+//
+//	go build -ldflags "-X github.com/mquinnv/warpclip/v2/internal/version.Version=2.1.12 \
+//	  -X github.com/mquinnv/warpclip/v2/internal/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X github.com/mquinnv/warpclip/v2/internal/version.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Unstamped builds fall back to what the Go toolchain recorded about the
+// checkout they were built from.
+package version
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// Set with -ldflags -X at build time
+var (
+	// Version is the release version, without a leading "v"
+	Version = "2.1.11"
+	// Commit is the git commit built from
+	Commit = ""
+	// Date is when the binary was built, in RFC 3339
+	Date = ""
+)
+
+// Info describes a build of one of the binaries
+type Info struct {
+	Program   string `json:"program"`
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build information for program
+func Get(program string) Info {
+	info := Info{
+		Program:   program,
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+	}
+	if info.Commit != "" && info.Date != "" {
+		return info
+	}
+
+	// go build records the checkout's commit and commit time when built
+	// inside one
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	modified := false
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = setting.Value
+				if len(info.Commit) > 12 {
+					info.Commit = info.Commit[:12]
+				}
+			}
+		case "vcs.time":
+			if info.Date == "" {
+				info.Date = setting.Value
+			}
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if modified && Commit == "" && info.Commit != "" {
+		info.Commit += "-dirty"
+	}
+	return info
+}
+
+// String formats the version with whatever is known of the build, such as
+// "v2.1.11 (commit 1a2b3c4d5e6f, built 2024-05-01T12:00:00Z)"
+func (i Info) String() string {
+	s := "v" + i.Version
+	switch {
+	case i.Commit != "" && i.Date != "":
+		s += fmt.Sprintf(" (commit %s, built %s)", i.Commit, i.Date)
+	case i.Commit != "":
+		s += fmt.Sprintf(" (commit %s)", i.Commit)
+	case i.Date != "":
+		s += fmt.Sprintf(" (built %s)", i.Date)
+	}
+	return s
+}
+
+// Write prints program's build information to w for --version: after its
+// name, such as "WarpClip Remote Client", or as one JSON object if asJSON is
+// set
+func Write(w io.Writer, program, name string, asJSON bool) error {
+	info := Get(program)
+	if asJSON {
+		return json.NewEncoder(w).Encode(info)
+	}
+	_, err := fmt.Fprintf(w, "%s %s\n", name, info)
+	return err
+}
+
+// Compare compares two release versions such as "2.1.11" or "v2.1.12",
+// returning -1, 0 or 1 as a is older than, the same as or newer than b.
+// Missing parts count as 0 and anything after a "-" or "+" is ignored.
+func Compare(a, b string) int {
+	pa, pb := parts(a), parts(b)
+	for len(pa) < len(pb) {
+		pa = append(pa, 0)
+	}
+	for len(pb) < len(pa) {
+		pb = append(pb, 0)
+	}
+	for i := range pa {
+		switch {
+		case pa[i] < pb[i]:
+			return -1
+		case pa[i] > pb[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+// parts splits a version into its numeric parts
+func parts(v string) []int {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	var nums []int
+	for _, field := range strings.Split(v, ".") {
+		n, _ := strconv.Atoi(field)
+		nums = append(nums, n)
+	}
+	return nums
+}
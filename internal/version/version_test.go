@@ -0,0 +1,55 @@
+package version
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	oldCommit, oldDate := Commit, Date
+	defer func() { Commit, Date = oldCommit, oldDate }()
+	Commit, Date = "1a2b3c4", "2024-05-01T12:00:00Z"
+
+	var out bytes.Buffer
+	if err := Write(&out, "warpclipd", "warpclipd", false); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	want := "warpclipd v" + Version + " (commit 1a2b3c4, built 2024-05-01T12:00:00Z)\n"
+	if out.String() != want {
+		t.Errorf("Write printed %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	if err := Write(&out, "warpclip", "WarpClip Remote Client", true); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	var info Info
+	if err := json.Unmarshal(out.Bytes(), &info); err != nil {
+		t.Fatalf("Write printed invalid JSON %q: %v", out.String(), err)
+	}
+	if info.Program != "warpclip" || info.Version != Version || info.Commit != "1a2b3c4" || info.Date != "2024-05-01T12:00:00Z" || !strings.HasPrefix(info.GoVersion, "go") {
+		t.Errorf("Unexpected build information %+v", info)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"2.1.11", "2.1.11", 0},
+		{"v2.1.11", "2.1.11", 0},
+		{"2.1.11", "v2.1.12", -1},
+		{"2.10.0", "2.9.3", 1},
+		{"2.1", "2.1.0", 0},
+		{"2.1", "2.1.1", -1},
+		{"3.0.0-rc1", "3.0.0", 0},
+	}
+	for _, tt := range tests {
+		if got := Compare(tt.a, tt.b); got != tt.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}